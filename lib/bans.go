@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const bansKey = "bans"
+
+// A Ban on an IP address.
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// SaveBan stores the ban, replacing any previous ban on the same IP.
+func (db *DB) SaveBan(ban Ban) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("HSET", bansKey, ban.IP, data)
+	return err
+}
+
+func (db *DB) DeleteBan(ip string) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HDEL", bansKey, ip)
+	return err
+}
+
+// Bans returns the stored bans, including expired ones.
+func (db *DB) Bans() ([]Ban, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", bansKey))
+	if err != nil {
+		return nil, err
+	}
+
+	bans := make([]Ban, 0, len(values))
+	for _, data := range values {
+		var ban Ban
+		if err := json.Unmarshal([]byte(data), &ban); err != nil {
+			return nil, err
+		}
+
+		bans = append(bans, ban)
+	}
+
+	return bans, nil
+}
@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	blocksKey     = "blocks"
+	blocksChannel = "blocks"
+)
+
+type BlockStatus string
+
+const (
+	BlockPending   BlockStatus = "pending"
+	BlockConfirmed BlockStatus = "confirmed"
+	BlockOrphaned  BlockStatus = "orphaned"
+)
+
+// A Block found by one of the proxy's miners.
+type Block struct {
+	Height        int     `json:"height"`
+	Hash          string  `json:"hash"`
+	Worker        string  `json:"worker"`
+	Upstream      string  `json:"upstream"`
+	Reward        float64 `json:"reward"`
+	NetDifficulty float64 `json:"netDifficulty"`
+	// Share difficulty submitted in the round the block ended, and that
+	// over NetDifficulty.
+	RoundShares float64 `json:"roundShares"`
+	Effort      float64 `json:"effort"`
+
+	Status        BlockStatus `json:"status"`
+	Confirmations int         `json:"confirmations"`
+	FoundAt       time.Time   `json:"foundAt"`
+}
+
+// SaveBlock stores the block and publishes it on the blocks channel.
+func (db *DB) SaveBlock(block Block) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Do("HSET", blocksKey, block.Hash, data); err != nil {
+		return err
+	}
+
+	_, err = conn.Do("PUBLISH", blocksChannel, data)
+	return err
+}
+
+// Blocks returns every stored block.
+func (db *DB) Blocks() ([]Block, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", blocksKey))
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]Block, 0, len(values))
+	for _, data := range values {
+		var block Block
+		if err := json.Unmarshal([]byte(data), &block); err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const roundsKey = "rounds"
+
+// A Round is the work submitted to an upstream since its last block.
+type Round struct {
+	Upstream string    `json:"upstream"`
+	Shares   float64   `json:"shares"`
+	Start    time.Time `json:"start"`
+}
+
+// SaveRound stores the upstream's current round.
+func (db *DB) SaveRound(round Round) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(round)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("HSET", roundsKey, round.Upstream, data)
+	return err
+}
+
+// Rounds returns the stored rounds.
+func (db *DB) Rounds() ([]Round, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", roundsKey))
+	if err != nil {
+		return nil, err
+	}
+
+	rounds := make([]Round, 0, len(values))
+	for _, data := range values {
+		var round Round
+		if err := json.Unmarshal([]byte(data), &round); err != nil {
+			return nil, err
+		}
+
+		rounds = append(rounds, round)
+	}
+
+	return rounds, nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"log"
-	"net"
 
 	"net/http"
 	_ "net/http/pprof"
@@ -29,24 +28,31 @@ func main() {
 		log.Println(http.ListenAndServe(cfg.PProfHost, nil))
 	}()
 
-	// Set up the tcp server for stratum
-	addr, err := net.ResolveTCPAddr("tcp", cfg.Host)
-	if err != nil {
-		log.Fatalln("Could not resolve address:", err)
+	// Admin and stats endpoints
+	if cfg.APIHost != "" {
+		go func() {
+			log.Println("Listening on: http://"+cfg.APIHost, "(api)")
+			log.Fatalln(http.ListenAndServe(cfg.APIHost, server.APIHandler()))
+		}()
 	}
 
-	listener, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		log.Fatalln("Could not create listener:", err)
+	// Set up the tcp servers for stratum
+	listeners := cfg.ListenerConfigs()
+	if len(listeners) == 0 {
+		log.Fatalln("No stratum listeners configured")
 	}
 
-	log.Println("Listening on:", addr)
-	for {
-		conn, err := listener.AcceptTCP()
+	errs := make(chan error, len(listeners))
+	for _, lc := range listeners {
+		listener, err := server.NewListener(lc)
 		if err != nil {
-			log.Fatalln("Failed to accept socket:", err)
+			log.Fatalln("Could not create listener:", lc.Host, err)
 		}
 
-		go server.Handle(conn)
+		go func() {
+			errs <- listener.Serve()
+		}()
 	}
+
+	log.Fatalln("Listener failed:", <-errs)
 }
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrBadAddress = errors.New("invalid address")
+
+// Base58 versions of Bitcoin addresses.
+const (
+	versionP2PKH        = 0x00
+	versionP2SH         = 0x05
+	versionTestnetP2PKH = 0x6f
+	versionTestnetP2SH  = 0xc4
+)
+
+// AddressScript returns the output script paying a Bitcoin address, legacy
+// base58 or segwit (BIP 173 and 350).
+func AddressScript(addr string, testnet bool) ([]byte, error) {
+	hrp := "bc"
+	if testnet {
+		hrp = "tb"
+	}
+
+	lower := strings.ToLower(addr)
+	if strings.HasPrefix(lower, hrp+"1") || testnet && strings.HasPrefix(lower, "bcrt1") {
+		return segwitScript(addr)
+	}
+
+	raw, err := DecodeCheck(addr)
+	if err != nil || len(raw) != 1+20 {
+		return nil, ErrBadAddress
+	}
+
+	p2pkh, p2sh := byte(versionP2PKH), byte(versionP2SH)
+	if testnet {
+		p2pkh, p2sh = versionTestnetP2PKH, versionTestnetP2SH
+	}
+
+	switch raw[0] {
+	case p2pkh:
+		// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+		return append(append([]byte{0x76, 0xa9, 20}, raw[1:]...), 0x88, 0xac), nil
+	case p2sh:
+		// OP_HASH160 <hash> OP_EQUAL
+		return append(append([]byte{0xa9, 20}, raw[1:]...), 0x87), nil
+	}
+
+	return nil, ErrBadAddress
+}
+
+const (
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// segwitScript decodes a segwit address to its witness program output.
+func segwitScript(addr string) ([]byte, error) {
+	_, data, checksum, err := bech32Decode(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 1 {
+		return nil, ErrBadAddress
+	}
+
+	// Version 0 programs use bech32, later ones bech32m.
+	version := data[0]
+	if version > 16 || version == 0 && checksum != bech32Const || version != 0 && checksum != bech32mConst {
+		return nil, ErrBadAddress
+	}
+
+	program, ok := convertBits(data[1:], 5, 8)
+	if !ok || len(program) < 2 || len(program) > 40 || version == 0 && len(program) != 20 && len(program) != 32 {
+		return nil, ErrBadAddress
+	}
+
+	op := byte(0x00)
+	if version > 0 {
+		op = 0x50 + version
+	}
+
+	return append([]byte{op, byte(len(program))}, program...), nil
+}
+
+// bech32Decode splits a bech32 or bech32m string into its human-readable
+// part and 5 bit data, returning the checksum constant it ends with.
+func bech32Decode(s string) (string, []byte, uint32, error) {
+	if len(s) > 90 || strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, ErrBadAddress
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || len(s)-sep-1 < 6 {
+		return "", nil, 0, ErrBadAddress
+	}
+	hrp := s[:sep]
+
+	data := make([]byte, 0, len(s)-sep-1)
+	for _, c := range s[sep+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, 0, ErrBadAddress
+		}
+		data = append(data, byte(v))
+	}
+
+	checksum := bech32Polymod(append(bech32HRPExpand(hrp), data...))
+	return hrp, data[:len(data)-6], checksum, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	result := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+
+	return result
+}
+
+// convertBits regroups 5 bit groups into bytes, refusing non-zero padding.
+func convertBits(data []byte, from, to uint) ([]byte, bool) {
+	var acc, bits uint
+	var result []byte
+	maxv := uint(1)<<to - 1
+	for _, v := range data {
+		acc = acc<<from | uint(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+
+	if bits >= from || (acc<<(to-bits))&maxv != 0 {
+		return nil, false
+	}
+
+	return result, true
+}
+
+// Zcash transparent address versions, two bytes each.
+var (
+	zcashP2PKH        = [2]byte{0x1c, 0xb8}
+	zcashP2SH         = [2]byte{0x1c, 0xbd}
+	zcashTestnetP2PKH = [2]byte{0x1d, 0x25}
+	zcashTestnetP2SH  = [2]byte{0x1c, 0xba}
+)
+
+// ErrShieldedAddress is returned for Sapling addresses, which only a node
+// building the coinbase with its proofs can pay.
+var ErrShieldedAddress = errors.New("shielded address")
+
+// ZcashAddressScript returns the output script paying a transparent Zcash
+// address.
+func ZcashAddressScript(addr string, testnet bool) ([]byte, error) {
+	if valid, saplingTestnet := saplingAddress(addr); valid {
+		if saplingTestnet != testnet {
+			return nil, ErrBadAddress
+		}
+		return nil, ErrShieldedAddress
+	}
+
+	raw, err := DecodeCheck(addr)
+	if err != nil || len(raw) != 2+20 {
+		return nil, ErrBadAddress
+	}
+
+	p2pkh, p2sh := zcashP2PKH, zcashP2SH
+	if testnet {
+		p2pkh, p2sh = zcashTestnetP2PKH, zcashTestnetP2SH
+	}
+
+	switch [2]byte{raw[0], raw[1]} {
+	case p2pkh:
+		// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+		return append(append([]byte{0x76, 0xa9, 20}, raw[2:]...), 0x88, 0xac), nil
+	case p2sh:
+		// OP_HASH160 <hash> OP_EQUAL
+		return append(append([]byte{0xa9, 20}, raw[2:]...), 0x87), nil
+	}
+
+	return nil, ErrBadAddress
+}
+
+// Sapling addresses are a bech32 11 byte diversifier and 32 byte key.
+const saplingAddressSize = 11 + 32
+
+// saplingAddress reports whether addr is a Sapling address, and whether
+// it's a testnet one.
+func saplingAddress(addr string) (valid bool, testnet bool) {
+	hrp, data, checksum, err := bech32Decode(addr)
+	if err != nil || checksum != bech32Const || hrp != "zs" && hrp != "ztestsapling" {
+		return false, false
+	}
+
+	raw, ok := convertBits(data, 5, 8)
+	if !ok || len(raw) != saplingAddressSize {
+		return false, false
+	}
+
+	return true, hrp == "ztestsapling"
+}
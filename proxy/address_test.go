@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestAddressScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		testnet bool
+		script  string
+	}{
+		{name: "p2pkh", addr: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", script: "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac"},
+		{name: "p2sh", addr: "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", script: "a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87"},
+		{name: "testnet p2pkh", addr: "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", testnet: true, script: "76a914243f1394f44554f4ce3fd68649c19adc483ce92488ac"},
+		{name: "mainnet p2pkh on testnet", addr: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", testnet: true},
+		{name: "bad checksum", addr: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb"},
+		{name: "zcash", addr: "t1KzZ5n2TPEGYXTZ3WYGL1AYEumEQaRoHaL"},
+
+		// BIP 173 and 350, valid.
+		{name: "p2wpkh upper case", addr: "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", script: "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{name: "testnet p2wsh", addr: "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", testnet: true, script: "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{name: "version 1", addr: "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", script: "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{name: "version 16", addr: "BC1SW50QGDZ25J", script: "6002751e"},
+		{name: "version 2", addr: "bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", script: "5210751e76e8199196d454941c45d1b3a323"},
+		{name: "testnet p2wsh leading zeros", addr: "tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", testnet: true, script: "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{name: "testnet taproot", addr: "tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", testnet: true, script: "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{name: "taproot", addr: "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", script: "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{name: "regtest", addr: "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", testnet: true, script: "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+
+		// BIP 350, invalid.
+		{name: "unknown prefix", addr: "tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut", testnet: true},
+		{name: "version 1 with bech32", addr: "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd"},
+		{name: "version 3 with bech32", addr: "tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", testnet: true},
+		{name: "version 16 with bech32", addr: "BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL"},
+		{name: "version 0 with bech32m", addr: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh"},
+		{name: "testnet version 0 with bech32m", addr: "tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", testnet: true},
+		{name: "invalid character", addr: "bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4"},
+		{name: "version 17", addr: "BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R"},
+		{name: "program of 1 byte", addr: "bc1pw5dgrnzv"},
+		{name: "program of 41 bytes", addr: "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav"},
+		{name: "version 0 program of 16 bytes", addr: "BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P"},
+		{name: "mixed case", addr: "tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq", testnet: true},
+		{name: "padding over 4 bits", addr: "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf"},
+		{name: "non-zero padding", addr: "tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j", testnet: true},
+		{name: "empty data", addr: "bc1gmk9yu"},
+	}
+
+	for _, tt := range tests {
+		script, err := AddressScript(tt.addr, tt.testnet)
+		if tt.script == "" {
+			if err != ErrBadAddress {
+				t.Errorf("%s: script %x, %v, want %v", tt.name, script, err, ErrBadAddress)
+			}
+			continue
+		}
+
+		if err != nil || hex.EncodeToString(script) != tt.script {
+			t.Errorf("%s: script %x, %v, want %v", tt.name, script, err, tt.script)
+		}
+	}
+}
+
+func TestIsValidAddress(t *testing.T) {
+	tests := []struct {
+		addr           string
+		valid, testnet bool
+	}{
+		{addr: "t1KzZ5n2TPEGYXTZ3WYGL1AYEumEQaRoHaL", valid: true},
+		{addr: "t3Vz22vK5z2LcKEdg16Yv4FFneEL1zg9ojd", valid: true},
+		{addr: "tm9iNYCVAhLLa4rJtfqqHauR5xL1REdpiDs", valid: true, testnet: true},
+		{addr: "t2UNzUUx8mWBCRYPRezvA363EYXyEpHokyi", valid: true, testnet: true},
+		{addr: "zc8E5gYid86n4bo2Usdq1cpr7PpfoJGzttwBHEEgGhGkLUg7SPPVFNB2AkRFXZ7usfphup5426dt1buMmY3fkYeRrQGLa8y", valid: true},
+		{addr: "zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp5", valid: true},
+		{addr: "ztestsapling1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5sum0xq", valid: true, testnet: true},
+		// 42 bytes.
+		{addr: "zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5s23zcqp"},
+		{addr: "zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp6"},
+		{addr: "t1KzZ5n2TPEGYXTZ3WYGL1AYEumEQaRoHaM"},
+		{addr: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"},
+		{addr: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{addr: ""},
+	}
+
+	for _, tt := range tests {
+		valid, testnet := IsValidAddress(tt.addr)
+		if valid != tt.valid || testnet != tt.testnet {
+			t.Errorf("%s: valid %v, testnet %v, want %v, %v", tt.addr, valid, testnet, tt.valid, tt.testnet)
+		}
+	}
+}
+
+func TestZcashAddressScript(t *testing.T) {
+	tests := []struct {
+		addr    string
+		testnet bool
+		script  string
+		err     error
+	}{
+		{addr: "t1KzZ5n2TPEGYXTZ3WYGL1AYEumEQaRoHaL", script: "76a9141740b913475f30fba22429d7e28593a739371faf88ac"},
+		{addr: "t3Vz22vK5z2LcKEdg16Yv4FFneEL1zg9ojd", script: "a9147d46a730d31f97b1930d3368a967c309bd4d136a87"},
+		{addr: "t2UNzUUx8mWBCRYPRezvA363EYXyEpHokyi", testnet: true, script: "a914ef775f1f997f122a062fff1a2d7443abd1f9c64287"},
+		{addr: "t2UNzUUx8mWBCRYPRezvA363EYXyEpHokyi", err: ErrBadAddress},
+		{addr: "t1KzZ5n2TPEGYXTZ3WYGL1AYEumEQaRoHaL", testnet: true, err: ErrBadAddress},
+		{addr: "zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp5", err: ErrShieldedAddress},
+		{addr: "zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp5", testnet: true, err: ErrBadAddress},
+		// Sprout can't be paid since Canopy.
+		{addr: "zc8E5gYid86n4bo2Usdq1cpr7PpfoJGzttwBHEEgGhGkLUg7SPPVFNB2AkRFXZ7usfphup5426dt1buMmY3fkYeRrQGLa8y", err: ErrBadAddress},
+		{addr: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", err: ErrBadAddress},
+	}
+
+	for _, tt := range tests {
+		script, err := ZcashAddressScript(tt.addr, tt.testnet)
+		if err != tt.err || hex.EncodeToString(script) != tt.script {
+			t.Errorf("%s (testnet %v): script %x, %v, want %v, %v", tt.addr, tt.testnet, script, err, tt.script, tt.err)
+		}
+	}
+}
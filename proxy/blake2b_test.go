@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestBlake2b(t *testing.T) {
+	count := make([]byte, 200)
+	for i := range count {
+		count[i] = byte(i)
+	}
+
+	tests := []struct {
+		size     int
+		personal string
+		data     []byte
+		want     string
+	}{
+		// RFC 7693
+		{size: 64, data: []byte("abc"), want: "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{size: 32, personal: "ZcashBlockCommit", want: "56c8c342c9b3883a710ecaed681b0a3d9fbf1d160e2829c6d2e720ed759efd84"},
+		// A full last block, and more than one.
+		{size: 32, personal: "ZcashAuthDatHash", data: make([]byte, 128), want: "8bc2f381434842c987abc3bfa0384af58c9caf40a29f29dca6790a558b1f3fc3"},
+		{size: 32, personal: "ZcashAuthDatHash", data: count, want: "13f56700d2428b1b38ef6044a784f330aa6fd26830b087f8246a33a49395d5dd"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(blake2b(tt.size, tt.personal, tt.data)); got != tt.want {
+			t.Errorf("%d bytes of %q: got %v, want %v", len(tt.data), tt.personal, got, tt.want)
+		}
+	}
+}
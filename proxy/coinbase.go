@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// Coinbase is a SHA256d coinbase transaction the proxy builds for solo work,
+// split around the extranonce as in mining.notify.
+type Coinbase struct {
+	Height  int
+	Tag     []byte
+	Outputs []TxOut
+
+	// Bytes of nonce1 and nonce2 together.
+	ExtranonceSize int
+}
+
+// Coinbase script is limited to 100 bytes by consensus.
+const maxCoinbaseScript = 100
+
+// Split serialises the coinbase without witness, returning the parts before
+// and after the extranonce.
+func (c Coinbase) Split() (coinbase1, coinbase2 []byte, err error) {
+	height := scriptNumber(c.Height)
+	tag := c.Tag
+	if room := maxCoinbaseScript - len(height) - c.ExtranonceSize; len(tag) > room {
+		if room < 0 {
+			return nil, nil, ErrBadCoinbase
+		}
+		tag = tag[:room]
+	}
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint32(1)) // version
+	writeCompactSize(&b, 1)
+	_, _ = b.Write(make([]byte, 32))                              // previous output hash
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0xffffffff)) // and index
+	writeCompactSize(&b, uint64(len(height)+len(tag)+c.ExtranonceSize))
+	_, _ = b.Write(height)
+	_, _ = b.Write(tag)
+	coinbase1 = append([]byte{}, b.Bytes()...)
+
+	b.Reset()
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0xffffffff)) // sequence
+	writeCompactSize(&b, uint64(len(c.Outputs)))
+	for _, out := range c.Outputs {
+		_ = binary.Write(&b, binary.LittleEndian, out.Value)
+		writeCompactSize(&b, uint64(len(out.Script)))
+		_, _ = b.Write(out.Script)
+	}
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0)) // lock time
+
+	return coinbase1, b.Bytes(), nil
+}
+
+// scriptNumber pushes n the way BIP 34 requires the height.
+func scriptNumber(n int) []byte {
+	if n == 0 {
+		return []byte{0x00}
+	}
+
+	if n > 0 && n <= 16 {
+		return []byte{0x50 + byte(n)}
+	}
+
+	var num []byte
+	for v := uint64(n); v > 0; v >>= 8 {
+		num = append(num, byte(v))
+	}
+	if num[len(num)-1]&0x80 != 0 {
+		num = append(num, 0)
+	}
+
+	return append([]byte{byte(len(num))}, num...)
+}
+
+func writeCompactSize(b *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		_ = b.WriteByte(byte(n))
+	case n <= 0xffff:
+		_ = b.WriteByte(0xfd)
+		_ = binary.Write(b, binary.LittleEndian, uint16(n))
+	case n <= 0xffffffff:
+		_ = b.WriteByte(0xfe)
+		_ = binary.Write(b, binary.LittleEndian, uint32(n))
+	default:
+		_ = b.WriteByte(0xff)
+		_ = binary.Write(b, binary.LittleEndian, n)
+	}
+}
+
+// MerkleBranch returns the hashes mining.notify sends for the coinbase's
+// path to the merkle root, txids being the other transactions' IDs in
+// internal byte order.
+func MerkleBranch(txids []stratum.Uint256) []stratum.Uint256 {
+	var branch []stratum.Uint256
+
+	// The coinbase's place is taken by level[0], it's never hashed here.
+	level := append([]stratum.Uint256{{}}, txids...)
+	buf := make([]byte, 64)
+	for len(level) > 1 {
+		branch = append(branch, level[1])
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+
+		next := []stratum.Uint256{{}}
+		for i := 2; i < len(level); i += 2 {
+			copy(buf, level[i][:])
+			copy(buf[32:], level[i+1][:])
+			next = append(next, DoubleSHA256(buf))
+		}
+		level = next
+	}
+
+	return branch
+}
+
+// BitcoinBlock serialises the block a share of a solo job solves.
+func (w *Work) BitcoinBlock(share Share) []byte {
+	root := w.MerkleRoot(share.NoncePart1, share.NoncePart2)
+
+	var b bytes.Buffer
+	_, _ = b.Write(BuildBitcoinHeader(share.Version, w.HashPrevBlock, root, share.NTime, w.NBits, share.Nonce))
+	writeCompactSize(&b, uint64(1+len(w.Transactions)))
+
+	// version
+	_, _ = b.Write(w.Coinbase1[:4])
+	if w.CoinbaseWitness {
+		_, _ = b.Write([]byte{0x00, 0x01})
+	}
+	_, _ = b.Write(w.Coinbase1[4:])
+	_, _ = b.Write(share.NoncePart1)
+	_, _ = b.Write(share.NoncePart2)
+
+	// Outputs, then the witness reserved value before the lock time.
+	cb2 := w.Coinbase2
+	_, _ = b.Write(cb2[:len(cb2)-4])
+	if w.CoinbaseWitness {
+		writeCompactSize(&b, 1)
+		writeCompactSize(&b, 32)
+		_, _ = b.Write(make([]byte, 32))
+	}
+	_, _ = b.Write(cb2[len(cb2)-4:])
+
+	for _, tx := range w.Transactions {
+		_, _ = b.Write(tx)
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func TestScriptNumber(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{n: 0, want: "00"},
+		{n: 1, want: "51"},
+		{n: 16, want: "60"},
+		{n: 17, want: "0111"},
+		{n: 127, want: "017f"},
+		{n: 128, want: "028000"},
+		{n: 255, want: "02ff00"},
+		{n: 256, want: "020001"},
+		{n: 227836, want: "03fc7903"}, // BIP 34 activation
+		{n: 840000, want: "0340d10c"},
+		{n: 8388608, want: "0400008000"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(scriptNumber(tt.n)); got != tt.want {
+			t.Errorf("%d: got %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCompactSize(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{n: 0, want: "00"},
+		{n: 0xfc, want: "fc"},
+		{n: 0xfd, want: "fdfd00"},
+		{n: 0xffff, want: "fdffff"},
+		{n: 0x10000, want: "fe00000100"},
+		{n: 0xffffffff, want: "feffffffff"},
+		{n: 0x100000000, want: "ff0000000001000000"},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		writeCompactSize(&b, tt.n)
+		if got := hex.EncodeToString(b.Bytes()); got != tt.want {
+			t.Errorf("%#x: got %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCoinbaseSplit(t *testing.T) {
+	opTrue := []TxOut{{Value: 5000000000, Script: []byte{0x51}}}
+
+	tests := []struct {
+		name      string
+		coinbase  Coinbase
+		coinbase1 string
+		coinbase2 string
+		err       error
+	}{
+		{
+			name:      "tagged",
+			coinbase:  Coinbase{Height: 1, Tag: []byte("/x/"), Outputs: opTrue, ExtranonceSize: 8},
+			coinbase1: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0c512f782f",
+			coinbase2: "ffffffff0100f2052a01000000015100000000",
+		},
+		{
+			name:      "two outputs",
+			coinbase:  Coinbase{Height: 840000, Outputs: append(opTrue, TxOut{Script: []byte{0x6a}}), ExtranonceSize: 8},
+			coinbase1: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0c0340d10c",
+			coinbase2: "ffffffff0200f2052a0100000001510000000000000000016a00000000",
+		},
+		{
+			name:      "tag cut to the script limit",
+			coinbase:  Coinbase{Height: 1, Tag: bytes.Repeat([]byte{'x'}, 200), Outputs: opTrue, ExtranonceSize: 8},
+			coinbase1: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff6451" + hex.EncodeToString(bytes.Repeat([]byte{'x'}, 91)),
+			coinbase2: "ffffffff0100f2052a01000000015100000000",
+		},
+		{
+			name:     "extranonce too large",
+			coinbase: Coinbase{Height: 840000, Outputs: opTrue, ExtranonceSize: 100},
+			err:      ErrBadCoinbase,
+		},
+	}
+
+	for _, tt := range tests {
+		coinbase1, coinbase2, err := tt.coinbase.Split()
+		if err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+
+		if got := hex.EncodeToString(coinbase1); got != tt.coinbase1 {
+			t.Errorf("%s: coinbase1 %v, want %v", tt.name, got, tt.coinbase1)
+		}
+		if got := hex.EncodeToString(coinbase2); got != tt.coinbase2 {
+			t.Errorf("%s: coinbase2 %v, want %v", tt.name, got, tt.coinbase2)
+		}
+	}
+}
+
+func TestMerkleBranch(t *testing.T) {
+	txid := func(s string) stratum.Uint256 {
+		// Display order, the reverse of the internal one.
+		h, err := stratum.LittleEndian.Uint256(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	// Block 100000.
+	coinbase := txid("8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87")
+	txids := []stratum.Uint256{
+		txid("fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4"),
+		txid("6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4"),
+		txid("e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"),
+	}
+	root := txid("f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766")
+
+	tests := []struct {
+		name   string
+		txids  []stratum.Uint256
+		branch int
+		root   stratum.Uint256
+	}{
+		{name: "coinbase only", root: coinbase},
+		{name: "block 100000", txids: txids, branch: 2, root: root},
+		// An odd level pairs its last hash with itself.
+		{name: "odd", txids: txids[:2], branch: 2},
+		{name: "five", txids: append(append([]stratum.Uint256{}, txids...), txids[:2]...), branch: 3},
+	}
+
+	for _, tt := range tests {
+		branch := MerkleBranch(tt.txids)
+		if len(branch) != tt.branch {
+			t.Errorf("%s: %d hashes, want %d", tt.name, len(branch), tt.branch)
+			continue
+		}
+
+		h := coinbase
+		for _, b := range branch {
+			h = DoubleSHA256(append(append([]byte{}, h[:]...), b[:]...))
+		}
+		if tt.root != (stratum.Uint256{}) && h != tt.root {
+			t.Errorf("%s: root %x, want %x", tt.name, h, tt.root)
+		}
+		if tt.txids != nil && branch[0] != tt.txids[0] {
+			t.Errorf("%s: branch starts with %x, want the first txid", tt.name, branch[0])
+		}
+	}
+}
+
+func TestBitcoinBlock(t *testing.T) {
+	w := block1Work(t)
+
+	// Split block 1's coinbase around two bytes of its script as the
+	// extranonce.
+	coinbase := w.Coinbase1
+	w.Coinbase1, w.Coinbase2 = coinbase[:47], coinbase[49:]
+	share := Share{NTime: w.NTime, NoncePart1: coinbase[47:48], NoncePart2: coinbase[48:49], Nonce: 0x9962e301, Version: 1}
+
+	header := "010000006fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000982051fd1e4ba744bbbe680e1fee14677ba1a3c3540bf7b1cdb606e857233e0e61bc6649ffff001d01e36299"
+	want := header + "01" + hex.EncodeToString(coinbase)
+	if got := hex.EncodeToString(w.BitcoinBlock(share)); got != want {
+		t.Errorf("block 1\ngot  %v\nwant %v", got, want)
+	}
+
+	// With a witness commitment the coinbase gets its reserved value, and
+	// the other transactions follow.
+	w.CoinbaseWitness = true
+	w.Transactions = [][]byte{{0xaa, 0xbb}}
+	block := w.BitcoinBlock(share)
+
+	body := hex.EncodeToString(block[80:])
+	coinbaseHex := hex.EncodeToString(coinbase)
+	want = "02" + coinbaseHex[:8] + "0001" + coinbaseHex[8:len(coinbaseHex)-8] +
+		"0120" + hex.EncodeToString(make([]byte, 32)) + "00000000" + "aabb"
+	if body != want {
+		t.Errorf("witness block\ngot  %v\nwant %v", body, want)
+	}
+
+	// The header, and so the block hash, ignores the witness.
+	if got := hex.EncodeToString(block[:80]); got != header {
+		t.Errorf("witness block header %v", got)
+	}
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"errors"
+	"math"
+	"math/big"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// Difficulty is a share target relative to its algorithm's difficulty 1,
+// fractional below it.
+type Difficulty float64
+
+// Algorithm is a proof of work, setting what difficulty 1 is.
+type Algorithm string
+
+const (
+	SHA256d  Algorithm = "sha256d"
+	Equihash Algorithm = "equihash"
+	Scrypt   Algorithm = "scrypt"
+)
+
+// Difficulty 1 targets as pools use them.
+var (
+	diff1SHA256d  = diff1("00000000ffff0000000000000000000000000000000000000000000000000000")
+	diff1Equihash = diff1("0007ffff00000000000000000000000000000000000000000000000000000000")
+	diff1Scrypt   = diff1("0000ffff00000000000000000000000000000000000000000000000000000000")
+
+	maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+	ErrCompactNegative = errors.New("negative compact target")
+	ErrCompactOverflow = errors.New("compact target overflows 256 bits")
+)
+
+func diff1(s string) *big.Int {
+	x, _ := new(big.Int).SetString(s, 16)
+	return x
+}
+
+// DialectAlgorithm is the proof of work mined in a stratum dialect.
+func DialectAlgorithm(d stratum.Dialect) Algorithm {
+	if d.IsBitcoin() {
+		return SHA256d
+	}
+
+	return Equihash
+}
+
+// Diff1 returns the algorithm's difficulty 1 target, SHA256d's when unknown.
+func (a Algorithm) Diff1() *big.Int {
+	switch a {
+	case Equihash:
+		return new(big.Int).Set(diff1Equihash)
+	case Scrypt:
+		return new(big.Int).Set(diff1Scrypt)
+	default:
+		return new(big.Int).Set(diff1SHA256d)
+	}
+}
+
+// ToTarget returns the highest hash meeting the difficulty, difficulty 1
+// for zero and anything that isn't a positive number.
+func (d Difficulty) ToTarget(algo Algorithm) stratum.Uint256 {
+	diff1 := algo.Diff1()
+
+	f := float64(d)
+	if !(f > 0) || math.IsInf(f, 0) {
+		return targetFromInt(diff1)
+	}
+
+	// float64s are exact rationals, so only the final division rounds.
+	return RatTarget(new(big.Rat).SetFloat64(f), algo)
+}
+
+// FromTarget returns the difficulty of a target, to float64 precision.
+func FromTarget(target stratum.Uint256, algo Algorithm) Difficulty {
+	d := TargetRat(target, algo)
+	if d == nil {
+		return math.MaxFloat64
+	}
+
+	f, _ := d.Float64()
+	return Difficulty(f)
+}
+
+// TargetRat returns the exact difficulty of a target, nil for a zero
+// target. RatTarget gives the target back unchanged.
+func TargetRat(target stratum.Uint256, algo Algorithm) *big.Rat {
+	t := target.ToInteger()
+	if t.Sign() == 0 {
+		return nil
+	}
+
+	return new(big.Rat).SetFrac(algo.Diff1(), t)
+}
+
+// RatTarget returns the highest hash meeting a positive exact difficulty.
+func RatTarget(d *big.Rat, algo Algorithm) stratum.Uint256 {
+	q := new(big.Rat).SetInt(algo.Diff1())
+	q.Quo(q, d)
+
+	return targetFromInt(new(big.Int).Quo(q.Num(), q.Denom()))
+}
+
+// targetFromInt saturates at the highest target.
+func targetFromInt(x *big.Int) stratum.Uint256 {
+	var result stratum.Uint256
+	if x.Cmp(maxTarget) > 0 {
+		x = maxTarget
+	}
+
+	x.FillBytes(result[:])
+	return result
+}
+
+// CompactToTarget decodes an nBits value as consensus does: a byte size,
+// a sign bit and a 23 bit mantissa. Negative and oversized targets, which
+// no block can meet, are errors.
+func CompactToTarget(bits uint32) (stratum.Uint256, error) {
+	size := bits >> 24
+	word := bits & 0x007fffff
+
+	// Sizes below three shift mantissa bytes out before the checks.
+	if size <= 3 {
+		word >>= 8 * (3 - size)
+	}
+
+	if word != 0 && bits&0x00800000 != 0 {
+		return stratum.Uint256{}, ErrCompactNegative
+	}
+
+	if word != 0 && (size > 34 || (word > 0xff && size > 33) || (word > 0xffff && size > 32)) {
+		return stratum.Uint256{}, ErrCompactOverflow
+	}
+
+	x := big.NewInt(int64(word))
+	if size > 3 {
+		x.Lsh(x, uint(8*(size-3)))
+	}
+
+	return targetFromInt(x), nil
+}
+
+// TargetToCompact encodes a target as nBits, dropping all but its top
+// three significant bytes.
+func TargetToCompact(target stratum.Uint256) uint32 {
+	x := target.ToInteger()
+	size := uint32((x.BitLen() + 7) / 8)
+
+	var word uint32
+	if size <= 3 {
+		word = uint32(x.Uint64() << (8 * (3 - size)))
+	} else {
+		word = uint32(new(big.Int).Rsh(x, uint(8*(size-3))).Uint64())
+	}
+
+	// The mantissa's top bit is the sign, move it into the next byte.
+	if word&0x00800000 != 0 {
+		word >>= 8
+		size++
+	}
+
+	return size<<24 | word
+}
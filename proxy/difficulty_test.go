@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+var algorithms = []Algorithm{SHA256d, Equihash, Scrypt}
+
+func mustTarget(t *testing.T, s string) stratum.Uint256 {
+	t.Helper()
+
+	target, err := stratum.HexToUint256(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+func TestDifficultyTargets(t *testing.T) {
+	tests := []struct {
+		algo   Algorithm
+		d      Difficulty
+		target string
+	}{
+		{SHA256d, 1, "00000000ffff0000000000000000000000000000000000000000000000000000"},
+		{SHA256d, 2, "000000007fff8000000000000000000000000000000000000000000000000000"},
+		{SHA256d, 0.5, "00000001fffe0000000000000000000000000000000000000000000000000000"},
+		{SHA256d, 65536, "000000000000ffff000000000000000000000000000000000000000000000000"},
+		{Equihash, 1, "0007ffff00000000000000000000000000000000000000000000000000000000"},
+		{Equihash, 0.25, "001ffffc00000000000000000000000000000000000000000000000000000000"},
+		{Equihash, 1.0 / 8192, "ffffe00000000000000000000000000000000000000000000000000000000000"},
+		// Saturated at the highest target.
+		{Equihash, 1.0 / 16384, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{Scrypt, 1, "0000ffff00000000000000000000000000000000000000000000000000000000"},
+		{Scrypt, 1.0 / 65536, "ffff000000000000000000000000000000000000000000000000000000000000"},
+		// Anything but a positive number is difficulty 1.
+		{SHA256d, 0, "00000000ffff0000000000000000000000000000000000000000000000000000"},
+		{Equihash, -3, "0007ffff00000000000000000000000000000000000000000000000000000000"},
+		{Scrypt, Difficulty(math.NaN()), "0000ffff00000000000000000000000000000000000000000000000000000000"},
+		{SHA256d, Difficulty(math.Inf(1)), "00000000ffff0000000000000000000000000000000000000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		if got := stratum.ToHex(tt.d.ToTarget(tt.algo)); got != tt.target {
+			t.Errorf("%s difficulty %v: target %s, want %s", tt.algo, tt.d, got, tt.target)
+		}
+	}
+}
+
+func TestDifficultyRoundTrip(t *testing.T) {
+	difficulties := []Difficulty{1e-9, 0.001, 1.0 / 3, 0.5, 1, 3, 1024, 123456.789, 1e6, 1 << 40, 5e12}
+
+	for _, algo := range algorithms {
+		// A difficulty's target gives it back to float64 precision, unless
+		// the target saturated.
+		previous := stratum.Uint256{}
+		for i := range previous {
+			previous[i] = 0xff
+		}
+
+		for _, d := range difficulties {
+			target := d.ToTarget(algo)
+			if TargetCompare(target, previous) > 0 {
+				t.Errorf("%s: difficulty %v has a higher target than an easier one", algo, d)
+			}
+			previous = target
+
+			back := FromTarget(target, algo)
+			if target[0] == 0xff {
+				if back < d {
+					t.Errorf("%s: saturated difficulty %v came back as %v", algo, d, back)
+				}
+				continue
+			}
+
+			if math.Abs(float64(back-d))/float64(d) > 1e-12 {
+				t.Errorf("%s: difficulty %v came back as %v", algo, d, back)
+			}
+		}
+
+		if d := FromTarget(stratum.Uint256{}, algo); d != math.MaxFloat64 {
+			t.Errorf("%s: zero target has difficulty %v", algo, d)
+		}
+		if d := FromTarget(targetFromInt(algo.Diff1()), algo); d != 1 {
+			t.Errorf("%s: difficulty 1 target has difficulty %v", algo, d)
+		}
+	}
+}
+
+func TestTargetRatRoundTrip(t *testing.T) {
+	targets := []string{
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"00000000ffff0000000000000000000000000000000000000000000000000000",
+		"0007ffff00000000000000000000000000000000000000000000000000000000",
+		// Too many significant bits for a float64.
+		"00000000000000000005b9ac0000000000000000000000000000000000000001",
+		"000000003a4e9c7d1f2b6e8a9c0d1e2f3a4b5c6d7e8f90a1b2c3d4e5f6a7b8c9",
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+	}
+
+	for _, algo := range algorithms {
+		for _, s := range targets {
+			target := mustTarget(t, s)
+
+			d := TargetRat(target, algo)
+			if got := RatTarget(d, algo); got != target {
+				t.Errorf("%s: target %s came back as %x", algo, s, got)
+			}
+
+			// The float64 difficulty only gets close.
+			f, _ := d.Float64()
+			if FromTarget(target, algo) != Difficulty(f) {
+				t.Errorf("%s: target %s has difficulty %v, want %v", algo, s, FromTarget(target, algo), f)
+			}
+		}
+
+		if d := TargetRat(stratum.Uint256{}, algo); d != nil {
+			t.Errorf("%s: zero target has difficulty %v", algo, d)
+		}
+
+		// Sub-1 difficulties are exact too.
+		third := big.NewRat(1, 3)
+		target := RatTarget(third, algo)
+		want := new(big.Int).Mul(algo.Diff1(), big.NewInt(3))
+		if target.ToInteger().Cmp(want) != 0 && target[0] != 0xff {
+			t.Errorf("%s: difficulty 1/3 target %x", algo, target)
+		}
+		if target[0] != 0xff && TargetRat(target, algo).Cmp(third) != 0 {
+			t.Errorf("%s: difficulty 1/3 came back as %v", algo, TargetRat(target, algo))
+		}
+	}
+}
+
+func TestCompactToTarget(t *testing.T) {
+	zeros := func(n int) string { return strings.Repeat("00", n) }
+
+	tests := []struct {
+		name    string
+		bits    uint32
+		target  string
+		compact uint32
+		err     error
+	}{
+		// Real headers.
+		{name: "bitcoin genesis", bits: 0x1d00ffff, target: "00000000ffff" + zeros(26), compact: 0x1d00ffff},
+		{name: "bitcoin block 840000", bits: 0x17034219, target: zeros(9) + "034219" + zeros(20), compact: 0x17034219},
+		{name: "bitcoin regtest", bits: 0x207fffff, target: "7fffff" + zeros(29), compact: 0x207fffff},
+		{name: "zcash genesis", bits: 0x1f07ffff, target: "0007ffff" + zeros(28), compact: 0x1f07ffff},
+		{name: "zcash testnet genesis", bits: 0x2007ffff, target: "07ffff" + zeros(29), compact: 0x2007ffff},
+
+		// Bitcoin Core's test vectors.
+		{name: "zero", bits: 0, target: zeros(32)},
+		{name: "size 0", bits: 0x00123456, target: zeros(32)},
+		{name: "size 1 shifted out", bits: 0x01003456, target: zeros(32)},
+		{name: "size 2 shifted out", bits: 0x02000056, target: zeros(32)},
+		{name: "size 3 zero", bits: 0x03000000, target: zeros(32)},
+		{name: "size 4 zero", bits: 0x04000000, target: zeros(32)},
+		{name: "zero with the sign bit", bits: 0x00923456, target: zeros(32)},
+		{name: "size 1 zero with the sign bit", bits: 0x01803456, target: zeros(32)},
+		{name: "size 2 zero with the sign bit", bits: 0x02800056, target: zeros(32)},
+		{name: "size 3 negative zero", bits: 0x03800000, target: zeros(32)},
+		{name: "size 4 negative zero", bits: 0x04800000, target: zeros(32)},
+		{name: "size 1", bits: 0x01123456, target: zeros(31) + "12", compact: 0x01120000},
+		{name: "size 2", bits: 0x02123456, target: zeros(30) + "1234", compact: 0x02123400},
+		{name: "size 3", bits: 0x03123456, target: zeros(29) + "123456", compact: 0x03123456},
+		{name: "size 4", bits: 0x04123456, target: zeros(28) + "12345600", compact: 0x04123456},
+		{name: "mantissa with a leading zero byte", bits: 0x05009234, target: zeros(28) + "92340000", compact: 0x05009234},
+		{name: "size 32", bits: 0x20123456, target: "123456" + zeros(29), compact: 0x20123456},
+		{name: "size 34 single byte", bits: 0x220000ff, target: "ff" + zeros(31), compact: 0x2100ff00},
+		{name: "size 33 two bytes", bits: 0x2100ffff, target: "ffff" + zeros(30), compact: 0x2100ffff},
+		{name: "size 1 negative", bits: 0x01fedcba, err: ErrCompactNegative},
+		{name: "size 4 negative", bits: 0x04923456, err: ErrCompactNegative},
+		{name: "overflow", bits: 0xff123456, err: ErrCompactOverflow},
+		{name: "size 33 three bytes", bits: 0x21010000, err: ErrCompactOverflow},
+		{name: "size 34 two bytes", bits: 0x22000100, err: ErrCompactOverflow},
+		{name: "size 35", bits: 0x23000001, err: ErrCompactOverflow},
+	}
+
+	for _, tt := range tests {
+		target, err := CompactToTarget(tt.bits)
+		if tt.err != nil {
+			if err != tt.err {
+				t.Errorf("%s: got %x, %v, want %v", tt.name, target, err, tt.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := stratum.ToHex(target); got != tt.target {
+			t.Errorf("%s: target %s, want %s", tt.name, got, tt.target)
+		}
+
+		if compact := TargetToCompact(target); compact != tt.compact {
+			t.Errorf("%s: compact %08x, want %08x", tt.name, compact, tt.compact)
+		}
+	}
+}
+
+func TestTargetToCompact(t *testing.T) {
+	tests := []struct {
+		target  string
+		compact uint32
+	}{
+		// The mantissa's sign bit moves into another byte.
+		{"80", 0x02008000},
+		{"800000", 0x04008000},
+		{"ffff", 0x0300ffff},
+		// Digits below the top three bytes are dropped.
+		{"123456789a", 0x05123456},
+		{"00000000ffff0000000000000000000000000000000000000000000000000001", 0x1d00ffff},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", 0x2100ffff},
+	}
+
+	for _, tt := range tests {
+		target := mustTarget(t, tt.target)
+		if compact := TargetToCompact(target); compact != tt.compact {
+			t.Errorf("%s: compact %08x, want %08x", tt.target, compact, tt.compact)
+		}
+	}
+}
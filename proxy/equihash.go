@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Equihash solutions are verified as zcashd does (Zcash protocol spec,
+// section 7.6.1), so shares with made up solutions are turned down here
+// rather than by the pool or node.
+
+// EquihashSolutionSize is the size of a minimal n,k solution, 1344 bytes
+// for Zcash's 200,9.
+func EquihashSolutionSize(n, k int) int {
+	return (1 << uint(k)) * (n/(k+1) + 1) / 8
+}
+
+// equihashParameters reports whether n,k are parameters we can verify:
+// BLAKE2b gives at least one n bit hash, and indices fit a uint32.
+func equihashParameters(n, k int) bool {
+	return n > 0 && k > 0 && n%8 == 0 && n%(k+1) == 0 && n <= 512 &&
+		k < n/(k+1) && n/(k+1)+1 < 32 && 512/n*n/8 <= 64
+}
+
+// equihashPersonal is the BLAKE2b personalisation of Equihash n,k.
+func equihashPersonal(n, k int) string {
+	p := make([]byte, 16)
+	copy(p, "ZcashPoW")
+	binary.LittleEndian.PutUint32(p[8:], uint32(n))
+	binary.LittleEndian.PutUint32(p[12:], uint32(k))
+
+	return string(p)
+}
+
+// VerifyEquihash reports whether solution solves Equihash n,k for header,
+// the block header up to and including the nonce.
+func VerifyEquihash(n, k int, header, solution []byte) bool {
+	if !equihashParameters(n, k) || len(solution) != EquihashSolutionSize(n, k) {
+		return false
+	}
+
+	bitLen := n / (k + 1)
+	indices := expandBits(solution, bitLen+1)
+
+	sorted := append([]uint32(nil), indices...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] == sorted[i-1] {
+			return false
+		}
+	}
+
+	// Each BLAKE2b output gives perHash indices' n bit hashes, split into
+	// k+1 collision chunks.
+	perHash := uint32(512 / n)
+	personal := equihashPersonal(n, k)
+	input := make([]byte, len(header)+4)
+	copy(input, header)
+
+	rows := make([]equihashRow, len(indices))
+	for i, index := range indices {
+		binary.LittleEndian.PutUint32(input[len(header):], index/perHash)
+		hash := blake2b(int(perHash)*n/8, personal, input)
+		start := int(index%perHash) * n / 8
+		rows[i] = equihashRow{chunks: expandBits(hash[start:start+n/8], bitLen), first: index}
+	}
+
+	// Pairs collide on the next chunk, the left one first, up the tree.
+	for level := 0; level < k; level++ {
+		for i := 0; i < len(rows); i += 2 {
+			left, right := rows[i], rows[i+1]
+			if left.chunks[level] != right.chunks[level] || right.first < left.first {
+				return false
+			}
+
+			for j := range left.chunks {
+				left.chunks[j] ^= right.chunks[j]
+			}
+			rows[i/2] = left
+		}
+		rows = rows[:len(rows)/2]
+	}
+
+	return rows[0].chunks[k] == 0
+}
+
+// equihashRow is the xor of a subtree's hashes and its first index.
+type equihashRow struct {
+	chunks []uint32
+	first  uint32
+}
+
+// expandBits splits big-endian data into size bit numbers.
+func expandBits(data []byte, size int) []uint32 {
+	out := make([]uint32, 0, len(data)*8/size)
+	mask := uint64(1)<<uint(size) - 1
+
+	var acc uint64
+	var bits int
+	for _, b := range data {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		if bits >= size {
+			bits -= size
+			out = append(out, uint32(acc>>uint(bits)&mask))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// Equihash 48,5 is small enough to solve here.
+const (
+	testEquihashN = 48
+	testEquihashK = 5
+)
+
+// solveEquihash finds the n,k solutions of header with Wagner's algorithm,
+// as ordered index lists.
+func solveEquihash(n, k int, header []byte) [][]uint32 {
+	type row struct {
+		chunks  []uint32
+		indices []uint32
+	}
+
+	bitLen := n / (k + 1)
+	perHash := 512 / n
+	input := make([]byte, len(header)+4)
+	copy(input, header)
+
+	var rows []row
+	for i := 0; i < 1<<uint(bitLen+1); i++ {
+		binary.LittleEndian.PutUint32(input[len(header):], uint32(i/perHash))
+		hash := blake2b(perHash*n/8, equihashPersonal(n, k), input)
+		start := i % perHash * n / 8
+		rows = append(rows, row{chunks: expandBits(hash[start:start+n/8], bitLen), indices: []uint32{uint32(i)}})
+	}
+
+	distinct := func(a, b []uint32) bool {
+		for _, x := range a {
+			for _, y := range b {
+				if x == y {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	for level := 0; level < k; level++ {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].chunks[level] < rows[j].chunks[level] })
+
+		var next []row
+		for i := range rows {
+			for j := i + 1; j < len(rows) && rows[j].chunks[level] == rows[i].chunks[level]; j++ {
+				a, b := rows[i], rows[j]
+				if !distinct(a.indices, b.indices) {
+					continue
+				}
+				if b.indices[0] < a.indices[0] {
+					a, b = b, a
+				}
+
+				chunks := make([]uint32, len(a.chunks))
+				for c := range chunks {
+					chunks[c] = a.chunks[c] ^ b.chunks[c]
+				}
+				next = append(next, row{chunks: chunks, indices: append(append([]uint32(nil), a.indices...), b.indices...)})
+			}
+		}
+		rows = next
+	}
+
+	var solutions [][]uint32
+	for _, r := range rows {
+		if r.chunks[k] == 0 {
+			solutions = append(solutions, r.indices)
+		}
+	}
+
+	return solutions
+}
+
+// compressBits packs numbers of size bits big-endian, as solutions are.
+func compressBits(values []uint32, size int) []byte {
+	var out []byte
+	var acc uint64
+	var bits int
+	for _, v := range values {
+		acc = acc<<uint(size) | uint64(v)
+		bits += size
+		for bits >= 8 {
+			bits -= 8
+			out = append(out, byte(acc>>uint(bits)))
+		}
+	}
+
+	return out
+}
+
+// testEquihashShare finds a nonce for which w's header has a 48,5
+// solution.
+func testEquihashShare(t *testing.T, w *Work) Share {
+	t.Helper()
+
+	share := Share{NTime: w.NTime, NoncePart1: make([]byte, 16), NoncePart2: make([]byte, 16)}
+	for nonce := uint32(0); nonce < 100; nonce++ {
+		binary.LittleEndian.PutUint32(share.NoncePart2, nonce)
+		header := BuildBlockHeader(w.Version, w.HashPrevBlock[:], w.HashMerkleRoot[:], w.HashReserved[:], share.NTime, w.NBits, share.NoncePart1, share.NoncePart2)
+		if solutions := solveEquihash(testEquihashN, testEquihashK, header.Bytes()); len(solutions) > 0 {
+			share.Solution = compressBits(solutions[0], testEquihashN/(testEquihashK+1)+1)
+			return share
+		}
+	}
+
+	t.Fatal("no solution")
+	return share
+}
+
+func TestVerifyEquihash(t *testing.T) {
+	if size := EquihashSolutionSize(EquihashN, EquihashK); size != 1344 || !equihashParameters(EquihashN, EquihashK) {
+		t.Errorf("zcash solution size %d", size)
+	}
+
+	w := zcashGenesisWork(t)
+	share := testEquihashShare(t, w)
+	header := BuildBlockHeader(w.Version, w.HashPrevBlock[:], w.HashMerkleRoot[:], w.HashReserved[:], share.NTime, w.NBits, share.NoncePart1, share.NoncePart2).Bytes()
+	indices := expandBits(share.Solution, testEquihashN/(testEquihashK+1)+1)
+
+	modified := func(f func(indices []uint32)) []byte {
+		indices := append([]uint32(nil), indices...)
+		f(indices)
+		return compressBits(indices, testEquihashN/(testEquihashK+1)+1)
+	}
+
+	// zcashd's first 96,5 test vector.
+	vectorHeader := append([]byte("block header"), make([]byte, 32)...)
+	vector := compressBits([]uint32{
+		976, 126621, 100174, 123328, 38477, 105390, 38834, 90500, 6411, 116489, 51107, 129167, 25557, 92292, 38525, 56514,
+		1110, 98024, 15426, 74455, 3185, 84007, 24328, 36473, 17427, 129451, 27556, 119967, 31704, 62448, 110460, 117894,
+	}, 96/6+1)
+
+	tests := []struct {
+		name     string
+		n, k     int
+		header   []byte
+		solution []byte
+		want     bool
+	}{
+		{name: "zcashd vector", n: 96, k: 5, header: vectorHeader, solution: vector, want: true},
+		{name: "zcashd vector of another nonce", n: 96, k: 5, header: append([]byte("block header"), append([]byte{1}, make([]byte, 31)...)...), solution: vector},
+		{name: "solution", n: testEquihashN, k: testEquihashK, header: header, solution: share.Solution, want: true},
+		{name: "other header", n: testEquihashN, k: testEquihashK, header: append([]byte{1}, header[1:]...), solution: share.Solution},
+		{name: "other parameters", n: 96, k: 5, header: header, solution: share.Solution},
+		{name: "unsupported parameters", n: 50, k: 4, header: header, solution: share.Solution},
+		{name: "short", n: testEquihashN, k: testEquihashK, header: header, solution: share.Solution[1:]},
+		{name: "zeros", n: testEquihashN, k: testEquihashK, header: header, solution: make([]byte, len(share.Solution))},
+		{
+			name: "pair swapped", n: testEquihashN, k: testEquihashK, header: header,
+			solution: modified(func(i []uint32) { i[0], i[1] = i[1], i[0] }),
+		},
+		{
+			name: "halves swapped", n: testEquihashN, k: testEquihashK, header: header,
+			solution: modified(func(i []uint32) {
+				half := len(i) / 2
+				copy(i, append(append([]uint32(nil), i[half:]...), i[:half]...))
+			}),
+		},
+		{
+			name: "index repeated", n: testEquihashN, k: testEquihashK, header: header,
+			solution: modified(func(i []uint32) { i[3] = i[2] }),
+		},
+		{
+			name: "index changed", n: testEquihashN, k: testEquihashK, header: header,
+			solution: modified(func(i []uint32) { i[5] ^= 1 }),
+		},
+	}
+
+	for _, tt := range tests {
+		if got := VerifyEquihash(tt.n, tt.k, tt.header, tt.solution); got != tt.want {
+			t.Errorf("%s: verified %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEquihashCheck(t *testing.T) {
+	w := zcashGenesisWork(t)
+	w.N, w.K = testEquihashN, testEquihashK
+	share := testEquihashShare(t, w)
+
+	var easiest stratum.Uint256
+	for i := range easiest {
+		easiest[i] = 0xff
+	}
+
+	// The share's own ntime is hashed.
+	header := BuildBlockHeader(w.Version, w.HashPrevBlock[:], w.HashMerkleRoot[:], w.HashReserved[:], share.NTime, w.NBits, share.NoncePart1, share.NoncePart2)
+	header.WriteByte(byte(len(share.Solution)))
+	header.Write(share.Solution)
+	hash := w.ShareHash(share)
+	if hash != BlockHash(header.Bytes()) {
+		t.Errorf("share hash %v", stratum.ToHex(hash))
+	}
+
+	rolled := share
+	rolled.NTime++
+	wrong := share
+	wrong.Solution = bytes.Repeat([]byte{0xaa}, len(share.Solution))
+
+	tests := []struct {
+		name        string
+		share       Share
+		target      stratum.Uint256
+		blockTarget stratum.Uint256
+		want        ShareStatus
+	}{
+		{name: "share", share: share, target: easiest, want: ShareOK},
+		{name: "at the share target", share: share, target: hash, want: ShareOK},
+		{name: "low difficulty", share: share, target: stratum.Uint256{}, want: ShareInvalid},
+		{name: "block", share: share, target: stratum.Uint256{}, blockTarget: easiest, want: ShareBlock},
+		{name: "bad solution", share: wrong, target: easiest, blockTarget: easiest, want: ShareInvalidSolution},
+		// The solution is of the job's ntime.
+		{name: "other ntime", share: rolled, target: easiest, want: ShareInvalidSolution},
+	}
+
+	for _, tt := range tests {
+		w.Target = tt.blockTarget
+		if got := w.CheckShare(tt.share, tt.target); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
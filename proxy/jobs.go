@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type JobStatus string
+
+const (
+	JobCurrent JobStatus = "current"
+	// Superseded by a newer job on the same block, the pool may still
+	// take its shares.
+	JobStale JobStatus = "stale"
+	// Built on a block that is no longer the tip.
+	JobPreviousBlock JobStatus = "previous-block"
+	JobUnknown       JobStatus = "unknown"
+)
+
+const DefaultJobHistory = 16
+
+// MaxJobShares bounds the shares remembered per job so that a flood of
+// submissions can't grow the registry without limit.
+const MaxJobShares = 1 << 18
+
+type job struct {
+	work   *Work
+	shares map[[sha256.Size]byte]struct{}
+}
+
+// JobRegistry keeps the last jobs issued by a pool so that shares for
+// superseded jobs can still be checked, and the shares submitted on them to
+// catch duplicates.
+type JobRegistry struct {
+	size      int
+	maxShares int
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	order    []string
+	current  string
+	prevHash stratum.Uint256
+}
+
+// NewJobRegistry keeps the last size jobs, DefaultJobHistory when zero.
+func NewJobRegistry(size int) *JobRegistry {
+	if size <= 0 {
+		size = DefaultJobHistory
+	}
+
+	return &JobRegistry{
+		size:      size,
+		maxShares: MaxJobShares,
+		jobs:      make(map[string]*job),
+	}
+}
+
+// Add makes w the current job, evicting the oldest one when full. A job sent
+// again becomes the newest and keeps the shares already submitted on it.
+func (r *JobRegistry) Add(w *Work) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if j, ok := r.jobs[w.Job]; ok {
+		j.work = w
+		for i, id := range r.order {
+			if id == w.Job {
+				r.order = append(r.order[:i], r.order[i+1:]...)
+				break
+			}
+		}
+	} else {
+		r.jobs[w.Job] = &job{
+			work:   w,
+			shares: make(map[[sha256.Size]byte]struct{}),
+		}
+	}
+	r.order = append(r.order, w.Job)
+
+	for len(r.order) > r.size {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+
+	r.current = w.Job
+	r.prevHash = w.HashPrevBlock
+}
+
+// Lookup returns the job and how it stands against the current one.
+func (r *JobRegistry) Lookup(id string) (*Work, JobStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[id]
+	switch {
+	case !ok:
+		return nil, JobUnknown
+	case j.work.HashPrevBlock != r.prevHash:
+		return j.work, JobPreviousBlock
+	case id != r.current:
+		return j.work, JobStale
+	}
+
+	return j.work, JobCurrent
+}
+
+// Current returns the latest job, nil when there is none.
+func (r *JobRegistry) Current() *Work {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if j, ok := r.jobs[r.current]; ok {
+		return j.work
+	}
+
+	return nil
+}
+
+// Seen records a share on its job and returns whether it was submitted
+// before. The shares are forgotten with the job; once a job holds
+// MaxJobShares, further shares on it are reported as seen.
+func (r *JobRegistry) Seen(share Share) bool {
+	key := share.key()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[share.Job]
+	if !ok {
+		return false
+	}
+
+	if _, dup := j.shares[key]; dup || len(j.shares) >= r.maxShares {
+		return true
+	}
+
+	j.shares[key] = struct{}{}
+	return false
+}
+
+// Reset forgets every job, e.g. when the pool session they belong to ends.
+func (r *JobRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs = make(map[string]*job)
+	r.order = nil
+	r.current = ""
+}
+
+// key identifies a share within its job: the nonce1, nonce2, ntime and the
+// solution, or the nonce and version for SHA256d.
+func (s Share) key() [sha256.Size]byte {
+	h := sha256.New()
+	for _, part := range [][]byte{s.NoncePart1, s.NoncePart2, s.Solution} {
+		_ = binary.Write(h, binary.LittleEndian, uint32(len(part)))
+		_, _ = h.Write(part)
+	}
+	_ = binary.Write(h, binary.LittleEndian, [3]uint32{s.NTime, s.Nonce, s.Version})
+
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func testJob(id string, prevHash byte) *Work {
+	return &Work{ResponseNotify: stratum.ResponseNotify{Job: id, HashPrevBlock: stratum.Uint256{prevHash}}}
+}
+
+func TestJobRegistry(t *testing.T) {
+	r := NewJobRegistry(3)
+	if w := r.Current(); w != nil {
+		t.Fatalf("current job of an empty registry %v", w.Job)
+	}
+
+	steps := []struct {
+		name   string
+		add    *Work
+		lookup map[string]JobStatus
+	}{
+		{
+			name:   "first job",
+			add:    testJob("1", 1),
+			lookup: map[string]JobStatus{"1": JobCurrent, "2": JobUnknown},
+		},
+		{
+			name:   "same block",
+			add:    testJob("2", 1),
+			lookup: map[string]JobStatus{"1": JobStale, "2": JobCurrent},
+		},
+		{
+			name:   "new block",
+			add:    testJob("3", 2),
+			lookup: map[string]JobStatus{"1": JobPreviousBlock, "2": JobPreviousBlock, "3": JobCurrent},
+		},
+		{
+			name:   "oldest evicted",
+			add:    testJob("4", 2),
+			lookup: map[string]JobStatus{"1": JobUnknown, "2": JobPreviousBlock, "3": JobStale, "4": JobCurrent},
+		},
+		{
+			name:   "job sent again",
+			add:    testJob("3", 2),
+			lookup: map[string]JobStatus{"2": JobPreviousBlock, "3": JobCurrent, "4": JobStale},
+		},
+		{
+			// A reorg back to an older tip revives its jobs.
+			name:   "back to an older block",
+			add:    testJob("5", 1),
+			lookup: map[string]JobStatus{"2": JobUnknown, "3": JobPreviousBlock, "4": JobPreviousBlock, "5": JobCurrent},
+		},
+	}
+
+	for _, step := range steps {
+		r.Add(step.add)
+
+		if w := r.Current(); w != step.add {
+			t.Errorf("%s: current job %v, want %v", step.name, w.Job, step.add.Job)
+		}
+
+		for id, want := range step.lookup {
+			w, status := r.Lookup(id)
+			if status != want {
+				t.Errorf("%s: job %s is %s, want %s", step.name, id, status, want)
+			}
+			if (w == nil) != (want == JobUnknown) || (w != nil && w.Job != id) {
+				t.Errorf("%s: job %s looked up as %v", step.name, id, w)
+			}
+		}
+	}
+
+	r.Reset()
+	if w := r.Current(); w != nil {
+		t.Errorf("current job %v after reset", w.Job)
+	}
+	if _, status := r.Lookup("5"); status != JobUnknown {
+		t.Errorf("job 5 is %s after reset", status)
+	}
+}
+
+func TestJobRegistryDefaultSize(t *testing.T) {
+	r := NewJobRegistry(0)
+	for i := 0; i <= DefaultJobHistory; i++ {
+		r.Add(testJob(string(rune('a'+i)), 1))
+	}
+
+	if _, status := r.Lookup("a"); status != JobUnknown {
+		t.Errorf("oldest of %d jobs is %s, want evicted", DefaultJobHistory+1, status)
+	}
+	if _, status := r.Lookup("b"); status != JobStale {
+		t.Errorf("second oldest job is %s, want %s", status, JobStale)
+	}
+}
+
+func TestJobRegistrySeen(t *testing.T) {
+	r := NewJobRegistry(2)
+	r.Add(testJob("1", 1))
+	r.Add(testJob("2", 1))
+
+	share := Share{
+		Job:        "1",
+		NTime:      0x5b1c3a4f,
+		NoncePart1: []byte{0x08, 0x00, 0x00, 0x01},
+		NoncePart2: []byte{0x00, 0x00, 0x00, 0x2a},
+		Nonce:      0x9962e301,
+		Version:    0x20000000,
+	}
+	if r.Seen(share) {
+		t.Fatal("first share seen before")
+	}
+
+	tests := []struct {
+		name string
+		edit func(*Share)
+		seen bool
+	}{
+		{name: "same share", edit: func(*Share) {}, seen: true},
+		{name: "other job", edit: func(s *Share) { s.Job = "2" }},
+		{name: "unknown job", edit: func(s *Share) { s.Job = "3" }},
+		{name: "other nonce1", edit: func(s *Share) { s.NoncePart1 = []byte{0x08, 0x00, 0x00, 0x02} }},
+		{name: "other nonce2", edit: func(s *Share) { s.NoncePart2 = []byte{0x00, 0x00, 0x00, 0x2b} }},
+		{name: "other ntime", edit: func(s *Share) { s.NTime++ }},
+		{name: "other nonce", edit: func(s *Share) { s.Nonce++ }},
+		{name: "other version", edit: func(s *Share) { s.Version |= 0x00006000 }},
+		{name: "with a solution", edit: func(s *Share) { s.Solution = []byte{0x01} }},
+		// The same bytes split differently between the nonces.
+		{
+			name: "nonce bytes moved",
+			edit: func(s *Share) {
+				s.NoncePart1 = []byte{0x08, 0x00, 0x00}
+				s.NoncePart2 = []byte{0x01, 0x00, 0x00, 0x00, 0x2a}
+			},
+		},
+		// The version mask only limits what may change.
+		{name: "other mask", edit: func(s *Share) { s.VersionMask = 0x1fffe000 }, seen: true},
+	}
+
+	for _, tt := range tests {
+		s := share
+		tt.edit(&s)
+
+		if seen := r.Seen(s); seen != tt.seen {
+			t.Errorf("%s: seen %v, want %v", tt.name, seen, tt.seen)
+		}
+
+		// Each variation is recorded in turn.
+		if tt.seen || s.Job == "3" {
+			continue
+		}
+		if !r.Seen(s) {
+			t.Errorf("%s: not seen when sent again", tt.name)
+		}
+	}
+
+	// Shares are forgotten with their job.
+	r.Add(testJob("3", 1))
+	r.Add(testJob("1", 1))
+	if r.Seen(share) {
+		t.Error("share seen on a job sent again after eviction")
+	}
+
+	r.Reset()
+	if r.Seen(share) {
+		t.Error("share seen after reset")
+	}
+}
+
+func TestJobRegistryReuse(t *testing.T) {
+	r := NewJobRegistry(2)
+	r.Add(testJob("1", 1))
+	r.Add(testJob("2", 1))
+
+	share := Share{Job: "1", NoncePart2: []byte{0x00, 0x00, 0x00, 0x2a}}
+	if r.Seen(share) {
+		t.Fatal("first share seen before")
+	}
+
+	// Sending job 1 again keeps its shares and makes it the newest.
+	w := testJob("1", 1)
+	r.Add(w)
+	if got, status := r.Lookup("1"); got != w || status != JobCurrent {
+		t.Errorf("job 1 sent again looked up as %v, %s", got, status)
+	}
+	if !r.Seen(share) {
+		t.Error("share not seen on a job sent again")
+	}
+
+	r.Add(testJob("3", 1))
+	if _, status := r.Lookup("2"); status != JobUnknown {
+		t.Errorf("job 2 is %s, want it evicted", status)
+	}
+	if _, status := r.Lookup("1"); status != JobStale {
+		t.Errorf("job 1 is %s, want %s", status, JobStale)
+	}
+}
+
+func TestJobRegistryMaxShares(t *testing.T) {
+	r := NewJobRegistry(2)
+	r.maxShares = 2
+	r.Add(testJob("1", 1))
+	r.Add(testJob("2", 1))
+
+	for nonce, want := range []bool{false, false, true, true} {
+		if seen := r.Seen(Share{Job: "1", Nonce: uint32(nonce)}); seen != want {
+			t.Errorf("share %d on job 1: seen %v, want %v", nonce, seen, want)
+		}
+	}
+
+	// The cap is per job.
+	if r.Seen(Share{Job: "2"}) {
+		t.Error("first share on job 2 seen")
+	}
+}
@@ -12,6 +12,12 @@ import (
 	"github.com/BTCChina/mining-pool-proxy/stratum"
 )
 
+// Equihash parameters of Zcash blocks.
+const (
+	EquihashN = 200
+	EquihashK = 9
+)
+
 // work
 type Work struct {
 	stratum.ResponseNotify
@@ -27,6 +33,18 @@ type Work struct {
 	Difficulty Difficulty
 	Subsidy    float64
 
+	Dialect stratum.Dialect
+
+	// SHA256d jobs build the merkle root from these.
+	Coinbase1    []byte
+	Coinbase2    []byte
+	MerkleBranch []stratum.Uint256
+
+	// The rest of a solo job's block, and whether its coinbase carries the
+	// witness reserved value. Equihash jobs have the coinbase first.
+	Transactions    [][]byte
+	CoinbaseWitness bool
+
 	// TODO: server here
 	lastBlock string
 }
@@ -35,94 +53,146 @@ type ShareStatus string
 
 const (
 	ShareInvalid ShareStatus = "invalid"
-	ShareOK      ShareStatus = "ok"
-	ShareBlock   ShareStatus = "block"
+	// Equihash solution that doesn't solve the header.
+	ShareInvalidSolution ShareStatus = "invalid-solution"
+	// SHA256d version changed outside the negotiated mask.
+	ShareInvalidVersion ShareStatus = "invalid-version"
+	// NTime rolled further than NTimeLimits allow.
+	ShareNTimeTooOld ShareStatus = "ntime-too-old"
+	ShareNTimeTooNew ShareStatus = "ntime-too-new"
+	ShareOK          ShareStatus = "ok"
+	ShareBlock       ShareStatus = "block"
 )
 
-// Get the the share bits from submission
+// Share is a miner's submission, in either dialect.
+type Share struct {
+	Job        string
+	NTime      uint32
+	NoncePart1 []byte
+	NoncePart2 []byte
+
+	// Equihash
+	Solution []byte
+
+	// SHA256d, Version is the block version including any rolled bits,
+	// which must stay within VersionMask (BIP 310).
+	Nonce       uint32
+	Version     uint32
+	VersionMask uint32
+}
 
-// check the proof of work
-// Returns the difficulty, return error if invalid.
-func (w *Work) Check(nTime uint32, noncePart1, noncePart2, solution []byte, shareTarget stratum.Uint256, dead bool) ShareStatus {
-	buffer := BuildBlockHeader(w.Version, w.HashPrevBlock[:], w.HashMerkleRoot[:], w.HashReserved[:], w.NTime, w.NBits, noncePart1, noncePart2)
+// Bits returns the job's nBits. Equihash jobs carry the header's bytes,
+// which are little-endian.
+func (w *Work) Bits() uint32 {
+	if w.Dialect.IsBitcoin() {
+		return w.NBits
+	}
 
-	result, _ := Validate(w.N, w.K, buffer.Bytes(), solution, shareTarget, w.Target)
-	if result == ShareBlock {
-		if dead {
-			return result
-		}
+	return reverseUint32(w.NBits)
+}
 
-		_, _ = buffer.Write([]byte{0xfd, 0x40, 0x05})
-		_, _ = buffer.Write(solution)
+// NetworkDifficulty is the difficulty of the job's block target.
+func (w *Work) NetworkDifficulty() Difficulty {
+	return FromTarget(w.Target, DialectAlgorithm(w.Dialect))
+}
 
-		// The buffer now contains the completed block header
-		// Fill in the rest of the block
-		_, _ = buffer.Write(w.RawBlock[buffer.Len():])
-		// SubmitBlock func, params: (buffer.Bytes(), hash)
+// Notify returns the mining.notify for the work's dialect.
+func (w *Work) Notify() stratum.Response {
+	if !w.Dialect.IsBitcoin() {
+		return w.ResponseNotify
 	}
 
-	return result
+	return stratum.ResponseNotifyBitcoin{
+		Job:           w.Job,
+		HashPrevBlock: w.HashPrevBlock,
+		Coinbase1:     w.Coinbase1,
+		Coinbase2:     w.Coinbase2,
+		MerkleBranch:  w.MerkleBranch,
+		Version:       w.Version,
+		NBits:         w.NBits,
+		NTime:         w.NTime,
+		CleanJobs:     w.CleanJobs,
+	}
 }
 
-type Difficulty float64
-
-var POWLimit *big.Int
-
-func init() {
-	POWLimit, _ = new(big.Int).SetString("0x00000000FFFF0000000000000000000000000000000000000000000000000000", 0)
+// NTimeLimits bound a share's ntime, in seconds: Backward and Forward of the
+// job's ntime, and Future ahead of our clock.
+type NTimeLimits struct {
+	Backward uint32
+	Forward  uint32
+	Future   uint32
 }
 
-func (d Difficulty) ToTarget() stratum.Uint256 {
-	var result stratum.Uint256
-	if d == 0 {
-		copy(result[:], POWLimit.Bytes())
-		return result
+// CheckNTime checks a share's ntime against the job and the time now.
+// Equihash ntimes carry the header's bytes, which are little-endian, as
+// Bits does.
+func (w *Work) CheckNTime(nTime uint32, now time.Time, limits NTimeLimits) ShareStatus {
+	jobTime := w.NTime
+	if !w.Dialect.IsBitcoin() {
+		jobTime = reverseUint32(jobTime)
+		nTime = reverseUint32(nTime)
 	}
-	diff := big.NewInt(int64(d))
 
-	bytes := new(big.Int).Div(POWLimit, diff).Bytes()
-
-	if len(bytes) > len(result) {
-		copy(result[:], POWLimit.Bytes())
-		return result
+	switch {
+	case int64(nTime) < int64(jobTime)-int64(limits.Backward):
+		return ShareNTimeTooOld
+	case int64(nTime) > int64(jobTime)+int64(limits.Forward):
+		return ShareNTimeTooNew
+	case int64(nTime) > now.Unix()+int64(limits.Future):
+		return ShareNTimeTooNew
 	}
 
-	copy(result[len(result)-len(bytes):], bytes)
-
-	return result
+	return ShareOK
 }
 
-func FromTarget(target stratum.Uint256) Difficulty {
-	targ := target.ToInteger()
-	if targ.Cmp(new(big.Int)) == 0 {
-		// just return a vary large value
-		return 10000000000000000000
+// CheckShare checks the proof of work of a share in the work's dialect.
+func (w *Work) CheckShare(share Share, shareTarget stratum.Uint256) ShareStatus {
+	if w.Dialect.IsBitcoin() {
+		return w.checkBitcoin(share, shareTarget)
 	}
 
-	res := new(big.Int).Div(POWLimit, targ)
-	return Difficulty(res.Uint64())
+	return w.Check(share.NTime, share.NoncePart1, share.NoncePart2, share.Solution, shareTarget)
 }
 
-func TargetCompare(a, b stratum.Uint256) int {
-	return a.ToInteger().Cmp(b.ToInteger())
+// Check the proof of work of a share with the given ntime, nonce and
+// solution.
+func (w *Work) Check(nTime uint32, noncePart1, noncePart2, solution []byte, shareTarget stratum.Uint256) ShareStatus {
+	buffer := BuildBlockHeader(w.Version, w.HashPrevBlock[:], w.HashMerkleRoot[:], w.HashReserved[:], nTime, w.NBits, noncePart1, noncePart2)
+
+	result, _ := Validate(w.N, w.K, buffer.Bytes(), solution, shareTarget, w.Target)
+	return result
 }
 
-// CompactToTarget converts a NDiff value to a Target.
-func CompactToTarget(x uint32) (stratum.Uint256, error) {
-	x = reverseUint32(x)
+// PrevBlockHash returns the hash of the block the job builds on, in the
+// byte order nodes display it.
+func (w *Work) PrevBlockHash() string {
+	prev := w.HashPrevBlock
+	if w.Dialect.IsBitcoin() {
+		prev = HeaderPrevHash(prev)
+	}
+
+	for i, j := 0, len(prev)-1; i < j; i, j = i+1, j-1 {
+		prev[i], prev[j] = prev[j], prev[i]
+	}
 
-	// The top byte is the number of bytes in the final string
-	var result stratum.Uint256
-	i := (x & 0xff000000) >> 24
+	return stratum.ToHex(prev)
+}
 
-	if int(i) > len(result) {
-		return result, errors.New("invalid compact target")
+// ShareHash returns the block hash of a share as a big-endian number.
+func (w *Work) ShareHash(share Share) stratum.Uint256 {
+	if w.Dialect.IsBitcoin() {
+		return w.bitcoinHash(share)
 	}
 
-	parts := []byte{byte((x & 0x7f0000) >> 16), byte((x & 0xff00) >> 8), byte(x & 0xff)}
-	copy(result[len(result)-int(i):], parts)
+	buffer := BuildBlockHeader(w.Version, w.HashPrevBlock[:], w.HashMerkleRoot[:], w.HashReserved[:], share.NTime, w.NBits, share.NoncePart1, share.NoncePart2)
+	writeCompactSize(buffer, uint64(len(share.Solution)))
+	_, _ = buffer.Write(share.Solution)
 
-	return result, nil
+	return BlockHash(buffer.Bytes())
+}
+
+func TargetCompare(a, b stratum.Uint256) int {
+	return a.ToInteger().Cmp(b.ToInteger())
 }
 
 // create block header
@@ -140,39 +210,25 @@ func BuildBlockHeader(version uint32, hashPrevBlock, hashMerkleRoot, hashReserve
 	return buffer
 }
 
-// Validate checks POW validity of a header.
+// Validate checks POW validity of a header: the Equihash n,k solution, then
+// the hash against globalTarget for a block and shareTarget for a share.
 func Validate(n, k int, headerNonce []byte, solution []byte, shareTarget, globalTarget stratum.Uint256) (ShareStatus, string) {
-	// ok, err := sha256.Verify(n, k, headerNonce, solution)
-	// if err != nil {
-	// 	return ShareInvalid, ""
-	// }
-
-	// if !ok {
-	// 	return ShareInvalid, ""
-	// }
-
-	// // Double sha to check the target
-	// hash := sha256.New()
-	// _, _ = hash.Write(headerNonce)
-	// _, _ = hash.Write([]byte{0xfd, 0x40, 0x05})
-	// _, _ = hash.Write(solution)
-
-	// round1 := hash.Sum(nil)
-	// round2 := sha256.Sum256(round1[:])
-
-	// // Reverse the hash
-	// for i, j := 0, len(round2)-1; i < j; i, j = i+1, j-1 {
-	// 	round2[i], round2[j] = round2[j], round2[i]
-	// }
-
-	// // Check against the global target
-	// if TargetCompare(round2, globalTarget) <= 0 {
-	// 	return ShareBlock, hex.EncodeToString(round2[:])
-	// }
-
-	// if TargetCompare(round2, shareTarget) > 1 {
-	// 	return ShareInvalid, ""
-	// }
+	if !VerifyEquihash(n, k, headerNonce, solution) {
+		return ShareInvalidSolution, ""
+	}
+
+	header := bytes.NewBuffer(append([]byte(nil), headerNonce...))
+	writeCompactSize(header, uint64(len(solution)))
+	_, _ = header.Write(solution)
+
+	hash := BlockHash(header.Bytes())
+	if TargetCompare(hash, globalTarget) <= 0 {
+		return ShareBlock, stratum.ToHex(hash)
+	}
+
+	if TargetCompare(hash, shareTarget) > 0 {
+		return ShareInvalid, ""
+	}
 
 	return ShareOK, ""
 }
@@ -192,22 +248,27 @@ func ad2h(a, d float64) float64 {
 	return (1073741824 * a * d) / 15
 }
 
-// Check if the miner's address is valid
+// IsValidAddress reports whether addr is a Zcash address, transparent,
+// Sprout or Sapling, and whether it's a testnet one.
 func IsValidAddress(addr string) (valid bool, testnet bool) {
+	if valid, testnet := saplingAddress(addr); valid {
+		return true, testnet
+	}
+
 	address, err := DecodeCheck(addr)
 	if err != nil {
 		return false, false
 	}
 
 	switch len(address) {
-	case 1 + 1 + 20: // Testnet
-		if address[0] == 0x1c && (address[1] == 0xbd || address[1] == 0xb8) {
+	case 1 + 1 + 20: // Transparent
+		switch [2]byte{address[0], address[1]} {
+		case zcashP2PKH, zcashP2SH:
 			return true, false
-		}
-		if address[0] == 0x1d && (address[1] == 0xba || address[1] == 0x25) {
+		case zcashTestnetP2PKH, zcashTestnetP2SH:
 			return true, true
 		}
-	case 1 + 1 + 32 + 32: // Production
+	case 1 + 1 + 32 + 32: // Sprout
 		if address[0] == 0x16 {
 			if address[1] == 0x9a {
 				return true, false
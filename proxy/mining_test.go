@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// zcashGenesisNotify is the job for the Zcash genesis block.
+const zcashGenesisNotify = `{"id":null,"method":"mining.notify","params":["0","04000000","0000000000000000000000000000000000000000000000000000000000000000","f84c7a85b768123f1dff1d4c4cece70083b2d27e117b4ac2e31d087988a5eac4","0000000000000000000000000000000000000000000000000000000000000000","90041358","ffff071f",true]}`
+
+func zcashGenesisWork(t *testing.T) *Work {
+	t.Helper()
+
+	msg, err := stratum.Equihash.ParseServerMessage([]byte(zcashGenesisNotify))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Work{ResponseNotify: msg.(stratum.ResponseNotify), Dialect: stratum.Equihash}
+}
+
+func TestCheckNTime(t *testing.T) {
+	limits := NTimeLimits{Backward: 60, Forward: 600, Future: 7200}
+
+	zcash := zcashGenesisWork(t)
+	if bits := zcash.Bits(); bits != 0x1f07ffff {
+		t.Fatalf("zcash genesis bits %08x", bits)
+	}
+
+	bitcoin := block1Work(t)
+
+	tests := []struct {
+		name  string
+		work  *Work
+		ntime string
+		now   int64
+		want  ShareStatus
+	}{
+		{name: "zcash job's ntime", work: zcash, ntime: "90041358", now: 1477641360, want: ShareOK},
+		{name: "zcash rolled forward", work: zcash, ntime: "ae041358", now: 1477641400, want: ShareOK},
+		{name: "zcash rolled back", work: zcash, ntime: "54041358", now: 1477641360, want: ShareOK},
+		{name: "zcash rolled too far back", work: zcash, ntime: "53041358", now: 1477641360, want: ShareNTimeTooOld},
+		{name: "zcash at the forward limit", work: zcash, ntime: "e8061358", now: 1477642000, want: ShareOK},
+		{name: "zcash past the forward limit", work: zcash, ntime: "e9061358", now: 1477642000, want: ShareNTimeTooNew},
+		{name: "zcash ahead of the clock", work: zcash, ntime: "ae041358", now: 1477641360 - 7200, want: ShareNTimeTooNew},
+		{name: "bitcoin job's ntime", work: bitcoin, ntime: "4966bc61", now: 1231469665, want: ShareOK},
+		{name: "bitcoin rolled forward", work: bitcoin, ntime: "4966bc7f", now: 1231469700, want: ShareOK},
+		{name: "bitcoin rolled back", work: bitcoin, ntime: "4966bc25", now: 1231469665, want: ShareOK},
+		{name: "bitcoin rolled too far back", work: bitcoin, ntime: "4966bc24", now: 1231469665, want: ShareNTimeTooOld},
+		{name: "bitcoin past the forward limit", work: bitcoin, ntime: "4966beba", now: 1231470300, want: ShareNTimeTooNew},
+	}
+
+	solution := "fd4005" + strings.Repeat("00", 1344)
+
+	for _, tt := range tests {
+		var nTime uint32
+		if tt.work.Dialect.IsBitcoin() {
+			req, err := stratum.Bitcoin.Parse([]byte(`{"id":1,"method":"mining.submit","params":["w","1","00000000","` + tt.ntime + `","00000000"]}`))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			nTime = req.(stratum.RequestSubmitBitcoin).NTime
+		} else {
+			req, err := stratum.Equihash.Parse([]byte(`{"id":1,"method":"mining.submit","params":["w","0","` + tt.ntime + `","` + strings.Repeat("00", 16) + `","` + solution + `"]}`))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			nTime = req.(stratum.RequestSubmit).NTime
+		}
+
+		if got := tt.work.CheckNTime(nTime, time.Unix(tt.now, 0), limits); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
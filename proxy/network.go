@@ -2,9 +2,9 @@ package proxy
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
-	"io"
 	"net"
 	"time"
 
@@ -13,36 +13,124 @@ import (
 
 // LRW reads lines from a net.Conn with a specified timeout.
 type LRW struct {
-	conn    net.Conn
-	scanner *bufio.Scanner
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// Longest line accepted from the peer, in bytes.
+	MaxMessageSize int
+	// Time a peer gets to finish a line once its first byte arrived.
+	MessageTimeout time.Duration
+
+	// Dialect messages are parsed in.
+	Dialect stratum.Dialect
+}
+
+const (
+	DefaultMaxMessageSize = 16 * 1024
+	DefaultMessageTimeout = 30 * time.Second
+)
+
+var (
+	ErrMessageTooLarge = errors.New("message too large")
+	ErrMessageTimeout  = errors.New("message not completed in time")
+)
+
+// MalformedError is returned for lines that aren't valid stratum.
+type MalformedError struct {
+	Line []byte
+	Err  error
+}
+
+func (e *MalformedError) Error() string {
+	return "malformed message: " + e.Err.Error()
 }
 
 func NewLRW(conn net.Conn) *LRW {
 	return &LRW{
-		conn:    conn,
-		scanner: bufio.NewScanner(conn),
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+
+		MaxMessageSize: DefaultMaxMessageSize,
+		MessageTimeout: DefaultMessageTimeout,
+	}
+}
+
+// readLine waits until deadline for a message to start, the rest of it
+// has to arrive within MessageTimeout. Empty lines are skipped.
+func (lrw *LRW) readLine(deadline time.Time) ([]byte, error) {
+	for {
+		if err := lrw.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		if _, err := lrw.reader.Peek(1); err != nil {
+			return nil, err
+		}
+
+		messageDeadline := time.Now().Add(lrw.MessageTimeout)
+		slow := lrw.MessageTimeout > 0 && messageDeadline.Before(deadline)
+		if slow {
+			if err := lrw.conn.SetReadDeadline(messageDeadline); err != nil {
+				return nil, err
+			}
+		}
+
+		var line []byte
+		for {
+			chunk, err := lrw.reader.ReadSlice('\n')
+			if lrw.MaxMessageSize > 0 && len(line)+len(chunk) > lrw.MaxMessageSize+len("\r\n") {
+				return nil, ErrMessageTooLarge
+			}
+
+			line = append(line, chunk...)
+			if err == nil {
+				break
+			}
+
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && slow {
+				return nil, ErrMessageTimeout
+			}
+
+			return nil, err
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			return line, nil
+		}
 	}
 }
 
 func (lrw *LRW) ReadStratumTimed(deadline time.Time) (stratum.Request, error) {
-	if err := lrw.conn.SetReadDeadline(deadline); err != nil {
+	line, err := lrw.readLine(deadline)
+	if err != nil {
 		return nil, err
 	}
 
-	if !lrw.scanner.Scan() {
-		// Scanner will set error to nil on EOF.
-		if lrw.scanner.Err() == nil {
-			return nil, io.EOF
-		}
-
-		return nil, lrw.scanner.Err()
+	val, err := lrw.Dialect.Parse(line)
+	if err != nil {
+		return nil, &MalformedError{Line: line, Err: err}
 	}
 
-	val, err := stratum.Parse(lrw.scanner.Bytes())
+	return val, nil
+}
+
+// ReadServerMessageTimed reads a message sent by a pool.
+func (lrw *LRW) ReadServerMessageTimed(deadline time.Time) (stratum.Response, error) {
+	line, err := lrw.readLine(deadline)
 	if err != nil {
 		return nil, err
 	}
 
+	val, err := lrw.Dialect.ParseServerMessage(line)
+	if err != nil {
+		return nil, &MalformedError{Line: line, Err: err}
+	}
+
 	return val, nil
 }
 
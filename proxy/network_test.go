@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+const subscribeLine = `{"id":1,"method":"mining.subscribe","params":[]}`
+
+// pipeLRW returns an LRW reading what is written to the other end.
+func pipeLRW(t *testing.T) (*LRW, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+
+	return NewLRW(server), client
+}
+
+func TestLRWReadsLines(t *testing.T) {
+	lrw, client := pipeLRW(t)
+
+	go func() {
+		_, _ = client.Write([]byte("\r\n\n" + subscribeLine + "\r\n" + `{"id":2,"method":"mining.nonsense"`))
+		_, _ = client.Write([]byte("}\n"))
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	req, err := lrw.ReadStratumTimed(deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Type() != stratum.Subscribe {
+		t.Fatalf("got %v after empty lines", req.Type())
+	}
+
+	// A line split across writes is read whole.
+	if _, err := lrw.ReadStratumTimed(deadline); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLRWMalformed(t *testing.T) {
+	lrw, client := pipeLRW(t)
+
+	go func() {
+		_, _ = client.Write([]byte("not json\n"))
+	}()
+
+	_, err := lrw.ReadStratumTimed(time.Now().Add(5 * time.Second))
+	if merr, ok := err.(*MalformedError); !ok || string(merr.Line) != "not json" {
+		t.Fatalf("got %#v", err)
+	}
+}
+
+func TestLRWMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		size int
+		err  error
+	}{
+		{len(subscribeLine), nil},
+		{len(subscribeLine) - 1, ErrMessageTooLarge},
+	}
+
+	for _, tt := range tests {
+		lrw, client := pipeLRW(t)
+		lrw.MaxMessageSize = tt.size
+
+		go func() {
+			_, _ = client.Write([]byte(subscribeLine + "\r\n"))
+		}()
+
+		if _, err := lrw.ReadStratumTimed(time.Now().Add(5 * time.Second)); err != tt.err {
+			t.Errorf("limit %v: got %v, want %v", tt.size, err, tt.err)
+		}
+	}
+
+	// Lines longer than bufio's buffer are read in chunks.
+	lrw, client := pipeLRW(t)
+	lrw.MaxMessageSize = 64 * 1024
+	go func() {
+		_, _ = client.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["` + strings.Repeat("x", 32*1024) + `"]}` + "\n"))
+	}()
+
+	if _, err := lrw.ReadStratumTimed(time.Now().Add(5 * time.Second)); err != nil {
+		t.Errorf("long line: %v", err)
+	}
+}
+
+func TestLRWMessageTimeout(t *testing.T) {
+	lrw, client := pipeLRW(t)
+	lrw.MessageTimeout = 50 * time.Millisecond
+
+	go func() {
+		_, _ = client.Write([]byte(`{"id":1,`))
+	}()
+
+	// The line started, it has MessageTimeout to finish.
+	_, err := lrw.ReadStratumTimed(time.Now().Add(5 * time.Second))
+	if err != ErrMessageTimeout {
+		t.Fatalf("got %v, want %v", err, ErrMessageTimeout)
+	}
+
+	// Idle peers get the whole deadline, and it isn't a slow message.
+	lrw, _ = pipeLRW(t)
+	lrw.MessageTimeout = time.Second
+	_, err = lrw.ReadStratumTimed(time.Now().Add(50 * time.Millisecond))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HAProxy PROXY protocol, see https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+
+var (
+	proxyV1Tag       = []byte("PROXY")
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrProxyHeader = errors.New("invalid proxy protocol header")
+)
+
+const (
+	// Longest possible v1 header, including the CRLF.
+	proxyV1MaxLength = 107
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	proxyV2FamilyTCP4 = 0x11
+	proxyV2FamilyTCP6 = 0x21
+)
+
+// ProxyConn is a net.Conn whose addresses were read from a PROXY protocol header.
+type ProxyConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	src    net.Addr
+	dst    net.Addr
+}
+
+// NewProxyConn reads a v1 or v2 PROXY header from conn before the deadline.
+func NewProxyConn(conn net.Conn, deadline time.Time) (*ProxyConn, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	src, dst, err := ReadProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return &ProxyConn{
+		Conn:   conn,
+		reader: reader,
+		src:    src,
+		dst:    dst,
+	}, nil
+}
+
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client's address as reported by the proxy.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if c.src != nil {
+		return c.src
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if c.dst != nil {
+		return c.dst
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// ReadProxyHeader consumes a PROXY header. The addresses are nil for health
+// checks from the proxy itself (v1 UNKNOWN, v2 LOCAL) and unsupported families.
+func ReadProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	// Peek no further than a v1 header surely goes before telling the
+	// versions apart, health checks send only "PROXY UNKNOWN\r\n".
+	tag, err := r.Peek(len(proxyV1Tag))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(tag, proxyV1Tag) {
+		return readProxyV1(r)
+	}
+
+	if !bytes.HasPrefix(proxyV2Signature, tag) {
+		return nil, nil, ErrProxyHeader
+	}
+
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(sig, proxyV2Signature) {
+		return nil, nil, ErrProxyHeader
+	}
+
+	return readProxyV2(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasPrefix(line, proxyV1Prefix) || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return nil, nil, ErrProxyHeader
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, nil, ErrProxyHeader
+		}
+
+		src, err := parseProxyV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dst, err := parseProxyV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return src, dst, nil
+
+	default:
+		return nil, nil, ErrProxyHeader
+	}
+}
+
+func parseProxyV1Addr(host, port string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		return nil, ErrProxyHeader
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+
+	verCmd := header[12]
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+
+	if verCmd>>4 != 0x2 {
+		return nil, nil, ErrProxyHeader
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch verCmd & 0xf {
+	case proxyV2CmdLocal:
+		return nil, nil, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, nil, ErrProxyHeader
+	}
+
+	switch family {
+	case proxyV2FamilyTCP4:
+		if len(payload) < 12 {
+			return nil, nil, ErrProxyHeader
+		}
+
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))},
+			&net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:]))},
+			nil
+
+	case proxyV2FamilyTCP6:
+		if len(payload) < 36 {
+			return nil, nil, ErrProxyHeader
+		}
+
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))},
+			&net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:]))},
+			nil
+
+	default:
+		// UDP and unix sockets carry nothing we can use, keep the TCP addresses.
+		return nil, nil, nil
+	}
+}
+
+// ParseNets parses a list of CIDRs or plain IPs.
+func ParseNets(list []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("invalid address: " + s)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, n)
+	}
+
+	return result, nil
+}
+
+// ContainsAddr reports whether the IP of addr is in one of nets.
+func ContainsAddr(nets []*net.IPNet, addr net.Addr) bool {
+	ip := AddrIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddrIP returns the IP of a TCP address.
+func AddrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil
+		}
+
+		return net.ParseIP(host)
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func v2Header(verCmd, family byte, payload []byte) string {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, verCmd, family, byte(len(payload)>>8), byte(len(payload)))
+	return string(append(header, payload...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tcp4 := []byte{
+		192, 0, 2, 1, // source
+		198, 51, 100, 2, // destination
+		0xdc, 0x04, // 56324
+		0x0d, 0x05, // 3333
+	}
+
+	tcp6 := make([]byte, 36)
+	copy(tcp6, net.ParseIP("2001:db8::1"))
+	copy(tcp6[16:], net.ParseIP("2001:db8::2"))
+	tcp6[32], tcp6[33], tcp6[34], tcp6[35] = 0xdc, 0x04, 0x0d, 0x05
+
+	tests := []struct {
+		name     string
+		header   string
+		src, dst string
+		err      bool
+	}{
+		{name: "v1 tcp4", header: "PROXY TCP4 192.0.2.1 198.51.100.2 56324 3333\r\n", src: "192.0.2.1:56324", dst: "198.51.100.2:3333"},
+		{name: "v1 tcp6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 3333\r\n", src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:3333"},
+		{name: "v1 unknown", header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+		{name: "v1 health check", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 no space", header: "PROXYUNKNOWN\r\n", err: true},
+		{name: "v1 family mismatch", header: "PROXY TCP4 2001:db8::1 2001:db8::2 1 2\r\n", err: true},
+		{name: "v1 bad port", header: "PROXY TCP4 192.0.2.1 198.51.100.2 65536 3333\r\n", err: true},
+		{name: "v1 missing fields", header: "PROXY TCP4 192.0.2.1\r\n", err: true},
+		{name: "v1 no crlf", header: "PROXY TCP4 192.0.2.1 198.51.100.2 56324 3333\n", err: true},
+		{name: "v1 too long", header: "PROXY UNKNOWN " + strings.Repeat("x", proxyV1MaxLength) + "\r\n", err: true},
+		{name: "not a proxy header", header: `{"id":1,"method":"mining.subscribe","params":[]}` + "\n", err: true},
+		{name: "v2 tcp4", header: v2Header(0x21, proxyV2FamilyTCP4, tcp4), src: "192.0.2.1:56324", dst: "198.51.100.2:3333"},
+		{name: "v2 tcp6", header: v2Header(0x21, proxyV2FamilyTCP6, tcp6), src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:3333"},
+		{name: "v2 local", header: v2Header(0x20, 0x00, nil)},
+		{name: "v2 unix", header: v2Header(0x21, 0x31, make([]byte, 216))},
+		{name: "v2 tlvs after addresses", header: v2Header(0x21, proxyV2FamilyTCP4, append(tcp4, 0x04, 0x00, 0x01, 0x00)), src: "192.0.2.1:56324", dst: "198.51.100.2:3333"},
+		{name: "v2 short payload", header: v2Header(0x21, proxyV2FamilyTCP4, tcp4[:8]), err: true},
+		{name: "v2 bad version", header: v2Header(0x11, proxyV2FamilyTCP4, tcp4), err: true},
+		{name: "v2 bad command", header: v2Header(0x22, proxyV2FamilyTCP4, tcp4), err: true},
+		{name: "v2 truncated", header: v2Header(0x21, proxyV2FamilyTCP4, tcp4)[:20], err: true},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.header + "rest"))
+		src, dst, err := ReadProxyHeader(r)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: no error", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+
+		if got := addrString(src); got != tt.src {
+			t.Errorf("%v: source %v, want %v", tt.name, got, tt.src)
+		}
+
+		if got := addrString(dst); got != tt.dst {
+			t.Errorf("%v: destination %v, want %v", tt.name, got, tt.dst)
+		}
+
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "rest" {
+			t.Errorf("%v: header not consumed exactly, left %q", tt.name, rest)
+		}
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
+
+func TestProxyConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.2 56324 3333\r\nhello"))
+	}()
+
+	conn, err := NewProxyConn(server, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("remote address %v", got)
+	}
+
+	if got := AddrIP(conn.RemoteAddr()); !got.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("remote IP %v", got)
+	}
+
+	buf := make([]byte, 5)
+	if _, err := conn.Read(buf); err != nil || !bytes.Equal(buf, []byte("hello")) {
+		t.Errorf("read %q, %v after the header", buf, err)
+	}
+}
+
+// The 15 byte health check must be read without waiting for more.
+func TestProxyConnHealthCheck(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY UNKNOWN\r\n"))
+	}()
+
+	conn, err := NewProxyConn(server, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.RemoteAddr() != server.RemoteAddr() {
+		t.Errorf("remote address %v", conn.RemoteAddr())
+	}
+}
+
+func TestParseNets(t *testing.T) {
+	nets, err := ParseNets([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3:1", true},
+		{"11.0.0.1:1", false},
+		{"192.0.2.7:3333", true},
+		{"192.0.2.8:3333", false},
+		{"[2001:db8::5]:3333", true},
+		{"[::1]:3333", true},
+		{"[::2]:3333", false},
+	}
+
+	for _, tt := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := ContainsAddr(nets, addr); got != tt.want {
+			t.Errorf("ContainsAddr(%v) = %v", tt.addr, got)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not an ip"} {
+		if _, err := ParseNets([]string{bad}); err == nil {
+			t.Errorf("ParseNets(%q) accepted", bad)
+		}
+	}
+}
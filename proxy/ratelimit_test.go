@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if !b.Take() {
+			t.Fatalf("burst token %v refused", i)
+		}
+	}
+
+	if b.Take() {
+		t.Fatal("took more than the burst")
+	}
+
+	// Half a second refills one token at 2 per second.
+	b.mu.Lock()
+	b.last = b.last.Add(-500 * time.Millisecond)
+	b.mu.Unlock()
+
+	if !b.Take() {
+		t.Fatal("token not refilled")
+	}
+
+	if b.Take() {
+		t.Fatal("refilled more than the rate")
+	}
+
+	// Refills stop at the burst.
+	b.mu.Lock()
+	b.last = b.last.Add(-time.Hour)
+	b.mu.Unlock()
+
+	taken := 0
+	for b.Take() {
+		taken++
+	}
+
+	if taken != 3 {
+		t.Errorf("took %v tokens after a long pause, want the burst of 3", taken)
+	}
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// DoubleSHA256 hashes data twice, as bitcoin does.
+func DoubleSHA256(data []byte) stratum.Uint256 {
+	first := sha256.Sum256(data)
+	return sha256.Sum256(first[:])
+}
+
+// MerkleRoot folds the coinbase hash with the branches sent in mining.notify.
+func MerkleRoot(coinbaseHash stratum.Uint256, branches []stratum.Uint256) stratum.Uint256 {
+	root := coinbaseHash
+	buf := make([]byte, 64)
+	for _, branch := range branches {
+		copy(buf, root[:])
+		copy(buf[32:], branch[:])
+		root = DoubleSHA256(buf)
+	}
+
+	return root
+}
+
+// HeaderPrevHash returns hashPrevBlock as sent in mining.notify, with each
+// 4 byte word byte swapped, in block header order.
+func HeaderPrevHash(hashPrevBlock stratum.Uint256) stratum.Uint256 {
+	var result stratum.Uint256
+	for i := 0; i < len(hashPrevBlock); i += 4 {
+		binary.LittleEndian.PutUint32(result[i:], binary.BigEndian.Uint32(hashPrevBlock[i:]))
+	}
+
+	return result
+}
+
+// BuildBitcoinHeader serialises an 80 byte SHA256d block header. hashPrevBlock
+// is as sent in mining.notify.
+func BuildBitcoinHeader(version uint32, hashPrevBlock, hashMerkleRoot stratum.Uint256, nTime, nBits, nonce uint32) []byte {
+	prevHash := HeaderPrevHash(hashPrevBlock)
+
+	buffer := bytes.NewBuffer(make([]byte, 0, 80))
+	_ = binary.Write(buffer, binary.LittleEndian, version)
+	_, _ = buffer.Write(prevHash[:])
+	_, _ = buffer.Write(hashMerkleRoot[:])
+	_ = binary.Write(buffer, binary.LittleEndian, nTime)
+	_ = binary.Write(buffer, binary.LittleEndian, nBits)
+	_ = binary.Write(buffer, binary.LittleEndian, nonce)
+
+	return buffer.Bytes()
+}
+
+// BlockHash returns the hash of a header as a big-endian number.
+func BlockHash(header []byte) stratum.Uint256 {
+	hash := DoubleSHA256(header)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+
+	return hash
+}
+
+// MerkleRoot of a SHA256d job with the given nonces in the coinbase.
+func (w *Work) MerkleRoot(noncePart1, noncePart2 []byte) stratum.Uint256 {
+	coinbase := make([]byte, 0, len(w.Coinbase1)+len(noncePart1)+len(noncePart2)+len(w.Coinbase2))
+	coinbase = append(coinbase, w.Coinbase1...)
+	coinbase = append(coinbase, noncePart1...)
+	coinbase = append(coinbase, noncePart2...)
+	coinbase = append(coinbase, w.Coinbase2...)
+
+	return MerkleRoot(DoubleSHA256(coinbase), w.MerkleBranch)
+}
+
+// RollVersion applies version bits sent by a miner to the job's version.
+func (w *Work) RollVersion(versionBits, mask uint32) uint32 {
+	return w.Version&^mask | versionBits
+}
+
+func (w *Work) checkBitcoin(share Share, shareTarget stratum.Uint256) ShareStatus {
+	if (share.Version^w.Version)&^share.VersionMask != 0 {
+		return ShareInvalidVersion
+	}
+
+	hash := w.bitcoinHash(share)
+
+	if TargetCompare(hash, w.Target) <= 0 {
+		return ShareBlock
+	}
+
+	if TargetCompare(hash, shareTarget) > 0 {
+		return ShareInvalid
+	}
+
+	return ShareOK
+}
+
+func (w *Work) bitcoinHash(share Share) stratum.Uint256 {
+	root := w.MerkleRoot(share.NoncePart1, share.NoncePart2)
+	return BlockHash(BuildBitcoinHeader(share.Version, w.HashPrevBlock, root, share.NTime, w.NBits, share.Nonce))
+}
+
+// CoinbaseHeight reads the block height a SHA256d job's coinbase starts its
+// script with (BIP 34), false when coinbase1 is too short to hold it.
+func (w *Work) CoinbaseHeight() (int, bool) {
+	// version, input count, previous output
+	const scriptOffset = 4 + 1 + 32 + 4
+
+	cb := w.Coinbase1
+	if len(cb) < scriptOffset+2 {
+		return 0, false
+	}
+
+	// Skip the script length, a compact size.
+	i := scriptOffset + 1
+	switch cb[scriptOffset] {
+	case 0xfd:
+		i += 2
+	case 0xfe:
+		i += 4
+	case 0xff:
+		i += 8
+	}
+
+	if i >= len(cb) {
+		return 0, false
+	}
+
+	n := int(cb[i])
+	if n < 1 || n > 8 || i+1+n > len(cb) {
+		return 0, false
+	}
+
+	var height uint64
+	for j := n - 1; j >= 0; j-- {
+		height = height<<8 | uint64(cb[i+1+j])
+	}
+
+	return int(height), true
+}
+
+// TxOut is an output of a coinbase transaction.
+type TxOut struct {
+	Value  uint64
+	Script []byte
+}
+
+var ErrBadCoinbase = errors.New("malformed coinbase")
+
+// CoinbaseOutputs parses the outputs of a SHA256d job's coinbase, filling
+// the extranonce of extranonceSize bytes with zeros.
+func (w *Work) CoinbaseOutputs(extranonceSize int) ([]TxOut, error) {
+	tx := make([]byte, 0, len(w.Coinbase1)+extranonceSize+len(w.Coinbase2))
+	tx = append(tx, w.Coinbase1...)
+	tx = append(tx, make([]byte, extranonceSize)...)
+	tx = append(tx, w.Coinbase2...)
+
+	r := txReader{buf: tx}
+	r.next(4) // version
+
+	r.skipInputs()
+	outputs := r.outputs()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return outputs, nil
+}
+
+type txReader struct {
+	buf []byte
+	err error
+}
+
+func (r *txReader) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = ErrBadCoinbase
+		return make([]byte, 8)
+	}
+
+	v := r.buf[:n]
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *txReader) compactSize() uint64 {
+	switch n := r.next(1)[0]; n {
+	case 0xfd:
+		return uint64(binary.LittleEndian.Uint16(r.next(2)))
+	case 0xfe:
+		return uint64(binary.LittleEndian.Uint32(r.next(4)))
+	case 0xff:
+		return binary.LittleEndian.Uint64(r.next(8))
+	default:
+		return uint64(n)
+	}
+}
+
+func (r *txReader) skipInputs() {
+	count := r.compactSize()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		r.next(36) // previous output
+		r.next(int(r.compactSize()))
+		r.next(4) // sequence
+	}
+}
+
+func (r *txReader) outputs() []TxOut {
+	count := r.compactSize()
+	var outputs []TxOut
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		value := binary.LittleEndian.Uint64(r.next(8))
+		script := r.next(int(r.compactSize()))
+		outputs = append(outputs, TxOut{Value: value, Script: script})
+	}
+
+	return outputs
+}
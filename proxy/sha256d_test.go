@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// block1Work is the job for bitcoin block 1, its coinbase the only
+// transaction.
+func block1Work(t *testing.T) *Work {
+	t.Helper()
+
+	prev, err := stratum.HexToUint256("0a8ce26f72b3f1b646a2a6c14ff763ae65831e939c085ae10019d66800000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := CompactToTarget(0x1d00ffff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Work{
+		Dialect: stratum.Bitcoin,
+		ResponseNotify: stratum.ResponseNotify{
+			Version:       1,
+			NTime:         0x4966bc61,
+			NBits:         0x1d00ffff,
+			HashPrevBlock: prev,
+		},
+		Coinbase1: mustHex(t, "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0704ffff001d0104ffffffff0100f2052a0100000043410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac00000000"),
+		Target:    target,
+	}
+}
+
+func TestRollVersion(t *testing.T) {
+	w := &Work{ResponseNotify: stratum.ResponseNotify{Version: 0x20000000}}
+
+	tests := []struct {
+		versionBits, mask, want uint32
+	}{
+		{0, 0, 0x20000000},
+		{0x00006000, 0x1fffe000, 0x20006000},
+		{0x1fffe000, 0x1fffe000, 0x3fffe000},
+		// Bits the mask clears in the job's version.
+		{0, 0x20000000, 0},
+	}
+
+	for _, tt := range tests {
+		if got := w.RollVersion(tt.versionBits, tt.mask); got != tt.want {
+			t.Errorf("RollVersion(%08x, %08x) = %08x, want %08x", tt.versionBits, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestCheckBitcoinVersion(t *testing.T) {
+	w := block1Work(t)
+
+	var easy stratum.Uint256
+	for i := range easy {
+		easy[i] = 0xff
+	}
+
+	tests := []struct {
+		name        string
+		version     uint32
+		mask        uint32
+		shareTarget stratum.Uint256
+		want        ShareStatus
+	}{
+		{name: "block", version: 1, want: ShareBlock},
+		{name: "rolled without a mask", version: 0x00006001, want: ShareInvalidVersion},
+		{name: "rolled outside the mask", version: 0x20000001, mask: 0x1fffe000, want: ShareInvalidVersion},
+		{name: "rolled within the mask", version: 0x00006001, mask: 0x1fffe000, shareTarget: easy, want: ShareOK},
+		{name: "rolled within the mask, above target", version: 0x00006001, mask: 0x1fffe000, want: ShareInvalid},
+	}
+
+	for _, tt := range tests {
+		share := Share{
+			NTime:       0x4966bc61,
+			Nonce:       0x9962e301,
+			Version:     tt.version,
+			VersionMask: tt.mask,
+		}
+
+		if got := w.CheckShare(share, tt.shareTarget); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	hash := stratum.ToHex(w.ShareHash(Share{NTime: 0x4966bc61, Nonce: 0x9962e301, Version: 1}))
+	if want := "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048"; hash != want {
+		t.Errorf("block 1 hash %s, want %s", hash, want)
+	}
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertCheckInterval is how often the certificate files are checked for changes.
+const CertCheckInterval = 10 * time.Second
+
+var ErrPinMismatch = errors.New("no certificate in chain matches a pinned key")
+
+// CertReloader serves a certificate from disk and reloads it when the files change.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the certificate and key from disk.
+func (r *CertReloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+	r.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	cert, modTime, checked := r.cert, r.modTime, r.checked
+	r.mu.RUnlock()
+
+	if time.Since(checked) < CertCheckInterval {
+		return cert, nil
+	}
+
+	r.mu.Lock()
+	r.checked = time.Now()
+	r.mu.Unlock()
+
+	// Keep serving the old certificate if the new one can't be loaded,
+	// e.g. when only one of the two files has been replaced so far.
+	if latest, err := r.lastModified(); err == nil && latest.After(modTime) {
+		if err := r.Reload(); err == nil {
+			r.mu.RLock()
+			cert = r.cert
+			r.mu.RUnlock()
+		}
+	}
+
+	return cert, nil
+}
+
+func (r *CertReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// LoadCertPool reads a PEM encoded bundle of certificates.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + file)
+	}
+
+	return pool, nil
+}
+
+// ParsePins decodes hex encoded SHA-256 hashes of SubjectPublicKeyInfo.
+func ParsePins(pins []string) ([][]byte, error) {
+	result := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		data, err := hex.DecodeString(pin)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) != sha256.Size {
+			return nil, errors.New("pin is not a SHA-256 hash: " + pin)
+		}
+
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+// PinnedVerifier returns a tls.Config.VerifyPeerCertificate func that accepts
+// the connection only if a certificate in the chain has a pinned public key.
+func PinnedVerifier(pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var certs []*x509.Certificate
+		for _, chain := range verifiedChains {
+			certs = append(certs, chain...)
+		}
+
+		// Chains are empty when verification is skipped, check the peer's certificates.
+		if len(certs) == 0 {
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+
+				certs = append(certs, cert)
+			}
+		}
+
+		for _, cert := range certs {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin) {
+					return nil
+				}
+			}
+		}
+
+		return ErrPinMismatch
+	}
+}
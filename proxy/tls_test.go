@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// writeCert writes a new self-signed certificate for name and its key.
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile, cert
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeCert(t, dir, "first.example")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served, _ := r.GetCertificate(nil)
+	if got := served.Certificate[0]; string(got) != string(first.Raw) {
+		t.Fatal("not serving the certificate on disk")
+	}
+
+	_, _, second := writeCert(t, dir, "second.example")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Within the check interval the old certificate is kept.
+	served, _ = r.GetCertificate(nil)
+	if string(served.Certificate[0]) != string(first.Raw) {
+		t.Fatal("reloaded before the check interval passed")
+	}
+
+	r.mu.Lock()
+	r.checked = time.Now().Add(-CertCheckInterval)
+	r.mu.Unlock()
+
+	served, _ = r.GetCertificate(nil)
+	if string(served.Certificate[0]) != string(second.Raw) {
+		t.Fatal("changed certificate not reloaded")
+	}
+
+	// A broken pair keeps the last good certificate.
+	if err := ioutil.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	evenLater := later.Add(time.Minute)
+	if err := os.Chtimes(keyFile, evenLater, evenLater); err != nil {
+		t.Fatal(err)
+	}
+
+	r.mu.Lock()
+	r.checked = time.Now().Add(-CertCheckInterval)
+	r.mu.Unlock()
+
+	served, _ = r.GetCertificate(nil)
+	if served == nil || string(served.Certificate[0]) != string(second.Raw) {
+		t.Fatal("lost the certificate to a broken key file")
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	sum := sha256.Sum256([]byte("key"))
+
+	tests := []struct {
+		pins []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{hex.EncodeToString(sum[:])}, true},
+		{[]string{hex.EncodeToString(sum[:16])}, false},
+		{[]string{"not hex"}, false},
+		{[]string{hex.EncodeToString(sum[:]), ""}, false},
+	}
+
+	for _, tt := range tests {
+		pins, err := ParsePins(tt.pins)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParsePins(%q) error = %v", tt.pins, err)
+			continue
+		}
+
+		if tt.ok && len(pins) != len(tt.pins) {
+			t.Errorf("ParsePins(%q) returned %v pins", tt.pins, len(pins))
+		}
+	}
+}
+
+func TestPinnedVerifier(t *testing.T) {
+	_, _, cert := writeCert(t, t.TempDir(), "pool.example")
+	_, _, other := writeCert(t, t.TempDir(), "other.example")
+
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	verify := PinnedVerifier([][]byte{pin[:]})
+
+	tests := []struct {
+		name   string
+		raw    [][]byte
+		chains [][]*x509.Certificate
+		err    error
+	}{
+		{"verified chain", nil, [][]*x509.Certificate{{other, cert}}, nil},
+		{"raw certificates", [][]byte{cert.Raw}, nil, nil},
+		{"other key", [][]byte{other.Raw}, nil, ErrPinMismatch},
+		{"other chain", [][]byte{cert.Raw}, [][]*x509.Certificate{{other}}, ErrPinMismatch},
+	}
+
+	for _, tt := range tests {
+		if err := verify(tt.raw, tt.chains); err != tt.err {
+			t.Errorf("%v: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, "ca.example")
+
+	if _, err := LoadCertPool(certFile); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := LoadCertPool(keyFile); err == nil {
+		t.Error("loaded a pool without certificates")
+	}
+
+	if _, err := LoadCertPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
+
+// LRW reads stratum from TLS connections as from plain ones.
+func TestLRWOverTLS(t *testing.T) {
+	certFile, keyFile, cert := writeCert(t, t.TempDir(), "proxy.example")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	serverConn, clientConn := net.Pipe()
+	server := tls.Server(serverConn, &tls.Config{GetCertificate: r.GetCertificate})
+	client := tls.Client(clientConn, &tls.Config{ServerName: "proxy.example", RootCAs: roots})
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		_, _ = client.Write([]byte(`{"id":1,"method":"mining.subscribe","params":[]}` + "\n"))
+	}()
+
+	lrw := NewLRW(server)
+	req, err := lrw.WaitForType(stratum.Subscribe, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := req.(stratum.RequestSubscribe); !ok {
+		t.Fatalf("got %T", req)
+	}
+
+	if state := server.ConnectionState(); !strings.HasPrefix(tls.VersionName(state.Version), "TLS") {
+		t.Errorf("no TLS session, version %v", state.Version)
+	}
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+const (
+	DefaultQueueSize = 256
+
+	// Largest batch of queued messages written with a single call.
+	maxWriteBatch = 32 * 1024
+)
+
+var (
+	ErrQueueFull    = errors.New("write queue full")
+	ErrWriterClosed = errors.New("writer closed")
+)
+
+// Writer queues stratum messages for a connection and writes them from its
+// own goroutine, so a slow peer never blocks the sender. Replies are written
+// before notifications. The connection is closed when the queue overflows.
+type Writer struct {
+	conn    net.Conn
+	timeout time.Duration
+
+	replies  chan []byte
+	notifies chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func NewWriter(conn net.Conn, size int, timeout time.Duration) *Writer {
+	w := &Writer{
+		conn:     conn,
+		timeout:  timeout,
+		replies:  make(chan []byte, size),
+		notifies: make(chan []byte, size),
+		done:     make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// Reply queues a response to a request.
+func (w *Writer) Reply(resp stratum.Response) error {
+	return w.enqueue(w.replies, resp)
+}
+
+// Notify queues a server initiated message.
+func (w *Writer) Notify(resp stratum.Response) error {
+	return w.enqueue(w.notifies, resp)
+}
+
+// Request queues a request to the peer, used when we are the client.
+func (w *Writer) Request(req stratum.Request) error {
+	return w.enqueue(w.replies, req)
+}
+
+// Len returns the number of queued messages.
+func (w *Writer) Len() int {
+	return len(w.replies) + len(w.notifies)
+}
+
+// Err returns the reason the writer stopped, nil while it is running.
+func (w *Writer) Err() error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// Close stops the writer and the connection, dropping queued messages.
+func (w *Writer) Close() error {
+	w.fail(ErrWriterClosed)
+	return nil
+}
+
+func (w *Writer) enqueue(queue chan []byte, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, byte('\n'))
+
+	select {
+	case <-w.done:
+		return w.err
+	default:
+	}
+
+	select {
+	case queue <- data:
+		return nil
+	default:
+		w.fail(ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+func (w *Writer) fail(err error) {
+	w.closeOnce.Do(func() {
+		w.err = err
+		close(w.done)
+		_ = w.conn.Close()
+	})
+}
+
+// next blocks for a message, preferring replies.
+func (w *Writer) next() ([]byte, bool) {
+	select {
+	case data := <-w.replies:
+		return data, true
+	default:
+	}
+
+	select {
+	case data := <-w.replies:
+		return data, true
+	case data := <-w.notifies:
+		return data, true
+	case <-w.done:
+		return nil, false
+	}
+}
+
+// poll returns a queued message without blocking, preferring replies.
+func (w *Writer) poll() ([]byte, bool) {
+	select {
+	case data := <-w.replies:
+		return data, true
+	default:
+	}
+
+	select {
+	case data := <-w.notifies:
+		return data, true
+	default:
+		return nil, false
+	}
+}
+
+func (w *Writer) run() {
+	buf := make([]byte, 0, maxWriteBatch)
+	for {
+		data, ok := w.next()
+		if !ok {
+			return
+		}
+
+		// Coalesce whatever else is queued into the same write.
+		buf = append(buf[:0], data...)
+		for len(buf) < maxWriteBatch {
+			data, ok := w.poll()
+			if !ok {
+				break
+			}
+
+			buf = append(buf, data...)
+		}
+
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+			w.fail(err)
+			return
+		}
+
+		if _, err := w.conn.Write(buf); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+}
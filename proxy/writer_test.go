@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func TestWriterOrder(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	w := NewWriter(server, 8, time.Second)
+	defer w.Close()
+
+	// The first message is taken off the queue and blocks on the pipe.
+	if err := w.Notify(stratum.ResponseSetDifficulty{Difficulty: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && w.Len() > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	_ = w.Notify(stratum.ResponseSetDifficulty{Difficulty: 2})
+	_ = w.Reply(stratum.ResponseGeneral{ID: 7, Result: true})
+
+	r := bufio.NewReader(client)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	// Replies overtake queued notifications.
+	want := []string{"[1]", `"id":7`, "[2]"}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) {
+			t.Errorf("line %v is %q, want it to contain %v", i, line, want[i])
+		}
+	}
+
+	if w.Err() != nil {
+		t.Errorf("writer failed: %v", w.Err())
+	}
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	// The peer never reads: one message is stuck in the write, then the
+	// queue fills up.
+	w := NewWriter(server, 2, time.Minute)
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = w.Notify(stratum.ResponseSetDifficulty{Difficulty: float64(i)})
+	}
+
+	if err != ErrQueueFull {
+		t.Fatalf("got %v, want %v", err, ErrQueueFull)
+	}
+
+	if w.Err() != ErrQueueFull {
+		t.Errorf("Err() = %v", w.Err())
+	}
+
+	// The connection is closed, later messages fail.
+	if err := w.Reply(stratum.ResponseGeneral{ID: 1}); err != ErrQueueFull {
+		t.Errorf("reply after overflow: %v", err)
+	}
+
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Error("connection still open")
+	}
+}
+
+func TestWriterTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	w := NewWriter(server, 8, 20*time.Millisecond)
+	if err := w.Notify(stratum.ResponseSetDifficulty{Difficulty: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && w.Err() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if ne, ok := w.Err().(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("got %v, want a write timeout", w.Err())
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	w := NewWriter(server, 8, time.Second)
+	_ = w.Close()
+	_ = w.Close()
+
+	if err := w.Notify(stratum.ResponseSetDifficulty{Difficulty: 1}); err != ErrWriterClosed {
+		t.Errorf("notify after close: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// Solo Equihash jobs pay from a transparent v4 (Sapling) coinbase, which
+// stays valid after NU5 and, unlike v5, has a SHA256d txid.
+
+const (
+	zcashOverwintered   = 1 << 31
+	zcashSaplingVersion = 4
+	zcashSaplingGroupID = 0x892f2085
+	zcashNU5Version     = 5
+)
+
+// ZcashCoinbase is a transparent Zcash coinbase transaction the proxy builds
+// for solo work. Equihash miners roll the header nonce, so it has no
+// extranonce.
+type ZcashCoinbase struct {
+	Height  int
+	Tag     []byte
+	Outputs []TxOut
+}
+
+// Serialize the coinbase, expiring at its height as NU5 requires.
+func (c ZcashCoinbase) Serialize() []byte {
+	script := append(scriptNumber(c.Height), c.Tag...)
+	if len(script) > maxCoinbaseScript {
+		script = script[:maxCoinbaseScript]
+	}
+	// Coinbase scripts take at least 2 bytes, heights up to 16 only one.
+	if len(script) < 2 {
+		script = append(script, 0x00)
+	}
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint32(zcashOverwintered|zcashSaplingVersion))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(zcashSaplingGroupID))
+	writeCompactSize(&b, 1)
+	_, _ = b.Write(make([]byte, 32))                              // previous output hash
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0xffffffff)) // and index
+	writeCompactSize(&b, uint64(len(script)))
+	_, _ = b.Write(script)
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0xffffffff)) // sequence
+	writeCompactSize(&b, uint64(len(c.Outputs)))
+	for _, out := range c.Outputs {
+		_ = binary.Write(&b, binary.LittleEndian, out.Value)
+		writeCompactSize(&b, uint64(len(out.Script)))
+		_, _ = b.Write(out.Script)
+	}
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0))        // lock time
+	_ = binary.Write(&b, binary.LittleEndian, uint32(c.Height)) // expiry height
+	_ = binary.Write(&b, binary.LittleEndian, int64(0))         // Sapling value balance
+	writeCompactSize(&b, 0)                                     // Sapling spends
+	writeCompactSize(&b, 0)                                     // and outputs
+	writeCompactSize(&b, 0)                                     // Sprout joinsplits
+
+	return b.Bytes()
+}
+
+// ZcashTxOutputs parses the transparent outputs of a Zcash transaction of
+// any version.
+func ZcashTxOutputs(tx []byte) ([]TxOut, error) {
+	r := txReader{buf: tx}
+	header := binary.LittleEndian.Uint32(r.next(4))
+	if header&zcashOverwintered != 0 {
+		r.next(4) // version group
+	}
+	if header&^zcashOverwintered >= zcashNU5Version {
+		r.next(4 + 4 + 4) // consensus branch, lock time and expiry height
+	}
+
+	r.skipInputs()
+	outputs := r.outputs()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return outputs, nil
+}
+
+// ZcashLegacyAuthDigest is the auth digest of v4 and older transactions.
+var ZcashLegacyAuthDigest = stratum.Uint256{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+// ZcashAuthDataRoot is the root of the tree of a block's auth digests, in
+// transaction order and padded with zeros to a power of two (ZIP 244).
+func ZcashAuthDataRoot(digests []stratum.Uint256) stratum.Uint256 {
+	if len(digests) == 0 {
+		return stratum.Uint256{}
+	}
+
+	size := 1
+	for size < len(digests) {
+		size <<= 1
+	}
+
+	level := make([]stratum.Uint256, size)
+	copy(level, digests)
+	buf := make([]byte, 64)
+	for len(level) > 1 {
+		for i := 0; i < len(level); i += 2 {
+			copy(buf, level[i][:])
+			copy(buf[32:], level[i+1][:])
+			level[i/2] = blake2b256("ZcashAuthDatHash", buf)
+		}
+		level = level[:len(level)/2]
+	}
+
+	return level[0]
+}
+
+// ZcashBlockCommitments is the hashBlockCommitments of an NU5 header,
+// committing to the chain history and the block's auth data (ZIP 244).
+func ZcashBlockCommitments(chainHistoryRoot, authDataRoot stratum.Uint256) stratum.Uint256 {
+	buf := make([]byte, 96)
+	copy(buf, chainHistoryRoot[:])
+	copy(buf[32:], authDataRoot[:])
+
+	return blake2b256("ZcashBlockCommit", buf)
+}
+
+// ZcashBlock serialises the block a share of a solo Equihash job solves.
+func (w *Work) ZcashBlock(share Share) []byte {
+	b := BuildBlockHeader(w.Version, w.HashPrevBlock[:], w.HashMerkleRoot[:], w.HashReserved[:], share.NTime, w.NBits, share.NoncePart1, share.NoncePart2)
+	writeCompactSize(b, uint64(len(share.Solution)))
+	_, _ = b.Write(share.Solution)
+
+	writeCompactSize(b, uint64(len(w.Transactions)))
+	for _, tx := range w.Transactions {
+		_, _ = b.Write(tx)
+	}
+
+	return b.Bytes()
+}
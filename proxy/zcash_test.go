@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func TestZcashCoinbase(t *testing.T) {
+	opTrue := []TxOut{{Value: 312500000, Script: []byte{0x51}}, {Value: 1, Script: []byte{0x6a}}}
+
+	tests := []struct {
+		name     string
+		coinbase ZcashCoinbase
+		want     string
+	}{
+		{
+			name:     "tagged",
+			coinbase: ZcashCoinbase{Height: 2000000, Tag: []byte("/x/"), Outputs: opTrue},
+			want: "04000080" + "85202f89" + "01" + strings.Repeat("00", 32) + "ffffffff" + "07" + "0380841e" + "2f782f" + "ffffffff" +
+				"02" + "205fa01200000000" + "0151" + "0100000000000000" + "016a" +
+				"00000000" + "80841e00" + "0000000000000000" + "000000",
+		},
+		{
+			name:     "short script",
+			coinbase: ZcashCoinbase{Height: 1, Outputs: opTrue[:1]},
+			want: "04000080" + "85202f89" + "01" + strings.Repeat("00", 32) + "ffffffff" + "02" + "5100" + "ffffffff" +
+				"01" + "205fa01200000000" + "0151" +
+				"00000000" + "01000000" + "0000000000000000" + "000000",
+		},
+	}
+
+	for _, tt := range tests {
+		tx := tt.coinbase.Serialize()
+		if got := hex.EncodeToString(tx); got != tt.want {
+			t.Errorf("%s:\ngot  %v\nwant %v", tt.name, got, tt.want)
+		}
+
+		outputs, err := ZcashTxOutputs(tx)
+		if err != nil || len(outputs) != len(tt.coinbase.Outputs) {
+			t.Errorf("%s: outputs %v, %v", tt.name, outputs, err)
+			continue
+		}
+		for i, out := range outputs {
+			if out.Value != tt.coinbase.Outputs[i].Value || !bytes.Equal(out.Script, tt.coinbase.Outputs[i].Script) {
+				t.Errorf("%s: output %d %+v", tt.name, i, out)
+			}
+		}
+	}
+
+	// The script stays within 100 bytes.
+	tx := ZcashCoinbase{Height: 2000000, Tag: bytes.Repeat([]byte{'x'}, 200)}.Serialize()
+	if tx[4+4+1+36] != 100 {
+		t.Errorf("script of %d bytes", tx[4+4+1+36])
+	}
+}
+
+func TestZcashTxOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		tx      string
+		outputs int
+		value   uint64
+	}{
+		// Bitcoin's block 1 coinbase, as Zcash's v1 transactions are.
+		{name: "v1", tx: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0704ffff001d0104ffffffff0100f2052a0100000043410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac00000000", outputs: 1, value: 5000000000},
+		{
+			name: "v5",
+			tx: "05000080" + "0a27a726" + "b4d0d6c2" + "00000000" + "80841e00" +
+				"01" + strings.Repeat("00", 32) + "ffffffff" + "040380841e" + "ffffffff" +
+				"01" + "205fa01200000000" + "0151" + "000000",
+			outputs: 1,
+			value:   312500000,
+		},
+		{name: "truncated", tx: "0400008085202f8901"},
+	}
+
+	for _, tt := range tests {
+		tx, _ := hex.DecodeString(tt.tx)
+		outputs, err := ZcashTxOutputs(tx)
+		if tt.outputs == 0 {
+			if err != ErrBadCoinbase {
+				t.Errorf("%s: outputs %v, %v", tt.name, outputs, err)
+			}
+			continue
+		}
+
+		if err != nil || len(outputs) != tt.outputs || outputs[0].Value != tt.value {
+			t.Errorf("%s: outputs %+v, %v", tt.name, outputs, err)
+		}
+	}
+}
+
+func TestZcashCommitments(t *testing.T) {
+	a := stratum.Uint256{}
+	b := stratum.Uint256{}
+	c := stratum.Uint256{}
+	for i := range a {
+		a[i], b[i], c[i] = 1, 2, 3
+	}
+
+	tests := []struct {
+		name    string
+		digests []stratum.Uint256
+		want    string
+	}{
+		{name: "none", want: strings.Repeat("00", 32)},
+		{name: "coinbase only", digests: []stratum.Uint256{ZcashLegacyAuthDigest}, want: strings.Repeat("ff", 32)},
+		{name: "two", digests: []stratum.Uint256{a, b}, want: "754f093fbd3f95959470193b4ecb0c1c4a836b644d8a3fe0506ef92bbee2d490"},
+		// Padded with a zero leaf.
+		{name: "three", digests: []stratum.Uint256{a, b, c}, want: "63ff4fbeadfc7fc7e7eafecd624b59f292c84c3fda5662f9ffcf5e9b041ccc21"},
+	}
+
+	for _, tt := range tests {
+		root := ZcashAuthDataRoot(tt.digests)
+		if got := hex.EncodeToString(root[:]); got != tt.want {
+			t.Errorf("%s: auth data root %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	commitments := ZcashBlockCommitments(a, ZcashLegacyAuthDigest)
+	if got := hex.EncodeToString(commitments[:]); got != "f616b5cddef63a35c7337ee0180715e812bf82bfdadc7d5052fa0cc57267713b" {
+		t.Errorf("block commitments %v", got)
+	}
+}
+
+func TestZcashBlock(t *testing.T) {
+	w := zcashGenesisWork(t)
+	w.Transactions = [][]byte{{0xaa, 0xbb}, {0xcc}}
+
+	share := Share{
+		NTime:      w.NTime + 1,
+		NoncePart1: bytes.Repeat([]byte{0x01}, 16),
+		NoncePart2: bytes.Repeat([]byte{0x02}, 16),
+		Solution:   bytes.Repeat([]byte{0x03}, 1344),
+	}
+
+	block := w.ZcashBlock(share)
+	const headerSize = 140 + 3 + 1344
+	if len(block) != headerSize+1+3 {
+		t.Fatalf("block of %d bytes", len(block))
+	}
+
+	// The share's header, ntime included, then the transactions.
+	if BlockHash(block[:headerSize]) != w.ShareHash(share) {
+		t.Error("block hash isn't the share's")
+	}
+	if !bytes.Equal(block[100:104], []byte{0x90, 0x04, 0x13, 0x59}) {
+		t.Errorf("ntime %x", block[100:104])
+	}
+	if !bytes.Equal(block[140:143], []byte{0xfd, 0x40, 0x05}) || !bytes.Equal(block[headerSize:], []byte{0x02, 0xaa, 0xbb, 0xcc}) {
+		t.Errorf("block %x", block[140:])
+	}
+}
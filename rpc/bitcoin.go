@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+)
+
+// Replies of the bitcoind calls the proxy makes.
+type (
+	// BlockTemplate from getblocktemplate, see BIP 22 and 23.
+	BlockTemplate struct {
+		Version           uint32                `json:"version"`
+		Rules             []string              `json:"rules"`
+		VBAvailable       map[string]int        `json:"vbavailable"`
+		VBRequired        uint32                `json:"vbrequired"`
+		PreviousBlockHash string                `json:"previousblockhash"`
+		Transactions      []TemplateTransaction `json:"transactions"`
+		CoinbaseAux       map[string]string     `json:"coinbaseaux"`
+		CoinbaseValue     int64                 `json:"coinbasevalue"`
+		LongPollID        string                `json:"longpollid"`
+		Target            string                `json:"target"`
+		MinTime           int64                 `json:"mintime"`
+		Mutable           []string              `json:"mutable"`
+		NonceRange        string                `json:"noncerange"`
+		SigOpLimit        int                   `json:"sigoplimit"`
+		SizeLimit         int                   `json:"sizelimit"`
+		WeightLimit       int                   `json:"weightlimit"`
+		CurTime           int64                 `json:"curtime"`
+		Bits              string                `json:"bits"`
+		Height            int                   `json:"height"`
+
+		DefaultWitnessCommitment string `json:"default_witness_commitment"`
+
+		// zcashd's coinbase for its -mineraddress, and the header roots of
+		// a block with it.
+		CoinbaseTxn          *TemplateTransaction `json:"coinbasetxn"`
+		DefaultRoots         *TemplateRoots       `json:"defaultroots"`
+		FinalSaplingRootHash string               `json:"finalsaplingroothash"`
+	}
+
+	TemplateTransaction struct {
+		Data    string `json:"data"`
+		TxID    string `json:"txid"`
+		Hash    string `json:"hash"`
+		Depends []int  `json:"depends"`
+		Fee     int64  `json:"fee"`
+		SigOps  int    `json:"sigops"`
+		Weight  int    `json:"weight"`
+
+		// zcashd's, see ZIP 244.
+		AuthDigest string `json:"authdigest"`
+	}
+
+	// TemplateRoots from zcashd, ChainHistoryRoot since Heartwood and
+	// AuthDataRoot since NU5.
+	TemplateRoots struct {
+		MerkleRoot           string `json:"merkleroot"`
+		ChainHistoryRoot     string `json:"chainhistoryroot"`
+		AuthDataRoot         string `json:"authdataroot"`
+		BlockCommitmentsHash string `json:"blockcommitmentshash"`
+	}
+
+	BlockchainInfo struct {
+		Chain                string  `json:"chain"`
+		Blocks               int     `json:"blocks"`
+		Headers              int     `json:"headers"`
+		BestBlockHash        string  `json:"bestblockhash"`
+		Difficulty           float64 `json:"difficulty"`
+		MedianTime           int64   `json:"mediantime"`
+		VerificationProgress float64 `json:"verificationprogress"`
+		InitialBlockDownload bool    `json:"initialblockdownload"`
+		Pruned               bool    `json:"pruned"`
+	}
+
+	// BlockHeader from getblockheader, Confirmations is -1 off the main chain.
+	BlockHeader struct {
+		Hash              string  `json:"hash"`
+		Confirmations     int     `json:"confirmations"`
+		Height            int     `json:"height"`
+		Version           uint32  `json:"version"`
+		MerkleRoot        string  `json:"merkleroot"`
+		Time              int64   `json:"time"`
+		Nonce             uint32  `json:"nonce"`
+		Bits              string  `json:"bits"`
+		Difficulty        float64 `json:"difficulty"`
+		PreviousBlockHash string  `json:"previousblockhash"`
+		NextBlockHash     string  `json:"nextblockhash"`
+	}
+
+	// Block from getblock with verbosity 1, transactions as IDs.
+	Block struct {
+		BlockHeader
+
+		Size         int      `json:"size"`
+		Weight       int      `json:"weight"`
+		Transactions []string `json:"tx"`
+	}
+
+	AddressInfo struct {
+		IsValid      bool   `json:"isvalid"`
+		Address      string `json:"address"`
+		ScriptPubKey string `json:"scriptPubKey"`
+		IsScript     bool   `json:"isscript"`
+		IsWitness    bool   `json:"iswitness"`
+	}
+)
+
+// Error codes of bitcoind.
+const (
+	ErrorCodeNotFound = -5
+)
+
+// Template rules the proxy understands.
+var templateRules = []string{"segwit"}
+
+// GetBlockTemplate asks for a new template, or waits for the one after
+// longPollID when set.
+func (c *Client) GetBlockTemplate(ctx context.Context, longPollID string) (*BlockTemplate, error) {
+	request := map[string]interface{}{"rules": templateRules}
+	if longPollID != "" {
+		request["longpollid"] = longPollID
+	}
+
+	var template BlockTemplate
+	if err := c.CallContext(ctx, "getblocktemplate", []interface{}{request}, &template); err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+// SubmitBlock submits a serialised block, the node's reason for refusing it
+// is returned as an error. Inconclusive blocks were accepted but not yet
+// validated, see BIP 22.
+func (c *Client) SubmitBlock(ctx context.Context, block string) error {
+	var reason *string
+	if err := c.CallContext(ctx, "submitblock", []interface{}{block}, &reason); err != nil {
+		return err
+	}
+
+	if reason != nil && *reason != "inconclusive" {
+		return errors.New("block rejected: " + *reason)
+	}
+
+	return nil
+}
+
+func (c *Client) GetBlockchainInfo(ctx context.Context) (*BlockchainInfo, error) {
+	var info BlockchainInfo
+	if err := c.CallContext(ctx, "getblockchaininfo", nil, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+func (c *Client) GetBestBlockHash(ctx context.Context) (string, error) {
+	var hash string
+	err := c.CallContext(ctx, "getbestblockhash", nil, &hash)
+	return hash, err
+}
+
+func (c *Client) GetBlockHeader(ctx context.Context, hash string) (*BlockHeader, error) {
+	var header BlockHeader
+	if err := c.CallContext(ctx, "getblockheader", []interface{}{hash}, &header); err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+func (c *Client) GetBlock(ctx context.Context, hash string) (*Block, error) {
+	var block Block
+	if err := c.CallContext(ctx, "getblock", []interface{}{hash, 1}, &block); err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+func (c *Client) ValidateAddress(ctx context.Context, address string) (*AddressInfo, error) {
+	var info AddressInfo
+	if err := c.CallContext(ctx, "validateaddress", []interface{}{address}, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
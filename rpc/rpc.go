@@ -2,77 +2,301 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type (
+	// Client calls a node's JSON-RPC interface, moving on to the next node
+	// when one can't be reached.
 	Client struct {
-		address url.URL
+		nodes   []node
+		opts    Options
+		http    *http.Client
+		nextID  uint64
+		current int
+		mu      sync.Mutex
+	}
+
+	// Options for a Client, the zero value uses the defaults.
+	Options struct {
+		// The node's .cookie file, read on every call for nodes whose URL
+		// has no credentials.
+		CookieFile string
+
+		// Limit on each call, including the tries on every node, unless
+		// its context has a deadline of its own.
+		Timeout time.Duration
+	}
+
+	node struct {
+		url      string
+		user     string
+		password string
+	}
+
+	// Error is an error the node replied with.
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// BatchCall is one call of a batch, its result and error are set when
+	// the batch returns.
+	BatchCall struct {
+		Method string
+		Params []interface{}
+		Result interface{}
+		Err    error
 	}
 
 	rpcCall struct {
-		Method string        `json:"method"`
-		ID     *string       `json:"id"`
-		Params []interface{} `json:"params"`
+		JSONRPC string        `json:"jsonrpc"`
+		Method  string        `json:"method"`
+		ID      uint64        `json:"id"`
+		Params  []interface{} `json:"params"`
 	}
 
 	rpcResult struct {
-		Result *json.RawMessage       `json:"result"`
-		Error  map[string]interface{} `json:"error"`
-		ID     *string                `json:"id"`
+		Result *json.RawMessage `json:"result"`
+		Error  *Error           `json:"error"`
+		ID     uint64           `json:"id"`
 	}
+)
+
+const DefaultTimeout = 30 * time.Second
 
-	rpcResultError map[string]interface{}
+var (
+	ErrNoNodes      = errors.New("no nodes configured")
+	ErrUnauthorized = errors.New("node refused the credentials")
+	ErrBatchReply   = errors.New("batch reply doesn't match the calls")
 )
 
-func (rpe rpcResultError) Error() string {
-	return fmt.Sprint(map[string]interface{}(rpe))
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %v: %v", e.Code, e.Message)
 }
 
-func NewClient(address string) (*Client, error) {
-	url, err := url.Parse(address)
-	if err != nil {
-		return nil, err
+// ErrorCode returns the code of an error the node replied with.
+func ErrorCode(err error) (int, bool) {
+	rpe, ok := err.(*Error)
+	if !ok {
+		return 0, false
 	}
 
-	return &Client{
-		address: *url,
-	}, nil
+	return rpe.Code, true
 }
 
+// NewClient for the nodes at addresses, credentials may be in the URLs.
+func NewClient(addresses []string, opts Options) (*Client, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	c := Client{
+		opts: opts,
+		http: &http.Client{},
+	}
+
+	for _, address := range addresses {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+
+		n := node{}
+		if u.User != nil {
+			n.user = u.User.Username()
+			n.password, _ = u.User.Password()
+			u.User = nil
+		}
+		n.url = u.String()
+
+		c.nodes = append(c.nodes, n)
+	}
+
+	return &c, nil
+}
+
+// Call a method with the client's timeout.
 func (c *Client) Call(method string, params []interface{}, result interface{}) error {
-	data, err := json.Marshal(rpcCall{
-		Method: method,
-		ID:     nil,
-		Params: params,
-	})
-	if err != nil {
+	return c.CallContext(context.Background(), method, params, result)
+}
+
+// CallContext calls a method, unmarshalling its result into result.
+func (c *Client) CallContext(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	call := rpcCall{
+		JSONRPC: "1.0",
+		Method:  method,
+		ID:      atomic.AddUint64(&c.nextID, 1),
+		Params:  params,
+	}
+	if call.Params == nil {
+		call.Params = []interface{}{}
+	}
+
+	var reply rpcResult
+	if err := c.post(ctx, call, &reply); err != nil {
 		return err
 	}
 
-	resp, err := http.Post(c.address.String(), "application/json", bytes.NewBuffer(data))
-	if err != nil {
+	return reply.decode(result)
+}
+
+// Batch sends several calls in one request, each call gets its own result.
+// The error is for the request as a whole.
+func (c *Client) Batch(ctx context.Context, calls []*BatchCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	request := make([]rpcCall, len(calls))
+	byID := make(map[uint64]*BatchCall, len(calls))
+	for i, call := range calls {
+		request[i] = rpcCall{
+			JSONRPC: "1.0",
+			Method:  call.Method,
+			ID:      atomic.AddUint64(&c.nextID, 1),
+			Params:  call.Params,
+		}
+		if request[i].Params == nil {
+			request[i].Params = []interface{}{}
+		}
+
+		byID[request[i].ID] = call
+	}
+
+	var replies []rpcResult
+	if err := c.post(ctx, request, &replies); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	var rpcResult rpcResult
-	if err := json.NewDecoder(resp.Body).Decode(&rpcResult); err != nil {
+	if len(replies) != len(calls) {
+		return ErrBatchReply
+	}
+
+	for _, reply := range replies {
+		call, ok := byID[reply.ID]
+		if !ok {
+			return ErrBatchReply
+		}
+
+		call.Err = reply.decode(call.Result)
+		delete(byID, reply.ID)
+	}
+
+	return nil
+}
+
+func (r *rpcResult) decode(result interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.Result != nil && result != nil {
+		return json.Unmarshal([]byte(*r.Result), result)
+	}
+
+	return nil
+}
+
+// post sends a request to the current node, trying the others in turn when
+// it fails. Replies with an RPC error come from a working node.
+func (c *Client) post(ctx context.Context, request interface{}, reply interface{}) error {
+	data, err := json.Marshal(request)
+	if err != nil {
 		return err
 	}
 
-	if rpcResult.Error != nil {
-		return rpcResultError(rpcResult.Error)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
 	}
 
-	if rpcResult.Result != nil {
-		if err := json.Unmarshal([]byte(*rpcResult.Result), result); err != nil {
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+
+	for i := range c.nodes {
+		index := (start + i) % len(c.nodes)
+		err = c.postNode(ctx, c.nodes[index], data, reply)
+		if err == nil {
+			c.mu.Lock()
+			c.current = index
+			c.mu.Unlock()
+			return nil
+		}
+
+		if ctx.Err() != nil {
 			return err
 		}
 	}
 
+	return err
+}
+
+func (c *Client) postNode(ctx context.Context, n node, data []byte, reply interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	user, password := n.user, n.password
+	if user == "" && c.opts.CookieFile != "" {
+		if user, password, err = readCookie(c.opts.CookieFile); err != nil {
+			return err
+		}
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return ErrUnauthorized
+	}
+
+	// The node replies to failed calls with an error status and the error
+	// in the body.
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return fmt.Errorf("node replied %v: %v", resp.Status, err)
+	}
+
 	return nil
 }
+
+// readCookie reads user:password from a node's cookie file, which changes
+// every time the node starts.
+func readCookie(path string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(string(data)), ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid cookie file: " + path)
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,338 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCall struct {
+	ID     uint64            `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// testNode replies to calls with reply, checking the credentials when user
+// is set.
+func testNode(t *testing.T, user, password string, reply func(testCall) (interface{}, *Error)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); user != "" && (u != user || p != password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		answer := func(c testCall) map[string]interface{} {
+			result, err := reply(c)
+			return map[string]interface{}{"id": c.ID, "result": result, "error": err}
+		}
+
+		if strings.HasPrefix(string(body), "[") {
+			var calls []testCall
+			_ = json.Unmarshal(body, &calls)
+
+			// Replies to a batch may come in any order.
+			var replies []map[string]interface{}
+			for i := len(calls) - 1; i >= 0; i-- {
+				replies = append(replies, answer(calls[i]))
+			}
+			_ = json.NewEncoder(w).Encode(replies)
+			return
+		}
+
+		var c testCall
+		_ = json.Unmarshal(body, &c)
+
+		a := answer(c)
+		// bitcoind sends failed calls with an error status.
+		if a["error"].(*Error) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_ = json.NewEncoder(w).Encode(a)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func echoNode(c testCall) (interface{}, *Error) {
+	switch c.Method {
+	case "getbestblockhash":
+		return "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048", nil
+	case "getblockheader":
+		var hash string
+		_ = json.Unmarshal(c.Params[0], &hash)
+		if hash == "unknown" {
+			return nil, &Error{Code: ErrorCodeNotFound, Message: "Block not found"}
+		}
+		return BlockHeader{Hash: hash, Confirmations: 1, Height: 1}, nil
+	}
+
+	return nil, &Error{Code: -32601, Message: "Method not found"}
+}
+
+func TestClientCall(t *testing.T) {
+	node := testNode(t, "user", "pass", echoNode)
+	address := strings.Replace(node.URL, "http://", "http://user:pass@", 1)
+
+	c, err := NewClient([]string{address}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := c.GetBestBlockHash(context.Background())
+	if err != nil || hash != "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048" {
+		t.Errorf("best block %q, %v", hash, err)
+	}
+
+	header, err := c.GetBlockHeader(context.Background(), "abc")
+	if err != nil || header.Hash != "abc" || header.Confirmations != 1 {
+		t.Errorf("header %+v, %v", header, err)
+	}
+
+	_, err = c.GetBlockHeader(context.Background(), "unknown")
+	if code, ok := ErrorCode(err); !ok || code != ErrorCodeNotFound {
+		t.Errorf("unknown block: %v", err)
+	}
+
+	if err := c.Call("nonsense", nil, nil); err == nil {
+		t.Error("unknown method succeeded")
+	}
+
+	if _, ok := ErrorCode(ErrNoNodes); ok {
+		t.Error("error code of a client error")
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	node := testNode(t, "__cookie__", "abc123", echoNode)
+
+	cookie := filepath.Join(t.TempDir(), ".cookie")
+	if err := ioutil.WriteFile(cookie, []byte("__cookie__:abc123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient([]string{node.URL}, Options{CookieFile: cookie})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBestBlockHash(context.Background()); err != nil {
+		t.Errorf("with the cookie: %v", err)
+	}
+
+	// The node writes a new cookie when it restarts.
+	if err := ioutil.WriteFile(cookie, []byte("__cookie__:stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBestBlockHash(context.Background()); err != ErrUnauthorized {
+		t.Errorf("with a stale cookie: %v", err)
+	}
+
+	if err := ioutil.WriteFile(cookie, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBestBlockHash(context.Background()); err == nil {
+		t.Error("called with an invalid cookie")
+	}
+
+	// Credentials in the URL win over the cookie.
+	c, err = NewClient([]string{strings.Replace(node.URL, "http://", "http://__cookie__:abc123@", 1)}, Options{CookieFile: cookie})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBestBlockHash(context.Background()); err != nil {
+		t.Errorf("with credentials in the URL: %v", err)
+	}
+
+	if _, err := NewClient(nil, Options{}); err != ErrNoNodes {
+		t.Errorf("client without nodes: %v", err)
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	calls := 0
+	up := testNode(t, "", "", func(c testCall) (interface{}, *Error) {
+		calls++
+		return echoNode(c)
+	})
+
+	c, err := NewClient([]string{down.URL, up.URL}, Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetBestBlockHash(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The working node is tried first from then on.
+	if c.current != 1 || calls != 2 {
+		t.Errorf("current node %d after %d calls", c.current, calls)
+	}
+
+	// RPC errors come from a working node, there is no moving on.
+	c.current = 0
+	if _, err := c.GetBlockHeader(context.Background(), "unknown"); err == nil {
+		t.Error("unknown block found")
+	}
+	if c.current != 1 {
+		t.Errorf("current node %d after an rpc error", c.current)
+	}
+
+	c, err = NewClient([]string{down.URL}, Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBestBlockHash(context.Background()); err == nil {
+		t.Error("called a node that is down")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	c, err := NewClient([]string{slow.URL}, Options{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := c.GetBestBlockHash(context.Background()); err == nil {
+		t.Error("slow call succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	node := testNode(t, "", "", echoNode)
+
+	c, err := NewClient([]string{node.URL}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hash string
+	var header, unknown BlockHeader
+	calls := []*BatchCall{
+		{Method: "getbestblockhash", Result: &hash},
+		{Method: "getblockheader", Params: []interface{}{"abc"}, Result: &header},
+		{Method: "getblockheader", Params: []interface{}{"unknown"}, Result: &unknown},
+	}
+
+	if err := c.Batch(context.Background(), calls); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls[0].Err != nil || hash == "" {
+		t.Errorf("best block %q, %v", hash, calls[0].Err)
+	}
+	if calls[1].Err != nil || header.Hash != "abc" {
+		t.Errorf("header %+v, %v", header, calls[1].Err)
+	}
+	if code, _ := ErrorCode(calls[2].Err); code != ErrorCodeNotFound {
+		t.Errorf("unknown block: %v", calls[2].Err)
+	}
+
+	if err := c.Batch(context.Background(), nil); err != nil {
+		t.Errorf("empty batch: %v", err)
+	}
+
+	// A node that drops calls from the batch.
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id":1,"result":"x","error":null}]`))
+	}))
+	defer short.Close()
+
+	c, err = NewClient([]string{short.URL}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Batch(context.Background(), calls); err != ErrBatchReply {
+		t.Errorf("short batch reply: %v", err)
+	}
+}
+
+func TestSubmitBlock(t *testing.T) {
+	tests := []struct {
+		reason interface{}
+		err    bool
+	}{
+		{reason: nil},
+		{reason: "inconclusive"},
+		{reason: "duplicate", err: true},
+		{reason: "high-hash", err: true},
+	}
+
+	for _, tt := range tests {
+		var block string
+		node := testNode(t, "", "", func(c testCall) (interface{}, *Error) {
+			_ = json.Unmarshal(c.Params[0], &block)
+			return tt.reason, nil
+		})
+
+		c, err := NewClient([]string{node.URL}, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.SubmitBlock(context.Background(), "0100")
+		if (err != nil) != tt.err {
+			t.Errorf("%v: %v", tt.reason, err)
+		}
+		if block != "0100" {
+			t.Errorf("%v: submitted %q", tt.reason, block)
+		}
+	}
+}
+
+func TestGetBlockTemplate(t *testing.T) {
+	var request map[string]interface{}
+	node := testNode(t, "", "", func(c testCall) (interface{}, *Error) {
+		request = nil
+		_ = json.Unmarshal(c.Params[0], &request)
+		return BlockTemplate{Height: 840000, Bits: "17034219", LongPollID: "abc0"}, nil
+	})
+
+	c, err := NewClient([]string{node.URL}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := c.GetBlockTemplate(context.Background(), "")
+	if err != nil || template.Height != 840000 || template.Bits != "17034219" {
+		t.Fatalf("template %+v, %v", template, err)
+	}
+	if _, ok := request["longpollid"]; ok {
+		t.Errorf("requested %v without a long poll", request)
+	}
+	if rules, _ := request["rules"].([]interface{}); len(rules) != 1 || rules[0] != "segwit" {
+		t.Errorf("requested rules %v", request["rules"])
+	}
+
+	if _, err := c.GetBlockTemplate(context.Background(), "abc0"); err != nil {
+		t.Fatal(err)
+	}
+	if request["longpollid"] != "abc0" {
+		t.Errorf("requested %v, want the long poll", request)
+	}
+}
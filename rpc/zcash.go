@@ -0,0 +1,31 @@
+package rpc
+
+import "context"
+
+// Replies of the zcashd calls the proxy makes.
+type (
+	// BlockSubsidy from getblocksubsidy, amounts in ZEC. Funding streams
+	// paid to a lockbox have no address and no output.
+	BlockSubsidy struct {
+		Miner          float64         `json:"miner"`
+		Founders       float64         `json:"founders"`
+		FundingStreams []FundingStream `json:"fundingstreams"`
+	}
+
+	FundingStream struct {
+		Recipient string  `json:"recipient"`
+		Value     float64 `json:"value"`
+		ValueZat  int64   `json:"valueZat"`
+		Address   string  `json:"address"`
+	}
+)
+
+// GetBlockSubsidy returns how the block reward at height is split.
+func (c *Client) GetBlockSubsidy(ctx context.Context, height int) (*BlockSubsidy, error) {
+	var subsidy BlockSubsidy
+	if err := c.CallContext(ctx, "getblocksubsidy", []interface{}{height}, &subsidy); err != nil {
+		return nil, err
+	}
+
+	return &subsidy, nil
+}
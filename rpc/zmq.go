@@ -0,0 +1,187 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// A ZeroMQ SUB socket speaking ZMTP 3.0 with the NULL mechanism, enough to
+// follow a node's -zmqpub* notifications. See https://rfc.zeromq.org/spec/23/
+
+var (
+	ErrZMQGreeting = errors.New("invalid zmtp greeting")
+	ErrZMQFrame    = errors.New("invalid zmtp frame")
+)
+
+const (
+	zmqFlagMore    = 0x01
+	zmqFlagLong    = 0x02
+	zmqFlagCommand = 0x04
+
+	// Larger frames than a node sends are refused rather than allocated.
+	zmqMaxFrame = 1 << 20
+
+	zmqDialTimeout = 10 * time.Second
+)
+
+// ZMQSubscriber receives the messages a publisher sends on its topics.
+type ZMQSubscriber struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// DialZMQ connects to a tcp://host:port publisher and subscribes to topics.
+func DialZMQ(address string, topics ...string) (*ZMQSubscriber, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "tcp" {
+		return nil, errors.New("unsupported zmq address: " + address)
+	}
+
+	dialer := net.Dialer{Timeout: zmqDialTimeout, KeepAlive: 30 * time.Second}
+	conn, err := dialer.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	s := ZMQSubscriber{conn: conn, reader: bufio.NewReader(conn)}
+	if err := s.handshake(topics); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *ZMQSubscriber) handshake(topics []string) error {
+	if err := s.conn.SetDeadline(time.Now().Add(zmqDialTimeout)); err != nil {
+		return err
+	}
+
+	// Signature, version 3.0, NULL mechanism and as-server unset.
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3
+	copy(greeting[12:], "NULL")
+	if _, err := s.conn.Write(greeting); err != nil {
+		return err
+	}
+
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(s.reader, peer); err != nil {
+		return err
+	}
+
+	if peer[0] != 0xff || peer[9] != 0x7f || peer[10] < 3 || string(peer[12:16]) != "NULL" {
+		return ErrZMQGreeting
+	}
+
+	ready := []byte("\x05READY\x0bSocket-Type\x00\x00\x00\x03SUB")
+	if err := s.writeFrame(zmqFlagCommand, ready); err != nil {
+		return err
+	}
+
+	// The publisher's READY, its properties don't matter to us.
+	flags, _, err := s.readFrame()
+	if err != nil {
+		return err
+	}
+
+	if flags&zmqFlagCommand == 0 {
+		return ErrZMQFrame
+	}
+
+	// ZMTP 3.0 subscriptions are messages starting with 1.
+	for _, topic := range topics {
+		if err := s.writeFrame(0, append([]byte{1}, topic...)); err != nil {
+			return err
+		}
+	}
+
+	return s.conn.SetDeadline(time.Time{})
+}
+
+func (s *ZMQSubscriber) writeFrame(flags byte, body []byte) error {
+	var header []byte
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flags | zmqFlagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flags, byte(len(body))}
+	}
+
+	_, err := s.conn.Write(append(header, body...))
+	return err
+}
+
+func (s *ZMQSubscriber) readFrame() (byte, []byte, error) {
+	flags, err := s.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var size uint64
+	if flags&zmqFlagLong != 0 {
+		var buf [8]byte
+		if _, err := io.ReadFull(s.reader, buf[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(buf[:])
+	} else {
+		b, err := s.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+
+	if size > zmqMaxFrame {
+		return 0, nil, ErrZMQFrame
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(s.reader, body); err != nil {
+		return 0, nil, err
+	}
+
+	return flags, body, nil
+}
+
+// Receive waits for the next message, its topic first. Commands from the
+// publisher, e.g. heartbeats, are skipped.
+func (s *ZMQSubscriber) Receive() ([][]byte, error) {
+	var parts [][]byte
+	for {
+		flags, body, err := s.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if flags&zmqFlagCommand != 0 {
+			if parts != nil {
+				return nil, ErrZMQFrame
+			}
+			continue
+		}
+
+		parts = append(parts, body)
+		if flags&zmqFlagMore == 0 {
+			return parts, nil
+		}
+	}
+}
+
+// Close the connection.
+func (s *ZMQSubscriber) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,211 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func zmqFrame(flags byte, body []byte) []byte {
+	if len(body) > 255 {
+		header := make([]byte, 9)
+		header[0] = flags | zmqFlagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+		return append(header, body...)
+	}
+	return append([]byte{flags, byte(len(body))}, body...)
+}
+
+func zmqGreeting() []byte {
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3
+	copy(greeting[12:], "NULL")
+	greeting[16] = 1 // as-server
+	return greeting
+}
+
+// testPublisher accepts one subscriber, sends it greeting and, once it is
+// ready, the publisher's READY. The n subscriptions it reads go to topics
+// before publish writes to the connection.
+func testPublisher(t *testing.T, greeting []byte, n int, publish func(net.Conn)) (string, <-chan []string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	topics := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		if _, err := conn.Write(greeting); err != nil {
+			return
+		}
+
+		peer := make([]byte, 64)
+		if _, err := io.ReadFull(r, peer); err != nil {
+			return
+		}
+
+		frame := func() (byte, []byte, error) {
+			var header [2]byte
+			if _, err := io.ReadFull(r, header[:]); err != nil {
+				return 0, nil, err
+			}
+			body := make([]byte, header[1])
+			_, err := io.ReadFull(r, body)
+			return header[0], body, err
+		}
+
+		// Subscribers refusing the greeting hang up here.
+		flags, body, err := frame()
+		if err != nil {
+			return
+		}
+		if flags != zmqFlagCommand || !bytes.HasPrefix(body, []byte("\x05READY")) {
+			t.Errorf("subscriber sent %x %q, want its READY", flags, body)
+			return
+		}
+
+		ready := []byte("\x05READY\x0bSocket-Type\x00\x00\x00\x03PUB")
+		if _, err := conn.Write(zmqFrame(zmqFlagCommand, ready)); err != nil {
+			return
+		}
+
+		var subscribed []string
+		for len(subscribed) < n {
+			flags, body, err := frame()
+			if err != nil || flags != 0 || len(body) == 0 || body[0] != 1 {
+				break
+			}
+			subscribed = append(subscribed, string(body[1:]))
+		}
+		topics <- subscribed
+
+		publish(conn)
+	}()
+
+	return "tcp://" + l.Addr().String(), topics
+}
+
+func TestZMQSubscriber(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xab}, 32)
+	block := bytes.Repeat([]byte{0x01}, 1000)
+	sequence := []byte{1, 0, 0, 0}
+
+	address, topics := testPublisher(t, zmqGreeting(), 2, func(conn net.Conn) {
+		var stream []byte
+		stream = append(stream, zmqFrame(zmqFlagMore, []byte("hashblock"))...)
+		stream = append(stream, zmqFrame(zmqFlagMore, hash)...)
+		stream = append(stream, zmqFrame(0, sequence)...)
+		// A heartbeat between messages.
+		stream = append(stream, zmqFrame(zmqFlagCommand, []byte("\x04PING\x00\x00"))...)
+		stream = append(stream, zmqFrame(zmqFlagMore, []byte("rawblock"))...)
+		stream = append(stream, zmqFrame(0, block)...)
+		_, _ = conn.Write(stream)
+	})
+
+	s, err := DialZMQ(address, "hashblock", "rawblock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if subscribed := <-topics; len(subscribed) != 2 || subscribed[0] != "hashblock" || subscribed[1] != "rawblock" {
+		t.Errorf("subscribed to %q", subscribed)
+	}
+
+	parts, err := s.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 || string(parts[0]) != "hashblock" || !bytes.Equal(parts[1], hash) || !bytes.Equal(parts[2], sequence) {
+		t.Errorf("received %x", parts)
+	}
+
+	parts, err = s.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || string(parts[0]) != "rawblock" || !bytes.Equal(parts[1], block) {
+		t.Errorf("received %d parts, want the long block frame", len(parts))
+	}
+
+	if _, err := s.Receive(); err == nil {
+		t.Error("received after the publisher closed")
+	}
+}
+
+func TestZMQSubscriberErrors(t *testing.T) {
+	hashblock := zmqFrame(zmqFlagMore, []byte("hashblock"))
+
+	oversized := make([]byte, 9)
+	oversized[0] = zmqFlagLong
+	binary.BigEndian.PutUint64(oversized[1:], zmqMaxFrame+1)
+
+	tests := []struct {
+		name   string
+		stream []byte
+	}{
+		{name: "oversized frame", stream: oversized},
+		{name: "command in a message", stream: append(hashblock, zmqFrame(zmqFlagCommand, []byte("\x04PING\x00\x00"))...)},
+	}
+
+	for _, tt := range tests {
+		address, _ := testPublisher(t, zmqGreeting(), 1, func(conn net.Conn) {
+			_, _ = conn.Write(tt.stream)
+			// Hold the connection until the subscriber gives up.
+			_, _ = io.Copy(io.Discard, conn)
+		})
+
+		s, err := DialZMQ(address, "hashblock")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if _, err := s.Receive(); err != ErrZMQFrame {
+			t.Errorf("%s: received %v, want %v", tt.name, err, ErrZMQFrame)
+		}
+		_ = s.Close()
+	}
+}
+
+func TestDialZMQ(t *testing.T) {
+	old := zmqGreeting()
+	old[10] = 2
+
+	curve := zmqGreeting()
+	copy(curve[12:], "CURVE")
+
+	tests := []struct {
+		name     string
+		greeting []byte
+	}{
+		{name: "no signature", greeting: make([]byte, 64)},
+		{name: "zmtp 2", greeting: old},
+		{name: "curve mechanism", greeting: curve},
+	}
+
+	for _, tt := range tests {
+		address, _ := testPublisher(t, tt.greeting, 0, func(net.Conn) {})
+		if _, err := DialZMQ(address, "hashblock"); err != ErrZMQGreeting {
+			t.Errorf("%s: dialed with %v, want %v", tt.name, err, ErrZMQGreeting)
+		}
+	}
+
+	if _, err := DialZMQ("ipc:///tmp/bitcoind.sock", "hashblock"); err == nil {
+		t.Error("dialed an ipc address")
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIHandler serves the admin endpoints.
+func (s *ProxyServer) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bans", s.authAPI(s.handleBans))
+	mux.HandleFunc("/bans/", s.authAPI(s.handleBan))
+	mux.HandleFunc("/metrics", s.authAPI(s.handleMetrics))
+	mux.HandleFunc("/workers", s.authAPI(s.handleWorkers))
+	mux.HandleFunc("/blocks", s.authAPI(s.handleBlocks))
+	mux.HandleFunc("/luck", s.authAPI(s.handleLuck))
+	mux.HandleFunc("/audit", s.authAPI(s.handleAudit))
+
+	// Open for load balancers and monitoring.
+	mux.HandleFunc("/health", s.handleHealth)
+
+	return mux
+}
+
+// authAPI requires the configured bearer token. Without one only clients on
+// the loopback interface are let in.
+func (s *ProxyServer) authAPI(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Config.APIToken == "" {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+				http.Error(w, "forbidden, set apiToken for remote access", http.StatusForbidden)
+				return
+			}
+		} else {
+			want := []byte("Bearer " + s.Config.APIToken)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// GET /bans lists the active bans.
+func (s *ProxyServer) handleBans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, s.bans.List())
+}
+
+// POST /bans/<ip>?duration=<seconds> bans an IP, DELETE /bans/<ip> lifts the ban.
+func (s *ProxyServer) handleBan(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(strings.TrimPrefix(r.URL.Path, "/bans/"))
+	if ip == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		duration := DefaultBanDuration
+		if v := r.URL.Query().Get("duration"); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+
+			duration = time.Duration(seconds) * time.Second
+		}
+
+		s.bans.Ban(ip, BanManual, duration)
+		s.Kick(ip)
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		if !s.bans.Unban(ip) {
+			http.Error(w, "not banned", http.StatusNotFound)
+			return
+		}
+
+		log.Println("[bans] unbanned", ip)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Health of the proxy, healthy when every dialect's active upstream has a
+// session and the node, if any, is healthy.
+type Health struct {
+	Healthy bool `json:"healthy"`
+
+	// Whether each upstream has a session with its pool.
+	Upstreams map[string]bool `json:"upstreams"`
+	Node      *NodeStatus     `json:"node,omitempty"`
+}
+
+func (s *ProxyServer) Health() Health {
+	health := Health{
+		Healthy:   true,
+		Upstreams: make(map[string]bool),
+		Node:      s.node.Status(),
+	}
+
+	for _, u := range s.allUpstreams() {
+		subscribed := u.Subscribed()
+		health.Upstreams[u.Config.Addr()] = subscribed
+		if !subscribed && s.isActive(u) {
+			health.Healthy = false
+		}
+	}
+
+	if health.Node != nil && !health.Node.Healthy {
+		health.Healthy = false
+	}
+
+	return health
+}
+
+// GET /health, 503 when unhealthy.
+func (s *ProxyServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := s.Health()
+	if !health.Healthy {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	writeJSON(w, health)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("[api] could not write response:", err)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthAPI(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		remote string
+		header string
+		want   int
+	}{
+		{"no token, loopback", "", "127.0.0.1:5000", "", http.StatusOK},
+		{"no token, ipv6 loopback", "", "[::1]:5000", "", http.StatusOK},
+		{"no token, remote", "", "192.0.2.1:5000", "", http.StatusForbidden},
+		{"no token, remote with header", "", "192.0.2.1:5000", "Bearer ", http.StatusForbidden},
+		{"token", "secret", "192.0.2.1:5000", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "192.0.2.1:5000", "Bearer secreT", http.StatusUnauthorized},
+		{"token prefix", "secret", "192.0.2.1:5000", "Bearer secre", http.StatusUnauthorized},
+		{"missing token on loopback", "secret", "127.0.0.1:5000", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		s := &ProxyServer{Config: Config{APIToken: tt.token}}
+		h := s.authAPI(func(w http.ResponseWriter, r *http.Request) {})
+
+		r := httptest.NewRequest(http.MethodDelete, "/bans/192.0.2.9", nil)
+		r.RemoteAddr = tt.remote
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%v: status %v, want %v", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+)
+
+type (
+	// AuditConfig for checking upstream jobs against our own node.
+	AuditConfig struct {
+		// JSON-RPC URL of the node, the blocks node when empty. Jobs aren't
+		// audited without a node.
+		Node string `json:"node"`
+
+		// Seconds an upstream may build on a block other than the node's
+		// tip before it is flagged, nodes don't see blocks at the same time.
+		MaxLag int `json:"maxLag"`
+
+		// Move clients to the dialect's next upstream once one is flagged
+		// Threshold times within Window seconds.
+		Failover  bool `json:"failover"`
+		Threshold int  `json:"threshold"`
+		Window    int  `json:"window"`
+
+		// URL each finding is POSTed to as JSON.
+		Webhook string `json:"webhook"`
+	}
+
+	AuditKind string
+
+	// AuditFinding is something suspicious about an upstream's job.
+	AuditFinding struct {
+		Upstream string    `json:"upstream"`
+		Job      string    `json:"job"`
+		Kind     AuditKind `json:"kind"`
+		Detail   string    `json:"detail"`
+		At       time.Time `json:"at"`
+	}
+
+	auditor struct {
+		cfg  AuditConfig
+		node *rpc.Client
+		ps   *ProxyServer
+
+		mu       sync.Mutex
+		findings []AuditFinding
+		strikes  map[*Upstream][]time.Time
+		// The block each upstream was first seen building on off the tip.
+		lagging map[*Upstream]lag
+		// Payout address to output script, as the node decoded them.
+		scripts map[string][]byte
+	}
+
+	lag struct {
+		prevHash string
+		since    time.Time
+		reported bool
+	}
+)
+
+const (
+	// Built on a block behind the node's tip.
+	AuditStaleTip AuditKind = "stale-tip"
+	// Built on a block the node doesn't know.
+	AuditUnknownBlock AuditKind = "unknown-block"
+	// Built on a block off the node's main chain.
+	AuditForkBlock AuditKind = "fork-block"
+	// The coinbase doesn't pay the expected address.
+	AuditPayout AuditKind = "payout"
+)
+
+const (
+	DefaultAuditMaxLag    = 30 * time.Second
+	DefaultAuditThreshold = 3
+	DefaultAuditWindow    = 10 * time.Minute
+
+	auditHistory   = 100
+	webhookTimeout = 10 * time.Second
+)
+
+// newAuditor returns nil when there is no node to audit against.
+func newAuditor(cfg AuditConfig, node *rpc.Client, ps *ProxyServer) *auditor {
+	if node == nil {
+		return nil
+	}
+
+	return &auditor{
+		cfg:     cfg,
+		node:    node,
+		ps:      ps,
+		strikes: make(map[*Upstream][]time.Time),
+		lagging: make(map[*Upstream]lag),
+		scripts: make(map[string][]byte),
+	}
+}
+
+func (a *auditor) maxLag() time.Duration {
+	if a.cfg.MaxLag > 0 {
+		return time.Duration(a.cfg.MaxLag) * time.Second
+	}
+
+	return DefaultAuditMaxLag
+}
+
+func (a *auditor) threshold() int {
+	if a.cfg.Threshold > 0 {
+		return a.cfg.Threshold
+	}
+
+	return DefaultAuditThreshold
+}
+
+func (a *auditor) window() time.Duration {
+	if a.cfg.Window > 0 {
+		return time.Duration(a.cfg.Window) * time.Second
+	}
+
+	return DefaultAuditWindow
+}
+
+// check audits a job in the background, a nil auditor checks nothing.
+func (a *auditor) check(u *Upstream, w *proxy.Work) {
+	if a == nil {
+		return
+	}
+
+	go func() {
+		a.checkTip(u, w)
+		a.checkPayout(u, w)
+	}()
+}
+
+// newTip checks the upstreams' current jobs when the node moves to a new
+// block, their grace period starts now instead of with their next job.
+func (a *auditor) newTip(upstreams []*Upstream) {
+	if a == nil {
+		return
+	}
+
+	for _, u := range upstreams {
+		if work := u.jobs.Current(); work != nil {
+			go a.checkTip(u, work)
+		}
+	}
+}
+
+// checkTip flags jobs that keep building on anything but the node's tip.
+func (a *auditor) checkTip(u *Upstream, w *proxy.Work) {
+	// A node catching up knows less than the pools.
+	if a.ps.node != nil && !a.ps.node.synced() {
+		return
+	}
+
+	prevHash := w.PrevBlockHash()
+
+	header, err := a.node.GetBlockHeader(context.Background(), prevHash)
+
+	var kind AuditKind
+	var detail string
+	switch code, _ := rpc.ErrorCode(err); {
+	case err != nil && code == rpc.ErrorCodeNotFound:
+		kind, detail = AuditUnknownBlock, "node doesn't know block "+prevHash
+	case err != nil:
+		log.Printf("[audit] could not check job %v of %v: %v\n", w.Job, u.Config.Addr(), err)
+		return
+	case header.Confirmations < 0:
+		kind, detail = AuditForkBlock, "block "+prevHash+" is off the main chain"
+	case header.Confirmations > 1:
+		kind, detail = AuditStaleTip, "block "+prevHash+" is behind the node's tip"
+	}
+
+	a.mu.Lock()
+	if kind == "" {
+		delete(a.lagging, u)
+		a.mu.Unlock()
+		return
+	}
+
+	l, ok := a.lagging[u]
+	if !ok || l.prevHash != prevHash {
+		l = lag{prevHash: prevHash, since: time.Now()}
+		a.lagging[u] = l
+		a.mu.Unlock()
+
+		// Look again once the grace period is over, the pool may not send
+		// another job on this block.
+		time.AfterFunc(a.maxLag(), func() {
+			if current := u.jobs.Current(); current != nil {
+				a.checkTip(u, current)
+			}
+		})
+		return
+	}
+
+	report := !l.reported && time.Since(l.since) >= a.maxLag()
+	if report {
+		l.reported = true
+		a.lagging[u] = l
+	}
+	a.mu.Unlock()
+
+	if report {
+		a.report(u, w, kind, detail)
+	}
+}
+
+// checkPayout flags SHA256d coinbases without an output to the upstream's
+// payout address.
+func (a *auditor) checkPayout(u *Upstream, w *proxy.Work) {
+	if !w.Dialect.IsBitcoin() || u.Config.PayoutAddress == "" {
+		return
+	}
+
+	script, err := a.payoutScript(u.Config.PayoutAddress)
+	if err != nil {
+		log.Printf("[audit] could not decode payout address of %v: %v\n", u.Config.Addr(), err)
+		return
+	}
+
+	outputs, err := w.CoinbaseOutputs(u.extranonceSize())
+	if err != nil {
+		a.report(u, w, AuditPayout, err.Error())
+		return
+	}
+
+	for _, out := range outputs {
+		if bytes.Equal(out.Script, script) && out.Value > 0 {
+			return
+		}
+	}
+
+	a.report(u, w, AuditPayout, "coinbase doesn't pay "+u.Config.PayoutAddress)
+}
+
+// payoutScript asks the node for an address's output script once.
+func (a *auditor) payoutScript(address string) ([]byte, error) {
+	a.mu.Lock()
+	script, ok := a.scripts[address]
+	a.mu.Unlock()
+	if ok {
+		return script, nil
+	}
+
+	info, err := a.node.ValidateAddress(context.Background(), address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsValid {
+		return nil, errors.New("invalid address " + address)
+	}
+
+	script, err = hex.DecodeString(info.ScriptPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.scripts[address] = script
+	a.mu.Unlock()
+
+	return script, nil
+}
+
+// report a finding, failing over once the upstream has too many.
+func (a *auditor) report(u *Upstream, w *proxy.Work, kind AuditKind, detail string) {
+	finding := AuditFinding{
+		Upstream: u.Config.Addr(),
+		Job:      w.Job,
+		Kind:     kind,
+		Detail:   detail,
+		At:       time.Now(),
+	}
+
+	log.Printf("[audit] %v job %v: %v: %v\n", finding.Upstream, finding.Job, kind, detail)
+	a.ps.count("audit_" + string(kind))
+
+	cutoff := finding.At.Add(-a.window())
+
+	a.mu.Lock()
+	a.findings = append(a.findings, finding)
+	if len(a.findings) > auditHistory {
+		a.findings = a.findings[len(a.findings)-auditHistory:]
+	}
+
+	strikes := append(dropBefore(a.strikes[u], cutoff), finding.At)
+	a.strikes[u] = strikes
+	a.mu.Unlock()
+
+	if a.cfg.Webhook != "" {
+		go a.notify(finding)
+	}
+
+	if a.cfg.Failover && len(strikes) >= a.threshold() {
+		a.ps.failover(u)
+	}
+}
+
+// suspicious reports whether an upstream was flagged within the window.
+func (a *auditor) suspicious(u *Upstream) bool {
+	if a == nil {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(dropBefore(a.strikes[u], time.Now().Add(-a.window()))) > 0
+}
+
+// notify POSTs a finding to the webhook.
+func (a *auditor) notify(finding AuditFinding) {
+	data, err := json.Marshal(finding)
+	if err != nil {
+		return
+	}
+
+	client := http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(a.cfg.Webhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[audit] could not send alert: %v\n", err)
+		return
+	}
+	_ = resp.Body.Close()
+}
+
+// Findings returns the latest findings, oldest first.
+func (a *auditor) Findings() []AuditFinding {
+	if a == nil {
+		return []AuditFinding{}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]AuditFinding{}, a.findings...)
+}
+
+// GET /audit
+func (s *ProxyServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.auditor.Findings())
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// The coinbase of bitcoin block 1 and the script it pays.
+const (
+	block1Coinbase = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0704ffff001d0104ffffffff0100f2052a0100000043410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac00000000"
+	block1Script   = "410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"
+)
+
+// newTestAuditor returns an auditor of s against a node knowing the chain
+// genesis <- block1 <- tip, and a fork block.
+func newTestAuditor(t *testing.T, s *ProxyServer, cfg AuditConfig) (*auditor, *testNode) {
+	t.Helper()
+
+	node, client := newTestNode(t)
+	headers := map[string]*rpc.BlockHeader{
+		"0000000000000000000000000000000000000000000000000000000000000002": {Confirmations: 1},
+		"0000000000000000000000000000000000000000000000000000000000000001": {Confirmations: 2},
+		"00000000000000000000000000000000000000000000000000000000000000f0": {Confirmations: -1},
+	}
+	node.handle("getblockheader", func(params []json.RawMessage) (interface{}, *rpc.Error) {
+		var hash string
+		_ = json.Unmarshal(params[0], &hash)
+		if header, ok := headers[hash]; ok {
+			return header, nil
+		}
+		return nil, &rpc.Error{Code: rpc.ErrorCodeNotFound, Message: "Block not found"}
+	})
+	node.handle("validateaddress", func(params []json.RawMessage) (interface{}, *rpc.Error) {
+		var address string
+		_ = json.Unmarshal(params[0], &address)
+		switch address {
+		case "1Payout":
+			return rpc.AddressInfo{IsValid: true, Address: address, ScriptPubKey: block1Script}, nil
+		case "1Other":
+			return rpc.AddressInfo{IsValid: true, Address: address, ScriptPubKey: "76a914000000000000000000000000000000000000000088ac"}, nil
+		}
+		return rpc.AddressInfo{}, nil
+	})
+
+	a := newAuditor(cfg, client, s)
+	s.auditor = a
+
+	return a, node
+}
+
+// auditWork is a job building on the block whose displayed hash ends in
+// prev.
+func auditWork(t *testing.T, job string, prev byte) *proxy.Work {
+	t.Helper()
+
+	coinbase, err := hex.DecodeString(block1Coinbase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Equihash jobs carry the previous hash byte reversed.
+	var prevHash stratum.Uint256
+	prevHash[0] = prev
+
+	return &proxy.Work{
+		ResponseNotify: stratum.ResponseNotify{Job: job, HashPrevBlock: prevHash},
+		Coinbase1:      coinbase,
+	}
+}
+
+func testUpstream(s *ProxyServer, dialect stratum.Dialect, host string) *Upstream {
+	u := &Upstream{
+		Config:     UpstreamConfig{Dialect: dialect, Host: host, Port: 3333},
+		ps:         s,
+		jobs:       proxy.NewJobRegistry(0),
+		subscribed: true,
+	}
+	s.upstreams.all[dialect] = append(s.upstreams.all[dialect], u)
+	if _, ok := s.upstreams.active[dialect]; !ok {
+		s.upstreams.active[dialect] = u
+	}
+
+	return u
+}
+
+func TestAuditTip(t *testing.T) {
+	s := newTestServer(t, Config{})
+	a, _ := newTestAuditor(t, s, AuditConfig{MaxLag: 3600})
+	u := testUpstream(s, stratum.Equihash, "pool")
+
+	tests := []struct {
+		name string
+		prev byte
+		kind AuditKind
+	}{
+		{name: "on the tip", prev: 2},
+		{name: "behind the tip", prev: 1, kind: AuditStaleTip},
+		{name: "unknown block", prev: 3, kind: AuditUnknownBlock},
+		{name: "fork block", prev: 0xf0, kind: AuditForkBlock},
+	}
+
+	for _, tt := range tests {
+		a.findings = nil
+		w := auditWork(t, "1", tt.prev)
+		u.jobs.Add(w)
+
+		// Flagged only when the upstream stays on the block for the
+		// grace period.
+		a.checkTip(u, w)
+		if len(a.Findings()) != 0 {
+			t.Errorf("%s: reported before the grace period", tt.name)
+		}
+
+		if l, ok := a.lagging[u]; ok {
+			l.since = time.Now().Add(-time.Hour)
+			a.lagging[u] = l
+		} else if tt.kind != "" {
+			t.Errorf("%s: not tracked as lagging", tt.name)
+			continue
+		}
+
+		a.checkTip(u, w)
+		a.checkTip(u, w)
+
+		findings := a.Findings()
+		if tt.kind == "" {
+			if len(findings) != 0 {
+				t.Errorf("%s: reported %+v", tt.name, findings)
+			}
+			continue
+		}
+
+		if len(findings) != 1 || findings[0].Kind != tt.kind || findings[0].Upstream != "pool:3333" || findings[0].Job != "1" {
+			t.Errorf("%s: reported %+v, want one %s", tt.name, findings, tt.kind)
+		}
+	}
+
+	// Back on the tip the lag is forgotten.
+	a.checkTip(u, auditWork(t, "2", 2))
+	if _, ok := a.lagging[u]; ok {
+		t.Error("still lagging on the tip")
+	}
+}
+
+func TestAuditPayout(t *testing.T) {
+	s := newTestServer(t, Config{})
+	a, node := newTestAuditor(t, s, AuditConfig{})
+
+	tests := []struct {
+		name    string
+		dialect stratum.Dialect
+		address string
+		edit    func(*proxy.Work)
+		report  bool
+	}{
+		{name: "pays the address", dialect: stratum.Bitcoin, address: "1Payout"},
+		{name: "pays someone else", dialect: stratum.Bitcoin, address: "1Other", report: true},
+		{name: "no payout address", dialect: stratum.Bitcoin},
+		{name: "invalid address", dialect: stratum.Bitcoin, address: "1Invalid"},
+		{name: "equihash", dialect: stratum.Equihash, address: "1Other"},
+		{
+			name:    "truncated coinbase",
+			dialect: stratum.Bitcoin,
+			address: "1Payout",
+			edit:    func(w *proxy.Work) { w.Coinbase1 = w.Coinbase1[:60] },
+			report:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		a.findings = nil
+		u := &Upstream{Config: UpstreamConfig{Dialect: tt.dialect, Host: "pool", Port: 3333, PayoutAddress: tt.address}, ps: s}
+
+		w := auditWork(t, "1", 2)
+		w.Dialect = tt.dialect
+		if tt.edit != nil {
+			tt.edit(w)
+		}
+
+		a.checkPayout(u, w)
+
+		findings := a.Findings()
+		if !tt.report {
+			if len(findings) != 0 {
+				t.Errorf("%s: reported %+v", tt.name, findings)
+			}
+			continue
+		}
+
+		if len(findings) != 1 || findings[0].Kind != AuditPayout {
+			t.Errorf("%s: reported %+v, want a payout finding", tt.name, findings)
+		}
+	}
+
+	// Addresses are decoded once.
+	calls := 0
+	for _, method := range node.called() {
+		if method == "validateaddress" {
+			calls++
+		}
+	}
+	if calls != 3 {
+		t.Errorf("validated addresses %d times, want 3", calls)
+	}
+}
+
+func TestAuditFailover(t *testing.T) {
+	received := make(chan AuditFinding, 8)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var finding AuditFinding
+		_ = json.NewDecoder(r.Body).Decode(&finding)
+		received <- finding
+	}))
+	defer webhook.Close()
+
+	s := newTestServer(t, Config{})
+	a, _ := newTestAuditor(t, s, AuditConfig{Failover: true, Threshold: 2, Webhook: webhook.URL})
+
+	first := testUpstream(s, stratum.Equihash, "first")
+	second := testUpstream(s, stratum.Equihash, "second")
+	w := auditWork(t, "1", 1)
+
+	a.report(first, w, AuditStaleTip, "behind")
+	if u, _ := s.upstream(stratum.Equihash); u != first || !a.suspicious(first) || a.suspicious(second) {
+		t.Fatal("failed over before the threshold")
+	}
+
+	a.report(first, w, AuditStaleTip, "behind")
+	if u, _ := s.upstream(stratum.Equihash); u != second {
+		t.Fatalf("active upstream %v after the threshold, want second:3333", u.Config.Addr())
+	}
+
+	// Not back to a suspicious upstream.
+	a.report(second, w, AuditStaleTip, "behind")
+	a.report(second, w, AuditStaleTip, "behind")
+	if u, _ := s.upstream(stratum.Equihash); u != second {
+		t.Errorf("failed over to a suspicious upstream %v", u.Config.Addr())
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case finding := <-received:
+			if finding.Kind != AuditStaleTip || finding.Job != "1" {
+				t.Errorf("webhook got %+v", finding)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("webhook got %d findings, want 4", i)
+		}
+	}
+
+	if v := s.counters.Get("audit_" + string(AuditStaleTip)); v == nil || v.String() != "4" {
+		t.Errorf("counted %v findings, want 4", v)
+	}
+}
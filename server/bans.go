@@ -0,0 +1,289 @@
+package server
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/lib"
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+)
+
+type (
+	BanReason string
+
+	// BanConfig sets how many offences within Window get an IP banned.
+	// A zero threshold disables banning for that offence.
+	BanConfig struct {
+		InvalidShares     int `json:"invalidShares"`
+		MalformedMessages int `json:"malformedMessages"`
+		FailedAuths       int `json:"failedAuths"`
+		RateLimited       int `json:"rateLimited"`
+		SlowMessages      int `json:"slowMessages"`
+
+		// In seconds.
+		Window   int `json:"window"`
+		Duration int `json:"duration"`
+
+		// IPs or CIDRs that are never banned.
+		Whitelist []string `json:"whitelist"`
+	}
+
+	// BanManager counts offences per IP and bans repeat offenders.
+	BanManager struct {
+		cfg       BanConfig
+		db        *lib.DB
+		whitelist []*net.IPNet
+
+		mu      sync.Mutex
+		bans    map[string]lib.Ban
+		strikes map[string]map[BanReason][]time.Time
+	}
+)
+
+const (
+	BanInvalidShare BanReason = "invalid share"
+	BanMalformed    BanReason = "malformed message"
+	BanFailedAuth   BanReason = "failed authorization"
+	BanRateLimited  BanReason = "rate limited"
+	BanSlow         BanReason = "slow message"
+	BanManual       BanReason = "manual"
+)
+
+const (
+	DefaultBanWindow   = 10 * time.Minute
+	DefaultBanDuration = time.Hour
+
+	banSweepInterval = time.Minute
+)
+
+// NewBanManager loads the persisted bans from db, which may be nil.
+func NewBanManager(cfg BanConfig, db *lib.DB) (*BanManager, error) {
+	whitelist, err := proxy.ParseNets(cfg.Whitelist)
+	if err != nil {
+		return nil, err
+	}
+
+	b := BanManager{
+		cfg:       cfg,
+		db:        db,
+		whitelist: whitelist,
+		bans:      make(map[string]lib.Ban),
+		strikes:   make(map[string]map[BanReason][]time.Time),
+	}
+
+	if db != nil {
+		bans, err := db.Bans()
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		for _, ban := range bans {
+			if ban.Until.After(now) {
+				b.bans[ban.IP] = ban
+			}
+		}
+	}
+
+	go b.sweep()
+
+	return &b, nil
+}
+
+func (b *BanManager) window() time.Duration {
+	if b.cfg.Window > 0 {
+		return time.Duration(b.cfg.Window) * time.Second
+	}
+
+	return DefaultBanWindow
+}
+
+func (b *BanManager) duration() time.Duration {
+	if b.cfg.Duration > 0 {
+		return time.Duration(b.cfg.Duration) * time.Second
+	}
+
+	return DefaultBanDuration
+}
+
+func (b *BanManager) threshold(reason BanReason) int {
+	switch reason {
+	case BanInvalidShare:
+		return b.cfg.InvalidShares
+	case BanMalformed:
+		return b.cfg.MalformedMessages
+	case BanFailedAuth:
+		return b.cfg.FailedAuths
+	case BanRateLimited:
+		return b.cfg.RateLimited
+	case BanSlow:
+		return b.cfg.SlowMessages
+	default:
+		return 0
+	}
+}
+
+// IsBanned reports whether the IP has an active ban.
+func (b *BanManager) IsBanned(ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban, ok := b.bans[ip.String()]
+	return ok && ban.Until.After(time.Now())
+}
+
+// Strike records an offence, returns true if the IP got banned for it.
+func (b *BanManager) Strike(ip net.IP, reason BanReason) bool {
+	threshold := b.threshold(reason)
+	if threshold <= 0 || b.whitelisted(ip) {
+		return false
+	}
+
+	key := ip.String()
+	now := time.Now()
+	cutoff := now.Add(-b.window())
+
+	b.mu.Lock()
+	reasons, ok := b.strikes[key]
+	if !ok {
+		reasons = make(map[BanReason][]time.Time)
+		b.strikes[key] = reasons
+	}
+
+	times := append(dropBefore(reasons[reason], cutoff), now)
+	reasons[reason] = times
+	b.mu.Unlock()
+
+	if len(times) < threshold {
+		return false
+	}
+
+	b.Ban(ip, reason, b.duration())
+	return true
+}
+
+// Ban the IP for the duration.
+func (b *BanManager) Ban(ip net.IP, reason BanReason, duration time.Duration) {
+	ban := lib.Ban{
+		IP:     ip.String(),
+		Reason: string(reason),
+		Until:  time.Now().Add(duration),
+	}
+
+	b.mu.Lock()
+	b.bans[ban.IP] = ban
+	delete(b.strikes, ban.IP)
+	b.mu.Unlock()
+
+	log.Printf("[bans] banned %v until %v: %v\n", ban.IP, ban.Until.Format(time.RFC3339), reason)
+
+	if b.db != nil {
+		if err := b.db.SaveBan(ban); err != nil {
+			log.Println("[bans] could not persist ban:", err)
+		}
+	}
+}
+
+// Unban lifts the ban on the IP, returns false if it wasn't banned.
+func (b *BanManager) Unban(ip net.IP) bool {
+	key := ip.String()
+
+	b.mu.Lock()
+	_, ok := b.bans[key]
+	delete(b.bans, key)
+	delete(b.strikes, key)
+	b.mu.Unlock()
+
+	if b.db != nil {
+		if err := b.db.DeleteBan(key); err != nil {
+			log.Println("[bans] could not delete ban:", err)
+		}
+	}
+
+	return ok
+}
+
+// List the active bans, soonest to expire first.
+func (b *BanManager) List() []lib.Ban {
+	now := time.Now()
+
+	b.mu.Lock()
+	bans := make([]lib.Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		if ban.Until.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	b.mu.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+
+	return bans
+}
+
+func (b *BanManager) whitelisted(ip net.IP) bool {
+	for _, n := range b.whitelist {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sweep drops expired bans and stale strikes.
+func (b *BanManager) sweep() {
+	for range time.Tick(banSweepInterval) {
+		now := time.Now()
+		cutoff := now.Add(-b.window())
+
+		var expired []string
+
+		b.mu.Lock()
+		for key, ban := range b.bans {
+			if !ban.Until.After(now) {
+				delete(b.bans, key)
+				expired = append(expired, key)
+			}
+		}
+
+		for key, reasons := range b.strikes {
+			for reason, times := range reasons {
+				if times = dropBefore(times, cutoff); len(times) == 0 {
+					delete(reasons, reason)
+				} else {
+					reasons[reason] = times
+				}
+			}
+
+			if len(reasons) == 0 {
+				delete(b.strikes, key)
+			}
+		}
+		b.mu.Unlock()
+
+		if b.db == nil {
+			continue
+		}
+
+		for _, key := range expired {
+			if err := b.db.DeleteBan(key); err != nil {
+				log.Println("[bans] could not delete ban:", err)
+			}
+		}
+	}
+}
+
+func dropBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+
+	return times[i:]
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBanManagerStrikes(t *testing.T) {
+	b, err := NewBanManager(BanConfig{
+		InvalidShares: 3,
+		FailedAuths:   1,
+		Whitelist:     []string{"10.0.0.0/8"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("192.0.2.1")
+	for i := 1; i < 3; i++ {
+		if b.Strike(ip, BanInvalidShare) {
+			t.Fatalf("banned after %v strikes", i)
+		}
+	}
+
+	// Offences are counted per reason, and unconfigured ones never ban.
+	if b.Strike(ip, BanMalformed) || b.IsBanned(ip) {
+		t.Fatal("banned for an unconfigured offence")
+	}
+
+	if !b.Strike(ip, BanInvalidShare) || !b.IsBanned(ip) {
+		t.Fatal("not banned on reaching the threshold")
+	}
+
+	if other := net.ParseIP("192.0.2.2"); b.IsBanned(other) {
+		t.Fatal("ban applied to another IP")
+	}
+
+	if trusted := net.ParseIP("10.1.2.3"); b.Strike(trusted, BanFailedAuth) || b.IsBanned(trusted) {
+		t.Fatal("whitelisted IP banned")
+	}
+
+	if !b.Unban(ip) || b.IsBanned(ip) {
+		t.Fatal("unban failed")
+	}
+
+	if b.Unban(ip) {
+		t.Fatal("unbanned twice")
+	}
+
+	// The strikes went with the ban.
+	if b.Strike(ip, BanInvalidShare) {
+		t.Fatal("banned on old strikes")
+	}
+}
+
+func TestBanManagerWindow(t *testing.T) {
+	b, err := NewBanManager(BanConfig{InvalidShares: 2, Window: 60}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("2001:db8::1")
+	b.Strike(ip, BanInvalidShare)
+
+	// Move the first strike out of the window.
+	b.mu.Lock()
+	b.strikes[ip.String()][BanInvalidShare][0] = time.Now().Add(-2 * time.Minute)
+	b.mu.Unlock()
+
+	if b.Strike(ip, BanInvalidShare) {
+		t.Fatal("banned on a strike outside the window")
+	}
+
+	if !b.Strike(ip, BanInvalidShare) {
+		t.Fatal("not banned on two strikes within the window")
+	}
+}
+
+func TestBanManagerList(t *testing.T) {
+	b, err := NewBanManager(BanConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Ban(net.ParseIP("192.0.2.1"), BanManual, time.Hour)
+	b.Ban(net.ParseIP("192.0.2.2"), BanManual, time.Minute)
+	b.Ban(net.ParseIP("192.0.2.3"), BanManual, -time.Second)
+
+	bans := b.List()
+	if len(bans) != 2 {
+		t.Fatalf("got %v bans, want the 2 active ones", len(bans))
+	}
+
+	if bans[0].IP != "192.0.2.2" || bans[1].IP != "192.0.2.1" {
+		t.Errorf("bans not ordered by expiry: %+v", bans)
+	}
+
+	if b.IsBanned(net.ParseIP("192.0.2.3")) {
+		t.Error("expired ban still active")
+	}
+}
+
+func TestNewBanManagerBadWhitelist(t *testing.T) {
+	if _, err := NewBanManager(BanConfig{Whitelist: []string{"not an ip"}}, nil); err == nil {
+		t.Error("accepted an invalid whitelist")
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/lib"
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type (
+	// BlocksConfig for following the blocks our miners find on a node.
+	BlocksConfig struct {
+		// JSON-RPC URL of the node, the shared nodes when empty. Found blocks
+		// are recorded but never confirmed without either.
+		Node string `json:"node"`
+
+		// Depth at which a block counts as confirmed, and the seconds
+		// between checks.
+		Confirmations int `json:"confirmations"`
+		Interval      int `json:"interval"`
+	}
+
+	// blockTracker records found blocks and follows them on the node until
+	// they are confirmed or orphaned.
+	blockTracker struct {
+		cfg  BlocksConfig
+		node *rpc.Client
+		db   *lib.DB
+
+		mu     sync.Mutex
+		blocks map[string]*lib.Block
+	}
+)
+
+const (
+	DefaultBlockConfirmations = 100
+	DefaultBlockInterval      = time.Minute
+
+	// A block the node still doesn't know after this never made it out.
+	blockLostAfter = 10 * time.Minute
+	// Orphans are watched this long in case a reorg brings them back.
+	orphanWatch = 24 * time.Hour
+)
+
+// newBlockTracker loads the recorded blocks from db, node and db may be nil.
+func newBlockTracker(cfg BlocksConfig, node *rpc.Client, db *lib.DB) (*blockTracker, error) {
+	t := blockTracker{
+		cfg:    cfg,
+		node:   node,
+		db:     db,
+		blocks: make(map[string]*lib.Block),
+	}
+
+	if db != nil {
+		blocks, err := db.Blocks()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range blocks {
+			t.blocks[blocks[i].Hash] = &blocks[i]
+		}
+	}
+
+	if node != nil {
+		go t.watch()
+	}
+
+	return &t, nil
+}
+
+func (t *blockTracker) confirmations() int {
+	if t.cfg.Confirmations > 0 {
+		return t.cfg.Confirmations
+	}
+
+	return DefaultBlockConfirmations
+}
+
+func (t *blockTracker) interval() time.Duration {
+	if t.cfg.Interval > 0 {
+		return time.Duration(t.cfg.Interval) * time.Second
+	}
+
+	return DefaultBlockInterval
+}
+
+// found records a candidate block.
+func (t *blockTracker) found(block lib.Block) {
+	block.Status = lib.BlockPending
+
+	t.mu.Lock()
+	if _, ok := t.blocks[block.Hash]; ok {
+		t.mu.Unlock()
+		return
+	}
+	t.blocks[block.Hash] = &block
+	t.mu.Unlock()
+
+	log.Printf("[blocks] found block %v at height %v by '%v'\n", block.Hash, block.Height, block.Worker)
+	t.save(block)
+}
+
+func (t *blockTracker) save(block lib.Block) {
+	if t.db == nil {
+		return
+	}
+
+	if err := t.db.SaveBlock(block); err != nil {
+		log.Printf("[blocks] could not save block %v: %v\n", block.Hash, err)
+	}
+}
+
+// List returns the blocks, newest first.
+func (t *blockTracker) List() []lib.Block {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	blocks := make([]lib.Block, 0, len(t.blocks))
+	for _, block := range t.blocks {
+		blocks = append(blocks, *block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].FoundAt.After(blocks[j].FoundAt)
+	})
+
+	return blocks
+}
+
+func (t *blockTracker) watch() {
+	for range time.Tick(t.interval()) {
+		t.checkAll()
+	}
+}
+
+// poke checks the blocks now rather than on the next tick.
+func (t *blockTracker) poke() {
+	if t.node != nil {
+		go t.checkAll()
+	}
+}
+
+func (t *blockTracker) checkAll() {
+	for _, block := range t.unsettled() {
+		t.check(block)
+	}
+}
+
+// unsettled returns the blocks whose fate may still change: the unconfirmed
+// ones and recent orphans.
+func (t *blockTracker) unsettled() []lib.Block {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var blocks []lib.Block
+	for _, block := range t.blocks {
+		switch block.Status {
+		case lib.BlockPending:
+			blocks = append(blocks, *block)
+		case lib.BlockOrphaned:
+			if time.Since(block.FoundAt) < orphanWatch {
+				blocks = append(blocks, *block)
+			}
+		}
+	}
+
+	return blocks
+}
+
+// check asks the node where the block stands.
+func (t *blockTracker) check(block lib.Block) {
+	header, err := t.node.GetBlockHeader(context.Background(), block.Hash)
+	if code, ok := rpc.ErrorCode(err); ok && code == rpc.ErrorCodeNotFound {
+		if time.Since(block.FoundAt) < blockLostAfter {
+			return
+		}
+
+		header = &rpc.BlockHeader{Confirmations: -1}
+	} else if err != nil {
+		log.Printf("[blocks] could not check block %v: %v\n", block.Hash, err)
+		return
+	} else {
+		block.Height = header.Height
+	}
+
+	status := lib.BlockPending
+	switch {
+	case header.Confirmations < 0:
+		status = lib.BlockOrphaned
+	case header.Confirmations >= t.confirmations():
+		status = lib.BlockConfirmed
+	}
+
+	if status == block.Status && header.Confirmations == block.Confirmations {
+		return
+	}
+
+	if status != block.Status {
+		log.Printf("[blocks] block %v at height %v is %v\n", block.Hash, block.Height, status)
+	}
+
+	block.Status = status
+	block.Confirmations = header.Confirmations
+
+	t.mu.Lock()
+	t.blocks[block.Hash] = &block
+	t.mu.Unlock()
+
+	t.save(block)
+}
+
+// blockFound records a share of difficulty that met the network target of
+// its job, ending the upstream's round.
+func (s *ProxyServer) blockFound(dialect stratum.Dialect, worker string, difficulty proxy.Difficulty, work *proxy.Work, share proxy.Share) {
+	upstream := s.upstreamName(dialect)
+	netDifficulty := float64(work.NetworkDifficulty())
+	roundShares := s.rounds.end(upstream, float64(difficulty))
+
+	block := lib.Block{
+		Height:        work.Height,
+		Hash:          stratum.ToHex(work.ShareHash(share)),
+		Worker:        worker,
+		Upstream:      upstream,
+		Reward:        work.Subsidy,
+		NetDifficulty: netDifficulty,
+		RoundShares:   roundShares,
+		FoundAt:       time.Now(),
+	}
+
+	if netDifficulty > 0 {
+		block.Effort = roundShares / netDifficulty
+	}
+
+	s.blocks.found(block)
+	s.solo.submit(work, share)
+}
+
+// GET /blocks
+func (s *ProxyServer) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.blocks.List())
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/lib"
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type (
+	// testNode answers JSON-RPC calls, single or batched, with its handlers.
+	testNode struct {
+		mu       sync.Mutex
+		handlers map[string]nodeHandler
+		calls    []string
+	}
+
+	nodeHandler func(params []json.RawMessage) (interface{}, *rpc.Error)
+
+	nodeCall struct {
+		ID     uint64            `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+
+	nodeReply struct {
+		ID     uint64      `json:"id"`
+		Result interface{} `json:"result"`
+		Error  *rpc.Error  `json:"error"`
+	}
+)
+
+// newTestNode returns a node without handlers and a client calling it.
+func newTestNode(t *testing.T) (*testNode, *rpc.Client) {
+	t.Helper()
+
+	n := &testNode{handlers: make(map[string]nodeHandler)}
+	server := httptest.NewServer(n)
+	t.Cleanup(server.Close)
+
+	client, err := rpc.NewClient([]string{server.URL}, rpc.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n, client
+}
+
+// handle answers method with f.
+func (n *testNode) handle(method string, f nodeHandler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.handlers[method] = f
+}
+
+// reply answers method with result.
+func (n *testNode) reply(method string, result interface{}) {
+	n.handle(method, func([]json.RawMessage) (interface{}, *rpc.Error) {
+		return result, nil
+	})
+}
+
+// called returns the methods called so far.
+func (n *testNode) called() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string{}, n.calls...)
+}
+
+func (n *testNode) call(c nodeCall) nodeReply {
+	n.mu.Lock()
+	n.calls = append(n.calls, c.Method)
+	f, ok := n.handlers[c.Method]
+	n.mu.Unlock()
+
+	if !ok {
+		return nodeReply{ID: c.ID, Error: &rpc.Error{Code: -32601, Message: "Method not found"}}
+	}
+
+	result, err := f(c.Params)
+	return nodeReply{ID: c.ID, Result: result, Error: err}
+}
+
+func (n *testNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(raw) > 0 && raw[0] == '[' {
+		var calls []nodeCall
+		_ = json.Unmarshal(raw, &calls)
+
+		replies := make([]nodeReply, len(calls))
+		for i, c := range calls {
+			replies[i] = n.call(c)
+		}
+		_ = json.NewEncoder(w).Encode(replies)
+		return
+	}
+
+	var c nodeCall
+	_ = json.Unmarshal(raw, &c)
+	_ = json.NewEncoder(w).Encode(n.call(c))
+}
+
+func TestBlockTrackerFound(t *testing.T) {
+	tracker, err := newBlockTracker(BlocksConfig{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tracker.found(lib.Block{Hash: "a", Height: 1, FoundAt: now.Add(-time.Minute), Status: lib.BlockConfirmed})
+	tracker.found(lib.Block{Hash: "b", Height: 2, FoundAt: now})
+	// Found again, e.g. submitted twice.
+	tracker.found(lib.Block{Hash: "a", Height: 3, FoundAt: now})
+
+	blocks := tracker.List()
+	if len(blocks) != 2 || blocks[0].Hash != "b" || blocks[1].Hash != "a" {
+		t.Fatalf("listed %+v", blocks)
+	}
+	if blocks[1].Height != 1 || blocks[1].Status != lib.BlockPending {
+		t.Errorf("recorded %+v, want the first pending block", blocks[1])
+	}
+
+	// Without a node nothing is ever checked.
+	tracker.poke()
+}
+
+func TestBlockTrackerCheck(t *testing.T) {
+	node, client := newTestNode(t)
+
+	headers := map[string]*rpc.BlockHeader{
+		"pending":   {Confirmations: 3, Height: 840001},
+		"confirmed": {Confirmations: 100, Height: 840002},
+		"orphaned":  {Confirmations: -1, Height: 840003},
+		"revived":   {Confirmations: 1, Height: 840004},
+	}
+	node.handle("getblockheader", func(params []json.RawMessage) (interface{}, *rpc.Error) {
+		var hash string
+		_ = json.Unmarshal(params[0], &hash)
+		if hash == "broken" {
+			return nil, &rpc.Error{Code: -1, Message: "internal error"}
+		}
+
+		header, ok := headers[hash]
+		if !ok {
+			return nil, &rpc.Error{Code: rpc.ErrorCodeNotFound, Message: "Block not found"}
+		}
+		return header, nil
+	})
+
+	tracker, err := newBlockTracker(BlocksConfig{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.node = client
+
+	now := time.Now()
+	tests := []struct {
+		block         lib.Block
+		status        lib.BlockStatus
+		height        int
+		confirmations int
+	}{
+		{lib.Block{Hash: "pending", FoundAt: now}, lib.BlockPending, 840001, 3},
+		{lib.Block{Hash: "confirmed", FoundAt: now}, lib.BlockConfirmed, 840002, 100},
+		{lib.Block{Hash: "orphaned", FoundAt: now}, lib.BlockOrphaned, 840003, -1},
+		// A reorg brings an orphan back.
+		{lib.Block{Hash: "revived", FoundAt: now, Status: lib.BlockOrphaned, Confirmations: -1}, lib.BlockPending, 840004, 1},
+		// The node may not have it yet.
+		{lib.Block{Hash: "unknown", Height: 7, FoundAt: now}, lib.BlockPending, 7, 0},
+		{lib.Block{Hash: "lost", Height: 8, FoundAt: now.Add(-blockLostAfter - time.Minute)}, lib.BlockOrphaned, 8, -1},
+		{lib.Block{Hash: "broken", Height: 9, FoundAt: now}, lib.BlockPending, 9, 0},
+	}
+
+	for _, tt := range tests {
+		tracker.blocks[tt.block.Hash] = &lib.Block{}
+		*tracker.blocks[tt.block.Hash] = tt.block
+		if tt.block.Status == "" {
+			tracker.blocks[tt.block.Hash].Status = lib.BlockPending
+		}
+	}
+	tracker.checkAll()
+
+	for _, tt := range tests {
+		got := tracker.blocks[tt.block.Hash]
+		if got.Status != tt.status || got.Height != tt.height || got.Confirmations != tt.confirmations {
+			t.Errorf("%s: %v at %d with %d confirmations, want %v at %d with %d", tt.block.Hash,
+				got.Status, got.Height, got.Confirmations, tt.status, tt.height, tt.confirmations)
+		}
+	}
+
+	// Settled blocks aren't checked again, orphans are for a day.
+	tracker.blocks["old"] = &lib.Block{Hash: "old", Status: lib.BlockOrphaned, FoundAt: now.Add(-orphanWatch - time.Minute)}
+
+	unsettled := make(map[string]bool)
+	for _, block := range tracker.unsettled() {
+		unsettled[block.Hash] = true
+	}
+	for hash, want := range map[string]bool{"pending": true, "confirmed": false, "orphaned": true, "lost": true, "old": false, "unknown": true} {
+		if unsettled[hash] != want {
+			t.Errorf("%s: unsettled %v, want %v", hash, unsettled[hash], want)
+		}
+	}
+
+	if calls := len(node.called()); calls != len(tests) {
+		t.Errorf("%d calls to the node, want %d", calls, len(tests))
+	}
+}
+
+func TestBlockFound(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	target, err := proxy.CompactToTarget(0x1d00ffff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := &proxy.Work{
+		Dialect:        stratum.Bitcoin,
+		ResponseNotify: stratum.ResponseNotify{Job: "1"},
+		Coinbase1:      []byte{0x01},
+		Target:         target,
+		Height:         840000,
+		Subsidy:        3.125,
+	}
+
+	s.addRoundShare(stratum.Bitcoin, 0.25)
+	s.addRoundShare(stratum.Bitcoin, 0.5)
+	s.blockFound(stratum.Bitcoin, "worker.1", 0.25, work, proxy.Share{Job: "1", Nonce: 7})
+
+	blocks := s.blocks.List()
+	if len(blocks) != 1 {
+		t.Fatalf("recorded %d blocks", len(blocks))
+	}
+
+	b := blocks[0]
+	want := stratum.ToHex(work.ShareHash(proxy.Share{Job: "1", Nonce: 7}))
+	if b.Hash != want || b.Height != 840000 || b.Worker != "worker.1" || b.Reward != 3.125 || b.Status != lib.BlockPending {
+		t.Errorf("recorded %+v", b)
+	}
+	if b.NetDifficulty != 1 || b.RoundShares != 1 || b.Effort != 1 {
+		t.Errorf("net difficulty %v, round shares %v, effort %v, want 1, 1, 1", b.NetDifficulty, b.RoundShares, b.Effort)
+	}
+
+	// The block ended the round.
+	if round := s.rounds.get(""); round.Shares != 0 {
+		t.Errorf("round carried %v shares over", round.Shares)
+	}
+}
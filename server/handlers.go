@@ -0,0 +1,288 @@
+package server
+
+import (
+	"log"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// handle a request from a subscribed client.
+func (c *ProxyClient) handle(req stratum.Request) error {
+	switch req := req.(type) {
+	case stratum.RequestAuthorize:
+		return c.handleAuthorize(req)
+
+	case stratum.RequestSubmit:
+		return c.handleSubmit(req.ID, req.Worker, proxy.Share{
+			Job:        req.Job,
+			NTime:      req.NTime,
+			NoncePart1: c.noncePart1,
+			NoncePart2: req.NoncePart2,
+			Solution:   req.Solution,
+		}, 0)
+
+	case stratum.RequestSubmitBitcoin:
+		if len(req.NoncePart2) != c.noncePart2Size {
+			c.ps.Strike(c.ip, BanMalformed)
+			c.ps.countRejection(RejectMalformed)
+			return c.reply(req.ID, false, stratum.ErrorOther)
+		}
+
+		return c.handleSubmit(req.ID, req.Worker, proxy.Share{
+			Job:        req.Job,
+			NTime:      req.NTime,
+			NoncePart1: c.noncePart1,
+			NoncePart2: req.NoncePart2,
+			Nonce:      req.Nonce,
+		}, req.VersionBits)
+
+	case stratum.RequestSubscribe:
+		return c.reply(req.ID, nil, stratum.ErrorOther)
+
+	case stratum.RequestExtranonceSubscribe:
+		// The nonce never changes during a session, nothing to send.
+		return c.reply(req.ID, true, nil)
+
+	case stratum.RequestSuggestDifficulty:
+		return c.suggestDifficulty(req.ID, proxy.Difficulty(req.Difficulty))
+
+	case stratum.RequestSuggestTarget:
+		return c.suggestDifficulty(req.ID, proxy.FromTarget(req.Target, c.algorithm()))
+
+	case stratum.RequestConfigure:
+		return c.handleConfigure(req)
+
+	case stratum.RequestGetTransactions:
+		// Jobs come from upstream, we don't know their transactions.
+		return c.reply(req.ID, make([]string, 0), nil)
+
+	case stratum.RequestReply:
+		return nil
+
+	case stratum.RequestUnknown:
+		return c.reply(req.ID, nil, stratum.ErrorMethodNotFound)
+
+	default:
+		return stratum.ErrUnknownType
+	}
+}
+
+func (c *ProxyClient) handleAuthorize(req stratum.RequestAuthorize) error {
+	if !c.ps.checkAuth(req.Username, req.Password) {
+		log.Printf("[client %v %v] failed to authorize '%v'\n", c.ID, c.conn.RemoteAddr(), req.Username)
+		c.ps.Strike(c.ip, BanFailedAuth)
+
+		return c.reply(req.ID, false, stratum.ErrorUnauthorized)
+	}
+
+	c.name = req.Username
+	c.authorized = true
+
+	log.Printf("[client %v %v] '%v'\n", c.ID, c.conn.RemoteAddr(), c.name)
+
+	if err := c.reply(req.ID, true, nil); err != nil {
+		return err
+	}
+
+	if err := c.sendDifficulty(); err != nil {
+		return err
+	}
+
+	if work := c.ps.CurrentWork(c.dialect); work != nil {
+		return c.writer.Notify(work.Notify())
+	}
+
+	return nil
+}
+
+// handleConfigure grants version rolling within the pool's mask to sha256d
+// clients and turns down every other extension.
+func (c *ProxyClient) handleConfigure(req stratum.RequestConfigure) error {
+	result := make(map[string]interface{})
+	for _, extension := range req.Extensions {
+		result[extension] = false
+	}
+
+	if mask, minBitCount, ok := req.VersionRolling(); ok && c.dialect.IsBitcoin() {
+		granted := mask & c.ps.versionMask()
+		if bits.OnesCount32(granted) < minBitCount {
+			granted = 0
+		}
+
+		atomic.StoreUint32(&c.requestedMask, mask)
+		atomic.StoreUint32(&c.versionMask, granted)
+
+		for k, v := range stratum.VersionRollingResult(granted) {
+			result[k] = v
+		}
+	}
+
+	return c.reply(req.ID, result, nil)
+}
+
+// suggestDifficulty switches the client to a new share difficulty, no lower than the configured minimum.
+func (c *ProxyClient) suggestDifficulty(id interface{}, d proxy.Difficulty) error {
+	if min := proxy.Difficulty(c.ps.Config.MinDifficulty); d < min {
+		d = min
+	}
+
+	if d <= 0 {
+		return c.reply(id, false, stratum.ErrorOther)
+	}
+
+	c.difficulty = d
+	if err := c.reply(id, true, nil); err != nil {
+		return err
+	}
+
+	if !c.authorized {
+		return nil
+	}
+
+	return c.sendDifficulty()
+}
+
+// sendDifficulty tells the client its share target, as a target for Equihash
+// miners and as a difficulty for SHA256d ones.
+func (c *ProxyClient) sendDifficulty() error {
+	if c.dialect.IsBitcoin() {
+		return c.writer.Notify(stratum.ResponseSetDifficulty{Difficulty: float64(c.difficulty)})
+	}
+
+	return c.writer.Notify(stratum.ResponseSetTarget{Target: c.difficulty.ToTarget(c.algorithm())})
+}
+
+// algorithm is the proof of work the client mines.
+func (c *ProxyClient) algorithm() proxy.Algorithm {
+	return proxy.DialectAlgorithm(c.dialect)
+}
+
+// handleSubmit checks a share and forwards it upstream. versionBits are the
+// sha256d version bits the client rolled, if any.
+func (c *ProxyClient) handleSubmit(id interface{}, worker string, share proxy.Share, versionBits uint32) error {
+	if !c.authorized || worker != c.name {
+		c.ps.countRejection(RejectUnauthorized)
+		return c.reply(id, false, stratum.ErrorUnauthorized)
+	}
+
+	// Superseded jobs on the current block are still worth forwarding,
+	// those on an older block are not.
+	work, status := c.ps.Job(c.dialect, share.Job)
+	c.ps.countShare(c.name, status)
+
+	switch status {
+	case proxy.JobUnknown:
+		c.ps.countRejection(RejectJobNotFound)
+		return c.reply(id, false, stratum.ErrorJobNotFound)
+	case proxy.JobPreviousBlock:
+		c.ps.countRejection(RejectStale)
+		return c.reply(id, false, stratum.ErrorStale)
+	}
+
+	if !c.ps.checkNTime(c.name, work, share.NTime) {
+		return c.reply(id, false, stratum.ErrorNTime)
+	}
+
+	if c.dialect.IsBitcoin() {
+		share.VersionMask = atomic.LoadUint32(&c.versionMask)
+		share.Version = work.RollVersion(versionBits, share.VersionMask)
+	}
+
+	difficulty := c.difficulty
+	result := work.CheckShare(share, difficulty.ToTarget(c.algorithm()))
+	switch result {
+	case proxy.ShareInvalid:
+		c.ps.Strike(c.ip, BanInvalidShare)
+		c.ps.countRejection(RejectLowDifficulty)
+		return c.reply(id, false, stratum.ErrorLowDifficulty)
+
+	case proxy.ShareInvalidSolution:
+		c.ps.Strike(c.ip, BanInvalidShare)
+		c.ps.countRejection(RejectSolution)
+		return c.reply(id, false, stratum.ErrorSolution)
+
+	case proxy.ShareInvalidVersion:
+		c.ps.Strike(c.ip, BanInvalidShare)
+		c.ps.countRejection(RejectVersion)
+		return c.reply(id, false, stratum.ErrorVersionMask)
+	}
+
+	if c.ps.seenShare(c.dialect, share) {
+		c.ps.countDuplicate(c.name)
+		return c.reply(id, false, stratum.ErrorDuplicate)
+	}
+
+	if result == proxy.ShareBlock {
+		c.ps.blockFound(c.dialect, c.name, difficulty, work, share)
+	}
+
+	upstream, ok := c.ps.upstream(c.dialect)
+	if !ok {
+		// Solo, the share is as good as we know.
+		if result != proxy.ShareBlock {
+			c.ps.addRoundShare(c.dialect, difficulty)
+		}
+
+		return c.reply(id, true, nil)
+	}
+
+	// Relay the pool's verdict once it arrives, keep reading meanwhile.
+	err := upstream.Submit(share, func(e *stratum.Error) {
+		if e != nil {
+			c.ps.countRejection(RejectUpstream)
+			_ = c.reply(id, false, e)
+			return
+		}
+
+		// A block share was counted when it ended the round.
+		if result != proxy.ShareBlock {
+			c.ps.addRoundShare(c.dialect, difficulty)
+		}
+
+		_ = c.reply(id, true, nil)
+	})
+	if err != nil {
+		return c.reply(id, false, stratum.ErrorOther)
+	}
+
+	return nil
+}
+
+func (c *ProxyClient) reply(id interface{}, result interface{}, e *stratum.Error) error {
+	resp := stratum.ResponseGeneral{
+		ID:     id,
+		Result: result,
+	}
+
+	if e != nil {
+		resp.Error = e
+	}
+
+	return c.writer.Reply(resp)
+}
+
+// checkAuth validates a miner's credentials against the config.
+func (s *ProxyServer) checkAuth(username, password string) bool {
+	if username == "" {
+		return false
+	}
+
+	if s.Config.MinerPassword != "" && password != s.Config.MinerPassword {
+		return false
+	}
+
+	if s.Config.ValidateAddress {
+		// Usernames are address.worker
+		address := strings.SplitN(username, ".", 2)[0]
+		valid, testnet := proxy.IsValidAddress(address)
+		if !valid || testnet != s.Config.Testnet {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"math/bits"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// newTestServer returns a server without listeners, upstreams or metrics.
+func newTestServer(t *testing.T, cfg Config) *ProxyServer {
+	t.Helper()
+
+	bans, err := NewBanManager(cfg.Bans, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := newBlockTracker(cfg.Blocks, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rounds, err := newRoundTracker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ProxyServer{
+		Config:   cfg,
+		bans:     bans,
+		workers:  newWorkerStats(),
+		blocks:   blocks,
+		rounds:   rounds,
+		sessions: newSessionStore(0, 0),
+		counters: new(expvar.Map).Init(),
+	}
+	s.clients.m = make(map[ClientID]*ProxyClient)
+	s.v2.m = make(map[ClientID]*V2Client)
+	s.work.current = make(map[stratum.Dialect]*proxy.Work)
+	s.upstreams.active = make(map[stratum.Dialect]*Upstream)
+	s.upstreams.all = make(map[stratum.Dialect][]*Upstream)
+
+	return s
+}
+
+// testMiner is the miner's end of a client connected to s.
+type testMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// connect registers a client of the dialect with s, over a pipe.
+func connect(t *testing.T, s *ProxyServer, dialect stratum.Dialect) (*ProxyClient, *testMiner) {
+	t.Helper()
+
+	server, miner := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = miner.Close()
+	})
+
+	c := &ProxyClient{
+		ID:         ClientID(len(s.clients.m) + 1),
+		name:       "worker.1",
+		ip:         net.IPv4(127, 0, 0, 1),
+		ps:         s,
+		conn:       server,
+		writer:     proxy.NewWriter(server, 16, time.Second),
+		dialect:    dialect,
+		difficulty: 1,
+		authorized: true,
+	}
+
+	s.clients.Lock()
+	s.clients.m[c.ID] = c
+	s.clients.Unlock()
+
+	return c, &testMiner{t: t, conn: miner, reader: bufio.NewReader(miner)}
+}
+
+// read returns the next message the miner received, decoded.
+func (m *testMiner) read() map[string]interface{} {
+	m.t.Helper()
+
+	_ = m.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatal(err)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		m.t.Fatalf("%s: %v", line, err)
+	}
+
+	return msg
+}
+
+func TestHandleConfigure(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  stratum.Dialect
+		pool     uint32
+		params   map[string]interface{}
+		result   map[string]interface{}
+		granted  uint32
+		requests uint32
+	}{
+		{
+			name:     "within the default mask",
+			dialect:  stratum.Bitcoin,
+			params:   map[string]interface{}{stratum.VersionRollingMask: "00fff000", stratum.VersionRollingMinBitCount: float64(2)},
+			result:   map[string]interface{}{"version-rolling": true, "version-rolling.mask": "00ffe000"},
+			granted:  0x00ffe000,
+			requests: 0x00fff000,
+		},
+		{
+			name:     "narrowed by the pool",
+			dialect:  stratum.Bitcoin,
+			pool:     0x00006000,
+			params:   map[string]interface{}{stratum.VersionRollingMask: "ffffffff"},
+			result:   map[string]interface{}{"version-rolling": true, "version-rolling.mask": "00006000"},
+			granted:  0x00006000,
+			requests: 0xffffffff,
+		},
+		{
+			name:     "fewer bits than asked for",
+			dialect:  stratum.Bitcoin,
+			pool:     0x00006000,
+			params:   map[string]interface{}{stratum.VersionRollingMask: "1fffe000", stratum.VersionRollingMinBitCount: float64(16)},
+			result:   map[string]interface{}{"version-rolling": false},
+			requests: 0x1fffe000,
+		},
+		{
+			name:    "equihash",
+			dialect: stratum.Equihash,
+			params:  map[string]interface{}{stratum.VersionRollingMask: "1fffe000"},
+			result:  map[string]interface{}{"version-rolling": false},
+		},
+	}
+
+	for _, tt := range tests {
+		s := newTestServer(t, Config{})
+		if tt.pool != 0 {
+			u := &Upstream{Config: UpstreamConfig{Dialect: stratum.Bitcoin}, ps: s, versionMask: tt.pool}
+			s.upstreams.active[stratum.Bitcoin] = u
+		}
+
+		c, miner := connect(t, s, tt.dialect)
+		req := stratum.RequestConfigure{
+			RequestBase: stratum.RequestBase{ID: float64(1), Method: stratum.Configure},
+			Extensions:  []string{stratum.VersionRolling},
+			Params:      tt.params,
+		}
+
+		if err := c.handleConfigure(req); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if result := miner.read()["result"]; !reflect.DeepEqual(result, tt.result) {
+			t.Errorf("%s: replied %v, want %v", tt.name, result, tt.result)
+		}
+
+		if c.versionMask != tt.granted || c.requestedMask != tt.requests {
+			t.Errorf("%s: granted %08x of %08x, want %08x of %08x", tt.name, c.versionMask, c.requestedMask, tt.granted, tt.requests)
+		}
+	}
+}
+
+func TestSetVersionMask(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	rolling, miner := connect(t, s, stratum.Bitcoin)
+	rolling.requestedMask, rolling.versionMask = 0x1fffe000, 0x1fffe000
+
+	// Clients that didn't ask for version rolling aren't told.
+	fixed, _ := connect(t, s, stratum.Bitcoin)
+
+	s.SetVersionMask(0x00006000)
+
+	msg := miner.read()
+	if msg["method"] != string(stratum.SetVersionMask) || !reflect.DeepEqual(msg["params"], []interface{}{"00006000"}) {
+		t.Errorf("notified %v", msg)
+	}
+
+	if rolling.versionMask != 0x00006000 || fixed.versionMask != 0 {
+		t.Errorf("masks %08x and %08x, want 00006000 and 0", rolling.versionMask, fixed.versionMask)
+	}
+
+	// A wider pool mask gives back what the client asked for, no more.
+	s.SetVersionMask(0xffffffff)
+	if msg := miner.read(); !reflect.DeepEqual(msg["params"], []interface{}{"1fffe000"}) {
+		t.Errorf("notified %v", msg)
+	}
+}
+
+func TestHandleSubmitDuplicate(t *testing.T) {
+	s := newTestServer(t, Config{})
+	s.solo = &soloSource{ps: s, jobs: proxy.NewJobRegistry(0), dialect: stratum.Bitcoin}
+
+	// No share can meet a zero block target.
+	s.solo.jobs.Add(&proxy.Work{
+		Dialect:        stratum.Bitcoin,
+		ResponseNotify: stratum.ResponseNotify{Job: "1", Version: 0x20000000, NTime: uint32(time.Now().Unix())},
+		Coinbase1:      []byte{0x01, 0x00, 0x00, 0x00},
+	})
+
+	c, miner := connect(t, s, stratum.Bitcoin)
+	c.noncePart1 = []byte{0x00, 0x01}
+	c.difficulty = 1e-30
+	c.versionMask = 0x1fffe000
+
+	share := proxy.Share{
+		Job:        "1",
+		NTime:      uint32(time.Now().Unix()),
+		NoncePart1: c.noncePart1,
+		NoncePart2: []byte{0x00, 0x00, 0x00, 0x01},
+		Nonce:      7,
+	}
+
+	tests := []struct {
+		name        string
+		edit        func(*proxy.Share)
+		versionBits uint32
+		err         *stratum.Error
+	}{
+		{name: "first", edit: func(*proxy.Share) {}},
+		{name: "again", edit: func(*proxy.Share) {}, err: stratum.ErrorDuplicate},
+		{name: "other nonce", edit: func(s *proxy.Share) { s.Nonce++ }},
+		{name: "other nonce again", edit: func(s *proxy.Share) { s.Nonce++ }, err: stratum.ErrorDuplicate},
+		{name: "rolled version", edit: func(*proxy.Share) {}, versionBits: 0x00006000},
+		{name: "rolled version again", edit: func(*proxy.Share) {}, versionBits: 0x00006000, err: stratum.ErrorDuplicate},
+	}
+
+	for i, tt := range tests {
+		sh := share
+		tt.edit(&sh)
+
+		if err := c.handleSubmit(float64(i), c.name, sh, tt.versionBits); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		reply := miner.read()
+		if tt.err == nil {
+			if reply["result"] != true || reply["error"] != nil {
+				t.Errorf("%s: replied %v", tt.name, reply)
+			}
+			continue
+		}
+
+		if e, _ := reply["error"].([]interface{}); len(e) < 2 || e[0] != float64(tt.err.Code) {
+			t.Errorf("%s: replied %v, want error %v", tt.name, reply, tt.err)
+		}
+	}
+
+	if d := s.Workers()[c.name].Duplicates; d != 3 {
+		t.Errorf("counted %d duplicates, want 3", d)
+	}
+	if v := s.counters.Get("rejected_" + RejectDuplicate); v == nil || v.String() != "3" {
+		t.Errorf("duplicate rejections %v, want 3", v)
+	}
+}
+
+func TestHandleSubmitEquihash(t *testing.T) {
+	s := newTestServer(t, Config{Bans: BanConfig{InvalidShares: 2}})
+	u := testUpstream(s, stratum.Equihash, "pool")
+
+	// Equihash ntimes carry the header's little-endian bytes.
+	now := bits.ReverseBytes32(uint32(time.Now().Unix()))
+	u.jobs.Add(&proxy.Work{
+		Dialect:        stratum.Equihash,
+		ResponseNotify: stratum.ResponseNotify{Job: "1", NTime: now},
+		N:              proxy.EquihashN,
+		K:              proxy.EquihashK,
+	})
+
+	c, miner := connect(t, s, stratum.Equihash)
+	share := proxy.Share{
+		Job:        "1",
+		NTime:      now,
+		NoncePart1: make([]byte, 16),
+		NoncePart2: make([]byte, 16),
+		Solution:   make([]byte, 1344),
+	}
+
+	if err := c.handleSubmit(float64(1), c.name, share, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := miner.read()
+	if e, _ := reply["error"].([]interface{}); len(e) < 2 || e[1] != stratum.ErrorSolution.Message {
+		t.Errorf("replied %v, want %v", reply, stratum.ErrorSolution)
+	}
+
+	// The second strike bans, closing the client.
+	_ = c.handleSubmit(float64(2), c.name, share, 0)
+	if !s.bans.IsBanned(c.ip) {
+		t.Error("not banned for bad solutions")
+	}
+	if v := s.counters.Get("rejected_" + RejectSolution); v == nil || v.String() != "2" {
+		t.Errorf("solution rejections %v, want 2", v)
+	}
+	if luck := s.Luck(); luck[0].RoundShares != 0 {
+		t.Errorf("counted %v round shares", luck[0].RoundShares)
+	}
+}
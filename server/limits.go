@@ -0,0 +1,122 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+)
+
+type (
+	// LimitsConfig for connections and messages, zero means unlimited.
+	LimitsConfig struct {
+		MaxConns      int `json:"maxConns"`
+		MaxConnsPerIP int `json:"maxConnsPerIP"`
+
+		// Messages per second each client may send, with bursts of MessageBurst.
+		MessageRate  float64 `json:"messageRate"`
+		MessageBurst int     `json:"messageBurst"`
+
+		// Longest stratum line in bytes, and the seconds a client gets to
+		// finish sending one. Defaults to proxy.DefaultMaxMessageSize and
+		// proxy.DefaultMessageTimeout.
+		MaxMessageSize int `json:"maxMessageSize"`
+		MessageTimeout int `json:"messageTimeout"`
+
+		// Messages queued per client before it is disconnected as too slow,
+		// defaults to proxy.DefaultQueueSize.
+		WriteQueue int `json:"writeQueue"`
+
+		// Channels a Stratum V2 connection may have open, each holding a
+		// nonce1 like a V1 client. Defaults to DefaultMaxChannels.
+		MaxChannels int `json:"maxChannels"`
+	}
+
+	// NTimeConfig bounds in seconds how far miners may roll a job's ntime:
+	// back from and forward of the job's ntime, and ahead of our clock.
+	NTimeConfig struct {
+		Backward int `json:"backward"`
+		Forward  int `json:"forward"`
+		Future   int `json:"future"`
+	}
+
+	// connLimiter counts open connections per IP.
+	connLimiter struct {
+		cfg LimitsConfig
+
+		mu    sync.Mutex
+		total int
+		perIP map[string]int
+	}
+)
+
+const (
+	DefaultNTimeForward = 10 * 60
+	// Nodes refuse blocks more than two hours ahead.
+	DefaultNTimeFuture = 2 * 60 * 60
+)
+
+// maxChannels is the number of channels a V2 connection may have open.
+func (c LimitsConfig) maxChannels() int {
+	if c.MaxChannels > 0 {
+		return c.MaxChannels
+	}
+
+	return DefaultMaxChannels
+}
+
+// limits fills in the defaults, rolling back is refused unless configured.
+func (c NTimeConfig) limits() proxy.NTimeLimits {
+	limits := proxy.NTimeLimits{
+		Forward: DefaultNTimeForward,
+		Future:  DefaultNTimeFuture,
+	}
+
+	if c.Backward > 0 {
+		limits.Backward = uint32(c.Backward)
+	}
+
+	if c.Forward > 0 {
+		limits.Forward = uint32(c.Forward)
+	}
+
+	if c.Future > 0 {
+		limits.Future = uint32(c.Future)
+	}
+
+	return limits
+}
+
+func newConnLimiter(cfg LimitsConfig) *connLimiter {
+	return &connLimiter{
+		cfg:   cfg,
+		perIP: make(map[string]int),
+	}
+}
+
+// acquire a slot for the IP, returns false if a limit is reached.
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cfg.MaxConns > 0 && l.total >= l.cfg.MaxConns {
+		return false
+	}
+
+	if l.cfg.MaxConnsPerIP > 0 && l.perIP[ip] >= l.cfg.MaxConnsPerIP {
+		return false
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import "testing"
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(LimitsConfig{MaxConns: 3, MaxConnsPerIP: 2})
+
+	steps := []struct {
+		acquire bool
+		ip      string
+		want    bool
+	}{
+		{true, "a", true},
+		{true, "a", true},
+		{true, "a", false}, // per IP
+		{true, "b", true},
+		{true, "c", false}, // global
+		{false, "a", true},
+		{true, "c", true},
+		{true, "b", false}, // global again
+		{false, "b", true},
+		{false, "c", true},
+		{true, "b", true},
+	}
+
+	for i, step := range steps {
+		if !step.acquire {
+			l.release(step.ip)
+			continue
+		}
+
+		if got := l.acquire(step.ip); got != step.want {
+			t.Fatalf("step %v: acquire(%v) = %v", i, step.ip, got)
+		}
+	}
+
+	if l.total != 2 || l.perIP["a"] != 1 || l.perIP["b"] != 1 || l.perIP["c"] != 0 {
+		t.Errorf("counts: total %v, per IP %v", l.total, l.perIP)
+	}
+}
+
+func TestConnLimiterUnlimited(t *testing.T) {
+	l := newConnLimiter(LimitsConfig{})
+	for i := 0; i < 1000; i++ {
+		if !l.acquire("a") {
+			t.Fatal("limited without limits")
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		l.release("a")
+	}
+
+	if _, ok := l.perIP["a"]; ok || l.total != 0 {
+		t.Errorf("released IP kept: total %v, per IP %v", l.total, l.perIP)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type (
+	// ListenerConfig for a stratum port.
+	ListenerConfig struct {
+		Host string `json:"host"`
+
+		// Skip the port, e.g. for samples needing files that aren't there.
+		Disabled bool `json:"disabled"`
+
+		// Serve stratum over TLS when set.
+		TLS *TLSConfig `json:"tls"`
+
+		// Read a PROXY protocol header from connections coming from
+		// TrustedProxies. Other sources are served with their own address.
+		ProxyProtocol  bool     `json:"proxyProtocol"`
+		TrustedProxies []string `json:"trustedProxies"`
+
+		// Stratum dialect spoken by miners on this port, equihash by default.
+		Dialect stratum.Dialect `json:"dialect"`
+
+		// Speak Stratum V2 instead, translated onto the sha256d work.
+		V2 *V2Config `json:"v2"`
+	}
+
+	// Listener accepts miners on a single address.
+	Listener struct {
+		Config ListenerConfig
+
+		ps      *ProxyServer
+		tls     *tls.Config
+		trusted []*net.IPNet
+		v2      *v2Keys
+	}
+)
+
+func (s *ProxyServer) NewListener(cfg ListenerConfig) (*Listener, error) {
+	if !cfg.Dialect.Valid() {
+		return nil, errors.New("unknown dialect: " + string(cfg.Dialect))
+	}
+
+	l := Listener{
+		Config: cfg,
+		ps:     s,
+	}
+
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.Build()
+		if err != nil {
+			return nil, err
+		}
+
+		l.tls = tlsConfig
+	}
+
+	if cfg.ProxyProtocol {
+		if len(cfg.TrustedProxies) == 0 {
+			return nil, errors.New("proxyProtocol needs trustedProxies")
+		}
+
+		trusted, err := proxy.ParseNets(cfg.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+
+		l.trusted = trusted
+	}
+
+	if cfg.V2 != nil {
+		if cfg.Dialect != "" && !cfg.Dialect.IsBitcoin() {
+			return nil, errors.New("stratum v2 only speaks " + string(stratum.Bitcoin))
+		}
+		l.Config.Dialect = stratum.Bitcoin
+
+		keys, err := cfg.V2.keys()
+		if err != nil {
+			return nil, err
+		}
+
+		l.v2 = keys
+	}
+
+	return &l, nil
+}
+
+// Serve accepts connections until the listener fails.
+func (l *Listener) Serve() error {
+	addr, err := net.ResolveTCPAddr("tcp", l.Config.Host)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	switch {
+	case l.v2 != nil:
+		log.Printf("Listening on: %v (stratum v2, authority key %x)\n", addr, l.v2.authority.PublicKey())
+	case l.tls != nil:
+		log.Println("Listening on:", addr, "(tls)")
+	default:
+		log.Println("Listening on:", addr)
+	}
+
+	for {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			return err
+		}
+
+		go l.handle(conn)
+	}
+}
+
+func (l *Listener) handle(tcpConn *net.TCPConn) {
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		_ = tcpConn.Close()
+		return
+	}
+
+	if err := tcpConn.SetKeepAlivePeriod(KeepAliveInterval); err != nil {
+		_ = tcpConn.Close()
+		return
+	}
+
+	var conn net.Conn = tcpConn
+	if l.Config.ProxyProtocol && proxy.ContainsAddr(l.trusted, tcpConn.RemoteAddr()) {
+		proxyConn, err := proxy.NewProxyConn(conn, time.Now().Add(InitTimeout))
+		if err != nil {
+			log.Printf("[server] bad proxy header from %v: %v\n", tcpConn.RemoteAddr(), err)
+			_ = tcpConn.Close()
+			return
+		}
+
+		conn = proxyConn
+	}
+
+	if l.tls != nil {
+		// The handshake runs on the first read, bounded by InitTimeout.
+		conn = tls.Server(conn, l.tls)
+	}
+
+	if l.v2 != nil {
+		_ = l.ps.handleV2(conn, l.v2)
+		return
+	}
+
+	_ = l.ps.Handle(conn, l.Config.Dialect)
+}
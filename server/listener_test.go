@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestListenerConfigs(t *testing.T) {
+	cfg := Config{
+		Host: "localhost:3333",
+		Listeners: []ListenerConfig{
+			{Host: "localhost:3443", Disabled: true, TLS: &TLSConfig{CertFile: "proxy.crt", KeyFile: "proxy.key"}},
+			{Host: "localhost:3336", Dialect: "sha256d"},
+		},
+	}
+
+	want := []ListenerConfig{
+		{Host: "localhost:3333"},
+		{Host: "localhost:3336", Dialect: "sha256d"},
+	}
+
+	if got := cfg.ListenerConfigs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got := (Config{}).ListenerConfigs(); len(got) != 0 {
+		t.Errorf("got %+v without listeners", got)
+	}
+}
+
+// The example must start as it is, listeners needing files or keys that
+// aren't there are disabled.
+func TestExampleListeners(t *testing.T) {
+	data, err := ioutil.ReadFile("../config.json.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	listeners := cfg.ListenerConfigs()
+	if len(listeners) == 0 {
+		t.Fatal("no listeners enabled")
+	}
+
+	s := &ProxyServer{Config: cfg}
+	for _, lc := range listeners {
+		if _, err := s.NewListener(lc); err != nil {
+			t.Errorf("%v: %v", lc.Host, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/lib"
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type (
+	// Luck of an upstream. Effort is the share difficulty submitted over
+	// the network difficulty, 1 being a block on average luck, and luck
+	// over a window of blocks is their count over their total effort.
+	Luck struct {
+		Upstream string `json:"upstream"`
+
+		RoundShares   float64   `json:"roundShares"`
+		RoundStart    time.Time `json:"roundStart"`
+		NetDifficulty float64   `json:"netDifficulty"`
+		Effort        float64   `json:"effort"`
+
+		Blocks int `json:"blocks"`
+		// Keyed by the number of latest blocks, "all" for every block.
+		Luck map[string]float64 `json:"luck"`
+	}
+
+	// roundTracker accumulates the share difficulty of each upstream's
+	// current round.
+	roundTracker struct {
+		db *lib.DB
+
+		mu     sync.Mutex
+		rounds map[string]*lib.Round
+		dirty  map[string]bool
+	}
+)
+
+const roundSaveInterval = 30 * time.Second
+
+// Block counts luck is reported over.
+var luckWindows = map[string]int{
+	"10":  10,
+	"50":  50,
+	"100": 100,
+}
+
+// newRoundTracker loads the rounds from db, which may be nil.
+func newRoundTracker(db *lib.DB) (*roundTracker, error) {
+	t := roundTracker{
+		db:     db,
+		rounds: make(map[string]*lib.Round),
+		dirty:  make(map[string]bool),
+	}
+
+	if db != nil {
+		rounds, err := db.Rounds()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range rounds {
+			t.rounds[rounds[i].Upstream] = &rounds[i]
+		}
+
+		go t.saveLoop()
+	}
+
+	return &t, nil
+}
+
+// round returns the upstream's round, t.mu held.
+func (t *roundTracker) round(upstream string) *lib.Round {
+	round, ok := t.rounds[upstream]
+	if !ok {
+		round = &lib.Round{Upstream: upstream, Start: time.Now()}
+		t.rounds[upstream] = round
+	}
+
+	return round
+}
+
+func (t *roundTracker) add(upstream string, difficulty float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.round(upstream).Shares += difficulty
+	t.dirty[upstream] = true
+}
+
+// end the upstream's round with a block share of difficulty, returning the
+// round's total.
+func (t *roundTracker) end(upstream string, difficulty float64) float64 {
+	t.mu.Lock()
+	round := t.round(upstream)
+	shares := round.Shares + difficulty
+	round.Shares = 0
+	round.Start = time.Now()
+	saved := *round
+	delete(t.dirty, upstream)
+	t.mu.Unlock()
+
+	t.save(saved)
+	return shares
+}
+
+func (t *roundTracker) get(upstream string) lib.Round {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return *t.round(upstream)
+}
+
+func (t *roundTracker) save(round lib.Round) {
+	if t.db == nil {
+		return
+	}
+
+	if err := t.db.SaveRound(round); err != nil {
+		log.Printf("[rounds] could not save round of %v: %v\n", round.Upstream, err)
+	}
+}
+
+// saveLoop writes the rounds that changed, shares come too often to save
+// each one.
+func (t *roundTracker) saveLoop() {
+	for range time.Tick(roundSaveInterval) {
+		var rounds []lib.Round
+
+		t.mu.Lock()
+		for upstream := range t.dirty {
+			rounds = append(rounds, *t.rounds[upstream])
+		}
+		t.dirty = make(map[string]bool)
+		t.mu.Unlock()
+
+		for _, round := range rounds {
+			t.save(round)
+		}
+	}
+}
+
+// upstreamName identifies a dialect's upstream in rounds and blocks.
+func (s *ProxyServer) upstreamName(dialect stratum.Dialect) string {
+	if u, ok := s.upstream(dialect); ok {
+		return u.Config.Addr()
+	}
+
+	if s.solo != nil && s.solo.dialect == dialect {
+		return soloName
+	}
+
+	return ""
+}
+
+// addRoundShare counts a share the upstream accepted towards its round.
+func (s *ProxyServer) addRoundShare(dialect stratum.Dialect, difficulty proxy.Difficulty) {
+	s.rounds.add(s.upstreamName(dialect), float64(difficulty))
+}
+
+// Luck returns the luck of every upstream.
+func (s *ProxyServer) Luck() []Luck {
+	blocks := s.blocks.List()
+
+	var result []Luck
+	for _, u := range s.allUpstreams() {
+		result = append(result, s.luck(u.Config.Addr(), u.jobs.Current(), blocks))
+	}
+
+	if s.solo != nil {
+		result = append(result, s.luck(soloName, s.solo.jobs.Current(), blocks))
+	}
+
+	return result
+}
+
+// luck of one upstream whose current job is work, which may be nil.
+func (s *ProxyServer) luck(upstream string, work *proxy.Work, blocks []lib.Block) Luck {
+	round := s.rounds.get(upstream)
+
+	luck := Luck{
+		Upstream:    upstream,
+		RoundShares: round.Shares,
+		RoundStart:  round.Start,
+		Luck:        make(map[string]float64),
+	}
+
+	if work != nil {
+		luck.NetDifficulty = float64(work.NetworkDifficulty())
+		if luck.NetDifficulty > 0 {
+			luck.Effort = round.Shares / luck.NetDifficulty
+		}
+	}
+
+	// Newest first, see blockTracker.List.
+	var efforts []float64
+	for _, block := range blocks {
+		if block.Upstream == upstream && block.Effort > 0 {
+			efforts = append(efforts, block.Effort)
+		}
+	}
+	luck.Blocks = len(efforts)
+
+	for name, n := range luckWindows {
+		if len(efforts) >= n {
+			luck.Luck[name] = blockLuck(efforts[:n])
+		}
+	}
+	if len(efforts) > 0 {
+		luck.Luck["all"] = blockLuck(efforts)
+	}
+
+	return luck
+}
+
+func blockLuck(efforts []float64) float64 {
+	var total float64
+	for _, effort := range efforts {
+		total += effort
+	}
+
+	return float64(len(efforts)) / total
+}
+
+// GET /luck
+func (s *ProxyServer) handleLuck(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Luck())
+}
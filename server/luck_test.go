@@ -0,0 +1,110 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/lib"
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func TestBlockLuck(t *testing.T) {
+	tests := []struct {
+		efforts []float64
+		want    float64
+	}{
+		{[]float64{1}, 1},
+		{[]float64{0.5}, 2},
+		{[]float64{2}, 0.5},
+		{[]float64{0.5, 1.5}, 1},
+		{[]float64{0.25, 0.25, 0.5}, 3},
+		{[]float64{3, 1, 2, 2}, 0.5},
+	}
+
+	for _, tt := range tests {
+		if got := blockLuck(tt.efforts); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("blockLuck(%v) = %v, want %v", tt.efforts, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTracker(t *testing.T) {
+	rounds, err := newRoundTracker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := rounds.get("pool:3333").Start
+	rounds.add("pool:3333", 2)
+	rounds.add("pool:3333", 0.5)
+	rounds.add("other:3333", 7)
+
+	if shares := rounds.get("pool:3333").Shares; shares != 2.5 {
+		t.Errorf("round shares %v, want 2.5", shares)
+	}
+
+	time.Sleep(time.Millisecond)
+	if total := rounds.end("pool:3333", 1); total != 3.5 {
+		t.Errorf("round ended with %v shares, want 3.5", total)
+	}
+
+	round := rounds.get("pool:3333")
+	if round.Shares != 0 || !round.Start.After(start) {
+		t.Errorf("new round %+v, started before %v", round, start)
+	}
+	if shares := rounds.get("other:3333").Shares; shares != 7 {
+		t.Errorf("other upstream's round %v, want 7", shares)
+	}
+}
+
+func TestLuck(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	// A solo source mining at difficulty 4.
+	target, err := proxy.CompactToTarget(0x1c3fffc0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.solo = &soloSource{ps: s, jobs: proxy.NewJobRegistry(0), dialect: stratum.Bitcoin}
+	s.solo.jobs.Add(&proxy.Work{Dialect: stratum.Bitcoin, ResponseNotify: stratum.ResponseNotify{Job: "1"}, Target: target})
+
+	s.addRoundShare(stratum.Bitcoin, 1)
+
+	// Efforts newest first: twelve blocks at 0.5, then 2 and 4, and
+	// blocks of another upstream or without an effort that don't count.
+	now := time.Now()
+	efforts := []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 2, 4}
+	for i, effort := range efforts {
+		s.blocks.found(lib.Block{Hash: string(rune('a' + i)), Upstream: soloName, Effort: effort, FoundAt: now.Add(-time.Duration(i) * time.Minute)})
+	}
+	s.blocks.found(lib.Block{Hash: "other", Upstream: "pool:3333", Effort: 0.1, FoundAt: now})
+	s.blocks.found(lib.Block{Hash: "unknown", Upstream: soloName, FoundAt: now})
+
+	luck := s.Luck()
+	if len(luck) != 1 {
+		t.Fatalf("luck of %d upstreams, want the solo source", len(luck))
+	}
+
+	l := luck[0]
+	if l.Upstream != soloName || l.RoundShares != 1 || l.NetDifficulty != 4 || l.Effort != 0.25 {
+		t.Errorf("round %+v", l)
+	}
+	if l.Blocks != len(efforts) {
+		t.Errorf("%d blocks, want %d", l.Blocks, len(efforts))
+	}
+
+	want := map[string]float64{
+		"10":  2,
+		"all": 14.0 / 12,
+	}
+	if len(l.Luck) != len(want) {
+		t.Errorf("luck over %v, want %v", l.Luck, want)
+	}
+	for window, luck := range want {
+		if math.Abs(l.Luck[window]-luck) > 1e-12 {
+			t.Errorf("luck over %s blocks %v, want %v", window, l.Luck[window], luck)
+		}
+	}
+}
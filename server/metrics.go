@@ -0,0 +1,112 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+)
+
+// Metrics is a snapshot of the proxy's state, published through expvar
+// under "proxy" and served by the API on /metrics.
+type Metrics struct {
+	Clients   int `json:"clients"`
+	V2Clients int `json:"v2Clients"`
+
+	// Messages waiting in client write queues.
+	QueuedMessages int `json:"queuedMessages"`
+	MaxQueueDepth  int `json:"maxQueueDepth"`
+
+	Counters map[string]int64 `json:"counters"`
+
+	// Our node's state, when there is one.
+	Node *NodeStatus `json:"node,omitempty"`
+}
+
+// Counter names
+const (
+	CounterQueueOverflows = "queue_overflows"
+)
+
+// Reasons shares are rejected, each counted as "rejected_<reason>".
+const (
+	RejectUnauthorized  = "unauthorized"
+	RejectJobNotFound   = "job_not_found"
+	RejectStale         = "stale"
+	RejectLowDifficulty = "low_difficulty"
+	RejectVersion       = "invalid_version"
+	RejectSolution      = "invalid_solution"
+	RejectNTimeTooOld   = "ntime_too_old"
+	RejectNTimeTooNew   = "ntime_too_new"
+	RejectDuplicate     = "duplicate"
+	RejectMalformed     = "malformed"
+	// Turned down by the pool.
+	RejectUpstream = "upstream"
+)
+
+// count increments a named counter.
+func (s *ProxyServer) count(name string) {
+	s.counters.Add(name, 1)
+}
+
+// countRejection counts a share rejected for reason.
+func (s *ProxyServer) countRejection(reason string) {
+	s.count("rejected_" + reason)
+}
+
+func (s *ProxyServer) Metrics() Metrics {
+	m := Metrics{
+		Counters: make(map[string]int64),
+		Node:     s.node.Status(),
+	}
+
+	s.clients.RLock()
+	m.Clients = len(s.clients.m)
+	for _, c := range s.clients.m {
+		depth := c.writer.Len()
+		m.QueuedMessages += depth
+		if depth > m.MaxQueueDepth {
+			m.MaxQueueDepth = depth
+		}
+	}
+	s.clients.RUnlock()
+
+	s.v2.RLock()
+	m.V2Clients = len(s.v2.m)
+	s.v2.RUnlock()
+
+	s.counters.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			m.Counters[kv.Key] = v.Value()
+		}
+	})
+
+	return m
+}
+
+// published is the server the "proxy" expvar reports on, the last one
+// made. expvar names can only be published once per process.
+var published struct {
+	once   sync.Once
+	server *ProxyServer
+	sync.RWMutex
+}
+
+func (s *ProxyServer) publishMetrics() {
+	published.Lock()
+	published.server = s
+	published.Unlock()
+
+	published.once.Do(func() {
+		expvar.Publish("proxy", expvar.Func(func() interface{} {
+			published.RLock()
+			defer published.RUnlock()
+
+			return published.server.Metrics()
+		}))
+	})
+}
+
+// GET /metrics
+func (s *ProxyServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Metrics())
+}
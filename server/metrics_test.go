@@ -0,0 +1,28 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestPublishMetrics(t *testing.T) {
+	// Servers made after the first take over the expvar.
+	for i := 1; i <= 2; i++ {
+		s, err := NewProxy(Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < i; j++ {
+			s.count(CounterQueueOverflows)
+		}
+
+		var m Metrics
+		if err := json.Unmarshal([]byte(expvar.Get("proxy").String()), &m); err != nil {
+			t.Fatal(err)
+		}
+		if n := m.Counters[CounterQueueOverflows]; n != int64(i) {
+			t.Errorf("server %d: published %d overflows, want %d", i, n, i)
+		}
+	}
+}
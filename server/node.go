@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+)
+
+// NodeConfig for the JSON-RPC connection to our own full nodes.
+type NodeConfig struct {
+	// URLs of the nodes, credentials included, the later ones are used
+	// when the first can't be reached.
+	URLs []string `json:"urls"`
+
+	// The node's .cookie file, for URLs without credentials.
+	CookieFile string `json:"cookieFile"`
+
+	// Seconds before a call is given up.
+	Timeout int `json:"timeout"`
+
+	// Seconds between health checks, blocks the node may lag behind its
+	// headers and the fewest peers it may have to count as healthy.
+	Interval  int `json:"interval"`
+	MaxBehind int `json:"maxBehind"`
+	MinPeers  int `json:"minPeers"`
+}
+
+func (c NodeConfig) options() rpc.Options {
+	return rpc.Options{
+		CookieFile: c.CookieFile,
+		Timeout:    time.Duration(c.Timeout) * time.Second,
+	}
+}
+
+// client returns a client for the nodes, nil when there are none.
+func (c NodeConfig) client() (*rpc.Client, error) {
+	if len(c.URLs) == 0 {
+		return nil, nil
+	}
+
+	return rpc.NewClient(c.URLs, c.options())
+}
+
+// override returns a client for url, used by a feature configured with its
+// own node, or fallback when url is empty.
+func (c NodeConfig) override(url string, fallback *rpc.Client) (*rpc.Client, error) {
+	if url == "" {
+		return fallback, nil
+	}
+
+	return rpc.NewClient([]string{url}, c.options())
+}
+
+type (
+	// NodeStatus is the state of our node as last checked.
+	NodeStatus struct {
+		Chain                string  `json:"chain"`
+		Height               int     `json:"height"`
+		Headers              int     `json:"headers"`
+		BestBlock            string  `json:"bestBlock"`
+		Peers                int     `json:"peers"`
+		InitialBlockDownload bool    `json:"initialBlockDownload"`
+		VerificationProgress float64 `json:"verificationProgress"`
+		// Milliseconds the node took to answer.
+		Latency float64 `json:"latency"`
+
+		// Synced when out of initial block download and at most MaxBehind
+		// blocks behind its headers, healthy when synced with MinPeers.
+		Synced  bool `json:"synced"`
+		Healthy bool `json:"healthy"`
+
+		Error     string    `json:"error,omitempty"`
+		CheckedAt time.Time `json:"checkedAt"`
+	}
+
+	// nodeMonitor checks the node periodically.
+	nodeMonitor struct {
+		cfg  NodeConfig
+		node *rpc.Client
+
+		mu     sync.RWMutex
+		status NodeStatus
+	}
+)
+
+const (
+	DefaultNodeInterval  = 10 * time.Second
+	DefaultNodeMaxBehind = 2
+	DefaultNodeMinPeers  = 1
+)
+
+// newNodeMonitor returns nil without a node.
+func newNodeMonitor(cfg NodeConfig, node *rpc.Client) *nodeMonitor {
+	if node == nil {
+		return nil
+	}
+
+	m := nodeMonitor{cfg: cfg, node: node}
+	go m.watch()
+
+	return &m
+}
+
+func (m *nodeMonitor) interval() time.Duration {
+	if m.cfg.Interval > 0 {
+		return time.Duration(m.cfg.Interval) * time.Second
+	}
+
+	return DefaultNodeInterval
+}
+
+func (m *nodeMonitor) maxBehind() int {
+	if m.cfg.MaxBehind > 0 {
+		return m.cfg.MaxBehind
+	}
+
+	return DefaultNodeMaxBehind
+}
+
+func (m *nodeMonitor) minPeers() int {
+	if m.cfg.MinPeers > 0 {
+		return m.cfg.MinPeers
+	}
+
+	return DefaultNodeMinPeers
+}
+
+func (m *nodeMonitor) watch() {
+	m.check()
+	for range time.Tick(m.interval()) {
+		m.check()
+	}
+}
+
+// check asks the node for its chain state and peers in one batch.
+func (m *nodeMonitor) check() {
+	var info rpc.BlockchainInfo
+	var peers int
+	calls := []*rpc.BatchCall{
+		{Method: "getblockchaininfo", Result: &info},
+		{Method: "getconnectioncount", Result: &peers},
+	}
+
+	start := time.Now()
+	err := m.node.Batch(context.Background(), calls)
+	status := NodeStatus{
+		Latency:   float64(time.Since(start)) / float64(time.Millisecond),
+		CheckedAt: time.Now(),
+	}
+
+	for _, call := range calls {
+		if err == nil {
+			err = call.Err
+		}
+	}
+
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Chain = info.Chain
+		status.Height = info.Blocks
+		status.Headers = info.Headers
+		status.BestBlock = info.BestBlockHash
+		status.Peers = peers
+		status.InitialBlockDownload = info.InitialBlockDownload
+		status.VerificationProgress = info.VerificationProgress
+		status.Synced = !info.InitialBlockDownload && info.Headers-info.Blocks <= m.maxBehind()
+		status.Healthy = status.Synced && peers >= m.minPeers()
+	}
+
+	m.mu.Lock()
+	previous := m.status
+	m.status = status
+	m.mu.Unlock()
+
+	switch {
+	case status.Healthy && !previous.Healthy:
+		log.Printf("[node] healthy at height %v with %v peers\n", status.Height, status.Peers)
+	case !status.Healthy && (previous.Healthy || previous.CheckedAt.IsZero()):
+		log.Printf("[node] unhealthy: %v\n", status.problem())
+	}
+}
+
+// problem describes why the node isn't healthy.
+func (s NodeStatus) problem() string {
+	switch {
+	case s.Error != "":
+		return s.Error
+	case s.InitialBlockDownload:
+		return "in initial block download"
+	case !s.Synced:
+		return fmt.Sprintf("at height %v of %v", s.Height, s.Headers)
+	default:
+		return fmt.Sprintf("%v peers", s.Peers)
+	}
+}
+
+// Status returns the node's last status, nil without a node.
+func (m *nodeMonitor) Status() *NodeStatus {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := m.status
+	return &status
+}
+
+// synced reports whether the node is fit to build work on.
+func (m *nodeMonitor) synced() bool {
+	status := m.Status()
+	return status != nil && status.Synced
+}
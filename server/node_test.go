@@ -0,0 +1,144 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+)
+
+func TestNodeCheck(t *testing.T) {
+	synced := rpc.BlockchainInfo{Chain: "main", Blocks: 840000, Headers: 840000, BestBlockHash: "00aa", VerificationProgress: 1}
+
+	behind := synced
+	behind.Blocks = 839990
+
+	lagging := synced
+	lagging.Blocks = 839998
+
+	downloading := synced
+	downloading.InitialBlockDownload = true
+
+	tests := []struct {
+		name    string
+		cfg     NodeConfig
+		info    rpc.BlockchainInfo
+		peers   interface{}
+		synced  bool
+		healthy bool
+		problem string
+	}{
+		{name: "healthy", info: synced, peers: 8, synced: true, healthy: true},
+		{name: "no peers", info: synced, peers: 0, synced: true, problem: "0 peers"},
+		{name: "few peers", cfg: NodeConfig{MinPeers: 10}, info: synced, peers: 8, synced: true, problem: "8 peers"},
+		{name: "within max behind", info: lagging, peers: 8, synced: true, healthy: true},
+		{name: "behind", info: behind, peers: 8, problem: "at height 839990 of 840000"},
+		{name: "larger max behind", cfg: NodeConfig{MaxBehind: 10}, info: behind, peers: 8, synced: true, healthy: true},
+		{name: "initial block download", info: downloading, peers: 8, problem: "in initial block download"},
+		{name: "failed call", info: synced, problem: "Method not found"},
+	}
+
+	for _, tt := range tests {
+		node, client := newTestNode(t)
+		node.reply("getblockchaininfo", tt.info)
+		if tt.peers != nil {
+			node.reply("getconnectioncount", tt.peers)
+		}
+
+		m := nodeMonitor{cfg: tt.cfg, node: client}
+		m.check()
+
+		status := m.Status()
+		if status.Synced != tt.synced || status.Healthy != tt.healthy {
+			t.Errorf("%s: synced %v, healthy %v, want %v, %v", tt.name, status.Synced, status.Healthy, tt.synced, tt.healthy)
+		}
+		if m.synced() != tt.synced {
+			t.Errorf("%s: synced() %v", tt.name, m.synced())
+		}
+		if status.CheckedAt.IsZero() || status.Latency <= 0 {
+			t.Errorf("%s: checked at %v in %vms", tt.name, status.CheckedAt, status.Latency)
+		}
+
+		if tt.healthy {
+			continue
+		}
+		if problem := status.problem(); !strings.Contains(problem, tt.problem) {
+			t.Errorf("%s: problem %q, want %q", tt.name, problem, tt.problem)
+		}
+	}
+
+	node, client := newTestNode(t)
+	node.reply("getblockchaininfo", synced)
+	node.reply("getconnectioncount", 8)
+
+	m := nodeMonitor{node: client}
+	m.check()
+
+	status := m.Status()
+	if status.Chain != "main" || status.Height != 840000 || status.BestBlock != "00aa" || status.Peers != 8 || status.VerificationProgress != 1 {
+		t.Errorf("status %+v", status)
+	}
+
+	// The status returned is a copy.
+	status.Healthy = false
+	if !m.Status().Healthy {
+		t.Error("status changed through its copy")
+	}
+}
+
+func TestNodeMonitorNil(t *testing.T) {
+	if m := newNodeMonitor(NodeConfig{}, nil); m != nil {
+		t.Errorf("monitor %v without a node", m)
+	}
+
+	var m *nodeMonitor
+	if m.Status() != nil || m.synced() {
+		t.Error("status without a node")
+	}
+
+	client, err := NodeConfig{}.client()
+	if client != nil || err != nil {
+		t.Errorf("client %v, %v without urls", client, err)
+	}
+}
+
+func TestNodeHealth(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	if health := s.Health(); !health.Healthy || health.Node != nil {
+		t.Errorf("health %+v without a node", health)
+	}
+
+	node, client := newTestNode(t)
+	node.reply("getblockchaininfo", rpc.BlockchainInfo{Blocks: 100, Headers: 200})
+	node.reply("getconnectioncount", 8)
+
+	s.node = &nodeMonitor{node: client}
+	s.node.check()
+
+	health := s.Health()
+	if health.Healthy || health.Node == nil || health.Node.Height != 100 {
+		t.Errorf("health %+v with a node behind", health)
+	}
+}
+
+func TestSoloHoldsBackWork(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	node, client := newTestNode(t)
+	node.reply("getblockchaininfo", rpc.BlockchainInfo{InitialBlockDownload: true})
+	node.reply("getconnectioncount", 8)
+
+	s.node = &nodeMonitor{node: client}
+	s.node.check()
+
+	solo := soloSource{ps: s}
+	solo.update(&rpc.BlockTemplate{Height: 1, PreviousBlockHash: "00aa"})
+
+	if solo.nextJob != 0 || solo.prevHash != "" {
+		t.Errorf("made job %v on %q from an unsynced node", solo.nextJob, solo.prevHash)
+	}
+	if len(node.called()) != 2 {
+		t.Errorf("called %v", node.called())
+	}
+}
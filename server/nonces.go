@@ -0,0 +1,87 @@
+package server
+
+import (
+	"sync"
+)
+
+type (
+	// nonceSlots hands out the bytes a SHA256d pool leaves the proxy in its
+	// nonce1, so that no two live clients, channels or saved sessions share
+	// an extranonce.
+	nonceSlots struct {
+		mu   sync.Mutex
+		used []uint64
+		size int
+		free int
+		next int
+	}
+
+	// nonceLease is a slot held until released, once.
+	nonceLease struct {
+		slots *nonceSlots
+		slot  uint16
+		once  sync.Once
+	}
+)
+
+// All values of the client nonce bytes.
+const nonceSlotCount = 1 << (8 * bitcoinClientNonceSize)
+
+func newNonceSlots(size int) *nonceSlots {
+	return &nonceSlots{
+		used: make([]uint64, (size+63)/64),
+		size: size,
+		free: size,
+	}
+}
+
+// acquire takes a free slot, false when all are held. Slots are handed out
+// round robin so a released one isn't reused while its shares may still be
+// in flight.
+func (n *nonceSlots) acquire() (*nonceLease, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.free == 0 {
+		return nil, false
+	}
+
+	for i := 0; i < n.size; i++ {
+		slot := (n.next + i) % n.size
+		if n.used[slot/64]&(1<<uint(slot%64)) != 0 {
+			continue
+		}
+
+		n.used[slot/64] |= 1 << uint(slot%64)
+		n.free--
+		n.next = (slot + 1) % n.size
+
+		return &nonceLease{slots: n, slot: uint16(slot)}, true
+	}
+
+	return nil, false
+}
+
+// available returns the number of free slots.
+func (n *nonceSlots) available() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.free
+}
+
+// release gives the slot back, nil leases are ignored.
+func (l *nonceLease) release() {
+	if l == nil {
+		return
+	}
+
+	l.once.Do(func() {
+		n := l.slots
+
+		n.mu.Lock()
+		n.used[l.slot/64] &^= 1 << uint(l.slot%64)
+		n.free++
+		n.mu.Unlock()
+	})
+}
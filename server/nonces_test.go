@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func TestNonceSlots(t *testing.T) {
+	slots := newNonceSlots(130)
+
+	leases := make(map[uint16]*nonceLease)
+	for i := 0; i < 130; i++ {
+		lease, ok := slots.acquire()
+		if !ok {
+			t.Fatalf("acquire %d: slots full", i)
+		}
+		if _, ok := leases[lease.slot]; ok {
+			t.Fatalf("acquire %d: slot %d handed out twice", i, lease.slot)
+		}
+		leases[lease.slot] = lease
+	}
+
+	if _, ok := slots.acquire(); ok {
+		t.Fatal("acquired a slot with all of them held")
+	}
+
+	leases[64].release()
+	leases[64].release()
+	if n := slots.available(); n != 1 {
+		t.Fatalf("available after a double release = %d, want 1", n)
+	}
+
+	lease, ok := slots.acquire()
+	if !ok || lease.slot != 64 {
+		t.Fatalf("acquire after release = %v, %v, want slot 64", lease, ok)
+	}
+
+	// Released slots aren't reused while others are free.
+	slots = newNonceSlots(4)
+	first, _ := slots.acquire()
+	first.release()
+	for _, want := range []uint16{1, 2, 3, 0} {
+		if lease, _ := slots.acquire(); lease.slot != want {
+			t.Errorf("next slot %d, want %d", lease.slot, want)
+		}
+	}
+
+	var none *nonceLease
+	none.release()
+}
+
+func TestUpstreamClientNonce(t *testing.T) {
+	ps := &ProxyServer{}
+
+	tests := []struct {
+		dialect        stratum.Dialect
+		noncePart1     []byte
+		noncePart2Size int
+		size           int
+	}{
+		{dialect: stratum.Bitcoin, noncePart1: []byte{0x08, 0x00, 0x00, 0x02}, noncePart2Size: 4, size: 2},
+		{dialect: stratum.Bitcoin, noncePart1: []byte{0xf0}, noncePart2Size: 8, size: 6},
+		{dialect: stratum.Equihash, noncePart1: []byte{0xa0, 0xb1, 0xc2, 0xd3}, size: 16},
+	}
+
+	for _, tt := range tests {
+		u := &Upstream{Config: UpstreamConfig{Dialect: tt.dialect}, ps: ps, slots: newNonceSlots(nonceSlotCount)}
+		if _, _, _, err := u.clientNonce(1); err != ErrUpstreamDown {
+			t.Errorf("%s: clientNonce before subscribing: %v, want ErrUpstreamDown", tt.dialect, err)
+		}
+
+		if err := u.setNoncePart1(tt.noncePart1, tt.noncePart2Size); err != nil {
+			t.Fatalf("%s: %v", tt.dialect, err)
+		}
+
+		seen := make(map[string]bool)
+		for id := ClientID(1); id <= 3; id++ {
+			noncePart1, noncePart2Size, lease, err := u.clientNonce(id)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.dialect, err)
+			}
+
+			if !bytes.HasPrefix(noncePart1, tt.noncePart1) {
+				t.Errorf("%s: nonce1 %x doesn't start with the pool's %x", tt.dialect, noncePart1, tt.noncePart1)
+			}
+			if noncePart2Size != tt.size {
+				t.Errorf("%s: nonce2 size %d, want %d", tt.dialect, noncePart2Size, tt.size)
+			}
+			if seen[string(noncePart1)] {
+				t.Errorf("%s: nonce1 %x handed out twice", tt.dialect, noncePart1)
+			}
+			seen[string(noncePart1)] = true
+
+			if (lease != nil) != tt.dialect.IsBitcoin() {
+				t.Errorf("%s: lease %v", tt.dialect, lease)
+			}
+		}
+	}
+}
+
+// Client IDs keep growing, V2 channels take them too, but nonces must stay
+// unique among the live clients once the IDs pass the 2 bytes of a slot.
+func TestUpstreamNonceSpace(t *testing.T) {
+	u := &Upstream{Config: UpstreamConfig{Dialect: stratum.Bitcoin}, ps: &ProxyServer{}, slots: newNonceSlots(nonceSlotCount)}
+	if err := u.setNoncePart1([]byte{0x01}, 6); err != nil {
+		t.Fatal(err)
+	}
+	u.slots = newNonceSlots(4)
+
+	live := make(map[string]*nonceLease)
+	for id := ClientID(0x10000); id < 0x10004; id++ {
+		noncePart1, _, lease, err := u.clientNonce(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if live[string(noncePart1)] != nil {
+			t.Fatalf("client %d: nonce1 %x already in use", id, noncePart1)
+		}
+		live[string(noncePart1)] = lease
+	}
+
+	if _, _, _, err := u.clientNonce(0x10004); err != ErrNonceSpace {
+		t.Fatalf("clientNonce with all slots held: %v, want ErrNonceSpace", err)
+	}
+
+	for noncePart1, lease := range live {
+		lease.release()
+		delete(live, noncePart1)
+		break
+	}
+
+	noncePart1, _, _, err := u.clientNonce(0x10005)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live[string(noncePart1)] != nil {
+		t.Fatalf("nonce1 %x handed out twice", noncePart1)
+	}
+
+	// A new pool nonce1 starts over, old leases don't free new slots.
+	old := live
+	if err := u.setNoncePart1([]byte{0x02}, 6); err != nil {
+		t.Fatal(err)
+	}
+	for _, lease := range old {
+		lease.release()
+	}
+	if n := u.slots.available(); n != nonceSlotCount {
+		t.Errorf("available after the nonce1 changed = %d, want %d", n, nonceSlotCount)
+	}
+}
+
+func TestSessionNonceLease(t *testing.T) {
+	slots := newNonceSlots(8)
+	store := &sessionStore{
+		timeout:  time.Minute,
+		maxPerIP: 8,
+		m:        make(map[string]*session),
+		parked:   make(map[string]int),
+		epochs:   make(map[stratum.Dialect]uint64),
+	}
+
+	connect := func() *ProxyClient {
+		lease, ok := slots.acquire()
+		if !ok {
+			t.Fatal("slots full")
+		}
+
+		c := &ProxyClient{dialect: stratum.Bitcoin, noncePart1: []byte{byte(lease.slot)}, nonceLease: lease, authorized: true}
+		if err := store.create(c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// A saved session keeps its slot until it's resumed and then expires.
+	c := connect()
+	store.save(c)
+	if n := slots.available(); n != 7 {
+		t.Fatalf("available with a saved session = %d, want 7", n)
+	}
+
+	resumed := &ProxyClient{dialect: stratum.Bitcoin}
+	if !store.resume(c.session, resumed) || resumed.nonceLease != c.nonceLease {
+		t.Fatal("resumed session lost its nonce")
+	}
+	store.save(resumed)
+
+	store.m[c.session].expires = time.Now().Add(-time.Second)
+	if store.resume(c.session, &ProxyClient{dialect: stratum.Bitcoin}) {
+		t.Fatal("resumed an expired session")
+	}
+	if n := slots.available(); n != 8 {
+		t.Fatalf("available after expiry = %d, want 8", n)
+	}
+
+	// Sessions dropped for a new pool nonce1 give their slots back, as do
+	// connected clients when they leave.
+	saved, connected := connect(), connect()
+	store.save(saved)
+	store.drop(stratum.Bitcoin)
+	store.save(connected)
+	if n := slots.available(); n != 8 {
+		t.Fatalf("available after a drop = %d, want 8", n)
+	}
+	if len(store.m) != 0 {
+		t.Errorf("%d sessions left after a drop", len(store.m))
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+)
+
+// NotifyConfig for learning of new blocks from a node. Each source is
+// optional, the fastest one to see a block wins.
+type NotifyConfig struct {
+	// JSON-RPC URL of the node polled for its best block, the blocks
+	// node when empty, and the seconds between polls. There is no
+	// polling without a node.
+	Node string `json:"node"`
+	Poll int    `json:"poll"`
+
+	// The node's -zmqpubhashblock address, e.g. tcp://127.0.0.1:28332.
+	ZMQ string `json:"zmq"`
+
+	// Address the node's -blocknotify script requests /blocknotify/%s
+	// on, a host:port or unix:/path for a socket.
+	Listen string `json:"listen"`
+
+	// Bearer token the requests must carry, required on a host:port as
+	// anyone who can reach it could otherwise make the proxy drop work.
+	Secret string `json:"secret"`
+}
+
+const (
+	DefaultNotifyPoll = 5 * time.Second
+
+	zmqTopicHashBlock = "hashblock"
+	zmqRetryDelay     = 5 * time.Second
+)
+
+var ErrNotifySecret = errors.New("block notifications on TCP need a secret")
+
+// notifyBlocks starts the configured block notification sources, node may
+// be nil.
+func (s *ProxyServer) notifyBlocks(cfg NotifyConfig, node *rpc.Client) error {
+	if node != nil {
+		poll := DefaultNotifyPoll
+		if cfg.Poll > 0 {
+			poll = time.Duration(cfg.Poll) * time.Second
+		}
+
+		go s.pollBlocks(node, poll)
+	}
+
+	if cfg.ZMQ != "" {
+		go s.subscribeBlocks(cfg.ZMQ)
+	}
+
+	if cfg.Listen != "" {
+		if !strings.HasPrefix(cfg.Listen, "unix:") && cfg.Secret == "" {
+			return ErrNotifySecret
+		}
+
+		listener, err := listenNotify(cfg.Listen)
+		if err != nil {
+			return err
+		}
+
+		handler := s.authNotify(cfg.Secret, s.handleBlockNotify)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/blocknotify", handler)
+		mux.HandleFunc("/blocknotify/", handler)
+
+		go func() {
+			log.Println("[server] block notifications on", cfg.Listen)
+			log.Println("[server]", http.Serve(listener, mux))
+		}()
+	}
+
+	return nil
+}
+
+// listenNotify listens on a host:port or a unix:/path socket, replacing the
+// socket file a previous run left behind.
+func listenNotify(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, "unix:") {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, "unix:")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return net.Listen("unix", path)
+}
+
+// pollBlocks asks the node for its best block, the fallback when
+// notifications are lost.
+func (s *ProxyServer) pollBlocks(node *rpc.Client, interval time.Duration) {
+	for range time.Tick(interval) {
+		hash, err := node.GetBestBlockHash(context.Background())
+		if err != nil {
+			log.Println("[server] could not poll best block:", err)
+			continue
+		}
+
+		s.BlockNotify(hash)
+	}
+}
+
+// subscribeBlocks follows the node's hashblock notifications, reconnecting
+// when the connection drops.
+func (s *ProxyServer) subscribeBlocks(address string) {
+	for {
+		err := s.receiveBlocks(address)
+		log.Printf("[server] zmq %v: %v\n", address, err)
+		time.Sleep(zmqRetryDelay)
+	}
+}
+
+func (s *ProxyServer) receiveBlocks(address string) error {
+	sub, err := rpc.DialZMQ(address, zmqTopicHashBlock)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	log.Println("[server] subscribed to blocks on", address)
+
+	for {
+		parts, err := sub.Receive()
+		if err != nil {
+			return err
+		}
+
+		// Topic, hash in display order and sequence number.
+		if len(parts) < 2 || string(parts[0]) != zmqTopicHashBlock || len(parts[1]) != 32 {
+			continue
+		}
+
+		s.BlockNotify(hex.EncodeToString(parts[1]))
+	}
+}
+
+// authNotify requires the secret as a bearer token, when set.
+func (s *ProxyServer) authNotify(secret string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := []byte("Bearer " + secret)
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// GET or POST /blocknotify/<hash>, as run by the node's -blocknotify.
+func (s *ProxyServer) handleBlockNotify(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/blocknotify"), "/")
+	if hash == "" {
+		hash = r.URL.Query().Get("hash")
+	}
+
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+		http.Error(w, "invalid block hash", http.StatusBadRequest)
+		return
+	}
+
+	s.BlockNotify(strings.ToLower(hash))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BlockNotify handles a new best block from any source, returning false when
+// it was already known.
+func (s *ProxyServer) BlockNotify(hash string) bool {
+	s.tip.Lock()
+	if s.tip.hash == hash {
+		s.tip.Unlock()
+		return false
+	}
+	s.tip.hash = hash
+	s.tip.Unlock()
+
+	log.Println("[server] new block", hash)
+	s.count("block_notifications")
+
+	// Upstreams still on the old block are behind from now on, our own
+	// blocks may have gained a confirmation and solo work is stale.
+	s.auditor.newTip(s.allUpstreams())
+	s.blocks.poke()
+	s.solo.poke()
+
+	return true
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testBlockHash = "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048"
+
+func TestHandleBlockNotify(t *testing.T) {
+	s := newTestServer(t, Config{})
+	handler := s.authNotify("s3cret", s.handleBlockNotify)
+
+	tests := []struct {
+		name   string
+		url    string
+		auth   string
+		status int
+		tip    string
+	}{
+		{name: "no secret", url: "/blocknotify/" + testBlockHash, status: http.StatusUnauthorized},
+		{name: "wrong secret", url: "/blocknotify/" + testBlockHash, auth: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "hash in the path", url: "/blocknotify/" + testBlockHash, auth: "Bearer s3cret", status: http.StatusNoContent, tip: testBlockHash},
+		{name: "hash in the query", url: "/blocknotify?hash=" + strings.Replace(testBlockHash, "839", "840", 1), auth: "Bearer s3cret", status: http.StatusNoContent, tip: strings.Replace(testBlockHash, "839", "840", 1)},
+		{name: "upper case", url: "/blocknotify/" + strings.ToUpper(testBlockHash), auth: "Bearer s3cret", status: http.StatusNoContent, tip: testBlockHash},
+		{name: "short hash", url: "/blocknotify/00000000839a8e68", auth: "Bearer s3cret", status: http.StatusBadRequest},
+		{name: "not hex", url: "/blocknotify/" + strings.Repeat("zz", 32), auth: "Bearer s3cret", status: http.StatusBadRequest},
+		{name: "no hash", url: "/blocknotify", auth: "Bearer s3cret", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		before := s.tip.hash
+
+		req := httptest.NewRequest(http.MethodPost, tt.url, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		want := tt.tip
+		if want == "" {
+			want = before
+		}
+		if s.tip.hash != want {
+			t.Errorf("%s: tip %q, want %q", tt.name, s.tip.hash, want)
+		}
+	}
+
+	// Without a secret, as on a unix socket, anything goes through.
+	w := httptest.NewRecorder()
+	s.authNotify("", s.handleBlockNotify)(w, httptest.NewRequest(http.MethodGet, "/blocknotify/"+strings.Repeat("11", 32), nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("without a secret: status %d", w.Code)
+	}
+}
+
+func TestBlockNotify(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	if !s.BlockNotify(testBlockHash) {
+		t.Error("new block not taken")
+	}
+	if s.BlockNotify(testBlockHash) {
+		t.Error("same block taken twice")
+	}
+	if !s.BlockNotify(strings.Repeat("11", 32)) {
+		t.Error("next block not taken")
+	}
+
+	if v := s.counters.Get("block_notifications"); v == nil || v.String() != "2" {
+		t.Errorf("counted %v notifications, want 2", v)
+	}
+}
+
+func TestNotifyListen(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	if err := s.notifyBlocks(NotifyConfig{Listen: "127.0.0.1:0"}, nil); err != ErrNotifySecret {
+		t.Errorf("listened on TCP without a secret: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "blocknotify.sock")
+	if err := s.notifyBlocks(NotifyConfig{Listen: "unix:" + path}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+
+	resp, err := client.Get("http://proxy/blocknotify/" + testBlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status %d", resp.StatusCode)
+	}
+
+	s.tip.Lock()
+	defer s.tip.Unlock()
+	if s.tip.hash != testBlockHash {
+		t.Errorf("tip %q after a notification over the socket", s.tip.hash)
+	}
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"log"
 	"net"
 	"os"
@@ -10,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/BTCChina/mining-pool-proxy/lib"
 	"github.com/BTCChina/mining-pool-proxy/proxy"
 	"github.com/BTCChina/mining-pool-proxy/stratum"
 )
@@ -25,19 +28,74 @@ type (
 			sync.RWMutex
 		}
 
+		// Stratum V2 connections, fed from the sha256d work.
+		v2 struct {
+			m map[ClientID]*V2Client
+			sync.RWMutex
+		}
+
+		work struct {
+			current map[stratum.Dialect]*proxy.Work
+			sync.RWMutex
+		}
+
 		// The server ID part of the nonce.
 		NoncePart1a [8]byte
 
 		Config Config
+
+		// Pools per dialect, work comes from and shares go to the active one
+		// while the others are kept connected for failover.
+		upstreams struct {
+			active map[stratum.Dialect]*Upstream
+			all    map[stratum.Dialect][]*Upstream
+			sync.RWMutex
+		}
+
+		// The node's best block as last notified.
+		tip struct {
+			hash string
+			sync.Mutex
+		}
+
+		db       *lib.DB
+		bans     *BanManager
+		conns    *connLimiter
+		workers  *workerStats
+		blocks   *blockTracker
+		rounds   *roundTracker
+		auditor  *auditor
+		node     *nodeMonitor
+		solo     *soloSource
+		sessions *sessionStore
+		counters *expvar.Map
 	}
 
 	ProxyClient struct {
 		ID   ClientID
 		name string
-
-		ps   *ProxyServer
-		conn net.Conn
-		lrw  *proxy.LRW
+		ip   net.IP
+
+		ps     *ProxyServer
+		conn   net.Conn
+		lrw    *proxy.LRW
+		writer *proxy.Writer
+
+		dialect        stratum.Dialect
+		noncePart1     []byte
+		noncePart2Size int
+		nonceLease     *nonceLease
+		difficulty     proxy.Difficulty
+		authorized     bool
+		limiter        *proxy.TokenBucket
+
+		// Subscribe session ID.
+		session string
+
+		// BIP 310 masks the client asked for and was granted, accessed
+		// atomically as the upstream may narrow them.
+		requestedMask uint32
+		versionMask   uint32
 	}
 )
 
@@ -50,6 +108,14 @@ const (
 
 	InactivityTimeout = 3 * time.Minute
 	KeepAliveInterval = 30 * time.Second
+
+	DialTimeout = 10 * time.Second
+)
+
+var (
+	ErrBanned      = errors.New("banned")
+	ErrTooManyConn = errors.New("too many connections")
+	ErrRateLimited = errors.New("message rate exceeded")
 )
 
 func NewProxy(cfg Config) (*ProxyServer, error) {
@@ -64,33 +130,180 @@ func NewProxy(cfg Config) (*ProxyServer, error) {
 		},
 
 		Config: cfg,
+
+		conns:    newConnLimiter(cfg.Limits),
+		workers:  newWorkerStats(),
+		sessions: newSessionStore(time.Duration(cfg.SessionTimeout)*time.Second, cfg.MaxSessionsPerIP),
+		counters: new(expvar.Map).Init(),
+	}
+	server.v2.m = make(map[ClientID]*V2Client)
+	server.work.current = make(map[stratum.Dialect]*proxy.Work)
+	server.upstreams.active = make(map[stratum.Dialect]*Upstream)
+	server.upstreams.all = make(map[stratum.Dialect][]*Upstream)
+
+	if cfg.RedisHost != "" {
+		db, err := lib.NewDB(cfg.RedisHost, cfg.RedisPass)
+		if err != nil {
+			return nil, err
+		}
+
+		server.db = db
+	}
+
+	bans, err := NewBanManager(cfg.Bans, server.db)
+	if err != nil {
+		return nil, err
+	}
+	server.bans = bans
+
+	node, err := cfg.Node.client()
+	if err != nil {
+		return nil, err
+	}
+
+	blocksNode, err := cfg.Node.override(cfg.Blocks.Node, node)
+	if err != nil {
+		return nil, err
+	}
+
+	if node != nil {
+		server.node = newNodeMonitor(cfg.Node, node)
+	} else {
+		server.node = newNodeMonitor(cfg.Node, blocksNode)
+	}
+
+	blocks, err := newBlockTracker(cfg.Blocks, blocksNode, server.db)
+	if err != nil {
+		return nil, err
+	}
+	server.blocks = blocks
+
+	rounds, err := newRoundTracker(server.db)
+	if err != nil {
+		return nil, err
+	}
+	server.rounds = rounds
+
+	auditNode, err := cfg.Node.override(cfg.Audit.Node, blocksNode)
+	if err != nil {
+		return nil, err
+	}
+	server.auditor = newAuditor(cfg.Audit, auditNode, &server)
+
+	server.publishMetrics()
+
+	for _, uc := range cfg.UpstreamConfigs() {
+		if !uc.Dialect.Valid() {
+			return nil, errors.New("unknown dialect: " + string(uc.Dialect))
+		}
+
+		// The first pool of a dialect is active, the rest are backups.
+		dialect := stratum.Dialect(uc.Dialect.String())
+		u := NewUpstream(uc, &server)
+		if _, ok := server.upstreams.active[dialect]; !ok {
+			server.upstreams.active[dialect] = u
+		}
+		server.upstreams.all[dialect] = append(server.upstreams.all[dialect], u)
+	}
+
+	for _, upstreams := range server.upstreams.all {
+		for _, u := range upstreams {
+			go u.Run()
+		}
+	}
+
+	soloNode, err := cfg.Node.override(cfg.Solo.Node, blocksNode)
+	if err != nil {
+		return nil, err
+	}
+
+	solo, err := newSoloSource(cfg.Solo, soloNode, &server)
+	if err != nil {
+		return nil, err
+	}
+
+	if solo != nil {
+		if len(server.upstreams.all[solo.dialect]) > 0 {
+			return nil, errors.New("solo mining and an upstream of its dialect are exclusive")
+		}
+
+		server.solo = solo
+		go solo.run()
+	}
+
+	notifyNode, err := cfg.Node.override(cfg.Notify.Node, blocksNode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := server.notifyBlocks(cfg.Notify, notifyNode); err != nil {
+		return nil, err
 	}
 
 	return &server, nil
 }
 
-// Handle a new client connection, executed in a goroutine.
-func (s *ProxyServer) Handle(conn *net.TCPConn) error {
-	log.Println("[server] new connection from", conn.RemoteAddr())
-
-	if err := conn.SetKeepAlive(true); err != nil {
+// Handle a new client connection speaking dialect, executed in a goroutine.
+func (s *ProxyServer) Handle(conn net.Conn, dialect stratum.Dialect) error {
+	ip, err := s.admit(conn)
+	if err != nil {
 		return err
 	}
+	defer s.conns.release(ip.String())
 
-	if err := conn.SetKeepAlivePeriod(KeepAliveInterval); err != nil {
-		return err
+	queueSize := s.Config.Limits.WriteQueue
+	if queueSize <= 0 {
+		queueSize = proxy.DefaultQueueSize
 	}
 
 	client := ProxyClient{
-		ID:   ClientID(atomic.AddUint64(&s.idCount, 1)),
-		ps:   s,
-		conn: conn,
-		lrw:  proxy.NewLRW(conn),
+		ID:     ClientID(atomic.AddUint64(&s.idCount, 1)),
+		ip:     ip,
+		ps:     s,
+		conn:   conn,
+		lrw:    proxy.NewLRW(conn),
+		writer: proxy.NewWriter(conn, queueSize, WriteTimeout),
+
+		dialect:    stratum.Dialect(dialect.String()),
+		difficulty: proxy.Difficulty(s.Config.Difficulty),
+	}
+	client.lrw.Dialect = client.dialect
+
+	if s.Config.Limits.MaxMessageSize > 0 {
+		client.lrw.MaxMessageSize = s.Config.Limits.MaxMessageSize
+	}
+
+	if s.Config.Limits.MessageTimeout > 0 {
+		client.lrw.MessageTimeout = time.Duration(s.Config.Limits.MessageTimeout) * time.Second
+	}
+
+	if s.Config.Limits.MessageRate > 0 {
+		client.limiter = proxy.NewTokenBucket(s.Config.Limits.MessageRate, s.Config.Limits.MessageBurst)
 	}
 
 	return client.Serve()
 }
 
+// admit checks a new connection against the bans and connection limits,
+// closing it when refused. The caller releases the IP's connection slot.
+func (s *ProxyServer) admit(conn net.Conn) (net.IP, error) {
+	log.Println("[server] new connection from", conn.RemoteAddr())
+
+	ip := proxy.AddrIP(conn.RemoteAddr())
+	if s.bans.IsBanned(ip) {
+		_ = conn.Close()
+		return nil, ErrBanned
+	}
+
+	if !s.conns.acquire(ip.String()) {
+		log.Println("[server] connection limit reached, rejecting", conn.RemoteAddr())
+		_ = conn.Close()
+		return nil, ErrTooManyConn
+	}
+
+	return ip, nil
+}
+
 // Subscribe a client onto work notifications.
 func (s *ProxyServer) Subscribe(c *ProxyClient) {
 	s.clients.Lock()
@@ -106,12 +319,283 @@ func (s *ProxyServer) Unsubscribe(c *ProxyClient) {
 	s.clients.Unlock()
 }
 
-func (s *ProxyServer) BlockNotify() error {
-	log.Println("[server]", "new block detected")
-	// TODO: s.getwork
+// Strike records an offence from the IP, disconnecting everyone from it on a ban.
+func (s *ProxyServer) Strike(ip net.IP, reason BanReason) {
+	if !s.bans.Strike(ip, reason) {
+		return
+	}
+
+	s.Kick(ip)
+}
+
+// Kick disconnects all clients from the IP.
+func (s *ProxyServer) Kick(ip net.IP) {
+	s.clients.RLock()
+	for _, c := range s.clients.m {
+		if c.ip.Equal(ip) {
+			_ = c.Close()
+		}
+	}
+	s.clients.RUnlock()
+
+	s.v2.RLock()
+	defer s.v2.RUnlock()
+
+	for _, c := range s.v2.m {
+		if c.ip.Equal(ip) {
+			_ = c.Close()
+		}
+	}
+}
+
+// CurrentWork returns the latest job in the dialect, nil if there is none yet.
+func (s *ProxyServer) CurrentWork(dialect stratum.Dialect) *proxy.Work {
+	s.work.RLock()
+	defer s.work.RUnlock()
+
+	return s.work.current[dialect]
+}
+
+// upstream returns the active upstream of a dialect.
+func (s *ProxyServer) upstream(dialect stratum.Dialect) (*Upstream, bool) {
+	s.upstreams.RLock()
+	defer s.upstreams.RUnlock()
+
+	u, ok := s.upstreams.active[dialect]
+	return u, ok
+}
+
+// allUpstreams returns every upstream, active or not.
+func (s *ProxyServer) allUpstreams() []*Upstream {
+	s.upstreams.RLock()
+	defer s.upstreams.RUnlock()
+
+	var result []*Upstream
+	for _, upstreams := range s.upstreams.all {
+		result = append(result, upstreams...)
+	}
+
+	return result
+}
+
+// isActive reports whether u is the upstream its dialect's clients mine on.
+func (s *ProxyServer) isActive(u *Upstream) bool {
+	active, ok := s.upstream(u.dialect())
+	return ok && active == u
+}
+
+// failover moves the dialect's clients from an upstream to the next one with
+// a session and nothing suspicious, returning whether it did.
+func (s *ProxyServer) failover(from *Upstream) bool {
+	dialect := from.dialect()
+
+	s.upstreams.Lock()
+	if s.upstreams.active[dialect] != from {
+		s.upstreams.Unlock()
+		return false
+	}
+
+	var next *Upstream
+	upstreams := s.upstreams.all[dialect]
+	for i := range upstreams {
+		u := upstreams[(indexOf(upstreams, from)+1+i)%len(upstreams)]
+		if u != from && u.Subscribed() && !s.auditor.suspicious(u) {
+			next = u
+			break
+		}
+	}
+
+	if next == nil {
+		s.upstreams.Unlock()
+		log.Printf("[server] no upstream to fail over to from %v\n", from.Config.Addr())
+		return false
+	}
+
+	s.upstreams.active[dialect] = next
+	s.upstreams.Unlock()
+
+	log.Printf("[server] failing over from %v to %v\n", from.Config.Addr(), next.Config.Addr())
+	s.count("failovers")
+
+	// Clients reconnect for a nonce from the new pool.
+	s.ResetDialect(dialect)
+	if work := next.jobs.Current(); work != nil {
+		s.SetWork(work)
+	}
+
+	return true
+}
+
+func indexOf(upstreams []*Upstream, u *Upstream) int {
+	for i := range upstreams {
+		if upstreams[i] == u {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// jobs returns the jobs of a dialect's work source, its active upstream or
+// the node when solo mining, nil if there is none.
+func (s *ProxyServer) jobs(dialect stratum.Dialect) *proxy.JobRegistry {
+	if u, ok := s.upstream(dialect); ok {
+		return u.jobs
+	}
+
+	if s.solo != nil && s.solo.dialect == dialect {
+		return s.solo.jobs
+	}
+
 	return nil
 }
 
+// Job looks up a job of a dialect's work source.
+func (s *ProxyServer) Job(dialect stratum.Dialect, id string) (*proxy.Work, proxy.JobStatus) {
+	jobs := s.jobs(dialect)
+	if jobs == nil {
+		return nil, proxy.JobUnknown
+	}
+
+	return jobs.Lookup(id)
+}
+
+// seenShare returns whether a share was already submitted on its job, see
+// proxy.JobRegistry.Seen.
+func (s *ProxyServer) seenShare(dialect stratum.Dialect, share proxy.Share) bool {
+	jobs := s.jobs(dialect)
+	if jobs == nil {
+		return false
+	}
+
+	return jobs.Seen(share)
+}
+
+// SetWork makes w the current job of its dialect and notifies the clients speaking it.
+func (s *ProxyServer) SetWork(w *proxy.Work) {
+	dialect := stratum.Dialect(w.Dialect.String())
+
+	s.work.Lock()
+	s.work.current[dialect] = w
+	s.work.Unlock()
+
+	notify := w.Notify()
+
+	s.clients.RLock()
+	for _, c := range s.clients.m {
+		if c.dialect != dialect {
+			continue
+		}
+
+		if err := c.writer.Notify(notify); err != nil {
+			log.Printf("[client %v %v] could not notify: %v\n", c.ID, c.conn.RemoteAddr(), err)
+		}
+	}
+	s.clients.RUnlock()
+
+	if !dialect.IsBitcoin() {
+		return
+	}
+
+	s.v2.RLock()
+	defer s.v2.RUnlock()
+
+	for _, c := range s.v2.m {
+		c.setWork(w)
+	}
+}
+
+// SetVersionMask narrows the version bits sha256d clients may roll to mask,
+// e.g. when the pool granted a different mask.
+func (s *ProxyServer) SetVersionMask(mask uint32) {
+	s.clients.RLock()
+	for _, c := range s.clients.m {
+		requested := atomic.LoadUint32(&c.requestedMask)
+		if !c.dialect.IsBitcoin() || requested == 0 {
+			continue
+		}
+
+		granted := requested & mask
+		atomic.StoreUint32(&c.versionMask, granted)
+		if err := c.writer.Notify(stratum.ResponseSetVersionMask{Mask: granted}); err != nil {
+			log.Printf("[client %v %v] could not notify: %v\n", c.ID, c.conn.RemoteAddr(), err)
+		}
+	}
+	s.clients.RUnlock()
+
+	s.v2.RLock()
+	defer s.v2.RUnlock()
+
+	for _, c := range s.v2.m {
+		if c.rolling {
+			atomic.StoreUint32(&c.versionMask, mask)
+		}
+	}
+}
+
+// versionMask returns the version bits sha256d clients may roll, those the
+// pool granted when there is one.
+func (s *ProxyServer) versionMask() uint32 {
+	if u, ok := s.upstream(stratum.Bitcoin); ok {
+		return u.VersionMask()
+	}
+
+	return stratum.DefaultVersionMask
+}
+
+// ResetDialect drops the work of a dialect and disconnects its clients,
+// e.g. when the pool's nonce changed and their jobs aren't valid anymore.
+func (s *ProxyServer) ResetDialect(dialect stratum.Dialect) {
+	s.work.Lock()
+	delete(s.work.current, dialect)
+	s.work.Unlock()
+
+	s.sessions.drop(dialect)
+
+	s.clients.RLock()
+	for _, c := range s.clients.m {
+		if c.dialect == dialect {
+			_ = c.Close()
+		}
+	}
+	s.clients.RUnlock()
+
+	if !dialect.IsBitcoin() {
+		return
+	}
+
+	s.v2.RLock()
+	defer s.v2.RUnlock()
+
+	for _, c := range s.v2.m {
+		_ = c.Close()
+	}
+}
+
+// clientNonce returns the nonce1 and nonce2 size for a new client. The pool's
+// nonce1 comes first when there is an upstream, the client's ID or nonce
+// slot follows. A lease is returned for the slot, see Upstream.clientNonce.
+func (s *ProxyServer) clientNonce(dialect stratum.Dialect, id ClientID) ([]byte, int, *nonceLease, error) {
+	if u, ok := s.upstream(dialect); ok {
+		noncePart1, noncePart2Size, lease, err := u.clientNonce(id)
+		if err != ErrUpstreamDown {
+			return noncePart1, noncePart2Size, lease, err
+		}
+	}
+
+	if dialect.IsBitcoin() {
+		noncePart1 := make([]byte, 4)
+		binary.BigEndian.PutUint32(noncePart1, uint32(id))
+		return noncePart1, 4, nil, nil
+	}
+
+	// Write in the server ID, then the client ID
+	noncePart1 := make([]byte, 16)
+	copy(noncePart1, s.NoncePart1a[:])
+	binary.LittleEndian.PutUint64(noncePart1[8:], uint64(id))
+	return noncePart1, 16, nil, nil
+}
+
 // Serve runs the client.
 func (c *ProxyClient) Serve() (err error) {
 	log.Printf("[client %v %v] -> serving\n", c.ID, c.conn.RemoteAddr())
@@ -124,9 +608,13 @@ func (c *ProxyClient) Serve() (err error) {
 		log.Printf("[client %v %v] <- disconnected\n", c.ID, c.conn.RemoteAddr())
 	}()
 
-	defer c.conn.Close()
+	defer func() {
+		if c.writer.Err() == proxy.ErrQueueFull {
+			c.ps.count(CounterQueueOverflows)
+		}
 
-	var noncePart1 stratum.Uint128
+		_ = c.writer.Close()
+	}()
 
 	// Handle subscription
 	subscribe, err := c.lrw.WaitForType(stratum.Subscribe, time.Now().Add(InitTimeout))
@@ -135,63 +623,161 @@ func (c *ProxyClient) Serve() (err error) {
 	}
 
 	sub := subscribe.(stratum.RequestSubscribe)
-	if len(sub.Params) == 2 {
-		c.ps.Subscribe(c)
-		defer c.ps.Unsubscribe(c)
+	resumed := c.ps.sessions.resume(sub.SessionID(c.dialect), c)
+	if resumed {
+		log.Printf("[client %v %v] resumed session %v\n", c.ID, c.conn.RemoteAddr(), c.session)
+	} else {
+		c.noncePart1, c.noncePart2Size, c.nonceLease, err = c.ps.clientNonce(c.dialect, c.ID)
+		if err != nil {
+			return err
+		}
 
-		return c.ps.BlockNotify()
+		if err := c.ps.sessions.create(c); err != nil {
+			c.nonceLease.release()
+			return err
+		}
 	}
-
-	// Write in the server ID
-	copy(noncePart1[:], c.ps.NoncePart1a[:])
-
-	// Write in the client ID
-	binary.LittleEndian.PutUint64(noncePart1[8:], uint64(c.ID))
-
-	if err := c.lrw.WriteStratumTimed(stratum.ResponseSubscribeReply{
-		ID:         sub.ID,
-		Session:    "",
-		NoncePart1: noncePart1,
-	}, time.Now().Add(WriteTimeout)); err != nil {
+	// The session keeps the nonce, and its slot, once the client is gone.
+	defer c.ps.sessions.save(c)
+
+	if err := c.writer.Reply(stratum.ResponseSubscribeReply{
+		ID:             sub.ID,
+		Session:        c.session,
+		NoncePart1:     c.noncePart1,
+		Dialect:        c.dialect,
+		NoncePart2Size: c.noncePart2Size,
+	}); err != nil {
 		return err
 	}
 
-	// TODO: Handle Authorize
-
-	log.Printf("[client %v %v] '%v'\n", c.ID, c.conn.RemoteAddr(), c.name)
-
 	c.ps.Subscribe(c)
 	defer c.ps.Unsubscribe(c)
 
-	// Channel for reading input
-	ChanRequest := make(chan stratum.Request, 64)
-	go func() error {
-		defer close(ChanRequest)
+	// A resumed miner may not authorize again, bring it back up to date.
+	if resumed && c.authorized {
+		if err := c.sendDifficulty(); err != nil {
+			return err
+		}
 
-		for {
-			req, err := c.lrw.ReadStratumTimed(time.Now().Add(InactivityTimeout))
-			if err != nil {
+		if work := c.ps.CurrentWork(c.dialect); work != nil {
+			if err := c.writer.Notify(work.Notify()); err != nil {
 				return err
 			}
+		}
+	}
 
-			ChanRequest <- req
+	for {
+		req, err := c.lrw.ReadStratumTimed(time.Now().Add(InactivityTimeout))
+		switch err.(type) {
+		case nil:
+		case *proxy.MalformedError:
+			c.ps.Strike(c.ip, BanMalformed)
+			continue
+		default:
+			switch err {
+			case proxy.ErrMessageTooLarge:
+				c.ps.Strike(c.ip, BanMalformed)
+			case proxy.ErrMessageTimeout:
+				c.ps.Strike(c.ip, BanSlow)
+			}
+
+			return err
 		}
-	}()
 
-	return nil
+		if c.limiter != nil && !c.limiter.Take() {
+			c.ps.Strike(c.ip, BanRateLimited)
+			return ErrRateLimited
+		}
+
+		if err := c.handle(req); err != nil {
+			return err
+		}
+	}
 }
 
+// Close the client's writer and connection.
 func (c *ProxyClient) Close() error {
-	return c.conn.Close()
+	return c.writer.Close()
 }
 
 // Config for the pool server.
 type Config struct {
 	Host string `json:"host"`
 
+	// Additional stratum ports, e.g. for TLS.
+	Listeners []ListenerConfig `json:"listeners"`
+
+	UpstreamConfig
+
+	// Additional pools, those after the first of a dialect are backups.
+	Upstreams []UpstreamConfig `json:"upstreams"`
+
 	PProfHost string `json:"pprof_host"`
+	APIHost   string `json:"apiHost"`
+
+	// Bearer token required by the admin API, which only answers loopback
+	// clients without one.
+	APIToken string `json:"apiToken"`
+
+	RedisHost string `json:"redisHost"`
+	RedisPass string `json:"redisPass"`
 
 	Testnet bool `json:"testnet"`
+
+	// Share difficulty sent to miners.
+	Difficulty float64 `json:"difficulty"`
+	// Lowest difficulty miners may suggest.
+	MinDifficulty float64 `json:"minDifficulty"`
+
+	// Require miner usernames to start with a valid address.
+	ValidateAddress bool `json:"validateAddress"`
+	// Password miners must authorize with, any password is accepted when empty.
+	MinerPassword string `json:"minerPassword"`
+
+	// Jobs kept per upstream for late shares.
+	JobHistory int `json:"jobHistory"`
+
+	// Seconds a disconnected miner's session is kept for it to resume, and
+	// how many are kept per IP, DefaultSessionsPerIP when zero.
+	SessionTimeout   int `json:"sessionTimeout"`
+	MaxSessionsPerIP int `json:"maxSessionsPerIP"`
+
+	// Our own nodes, used by the features below without a node of their own.
+	Node NodeConfig `json:"node"`
+
+	Limits LimitsConfig `json:"limits"`
+	NTime  NTimeConfig  `json:"ntime"`
+	Bans   BanConfig    `json:"bans"`
+	Blocks BlocksConfig `json:"blocks"`
+	Audit  AuditConfig  `json:"audit"`
+	Notify NotifyConfig `json:"notify"`
+	Solo   SoloConfig   `json:"solo"`
+}
+
+// ListenerConfigs returns every stratum port to listen on.
+func (c Config) ListenerConfigs() []ListenerConfig {
+	var result []ListenerConfig
+	if c.Host != "" {
+		result = append(result, ListenerConfig{Host: c.Host})
+	}
+
+	for _, lc := range c.Listeners {
+		if !lc.Disabled {
+			result = append(result, lc)
+		}
+	}
+
+	return result
+}
+
+// UpstreamConfigs returns every pool to connect to.
+func (c Config) UpstreamConfigs() []UpstreamConfig {
+	var result []UpstreamConfig
+	if c.UpstreamConfig.Host != "" {
+		result = append(result, c.UpstreamConfig)
+	}
+
+	return append(result, c.Upstreams...)
 }
 
 func LoadConfig() (cfg Config, err error) {
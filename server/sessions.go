@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type (
+	// session is the state a miner gets back when it subscribes again with
+	// the session ID it was given.
+	session struct {
+		ID      string
+		dialect stratum.Dialect
+		epoch   uint64
+
+		noncePart1     []byte
+		noncePart2Size int
+		nonceLease     *nonceLease
+		difficulty     proxy.Difficulty
+		name           string
+		authorized     bool
+		requestedMask  uint32
+		versionMask    uint32
+
+		// Connected client or, once it's gone, when the session expires
+		// and the IP it was parked for.
+		client  *ProxyClient
+		expires time.Time
+		ip      string
+	}
+
+	sessionStore struct {
+		timeout  time.Duration
+		maxPerIP int
+
+		mu sync.Mutex
+		m  map[string]*session
+		// Sessions waiting to be resumed per IP.
+		parked map[string]int
+
+		// Bumped when a dialect's upstream nonce changes.
+		epochs map[stratum.Dialect]uint64
+	}
+)
+
+const (
+	DefaultSessionTimeout = 5 * time.Minute
+	DefaultSessionsPerIP  = 100
+
+	sessionSweepInterval = time.Minute
+	sessionIDSize        = 8
+)
+
+func newSessionStore(timeout time.Duration, maxPerIP int) *sessionStore {
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+
+	if maxPerIP <= 0 {
+		maxPerIP = DefaultSessionsPerIP
+	}
+
+	s := sessionStore{
+		timeout:  timeout,
+		maxPerIP: maxPerIP,
+		m:        make(map[string]*session),
+		parked:   make(map[string]int),
+		epochs:   make(map[stratum.Dialect]uint64),
+	}
+
+	go s.sweep()
+
+	return &s
+}
+
+// create a session for a client that has just been given its nonce.
+func (s *sessionStore) create(c *ProxyClient) error {
+	buf := make([]byte, sessionIDSize)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+
+	id := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	s.m[id] = &session{
+		ID:      id,
+		dialect: c.dialect,
+		epoch:   s.epochs[c.dialect],
+		client:  c,
+	}
+	s.mu.Unlock()
+
+	c.session = id
+	return nil
+}
+
+// resume restores the session id onto c. It returns false when the session
+// is unknown, expired, still connected or from another dialect.
+func (s *sessionStore) resume(id string, c *ProxyClient) bool {
+	if id == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.m[id]
+	if !ok || sess.dialect != c.dialect || sess.client != nil {
+		return false
+	}
+
+	if sess.epoch != s.epochs[sess.dialect] || !sess.expires.After(time.Now()) {
+		s.remove(sess)
+		return false
+	}
+
+	s.unpark(sess)
+	sess.client = c
+	c.session = id
+
+	c.noncePart1 = sess.noncePart1
+	c.noncePart2Size = sess.noncePart2Size
+	c.nonceLease = sess.nonceLease
+	c.difficulty = sess.difficulty
+	c.name = sess.name
+	c.authorized = sess.authorized
+	atomic.StoreUint32(&c.requestedMask, sess.requestedMask)
+	atomic.StoreUint32(&c.versionMask, sess.versionMask)
+
+	return true
+}
+
+// save the state of a disconnecting client into its session and start the
+// session's expiry. Sessions are kept only for authorized miners, and up to
+// maxPerIP of them per IP, as each holds a nonce slot.
+func (s *sessionStore) save(c *ProxyClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.m[c.session]
+	if !ok || sess.client != c {
+		c.nonceLease.release()
+		return
+	}
+
+	ip := c.ip.String()
+	if sess.epoch != s.epochs[sess.dialect] || !c.authorized || s.parked[ip] >= s.maxPerIP {
+		c.nonceLease.release()
+		delete(s.m, c.session)
+		return
+	}
+
+	sess.noncePart1 = c.noncePart1
+	sess.noncePart2Size = c.noncePart2Size
+	sess.nonceLease = c.nonceLease
+	sess.difficulty = c.difficulty
+	sess.name = c.name
+	sess.authorized = c.authorized
+	sess.requestedMask = atomic.LoadUint32(&c.requestedMask)
+	sess.versionMask = atomic.LoadUint32(&c.versionMask)
+
+	sess.client = nil
+	sess.expires = time.Now().Add(s.timeout)
+	sess.ip = ip
+	s.parked[ip]++
+}
+
+// unpark a saved session being resumed or removed. Called with s.mu held.
+func (s *sessionStore) unpark(sess *session) {
+	if s.parked[sess.ip]--; s.parked[sess.ip] <= 0 {
+		delete(s.parked, sess.ip)
+	}
+}
+
+// drop the sessions of a dialect, their nonces are meaningless once the
+// upstream gave us a new one. Connected clients are dropped as they save.
+func (s *sessionStore) drop(dialect stratum.Dialect) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.epochs[dialect]++
+	for _, sess := range s.m {
+		if sess.dialect == dialect && sess.client == nil {
+			s.remove(sess)
+		}
+	}
+}
+
+// remove a saved session, giving back its nonce slot. Called with s.mu held.
+func (s *sessionStore) remove(sess *session) {
+	s.unpark(sess)
+	sess.nonceLease.release()
+	delete(s.m, sess.ID)
+}
+
+// sweep drops expired sessions.
+func (s *sessionStore) sweep() {
+	for range time.Tick(sessionSweepInterval) {
+		now := time.Now()
+
+		s.mu.Lock()
+		for _, sess := range s.m {
+			if sess.client == nil && !sess.expires.After(now) {
+				s.remove(sess)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+// Saved sessions hold nonce slots, so only authorized miners get one, and
+// only so many per IP.
+func TestSessionSave(t *testing.T) {
+	slots := newNonceSlots(8)
+	store := newSessionStore(time.Minute, 2)
+
+	connect := func(ip string, authorized bool) *ProxyClient {
+		lease, ok := slots.acquire()
+		if !ok {
+			t.Fatal("slots full")
+		}
+
+		c := &ProxyClient{ip: net.ParseIP(ip), dialect: stratum.Bitcoin, nonceLease: lease, authorized: authorized}
+		if err := store.create(c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		ip         string
+		authorized bool
+		kept       bool
+	}{
+		{name: "unauthorized", ip: "192.0.2.1"},
+		{name: "first", ip: "192.0.2.1", authorized: true, kept: true},
+		{name: "second", ip: "192.0.2.1", authorized: true, kept: true},
+		{name: "third of the IP", ip: "192.0.2.1", authorized: true},
+		{name: "other IP", ip: "192.0.2.2", authorized: true, kept: true},
+	}
+
+	var kept []*ProxyClient
+	for _, tt := range tests {
+		c := connect(tt.ip, tt.authorized)
+		store.save(c)
+
+		if _, ok := store.m[c.session]; ok != tt.kept {
+			t.Errorf("%s: kept %v, want %v", tt.name, ok, tt.kept)
+		}
+		if tt.kept {
+			kept = append(kept, c)
+		}
+	}
+
+	if n := slots.available(); n != 8-len(kept) {
+		t.Errorf("%d slots available, want %d", n, 8-len(kept))
+	}
+
+	// Resuming a session makes room for another of its IP.
+	resumed := &ProxyClient{dialect: stratum.Bitcoin}
+	if !store.resume(kept[0].session, resumed) {
+		t.Fatal("session not resumed")
+	}
+
+	c := connect("192.0.2.1", true)
+	store.save(c)
+	if _, ok := store.m[c.session]; !ok {
+		t.Error("session not kept after another of its IP was resumed")
+	}
+
+	store.drop(stratum.Bitcoin)
+	if len(store.parked) != 0 {
+		t.Errorf("parked %v after a drop", store.parked)
+	}
+}
@@ -0,0 +1,626 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"math/bits"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type (
+	// SoloConfig for mining on our own node instead of a pool, sha256d or,
+	// paying Zcash addresses, Equihash.
+	SoloConfig struct {
+		// Address found blocks pay what the payouts leave. Solo mining is off
+		// without it or payouts, and its dialect can't have an upstream as
+		// well. A Sapling address must be the only one, paid by the coinbase
+		// of the zcashd it was given to as -mineraddress.
+		Address string         `json:"address"`
+		Payouts []PayoutConfig `json:"payouts"`
+
+		// JSON-RPC URL of the node, the blocks node when empty.
+		Node string `json:"node"`
+
+		// Text added to the coinbase script, and to an OP_RETURN output.
+		Tag     string `json:"tag"`
+		Message string `json:"message"`
+
+		// Seconds between templates for new transactions, the node's
+		// longpoll wakes us for new blocks.
+		Refresh int `json:"refresh"`
+	}
+
+	// PayoutConfig is a share of the block reward, e.g. for a partner or an
+	// operator fee.
+	PayoutConfig struct {
+		Address string  `json:"address"`
+		Percent float64 `json:"percent"`
+	}
+
+	// payout is a PayoutConfig with the address decoded.
+	payout struct {
+		script  []byte
+		percent float64
+	}
+
+	// soloSource turns the node's block templates into work.
+	soloSource struct {
+		cfg     SoloConfig
+		node    *rpc.Client
+		ps      *ProxyServer
+		jobs    *proxy.JobRegistry
+		dialect stratum.Dialect
+
+		// The payouts, then Address with the rest.
+		payouts []payout
+		message []byte
+
+		// Equihash work keeps the node's coinbase, paying a shielded
+		// address.
+		nodeCoinbase bool
+
+		mu       sync.Mutex
+		prevHash string
+		nextJob  uint64
+		// Ends the wait for the current template.
+		cancel context.CancelFunc
+	}
+)
+
+const (
+	DefaultSoloRefresh = 30 * time.Second
+
+	soloName       = "solo"
+	soloRetryDelay = 5 * time.Second
+
+	// Nonce1 and nonce2 of clients without an upstream, see clientNonce.
+	soloExtranonceSize = 8
+)
+
+var (
+	ErrSoloNode     = errors.New("solo mining needs a node")
+	ErrSoloPayouts  = errors.New("solo payouts must add up to 100 percent, or less with an address for the rest")
+	ErrSoloShielded = errors.New("a shielded solo address must be the only payout, without tag or message")
+)
+
+// newSoloSource returns nil when solo mining is off.
+func newSoloSource(cfg SoloConfig, node *rpc.Client, ps *ProxyServer) (*soloSource, error) {
+	if cfg.Address == "" && len(cfg.Payouts) == 0 {
+		return nil, nil
+	}
+
+	if node == nil {
+		return nil, ErrSoloNode
+	}
+
+	s := soloSource{
+		cfg:     cfg,
+		node:    node,
+		ps:      ps,
+		jobs:    proxy.NewJobRegistry(ps.Config.JobHistory),
+		dialect: soloDialect(cfg),
+	}
+
+	var total float64
+	for _, p := range cfg.Payouts {
+		if p.Percent <= 0 || p.Percent > 100 {
+			return nil, ErrSoloPayouts
+		}
+
+		script, err := payoutScript(p.Address, s.dialect, ps.Config.Testnet)
+		if err == proxy.ErrShieldedAddress {
+			s.nodeCoinbase = true
+		} else if err != nil {
+			return nil, err
+		}
+
+		s.payouts = append(s.payouts, payout{script: script, percent: p.Percent})
+		total += p.Percent
+	}
+
+	const epsilon = 1e-9
+	if total > 100+epsilon || cfg.Address == "" && total < 100-epsilon {
+		return nil, ErrSoloPayouts
+	}
+
+	if cfg.Address != "" {
+		script, err := payoutScript(cfg.Address, s.dialect, ps.Config.Testnet)
+		if err == proxy.ErrShieldedAddress {
+			s.nodeCoinbase = true
+		} else if err != nil {
+			return nil, err
+		}
+
+		s.payouts = append(s.payouts, payout{script: script, percent: 100 - total})
+	}
+
+	// Only the node can pay a shielded address, its coinbase can't change.
+	if s.nodeCoinbase && (len(s.payouts) > 1 || cfg.Tag != "" || cfg.Message != "") {
+		return nil, ErrSoloShielded
+	}
+
+	if cfg.Message != "" {
+		message := []byte(cfg.Message)
+		if len(message) > maxOPReturn {
+			return nil, errors.New("solo message longer than " + strconv.Itoa(maxOPReturn) + " bytes")
+		}
+
+		s.message = nullData(message)
+	}
+
+	return &s, nil
+}
+
+// Standard OP_RETURN outputs carry up to 80 bytes.
+const maxOPReturn = 80
+
+// nullData returns the OP_RETURN script carrying data, pushed by
+// OP_PUSHDATA1 past the 75 bytes a single opcode pushes.
+func nullData(data []byte) []byte {
+	if len(data) > 75 {
+		return append([]byte{0x6a, 0x4c, byte(len(data))}, data...)
+	}
+
+	return append([]byte{0x6a, byte(len(data))}, data...)
+}
+
+// soloDialect is Equihash when paying Zcash addresses, sha256d otherwise.
+func soloDialect(cfg SoloConfig) stratum.Dialect {
+	address := cfg.Address
+	if len(cfg.Payouts) > 0 {
+		address = cfg.Payouts[0].Address
+	}
+
+	if valid, _ := proxy.IsValidAddress(address); valid {
+		return stratum.Equihash
+	}
+
+	return stratum.Bitcoin
+}
+
+// payoutScript decodes a payout address of the solo dialect. Shielded
+// addresses have no script, they return proxy.ErrShieldedAddress.
+func payoutScript(address string, dialect stratum.Dialect, testnet bool) ([]byte, error) {
+	if valid, _ := proxy.IsValidAddress(address); valid == dialect.IsBitcoin() {
+		return nil, errors.New("solo payouts mix Bitcoin and Zcash addresses: " + address)
+	}
+
+	var script []byte
+	var err error
+	if dialect.IsBitcoin() {
+		script, err = proxy.AddressScript(address, testnet)
+	} else {
+		script, err = proxy.ZcashAddressScript(address, testnet)
+	}
+
+	if err == proxy.ErrShieldedAddress {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("invalid solo payout address " + address)
+	}
+
+	return script, nil
+}
+
+// outputs splits a block reward of value between the payouts, rounding
+// leftovers go to the last one.
+func (s *soloSource) outputs(value uint64) []proxy.TxOut {
+	outputs := make([]proxy.TxOut, 0, len(s.payouts)+1)
+	remaining := value
+	for i, p := range s.payouts {
+		amount := uint64(float64(value) * p.percent / 100)
+		if amount > remaining || i == len(s.payouts)-1 {
+			amount = remaining
+		}
+		remaining -= amount
+
+		if amount > 0 {
+			outputs = append(outputs, proxy.TxOut{Value: amount, Script: p.script})
+		}
+	}
+
+	if s.message != nil {
+		outputs = append(outputs, proxy.TxOut{Script: s.message})
+	}
+
+	return outputs
+}
+
+func (s *soloSource) refresh() time.Duration {
+	if s.cfg.Refresh > 0 {
+		return time.Duration(s.cfg.Refresh) * time.Second
+	}
+
+	return DefaultSoloRefresh
+}
+
+// run keeps the work up to date, waiting on the node between templates.
+func (s *soloSource) run() {
+	var longPollID string
+	for {
+		template, err := s.template(longPollID)
+		if err != nil {
+			log.Println("[solo] could not get a block template:", err)
+			longPollID = ""
+			time.Sleep(soloRetryDelay)
+			continue
+		}
+
+		// The wait ended for a refresh, ask for a template right away.
+		if template == nil {
+			longPollID = ""
+			continue
+		}
+
+		s.update(template)
+
+		longPollID = template.LongPollID
+		if longPollID == "" {
+			time.Sleep(s.refresh())
+		}
+	}
+}
+
+// template waits for the template after longPollID, or gets one now when
+// it is empty. It returns nil when the refresh interval passed or poke cut
+// the wait short.
+func (s *soloSource) template(longPollID string) (*rpc.BlockTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.refresh())
+	defer cancel()
+
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	template, err := s.node.GetBlockTemplate(ctx, longPollID)
+	if err != nil && (ctx.Err() == context.Canceled || ctx.Err() != nil && longPollID != "") {
+		return nil, nil
+	}
+
+	return template, err
+}
+
+// poke gets a new template without waiting for the node, e.g. on a block
+// notification that beat the longpoll.
+func (s *soloSource) poke() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// update sends the template's work to the clients, cleaning their jobs only
+// when the previous block changed.
+func (s *soloSource) update(template *rpc.BlockTemplate) {
+	if s.ps.node != nil && !s.ps.node.synced() {
+		log.Println("[solo] node isn't synced, holding back work")
+		return
+	}
+
+	s.mu.Lock()
+	newBlock := template.PreviousBlockHash != s.prevHash
+	s.prevHash = template.PreviousBlockHash
+	s.nextJob++
+	job := strconv.FormatUint(s.nextJob, 16)
+	s.mu.Unlock()
+
+	var w *proxy.Work
+	var err error
+	if s.dialect.IsBitcoin() {
+		w, err = soloWork(template, job, s.outputs(uint64(template.CoinbaseValue)), []byte(s.cfg.Tag))
+	} else {
+		w, err = s.zcashWork(template, job)
+	}
+	if err != nil {
+		log.Printf("[solo] could not use template at height %v: %v\n", template.Height, err)
+		return
+	}
+	w.CleanJobs = newBlock
+
+	if newBlock {
+		log.Printf("[solo] new block template at height %v\n", w.Height)
+		s.ps.BlockNotify(template.PreviousBlockHash)
+	}
+
+	s.jobs.Add(w)
+	s.ps.SetWork(w)
+}
+
+// soloWork builds the job for a template, its coinbase paying outputs.
+func soloWork(template *rpc.BlockTemplate, job string, outputs []proxy.TxOut, tag []byte) (*proxy.Work, error) {
+	bits, err := stratum.HexToUint32(template.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Templates have hashes in display order, the reverse of the header's.
+	prevHash, err := stratum.LittleEndian.Strict().Uint256(template.PreviousBlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	txids := make([]stratum.Uint256, len(template.Transactions))
+	transactions := make([][]byte, len(template.Transactions))
+	for i, tx := range template.Transactions {
+		if txids[i], err = stratum.LittleEndian.Strict().Uint256(tx.TxID); err != nil {
+			return nil, err
+		}
+
+		if transactions[i], err = hex.DecodeString(tx.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if template.DefaultWitnessCommitment != "" {
+		commitment, err := hex.DecodeString(template.DefaultWitnessCommitment)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, proxy.TxOut{Script: commitment})
+	}
+
+	coinbase := proxy.Coinbase{
+		Height:         template.Height,
+		Tag:            tag,
+		Outputs:        outputs,
+		ExtranonceSize: soloExtranonceSize,
+	}
+	coinbase1, coinbase2, err := coinbase.Split()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &proxy.Work{
+		ResponseNotify: stratum.ResponseNotify{
+			Job:     job,
+			Version: template.Version,
+			// mining.notify swaps each word, HeaderPrevHash undoes its own
+			// swap.
+			HashPrevBlock: proxy.HeaderPrevHash(prevHash),
+			NTime:         uint32(template.CurTime),
+			NBits:         bits,
+		},
+		Height:  template.Height,
+		At:      time.Now(),
+		Subsidy: float64(template.CoinbaseValue) / 1e8,
+		Dialect: stratum.Bitcoin,
+
+		Coinbase1:       coinbase1,
+		Coinbase2:       coinbase2,
+		MerkleBranch:    proxy.MerkleBranch(txids),
+		Transactions:    transactions,
+		CoinbaseWitness: template.DefaultWitnessCommitment != "",
+	}
+
+	if w.Target, err = proxy.CompactToTarget(w.Bits()); err != nil {
+		return nil, err
+	}
+	w.Difficulty = w.NetworkDifficulty()
+
+	return w, nil
+}
+
+// zcashWork builds the job for a zcashd template. Its coinbase pays the
+// payouts alongside the funding streams or founders' reward consensus
+// requires, unless the node's pays a shielded address.
+func (s *soloSource) zcashWork(template *rpc.BlockTemplate, job string) (*proxy.Work, error) {
+	if template.CoinbaseTxn == nil {
+		return nil, errors.New("template without a coinbase, is zcashd's -mineraddress set?")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.refresh())
+	defer cancel()
+
+	subsidy, err := s.node.GetBlockSubsidy(ctx, template.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	value := zatoshis(subsidy.Miner)
+	for _, tx := range template.Transactions {
+		value += uint64(tx.Fee)
+	}
+
+	if s.nodeCoinbase {
+		return zcashWork(template, job, template.CoinbaseTxn, value)
+	}
+
+	var required []proxy.TxOut
+	for _, stream := range subsidy.FundingStreams {
+		// Lockbox streams stay in the chain's value pool.
+		if stream.Address == "" {
+			continue
+		}
+
+		script, err := proxy.ZcashAddressScript(stream.Address, s.ps.Config.Testnet)
+		if err != nil {
+			return nil, errors.New("can't pay funding stream " + stream.Recipient + " to " + stream.Address)
+		}
+		required = append(required, proxy.TxOut{Value: uint64(stream.ValueZat), Script: script})
+	}
+
+	// The founders' address changes with the height, the node's coinbase
+	// has the right one.
+	if founders := zatoshis(subsidy.Founders); founders > 0 {
+		data, err := hex.DecodeString(template.CoinbaseTxn.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs, err := proxy.ZcashTxOutputs(data)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, out := range outputs {
+			if out.Value == founders {
+				required = append(required, out)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("no founders' reward in the node's coinbase")
+		}
+	}
+
+	coinbase := proxy.ZcashCoinbase{
+		Height:  template.Height,
+		Tag:     []byte(s.cfg.Tag),
+		Outputs: append(s.outputs(value), required...),
+	}.Serialize()
+
+	return zcashWork(template, job, &rpc.TemplateTransaction{
+		Data:       hex.EncodeToString(coinbase),
+		Hash:       stratum.ToHex(proxy.BlockHash(coinbase)),
+		AuthDigest: stratum.ToHex(proxy.ZcashLegacyAuthDigest),
+	}, value)
+}
+
+// zatoshis converts an amount in ZEC.
+func zatoshis(zec float64) uint64 {
+	return uint64(math.Round(zec * 1e8))
+}
+
+// zcashWork builds the job for a zcashd template with coinbase, paying the
+// miners value.
+func zcashWork(template *rpc.BlockTemplate, job string, coinbase *rpc.TemplateTransaction, value uint64) (*proxy.Work, error) {
+	nBits, err := stratum.HexToUint32(template.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	prevHash, err := stratum.LittleEndian.Strict().Uint256(template.PreviousBlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var coinbaseID stratum.Uint256
+	var txids, authDigests []stratum.Uint256
+	var transactions [][]byte
+	for i, tx := range append([]rpc.TemplateTransaction{*coinbase}, template.Transactions...) {
+		txid, err := stratum.LittleEndian.Strict().Uint256(tx.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		// Templates before NU5 have no auth digests, nor need them.
+		authDigest := proxy.ZcashLegacyAuthDigest
+		if tx.AuthDigest != "" {
+			if authDigest, err = stratum.LittleEndian.Strict().Uint256(tx.AuthDigest); err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := hex.DecodeString(tx.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			coinbaseID = txid
+		} else {
+			txids = append(txids, txid)
+		}
+		authDigests = append(authDigests, authDigest)
+		transactions = append(transactions, data)
+	}
+
+	reserved, err := zcashReserved(template, authDigests)
+	if err != nil {
+		return nil, err
+	}
+
+	// Equihash jobs carry the header's bytes, numbers being little-endian.
+	w := &proxy.Work{
+		ResponseNotify: stratum.ResponseNotify{
+			Job:            job,
+			Version:        bits.ReverseBytes32(template.Version),
+			HashPrevBlock:  prevHash,
+			HashMerkleRoot: proxy.MerkleRoot(coinbaseID, proxy.MerkleBranch(txids)),
+			HashReserved:   reserved,
+			NTime:          bits.ReverseBytes32(uint32(template.CurTime)),
+			NBits:          bits.ReverseBytes32(nBits),
+		},
+		Height:  template.Height,
+		N:       proxy.EquihashN,
+		K:       proxy.EquihashK,
+		At:      time.Now(),
+		Subsidy: float64(value) / 1e8,
+		Dialect: stratum.Equihash,
+
+		Transactions: transactions,
+	}
+
+	if w.Target, err = proxy.CompactToTarget(w.Bits()); err != nil {
+		return nil, err
+	}
+	w.Difficulty = w.NetworkDifficulty()
+
+	return w, nil
+}
+
+// zcashReserved is the header field after the merkle root: the block
+// commitments since NU5, the chain history root since Heartwood and the
+// Sapling root before.
+func zcashReserved(template *rpc.BlockTemplate, authDigests []stratum.Uint256) (stratum.Uint256, error) {
+	roots := template.DefaultRoots
+	switch {
+	case roots != nil && roots.AuthDataRoot != "":
+		history, err := stratum.LittleEndian.Strict().Uint256(roots.ChainHistoryRoot)
+		if err != nil {
+			return stratum.Uint256{}, err
+		}
+		return proxy.ZcashBlockCommitments(history, proxy.ZcashAuthDataRoot(authDigests)), nil
+	case roots != nil && roots.ChainHistoryRoot != "":
+		return stratum.LittleEndian.Strict().Uint256(roots.ChainHistoryRoot)
+	default:
+		return stratum.LittleEndian.Strict().Uint256(template.FinalSaplingRootHash)
+	}
+}
+
+// submit sends a block a share of a solo job solved to the node. Jobs of
+// the solo dialect are all solo ones, it has no upstream.
+func (s *soloSource) submit(work *proxy.Work, share proxy.Share) {
+	if s == nil || work.Dialect != s.dialect {
+		return
+	}
+
+	var raw []byte
+	if work.Dialect.IsBitcoin() {
+		raw = work.BitcoinBlock(share)
+	} else {
+		raw = work.ZcashBlock(share)
+	}
+
+	block := hex.EncodeToString(raw)
+	hash := stratum.ToHex(work.ShareHash(share))
+
+	go func() {
+		if err := s.node.SubmitBlock(context.Background(), block); err != nil {
+			log.Printf("[solo] block %v not accepted: %v\n", hash, err)
+			return
+		}
+
+		log.Printf("[solo] submitted block %v at height %v\n", hash, work.Height)
+		s.poke()
+	}()
+}
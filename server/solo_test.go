@@ -0,0 +1,643 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/rpc"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+const (
+	satoshiAddress = "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	satoshiScript  = "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac"
+	segwitAddress  = "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	segwitScript   = "0014751e76e8199196d454941c45d1b3a323f1433bd6"
+
+	genesisHash = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+
+	zcashAddress     = "t1KzZ5n2TPEGYXTZ3WYGL1AYEumEQaRoHaL"
+	zcashScript      = "76a9141740b913475f30fba22429d7e28593a739371faf88ac"
+	fundingAddress   = "t3Vz22vK5z2LcKEdg16Yv4FFneEL1zg9ojd"
+	fundingScript    = "a9147d46a730d31f97b1930d3368a967c309bd4d136a87"
+	saplingAddress   = "zs1qqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0jqgfzyvjz2f389q5j5ctfvp5"
+	zcashTestAddress = "t2UNzUUx8mWBCRYPRezvA363EYXyEpHokyi"
+)
+
+// block1Template is the template block 1 was mined on, with two
+// transactions and a witness commitment it didn't have.
+func block1Template() *rpc.BlockTemplate {
+	return &rpc.BlockTemplate{
+		Version:           1,
+		PreviousBlockHash: genesisHash,
+		Transactions: []rpc.TemplateTransaction{
+			{TxID: strings.Repeat("11", 32), Data: "aabb"},
+			{TxID: strings.Repeat("22", 32), Data: "ccdd"},
+		},
+		CoinbaseValue:            5000000000,
+		CurTime:                  0x4966bc61,
+		Bits:                     "1d00ffff",
+		Height:                   1,
+		DefaultWitnessCommitment: "6a24aa21a9ed" + strings.Repeat("00", 32),
+	}
+}
+
+func TestNewSoloSource(t *testing.T) {
+	_, node := newTestNode(t)
+
+	tests := []struct {
+		name    string
+		cfg     SoloConfig
+		node    *rpc.Client
+		off     bool
+		payouts []float64
+		dialect stratum.Dialect
+		shield  bool
+		err     string
+	}{
+		{name: "off", off: true},
+		{name: "no node", cfg: SoloConfig{Address: satoshiAddress}, err: ErrSoloNode.Error()},
+		{name: "address", cfg: SoloConfig{Address: satoshiAddress}, node: node, payouts: []float64{100}, dialect: stratum.Bitcoin},
+		{name: "zcash", cfg: SoloConfig{Address: zcashAddress}, node: node, payouts: []float64{100}, dialect: stratum.Equihash},
+		{
+			name:    "zcash fee",
+			cfg:     SoloConfig{Address: zcashAddress, Payouts: []PayoutConfig{{Address: fundingAddress, Percent: 2}}, Message: strings.Repeat("x", 80)},
+			node:    node,
+			payouts: []float64{2, 98},
+			dialect: stratum.Equihash,
+		},
+		{name: "sapling", cfg: SoloConfig{Address: saplingAddress}, node: node, payouts: []float64{100}, dialect: stratum.Equihash, shield: true},
+		{
+			name: "sapling with a fee",
+			cfg:  SoloConfig{Address: saplingAddress, Payouts: []PayoutConfig{{Address: zcashAddress, Percent: 2}}},
+			node: node,
+			err:  ErrSoloShielded.Error(),
+		},
+		{name: "sapling with a tag", cfg: SoloConfig{Address: saplingAddress, Tag: "/x/"}, node: node, err: ErrSoloShielded.Error()},
+		{name: "zcash testnet address", cfg: SoloConfig{Address: zcashTestAddress}, node: node, err: "invalid solo payout address"},
+		{
+			name: "mixed",
+			cfg:  SoloConfig{Address: satoshiAddress, Payouts: []PayoutConfig{{Address: zcashAddress, Percent: 2}}},
+			node: node,
+			err:  "solo payouts mix",
+		},
+		{
+			name:    "fee and address",
+			cfg:     SoloConfig{Address: satoshiAddress, Payouts: []PayoutConfig{{Address: segwitAddress, Percent: 2}}},
+			node:    node,
+			payouts: []float64{2, 98},
+			dialect: stratum.Bitcoin,
+		},
+		{
+			name:    "payouts only",
+			cfg:     SoloConfig{Payouts: []PayoutConfig{{Address: segwitAddress, Percent: 60}, {Address: satoshiAddress, Percent: 40}}},
+			node:    node,
+			payouts: []float64{60, 40},
+			dialect: stratum.Bitcoin,
+		},
+		{
+			name: "payouts short of 100",
+			cfg:  SoloConfig{Payouts: []PayoutConfig{{Address: segwitAddress, Percent: 60}}},
+			node: node,
+			err:  ErrSoloPayouts.Error(),
+		},
+		{
+			name: "payouts over 100",
+			cfg:  SoloConfig{Address: satoshiAddress, Payouts: []PayoutConfig{{Address: segwitAddress, Percent: 60}, {Address: segwitAddress, Percent: 50}}},
+			node: node,
+			err:  ErrSoloPayouts.Error(),
+		},
+		{
+			name: "zero percent",
+			cfg:  SoloConfig{Address: satoshiAddress, Payouts: []PayoutConfig{{Address: segwitAddress}}},
+			node: node,
+			err:  ErrSoloPayouts.Error(),
+		},
+		{name: "bad address", cfg: SoloConfig{Address: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb"}, node: node, err: "invalid solo payout address"},
+		{name: "long message", cfg: SoloConfig{Address: satoshiAddress, Message: strings.Repeat("x", 81)}, node: node, err: "solo message longer"},
+	}
+
+	for _, tt := range tests {
+		s, err := newSoloSource(tt.cfg, tt.node, newTestServer(t, Config{}))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if tt.off {
+			if s != nil {
+				t.Errorf("%s: solo mining on", tt.name)
+			}
+			continue
+		}
+
+		if s.dialect != tt.dialect || s.nodeCoinbase != tt.shield {
+			t.Errorf("%s: dialect %v, node coinbase %v", tt.name, s.dialect, s.nodeCoinbase)
+		}
+
+		var percents []float64
+		for _, p := range s.payouts {
+			percents = append(percents, p.percent)
+		}
+		if len(percents) != len(tt.payouts) {
+			t.Errorf("%s: payouts %v, want %v", tt.name, percents, tt.payouts)
+			continue
+		}
+		for i := range percents {
+			if percents[i] != tt.payouts[i] {
+				t.Errorf("%s: payouts %v, want %v", tt.name, percents, tt.payouts)
+			}
+		}
+	}
+}
+
+func TestSoloOutputs(t *testing.T) {
+	satoshi, _ := hex.DecodeString(satoshiScript)
+	segwit, _ := hex.DecodeString(segwitScript)
+
+	tests := []struct {
+		name    string
+		payouts []payout
+		message []byte
+		value   uint64
+		want    []uint64
+	}{
+		{name: "one", payouts: []payout{{satoshi, 100}}, value: 5000000000, want: []uint64{5000000000}},
+		{name: "fee", payouts: []payout{{segwit, 2}, {satoshi, 98}}, value: 5000000000, want: []uint64{100000000, 4900000000}},
+		{name: "thirds", payouts: []payout{{segwit, 100.0 / 3}, {segwit, 100.0 / 3}, {satoshi, 100.0 / 3}}, value: 100, want: []uint64{33, 33, 34}},
+		{name: "dust", payouts: []payout{{segwit, 50}, {satoshi, 50}}, value: 1, want: []uint64{1}},
+		{name: "message", payouts: []payout{{satoshi, 100}}, message: []byte{0x6a, 0x01, 'x'}, value: 100, want: []uint64{100, 0}},
+	}
+
+	for _, tt := range tests {
+		s := soloSource{payouts: tt.payouts, message: tt.message}
+		outputs := s.outputs(tt.value)
+
+		var values []uint64
+		var total uint64
+		for _, out := range outputs {
+			values = append(values, out.Value)
+			total += out.Value
+		}
+		if len(values) != len(tt.want) || total != tt.value {
+			t.Errorf("%s: outputs %v, want %v", tt.name, values, tt.want)
+			continue
+		}
+		for i := range values {
+			if values[i] != tt.want[i] {
+				t.Errorf("%s: outputs %v, want %v", tt.name, values, tt.want)
+			}
+		}
+
+		if tt.message != nil && !bytes.Equal(outputs[len(outputs)-1].Script, tt.message) {
+			t.Errorf("%s: last output %x, want the message", tt.name, outputs[len(outputs)-1].Script)
+		}
+	}
+}
+
+func TestSoloWork(t *testing.T) {
+	satoshi, _ := hex.DecodeString(satoshiScript)
+	outputs := []proxy.TxOut{{Value: 5000000000, Script: satoshi}}
+
+	w, err := soloWork(block1Template(), "1", outputs, []byte("/proxy/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous block as mining.notify sends it, see block 1 in proxy.
+	prev, _ := stratum.HexToUint256("0a8ce26f72b3f1b646a2a6c14ff763ae65831e939c085ae10019d66800000000")
+	if w.HashPrevBlock != prev {
+		t.Errorf("previous block %v", stratum.ToHex(w.HashPrevBlock))
+	}
+
+	if w.Job != "1" || w.Version != 1 || w.NTime != 0x4966bc61 || w.NBits != 0x1d00ffff || w.Height != 1 || w.Subsidy != 50 {
+		t.Errorf("work %+v", w.ResponseNotify)
+	}
+	target, _ := proxy.CompactToTarget(0x1d00ffff)
+	if w.Difficulty != 1 || w.Target != target {
+		t.Errorf("difficulty %v, target %v", w.Difficulty, stratum.ToHex(w.Target))
+	}
+
+	// Height then tag, before the extranonce.
+	if !bytes.HasSuffix(w.Coinbase1, append([]byte{0x51}, "/proxy/"...)) {
+		t.Errorf("coinbase1 %x", w.Coinbase1)
+	}
+	// The payout, then the witness commitment.
+	if !bytes.Contains(w.Coinbase2, append([]byte{0x02, 0x00, 0xf2, 0x05, 0x2a, 0x01, 0x00, 0x00, 0x00, byte(len(satoshi))}, satoshi...)) ||
+		!bytes.Contains(w.Coinbase2, mustDecodeHex(t, "26"+block1Template().DefaultWitnessCommitment)) {
+		t.Errorf("coinbase2 %x", w.Coinbase2)
+	}
+	if !w.CoinbaseWitness {
+		t.Error("no witness for the commitment")
+	}
+
+	first, _ := stratum.LittleEndian.Uint256(block1Template().Transactions[0].TxID)
+	if len(w.MerkleBranch) != 2 || w.MerkleBranch[0] != first {
+		t.Errorf("merkle branch %v", w.MerkleBranch)
+	}
+	if len(w.Transactions) != 2 || hex.EncodeToString(w.Transactions[1]) != "ccdd" {
+		t.Errorf("transactions %x", w.Transactions)
+	}
+
+	// The block leads with the header and the coinbase, then the template's
+	// transactions.
+	share := proxy.Share{NTime: w.NTime, NoncePart1: make([]byte, 4), NoncePart2: make([]byte, 4), Version: 1}
+	block := w.BitcoinBlock(share)
+	if block[80] != 3 || !bytes.HasSuffix(block, []byte{0xaa, 0xbb, 0xcc, 0xdd}) {
+		t.Errorf("block %x", block)
+	}
+
+	for _, broken := range []func(*rpc.BlockTemplate){
+		func(b *rpc.BlockTemplate) { b.Bits = "zz" },
+		func(b *rpc.BlockTemplate) { b.PreviousBlockHash = "00" },
+		func(b *rpc.BlockTemplate) { b.Transactions[0].TxID = "00" },
+		func(b *rpc.BlockTemplate) { b.Transactions[0].Data = "z" },
+		func(b *rpc.BlockTemplate) { b.DefaultWitnessCommitment = "z" },
+	} {
+		template := block1Template()
+		broken(template)
+		if _, err := soloWork(template, "1", outputs, nil); err == nil {
+			t.Errorf("built work from %+v", template)
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSoloUpdate(t *testing.T) {
+	s := newTestServer(t, Config{})
+	_, miner := connect(t, s, stratum.Bitcoin)
+
+	satoshi, _ := hex.DecodeString(satoshiScript)
+	solo := soloSource{ps: s, jobs: proxy.NewJobRegistry(0), dialect: stratum.Bitcoin, payouts: []payout{{satoshi, 100}}}
+
+	tests := []struct {
+		name  string
+		prev  string
+		clean bool
+	}{
+		{name: "first", prev: genesisHash, clean: true},
+		{name: "new transactions", prev: genesisHash},
+		{name: "new block", prev: strings.Repeat("00", 31) + "01", clean: true},
+	}
+
+	for i, tt := range tests {
+		template := block1Template()
+		template.PreviousBlockHash = tt.prev
+		solo.update(template)
+
+		job := solo.jobs.Current()
+		if job == nil || job.Job != strconv.Itoa(i+1) || job.CleanJobs != tt.clean {
+			t.Errorf("%s: job %+v", tt.name, job)
+			continue
+		}
+
+		notify := miner.read()
+		params, _ := notify["params"].([]interface{})
+		if notify["method"] != "mining.notify" || len(params) != 9 || params[0] != job.Job || params[8] != tt.clean {
+			t.Errorf("%s: notified %v", tt.name, notify)
+		}
+	}
+}
+
+func TestSoloTemplate(t *testing.T) {
+	node, client := newTestNode(t)
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	requests := make(chan map[string]interface{}, 10)
+	node.handle("getblocktemplate", func(params []json.RawMessage) (interface{}, *rpc.Error) {
+		var request map[string]interface{}
+		_ = json.Unmarshal(params[0], &request)
+		requests <- request
+
+		// Longpolls wait for a new block that never comes.
+		if request["longpollid"] != nil {
+			<-release
+		}
+		return block1Template(), nil
+	})
+
+	s := soloSource{cfg: SoloConfig{Refresh: 1}, node: client}
+
+	template, err := s.template("")
+	if err != nil || template == nil || template.Height != 1 {
+		t.Fatalf("template %+v, %v", template, err)
+	}
+	if request := <-requests; request["longpollid"] != nil {
+		t.Errorf("requested %v", request)
+	}
+
+	// A longpoll ends when poked.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if template, err := s.template("abc"); template != nil || err != nil {
+			t.Errorf("poked longpoll: %+v, %v", template, err)
+		}
+	}()
+
+	if request := <-requests; request["longpollid"] != "abc" {
+		t.Errorf("requested %v", request)
+	}
+	s.poke()
+	select {
+	case <-done:
+	case <-time.After(time.Second / 2):
+		t.Fatal("longpoll not poked")
+	}
+
+	// Or when it's time to refresh the transactions.
+	start := time.Now()
+	if template, err := s.template("abc"); template != nil || err != nil {
+		t.Errorf("refreshed longpoll: %+v, %v", template, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second/2 || elapsed > 3*time.Second {
+		t.Errorf("refreshed after %v", elapsed)
+	}
+
+	node.handle("getblocktemplate", func([]json.RawMessage) (interface{}, *rpc.Error) {
+		return nil, &rpc.Error{Code: -10, Message: "Bitcoin Core is in initial sync"}
+	})
+	if _, err := s.template(""); err == nil {
+		t.Error("no error from the node")
+	}
+
+	var nilSource *soloSource
+	nilSource.poke()
+}
+
+func TestNullData(t *testing.T) {
+	tests := []struct {
+		size   int
+		prefix string
+	}{
+		{size: 1, prefix: "6a01"},
+		{size: 75, prefix: "6a4b"},
+		{size: 76, prefix: "6a4c4c"},
+		{size: 80, prefix: "6a4c50"},
+	}
+
+	for _, tt := range tests {
+		data := bytes.Repeat([]byte{'x'}, tt.size)
+		script := nullData(data)
+		if got := hex.EncodeToString(script[:len(script)-tt.size]); got != tt.prefix || !bytes.HasSuffix(script, data) {
+			t.Errorf("%d bytes: script starts %v, want %v", tt.size, got, tt.prefix)
+		}
+	}
+}
+
+// zcashTemplate is a template of zcashd mining to zcashAddress at an NU5
+// height, with two transactions.
+func zcashTemplate(t *testing.T, outputs ...proxy.TxOut) *rpc.BlockTemplate {
+	t.Helper()
+
+	script, _ := hex.DecodeString(zcashScript)
+	coinbase := proxy.ZcashCoinbase{
+		Height:  2000000,
+		Outputs: append([]proxy.TxOut{{Value: 156250000 + 3000, Script: script}}, outputs...),
+	}.Serialize()
+
+	return &rpc.BlockTemplate{
+		Version:           4,
+		PreviousBlockHash: "0000000001e8d4a9dda48bd8a5ed1b8cb49b0a0f2b0cd62a4e7ae6a6b2d4c0f1",
+		Transactions: []rpc.TemplateTransaction{
+			{Data: "aabb", Hash: strings.Repeat("11", 32), AuthDigest: strings.Repeat("21", 32), Fee: 1000},
+			{Data: "ccdd", Hash: strings.Repeat("12", 32), AuthDigest: strings.Repeat("22", 32), Fee: 2000},
+		},
+		CoinbaseTxn: &rpc.TemplateTransaction{
+			Data:       hex.EncodeToString(coinbase),
+			Hash:       stratum.ToHex(proxy.BlockHash(coinbase)),
+			AuthDigest: strings.Repeat("ff", 32),
+		},
+		DefaultRoots: &rpc.TemplateRoots{
+			ChainHistoryRoot: strings.Repeat("33", 32),
+			AuthDataRoot:     strings.Repeat("44", 32),
+		},
+		CurTime: 0x65000000,
+		Bits:    "1c01af61",
+		Height:  2000000,
+	}
+}
+
+func TestZcashWork(t *testing.T) {
+	payee, _ := hex.DecodeString(zcashScript)
+	funding, _ := hex.DecodeString(fundingScript)
+	founders, _ := hex.DecodeString("a914" + strings.Repeat("55", 20) + "87")
+
+	tests := []struct {
+		name     string
+		template *rpc.BlockTemplate
+		subsidy  rpc.BlockSubsidy
+		payouts  []payout
+		node     bool
+		outputs  []proxy.TxOut
+		err      string
+	}{
+		{
+			name:     "funding streams",
+			template: zcashTemplate(t),
+			subsidy: rpc.BlockSubsidy{Miner: 1.5625, FundingStreams: []rpc.FundingStream{
+				{Recipient: "Zcash Community Grants", ValueZat: 12500000, Address: fundingAddress},
+				// A lockbox has no output.
+				{Recipient: "Lockbox", ValueZat: 18750000},
+			}},
+			payouts: []payout{{funding, 2}, {payee, 98}},
+			outputs: []proxy.TxOut{
+				{Value: 3125060, Script: funding},
+				{Value: 153127940, Script: payee},
+				{Value: 12500000, Script: funding},
+			},
+		},
+		{
+			name:     "founders' reward",
+			template: zcashTemplate(t, proxy.TxOut{Value: 250000000, Script: founders}),
+			subsidy:  rpc.BlockSubsidy{Miner: 10, Founders: 2.5},
+			payouts:  []payout{{payee, 100}},
+			outputs: []proxy.TxOut{
+				{Value: 1000003000, Script: payee},
+				{Value: 250000000, Script: founders},
+			},
+		},
+		{
+			name:     "shielded",
+			template: zcashTemplate(t),
+			subsidy:  rpc.BlockSubsidy{Miner: 1.5625},
+			node:     true,
+			outputs:  []proxy.TxOut{{Value: 156253000, Script: payee}},
+		},
+		{
+			name:     "founders' reward missing",
+			template: zcashTemplate(t),
+			subsidy:  rpc.BlockSubsidy{Miner: 10, Founders: 2.5},
+			payouts:  []payout{{payee, 100}},
+			err:      "no founders' reward",
+		},
+		{
+			name:     "shielded funding stream",
+			template: zcashTemplate(t),
+			subsidy:  rpc.BlockSubsidy{Miner: 1.5625, FundingStreams: []rpc.FundingStream{{Recipient: "Major Grants", ValueZat: 12500000, Address: saplingAddress}}},
+			payouts:  []payout{{payee, 100}},
+			err:      "can't pay funding stream",
+		},
+		{
+			name:     "no coinbase",
+			template: &rpc.BlockTemplate{Height: 2000000},
+			payouts:  []payout{{payee, 100}},
+			err:      "template without a coinbase",
+		},
+	}
+
+	for _, tt := range tests {
+		node, client := newTestNode(t)
+		node.reply("getblocksubsidy", tt.subsidy)
+
+		s := soloSource{ps: newTestServer(t, Config{}), node: client, dialect: stratum.Equihash, payouts: tt.payouts, nodeCoinbase: tt.node}
+		w, err := s.zcashWork(tt.template, "1")
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		outputs, err := proxy.ZcashTxOutputs(w.Transactions[0])
+		if err != nil || len(outputs) != len(tt.outputs) {
+			t.Errorf("%s: outputs %+v, %v", tt.name, outputs, err)
+			continue
+		}
+		for i, out := range outputs {
+			if out.Value != tt.outputs[i].Value || !bytes.Equal(out.Script, tt.outputs[i].Script) {
+				t.Errorf("%s: output %d %d to %x, want %d to %x", tt.name, i, out.Value, out.Script, tt.outputs[i].Value, tt.outputs[i].Script)
+			}
+		}
+
+		if coinbase := hex.EncodeToString(w.Transactions[0]); tt.node != (coinbase == tt.template.CoinbaseTxn.Data) {
+			t.Errorf("%s: node coinbase %v", tt.name, coinbase == tt.template.CoinbaseTxn.Data)
+		}
+		if want := float64(tt.outputs[0].Value) / 1e8; !tt.node && len(tt.payouts) == 1 && w.Subsidy != want {
+			t.Errorf("%s: subsidy %v, want %v", tt.name, w.Subsidy, want)
+		}
+	}
+}
+
+func TestZcashWorkHeader(t *testing.T) {
+	template := zcashTemplate(t)
+	w, err := zcashWork(template, "a", template.CoinbaseTxn, 156253000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Header bytes read big-endian, as from an Equihash pool.
+	if w.Version != 0x04000000 || w.NTime != 0x00000065 || w.NBits != 0x61af011c || w.Bits() != 0x1c01af61 {
+		t.Errorf("version %08x, ntime %08x, bits %08x", w.Version, w.NTime, w.NBits)
+	}
+	if w.PrevBlockHash() != template.PreviousBlockHash {
+		t.Errorf("previous block %v", w.PrevBlockHash())
+	}
+	if w.Dialect != stratum.Equihash || w.N != proxy.EquihashN || w.Height != 2000000 || w.Subsidy != 1.56253 {
+		t.Errorf("work %+v", w)
+	}
+	if target, _ := proxy.CompactToTarget(0x1c01af61); w.Target != target {
+		t.Errorf("target %v", stratum.ToHex(w.Target))
+	}
+
+	coinbase, _ := hex.DecodeString(template.CoinbaseTxn.Data)
+	txid := func(s string) stratum.Uint256 {
+		h, _ := stratum.LittleEndian.Uint256(s)
+		return h
+	}
+
+	root := proxy.MerkleRoot(proxy.DoubleSHA256(coinbase), proxy.MerkleBranch([]stratum.Uint256{txid(strings.Repeat("11", 32)), txid(strings.Repeat("12", 32))}))
+	if w.HashMerkleRoot != root {
+		t.Errorf("merkle root %x, want %x", w.HashMerkleRoot, root)
+	}
+
+	authDataRoot := proxy.ZcashAuthDataRoot([]stratum.Uint256{proxy.ZcashLegacyAuthDigest, txid(strings.Repeat("21", 32)), txid(strings.Repeat("22", 32))})
+	if w.HashReserved != proxy.ZcashBlockCommitments(txid(strings.Repeat("33", 32)), authDataRoot) {
+		t.Errorf("block commitments %x", w.HashReserved)
+	}
+
+	// Before NU5 the header has the chain history root, before Heartwood
+	// the Sapling root.
+	template.DefaultRoots.AuthDataRoot = ""
+	if w, err := zcashWork(template, "a", template.CoinbaseTxn, 0); err != nil || w.HashReserved != txid(strings.Repeat("33", 32)) {
+		t.Errorf("heartwood reserved %x, %v", w.HashReserved, err)
+	}
+
+	template.DefaultRoots = nil
+	template.FinalSaplingRootHash = strings.Repeat("66", 32)
+	if w, err := zcashWork(template, "a", template.CoinbaseTxn, 0); err != nil || w.HashReserved != txid(strings.Repeat("66", 32)) {
+		t.Errorf("sapling reserved %x, %v", w.HashReserved, err)
+	}
+
+	template.FinalSaplingRootHash = ""
+	if _, err := zcashWork(template, "a", template.CoinbaseTxn, 0); err == nil {
+		t.Error("built work without a reserved hash")
+	}
+}
+
+func TestSoloSubmit(t *testing.T) {
+	node, client := newTestNode(t)
+	submitted := make(chan string, 1)
+	node.handle("submitblock", func(params []json.RawMessage) (interface{}, *rpc.Error) {
+		var block string
+		_ = json.Unmarshal(params[0], &block)
+		submitted <- block
+		return nil, nil
+	})
+
+	template := zcashTemplate(t)
+	w, err := zcashWork(template, "a", template.CoinbaseTxn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := proxy.Share{NTime: w.NTime, NoncePart1: make([]byte, 16), NoncePart2: make([]byte, 16), Solution: make([]byte, 1344)}
+
+	// Bitcoin solo mining leaves Equihash blocks to their pool.
+	s := soloSource{node: client, dialect: stratum.Bitcoin}
+	s.submit(w, share)
+
+	s.dialect = stratum.Equihash
+	s.submit(w, share)
+
+	select {
+	case block := <-submitted:
+		if block != hex.EncodeToString(w.ZcashBlock(share)) {
+			t.Errorf("submitted %v", block)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("block not submitted")
+	}
+
+	select {
+	case block := <-submitted:
+		t.Errorf("submitted %v as well", block)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+)
+
+type (
+	// TLSConfig for a stratum listener.
+	TLSConfig struct {
+		CertFile string `json:"certFile"`
+		KeyFile  string `json:"keyFile"`
+
+		// Client certificates signed by ClientCAFile are verified when set.
+		ClientCAFile      string `json:"clientCAFile"`
+		RequireClientCert bool   `json:"requireClientCert"`
+	}
+
+	// UpstreamTLSConfig for dialing a pool.
+	UpstreamTLSConfig struct {
+		// Only trust pools signed by the CAs in CAFile, system roots otherwise.
+		CAFile     string `json:"caFile"`
+		ServerName string `json:"serverName"`
+
+		// Hex encoded SHA-256 hashes of a SubjectPublicKeyInfo in the pool's chain.
+		Pins []string `json:"pins"`
+	}
+)
+
+// Build the tls.Config served to miners.
+func (c *TLSConfig) Build() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls listener needs certFile and keyFile")
+	}
+
+	reloader, err := proxy.NewCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pool, err := proxy.LoadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if c.RequireClientCert {
+		return nil, errors.New("requireClientCert needs clientCAFile")
+	}
+
+	return cfg, nil
+}
+
+// Build the tls.Config used to dial host.
+func (c *UpstreamTLSConfig) Build(host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.ServerName != "" {
+		cfg.ServerName = c.ServerName
+	}
+
+	if c.CAFile != "" {
+		pool, err := proxy.LoadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if len(c.Pins) > 0 {
+		pins, err := proxy.ParsePins(c.Pins)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.VerifyPeerCertificate = proxy.PinnedVerifier(pins)
+	}
+
+	return cfg, nil
+}
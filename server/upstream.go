@@ -0,0 +1,625 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+type (
+	// UpstreamConfig for the pool the proxy mines on.
+	UpstreamConfig struct {
+		Host     string `json:"upstreamHost"`
+		Port     int    `json:"upstreamPort"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+
+		// Dial the pool over TLS when set.
+		TLS *UpstreamTLSConfig `json:"upstreamTLS"`
+
+		Dialect stratum.Dialect `json:"dialect"`
+
+		// Address the pool's coinbases must pay, checked by the auditor.
+		PayoutAddress string `json:"payoutAddress"`
+	}
+
+	// Upstream is the proxy's session with a pool. Shares from all clients
+	// are submitted through it and its jobs are sent to all clients.
+	Upstream struct {
+		Config UpstreamConfig
+
+		ps *ProxyServer
+
+		mu             sync.Mutex
+		writer         *proxy.Writer
+		nextID         uint64
+		pending        map[uint64]func(stratum.ResponseGeneral)
+		subscribed     bool
+		noncePart1     []byte
+		noncePart2Size int
+		target         stratum.Uint256
+		versionMask    uint32
+
+		// Client parts of the SHA256d nonce1s under the pool's, replaced
+		// when the pool's changes.
+		slots *nonceSlots
+
+		jobs *proxy.JobRegistry
+	}
+)
+
+const (
+	UpstreamRetryInterval = 5 * time.Second
+
+	// Pools send a job at least every few minutes.
+	UpstreamIdleTimeout = 10 * time.Minute
+
+	// Pool lines carry more than a miner's, e.g. merkle branches.
+	upstreamMaxMessageSize = 256 * 1024
+
+	// Bytes of the nonce the proxy keeps to tell its clients apart.
+	clientNonceSize        = 8
+	bitcoinClientNonceSize = 2
+)
+
+var (
+	ErrUpstreamDown      = errors.New("upstream not connected")
+	ErrUpstreamNonce     = errors.New("upstream nonce1 too long")
+	ErrUpstreamReconnect = errors.New("upstream asked to reconnect")
+	ErrNonceSpace        = errors.New("no client nonces left under the upstream's")
+
+	// Sent to miners whose shares were in flight when the pool went away.
+	errorUpstreamLost = &stratum.Error{Code: 20, Message: "Upstream disconnected"}
+)
+
+func (u UpstreamConfig) Addr() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+// Dial connects to the pool.
+func (u UpstreamConfig) Dial() (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   DialTimeout,
+		KeepAlive: KeepAliveInterval,
+	}
+
+	if u.TLS == nil {
+		return dialer.Dial("tcp", u.Addr())
+	}
+
+	cfg, err := u.TLS.Build(u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.DialWithDialer(dialer, "tcp", u.Addr(), cfg)
+}
+
+func NewUpstream(cfg UpstreamConfig, ps *ProxyServer) *Upstream {
+	return &Upstream{
+		Config: cfg,
+		ps:     ps,
+		slots:  newNonceSlots(nonceSlotCount),
+		jobs:   proxy.NewJobRegistry(ps.Config.JobHistory),
+	}
+}
+
+// Run keeps a session with the pool open, reconnecting when it fails.
+func (u *Upstream) Run() {
+	for {
+		err := u.session()
+		log.Printf("[upstream %v] session ended: %v\n", u.Config.Addr(), err)
+
+		time.Sleep(UpstreamRetryInterval)
+	}
+}
+
+func (u *Upstream) session() error {
+	conn, err := u.Config.Dial()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[upstream %v] connected\n", u.Config.Addr())
+
+	lrw := proxy.NewLRW(conn)
+	lrw.MaxMessageSize = upstreamMaxMessageSize
+	lrw.Dialect = u.dialect()
+
+	writer := proxy.NewWriter(conn, proxy.DefaultQueueSize, WriteTimeout)
+	defer writer.Close()
+
+	u.mu.Lock()
+	u.writer = writer
+	u.pending = make(map[uint64]func(stratum.ResponseGeneral))
+	u.mu.Unlock()
+
+	defer u.reset()
+
+	// BIP 310 asks for version rolling before subscribing.
+	if u.dialect().IsBitcoin() {
+		if err := u.call(func(id uint64) stratum.Request {
+			return stratum.RequestConfigure{
+				RequestBase: stratum.RequestBase{ID: id, Method: stratum.Configure},
+				Extensions:  []string{stratum.VersionRolling},
+				Params: map[string]interface{}{
+					stratum.VersionRollingMask:        stratum.ToHex(stratum.DefaultVersionMask),
+					stratum.VersionRollingMinBitCount: 2,
+				},
+			}
+		}, u.onConfigure); err != nil {
+			return err
+		}
+	}
+
+	if err := u.call(func(id uint64) stratum.Request {
+		return stratum.RequestSubscribe{
+			RequestBase: stratum.RequestBase{ID: id, Method: stratum.Subscribe},
+		}
+	}, u.onSubscribe); err != nil {
+		return err
+	}
+
+	if err := u.call(func(id uint64) stratum.Request {
+		return stratum.RequestAuthorize{
+			RequestBase: stratum.RequestBase{ID: id, Method: stratum.Authorize},
+			Username:    u.Config.Username,
+			Password:    u.Config.Password,
+		}
+	}, u.onAuthorize); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := lrw.ReadServerMessageTimed(time.Now().Add(UpstreamIdleTimeout))
+		if err != nil {
+			if _, ok := err.(*proxy.MalformedError); ok {
+				log.Printf("[upstream %v] ignoring message: %v\n", u.Config.Addr(), err)
+				continue
+			}
+
+			if werr := writer.Err(); werr != nil {
+				return werr
+			}
+
+			return err
+		}
+
+		if err := u.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// reset forgets the session's state and fails its pending requests.
+func (u *Upstream) reset() {
+	u.mu.Lock()
+	pending := u.pending
+	u.writer = nil
+	u.pending = nil
+	u.subscribed = false
+	u.mu.Unlock()
+
+	// The pool won't know the jobs of a session it forgot.
+	u.jobs.Reset()
+
+	for _, done := range pending {
+		done(stratum.ResponseGeneral{Error: errorUpstreamLost})
+	}
+}
+
+// call sends the request built for a new ID, done gets the pool's reply.
+func (u *Upstream) call(build func(id uint64) stratum.Request, done func(stratum.ResponseGeneral)) error {
+	u.mu.Lock()
+	if u.writer == nil {
+		u.mu.Unlock()
+		return ErrUpstreamDown
+	}
+
+	u.nextID++
+	id := u.nextID
+	u.pending[id] = done
+	writer := u.writer
+	u.mu.Unlock()
+
+	return writer.Request(build(id))
+}
+
+func (u *Upstream) handle(msg stratum.Response) error {
+	switch msg := msg.(type) {
+	case stratum.ResponseGeneral:
+		id, ok := replyID(msg.ID)
+		if !ok {
+			return nil
+		}
+
+		u.mu.Lock()
+		done := u.pending[id]
+		delete(u.pending, id)
+		u.mu.Unlock()
+
+		if done != nil {
+			done(msg)
+		}
+
+	case stratum.ResponseNotify:
+		u.notify(msg)
+
+	case stratum.ResponseNotifyBitcoin:
+		u.notifyBitcoin(msg)
+
+	case stratum.ResponseSetTarget:
+		u.mu.Lock()
+		u.target = msg.Target
+		u.mu.Unlock()
+
+	case stratum.ResponseSetDifficulty:
+		u.mu.Lock()
+		u.target = proxy.Difficulty(msg.Difficulty).ToTarget(proxy.DialectAlgorithm(u.dialect()))
+		u.mu.Unlock()
+
+	case stratum.ResponseSetExtranonce:
+		return u.setNoncePart1(msg.NoncePart1, msg.NoncePart2Size)
+
+	case stratum.ResponseSetVersionMask:
+		u.setVersionMask(msg.Mask)
+
+	case stratum.ResponseReconnect:
+		return ErrUpstreamReconnect
+
+	case stratum.ResponseShowMessage:
+		log.Printf("[upstream %v] message: %v\n", u.Config.Addr(), msg.Message)
+
+	case stratum.ResponseGetVersion:
+		version := json.RawMessage(`"mining-pool-proxy"`)
+		u.mu.Lock()
+		writer := u.writer
+		u.mu.Unlock()
+
+		if writer != nil {
+			return writer.Request(stratum.RequestReply{
+				RequestBase: stratum.RequestBase{ID: msg.ID},
+				Result:      &version,
+			})
+		}
+	}
+
+	return nil
+}
+
+func (u *Upstream) onConfigure(resp stratum.ResponseGeneral) {
+	if resp.Error == errorUpstreamLost {
+		return
+	}
+
+	// Pools without mining.configure reply with an error, mining goes on
+	// without version rolling.
+	mask := uint32(0)
+	if resp.Error == nil {
+		result, _ := resp.Result.(*json.RawMessage)
+		mask, _ = stratum.ParseVersionRollingResult(result)
+	}
+
+	u.setVersionMask(mask)
+}
+
+// setVersionMask stores the version bits the pool lets us roll and passes
+// them on to the clients.
+func (u *Upstream) setVersionMask(mask uint32) {
+	u.mu.Lock()
+	changed := u.versionMask != mask
+	u.versionMask = mask
+	u.mu.Unlock()
+
+	log.Printf("[upstream %v] version mask %08x\n", u.Config.Addr(), mask)
+
+	if changed && u.ps.isActive(u) {
+		u.ps.SetVersionMask(mask)
+	}
+}
+
+// VersionMask returns the version bits the pool lets us roll.
+func (u *Upstream) VersionMask() uint32 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.versionMask
+}
+
+func (u *Upstream) onSubscribe(resp stratum.ResponseGeneral) {
+	if resp.Error == errorUpstreamLost {
+		return
+	}
+
+	if resp.Error != nil {
+		log.Printf("[upstream %v] subscribe failed: %v\n", u.Config.Addr(), resp.Error)
+		u.closeWriter()
+		return
+	}
+
+	result, _ := resp.Result.(*json.RawMessage)
+	_, noncePart1, noncePart2Size, err := stratum.ParseSubscribeResult(result)
+	if err != nil {
+		log.Printf("[upstream %v] bad subscribe reply: %v\n", u.Config.Addr(), err)
+		u.closeWriter()
+		return
+	}
+
+	if err := u.setNoncePart1(noncePart1, noncePart2Size); err != nil {
+		log.Printf("[upstream %v] %v\n", u.Config.Addr(), err)
+		u.closeWriter()
+	}
+}
+
+func (u *Upstream) onAuthorize(resp stratum.ResponseGeneral) {
+	if resp.Error == errorUpstreamLost {
+		return
+	}
+
+	if ok, _ := replyResult(resp); !ok {
+		log.Printf("[upstream %v] authorize failed as '%v'\n", u.Config.Addr(), u.Config.Username)
+		u.closeWriter()
+	}
+}
+
+// closeWriter ends the session.
+func (u *Upstream) closeWriter() {
+	u.mu.Lock()
+	writer := u.writer
+	u.mu.Unlock()
+
+	if writer != nil {
+		_ = writer.Close()
+	}
+}
+
+// setNoncePart1 stores the pool's nonce1, which goes at the start of every
+// client's nonce1. Clients are disconnected when it changes, their jobs
+// aren't valid anymore. A zero noncePart2Size keeps the current size.
+func (u *Upstream) setNoncePart1(noncePart1 []byte, noncePart2Size int) error {
+	if u.dialect().IsBitcoin() {
+		if noncePart2Size == 0 {
+			u.mu.Lock()
+			noncePart2Size = u.noncePart2Size
+			u.mu.Unlock()
+		}
+
+		if noncePart2Size <= bitcoinClientNonceSize {
+			return ErrUpstreamNonce
+		}
+	} else if len(noncePart1) > clientNonceSize {
+		return ErrUpstreamNonce
+	}
+
+	u.mu.Lock()
+	changed := string(u.noncePart1) != string(noncePart1) || u.noncePart2Size != noncePart2Size
+	u.noncePart1 = append([]byte{}, noncePart1...)
+	u.noncePart2Size = noncePart2Size
+	u.subscribed = true
+	if changed {
+		// Clients on the old nonce1 give their slots back to the old set.
+		u.slots = newNonceSlots(nonceSlotCount)
+	}
+	u.mu.Unlock()
+
+	if changed && u.ps.isActive(u) {
+		u.ps.ResetDialect(u.dialect())
+	}
+
+	return nil
+}
+
+// Subscribed reports whether the upstream has a session with its pool.
+func (u *Upstream) Subscribed() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.subscribed
+}
+
+// extranonceSize is the pool's nonce1 and nonce2 together.
+func (u *Upstream) extranonceSize() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return len(u.noncePart1) + u.noncePart2Size
+}
+
+// clientNonce returns the nonce1 and nonce2 size for a client, ErrUpstreamDown
+// before the pool's nonce1 is known. SHA256d pools leave too few bytes for
+// the client's ID, it gets a slot instead which the caller releases once
+// the nonce is no longer in use, ErrNonceSpace when all are taken.
+func (u *Upstream) clientNonce(id ClientID) ([]byte, int, *nonceLease, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !u.subscribed {
+		return nil, 0, nil, ErrUpstreamDown
+	}
+
+	if u.dialect().IsBitcoin() {
+		lease, ok := u.slots.acquire()
+		if !ok {
+			return nil, 0, nil, ErrNonceSpace
+		}
+
+		noncePart1 := append([]byte{}, u.noncePart1...)
+		noncePart1 = append(noncePart1, byte(lease.slot>>8), byte(lease.slot))
+		return noncePart1, u.noncePart2Size - bitcoinClientNonceSize, lease, nil
+	}
+
+	noncePart1 := make([]byte, 2*clientNonceSize)
+	copy(noncePart1, u.noncePart1)
+	binary.LittleEndian.PutUint64(noncePart1[clientNonceSize:], uint64(id))
+	return noncePart1, 2 * clientNonceSize, nil, nil
+}
+
+func (u *Upstream) dialect() stratum.Dialect {
+	return stratum.Dialect(u.Config.Dialect.String())
+}
+
+// work turns a job from the pool into proxy.Work.
+func (u *Upstream) work(n stratum.ResponseNotify) *proxy.Work {
+	u.mu.Lock()
+	shareTarget := u.target
+	u.mu.Unlock()
+
+	w := &proxy.Work{
+		ResponseNotify: n,
+		At:             time.Now(),
+		Dialect:        u.dialect(),
+	}
+
+	if !w.Dialect.IsBitcoin() {
+		w.N, w.K = proxy.EquihashN, proxy.EquihashK
+	}
+
+	// Pools may send the job before the share target, leave the difficulty
+	// unknown until then.
+	if shareTarget != (stratum.Uint256{}) {
+		w.Difficulty = proxy.FromTarget(shareTarget, proxy.DialectAlgorithm(w.Dialect))
+	}
+
+	target, err := proxy.CompactToTarget(w.Bits())
+	if err != nil {
+		log.Printf("[upstream %v] job %v: %v\n", u.Config.Addr(), n.Job, err)
+		return nil
+	}
+	w.Target = target
+
+	return w
+}
+
+func (u *Upstream) notify(n stratum.ResponseNotify) {
+	if w := u.work(n); w != nil {
+		u.setWork(w)
+	}
+}
+
+func (u *Upstream) notifyBitcoin(n stratum.ResponseNotifyBitcoin) {
+	w := u.work(stratum.ResponseNotify{
+		Job:           n.Job,
+		Version:       n.Version,
+		HashPrevBlock: n.HashPrevBlock,
+		NTime:         n.NTime,
+		NBits:         n.NBits,
+		CleanJobs:     n.CleanJobs,
+	})
+	if w == nil {
+		return
+	}
+
+	w.Coinbase1 = n.Coinbase1
+	w.Coinbase2 = n.Coinbase2
+	w.MerkleBranch = n.MerkleBranch
+
+	if height, ok := w.CoinbaseHeight(); ok {
+		w.Height = height
+	}
+
+	u.setWork(w)
+}
+
+// setWork records a job and, when the upstream is active, sends it to the
+// clients. Every upstream's jobs are audited.
+func (u *Upstream) setWork(w *proxy.Work) {
+	u.jobs.Add(w)
+	u.ps.auditor.check(u, w)
+
+	if u.ps.isActive(u) {
+		u.ps.SetWork(w)
+	}
+}
+
+// Job looks up a job the pool sent during this session.
+func (u *Upstream) Job(id string) (*proxy.Work, proxy.JobStatus) {
+	return u.jobs.Lookup(id)
+}
+
+// Submit forwards a share, done is called with the pool's verdict.
+func (u *Upstream) Submit(share proxy.Share, done func(*stratum.Error)) error {
+	u.mu.Lock()
+	prefix := len(u.noncePart1)
+	u.mu.Unlock()
+
+	if prefix > len(share.NoncePart1) {
+		return ErrUpstreamNonce
+	}
+
+	// The pool only knows its own part of nonce1, the rest is ours.
+	nonce := append(append([]byte{}, share.NoncePart1[prefix:]...), share.NoncePart2...)
+
+	return u.call(func(id uint64) stratum.Request {
+		base := stratum.RequestBase{ID: id, Method: stratum.Submit}
+		if u.dialect().IsBitcoin() {
+			return stratum.RequestSubmitBitcoin{
+				RequestBase: base,
+				Worker:      u.Config.Username,
+				Job:         share.Job,
+				NoncePart2:  nonce,
+				NTime:       share.NTime,
+				Nonce:       share.Nonce,
+
+				VersionBits:    share.Version & share.VersionMask,
+				HasVersionBits: share.VersionMask != 0,
+			}
+		}
+
+		return stratum.RequestSubmit{
+			RequestBase: base,
+			Worker:      u.Config.Username,
+			Job:         share.Job,
+			NTime:       share.NTime,
+			NoncePart2:  nonce,
+			Solution:    share.Solution,
+		}
+	}, func(resp stratum.ResponseGeneral) {
+		if ok, e := replyResult(resp); !ok {
+			done(e)
+			return
+		}
+
+		done(nil)
+	})
+}
+
+// replyID converts a JSON decoded ID back to ours.
+func replyID(id interface{}) (uint64, bool) {
+	switch id := id.(type) {
+	case float64:
+		return uint64(id), true
+	case string:
+		n, err := strconv.ParseUint(id, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// replyResult reports whether the pool accepted a request.
+func replyResult(resp stratum.ResponseGeneral) (bool, *stratum.Error) {
+	if e, ok := resp.Error.(*stratum.Error); ok && e != nil {
+		return false, e
+	}
+
+	result, _ := resp.Result.(*json.RawMessage)
+	if result == nil {
+		return false, stratum.ErrorOther
+	}
+
+	var ok bool
+	if err := json.Unmarshal(*result, &ok); err != nil || !ok {
+		return false, stratum.ErrorOther
+	}
+
+	return true, nil
+}
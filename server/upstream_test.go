@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+)
+
+func TestUpstreamWork(t *testing.T) {
+	tests := []struct {
+		name       string
+		dialect    stratum.Dialect
+		nbits      uint32
+		difficulty float64
+		n, k       int
+	}{
+		{name: "zcash before the target", dialect: stratum.Equihash, nbits: 0xffff071f, n: proxy.EquihashN, k: proxy.EquihashK},
+		{name: "zcash", dialect: stratum.Equihash, nbits: 0xffff071f, difficulty: 4, n: proxy.EquihashN, k: proxy.EquihashK},
+		{name: "bitcoin before the target", dialect: stratum.Bitcoin, nbits: 0x1d00ffff},
+		{name: "bitcoin", dialect: stratum.Bitcoin, nbits: 0x1d00ffff, difficulty: 2048},
+		{name: "bitcoin below one", dialect: stratum.Bitcoin, nbits: 0x1d00ffff, difficulty: 0.25},
+	}
+
+	for _, tt := range tests {
+		u := &Upstream{Config: UpstreamConfig{Dialect: tt.dialect}}
+		if tt.difficulty > 0 {
+			u.target = proxy.Difficulty(tt.difficulty).ToTarget(proxy.DialectAlgorithm(tt.dialect))
+		}
+
+		w := u.work(stratum.ResponseNotify{Job: "1", NBits: tt.nbits})
+		if w == nil {
+			t.Errorf("%s: no work", tt.name)
+			continue
+		}
+
+		if w.N != tt.n || w.K != tt.k {
+			t.Errorf("%s: equihash parameters %d, %d, want %d, %d", tt.name, w.N, w.K, tt.n, tt.k)
+		}
+
+		// Targets round the difficulty a little.
+		if d := float64(w.Difficulty); d < tt.difficulty*0.999999 || d > tt.difficulty*1.000001 {
+			t.Errorf("%s: difficulty %v, want %v", tt.name, d, tt.difficulty)
+		}
+
+		if w.Target == (stratum.Uint256{}) {
+			t.Errorf("%s: no block target", tt.name)
+		}
+	}
+
+	u := &Upstream{Config: UpstreamConfig{Dialect: stratum.Bitcoin}}
+	if w := u.work(stratum.ResponseNotify{Job: "1", NBits: 0x1d80ffff}); w != nil {
+		t.Errorf("negative nbits: got work %+v", w)
+	}
+}
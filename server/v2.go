@@ -0,0 +1,804 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+	"github.com/BTCChina/mining-pool-proxy/sv2"
+)
+
+// Stratum V2 miners are translated onto the sha256d work and upstream:
+// every channel gets a nonce1 of its own, like a V1 client, and its jobs
+// are built from the current proxy.Work.
+
+type (
+	// V2Config turns a listener into a Stratum V2 port.
+	V2Config struct {
+		// Hex secp256k1 secret key signing our certificates. Miners are
+		// configured with its public key, logged on startup.
+		AuthorityKey string `json:"authorityKey"`
+
+		// Lifetime of a certificate in seconds, a day by default.
+		CertValidity int `json:"certValidity"`
+	}
+
+	// V2Client is a Stratum V2 connection with any number of channels.
+	V2Client struct {
+		ID ClientID
+		ip net.IP
+
+		ps      *ProxyServer
+		conn    *sv2.Conn
+		limiter *proxy.TokenBucket
+
+		out       chan sv2.Message
+		done      chan struct{}
+		closeOnce sync.Once
+
+		mu          sync.Mutex
+		channels    map[uint32]*v2Channel
+		nextChannel uint32
+		jobs        map[uint32]v2Job
+		nextJob     uint32
+		// Jobs below firstJob were built on an older block.
+		firstJob    uint32
+		prevHash    stratum.Uint256
+		hasPrevHash bool
+
+		// Whether the miner may roll the version and within which bits,
+		// the mask is accessed atomically.
+		rolling     bool
+		versionMask uint32
+	}
+
+	v2Channel struct {
+		id       uint32
+		name     string
+		extended bool
+
+		noncePart1     []byte
+		noncePart2Size int
+		nonceLease     *nonceLease
+		difficulty     proxy.Difficulty
+		target         stratum.Uint256
+
+		// Difficulty of the shares accepted, fractions included.
+		sharesSum float64
+	}
+
+	v2Job struct {
+		work    *proxy.Work
+		channel uint32
+	}
+
+	// v2Keys of a listener's handshakes.
+	v2Keys struct {
+		authority *sv2.PrivateKey
+		static    *sv2.PrivateKey
+		validity  time.Duration
+
+		// Certificate of the static key, shared by all handshakes until
+		// half its validity is left.
+		mu      sync.Mutex
+		cert    sv2.Certificate
+		renewAt time.Time
+	}
+)
+
+const (
+	DefaultCertValidity = 24 * time.Hour
+	DefaultMaxChannels  = 64
+)
+
+// Error codes sent to V2 miners.
+const (
+	v2ErrProtocol       = "unsupported-protocol"
+	v2ErrVersion        = "protocol-version-mismatch"
+	v2ErrFlags          = "unsupported-feature-flags"
+	v2ErrUnknownUser    = "unknown-user"
+	v2ErrMaxTarget      = "max-target-out-of-range"
+	v2ErrExtranonceSize = "unsupported-min-extranonce-size"
+	v2ErrChannelID      = "invalid-channel-id"
+	v2ErrJobID          = "invalid-job-id"
+	v2ErrStale          = "stale-share"
+	v2ErrDifficulty     = "difficulty-too-low"
+	v2ErrDuplicate      = "duplicate-share"
+	v2ErrRejected       = "rejected"
+	v2ErrExtranonce     = "invalid-extranonce"
+	v2ErrShareVersion   = "invalid-version"
+	v2ErrNTime          = "ntime-out-of-range"
+	v2ErrNonceSpace     = "extranonce-space-exhausted"
+	v2ErrChannels       = "too-many-channels"
+)
+
+var ErrV2Setup = errors.New("expected SetupConnection")
+
+func (cfg V2Config) keys() (*v2Keys, error) {
+	authority, err := sv2.ParsePrivateKey(cfg.AuthorityKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Miners only pin the authority key, the static key can change on
+	// every start.
+	static, err := sv2.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	validity := DefaultCertValidity
+	if cfg.CertValidity > 0 {
+		validity = time.Duration(cfg.CertValidity) * time.Second
+	}
+
+	keys := &v2Keys{
+		authority: authority,
+		static:    static,
+		validity:  validity,
+	}
+
+	if _, err := keys.certificate(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// certificate returns the static key's certificate, signing a new one when
+// the current one is past half its validity.
+func (k *v2Keys) certificate(now time.Time) (sv2.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if now.Before(k.renewAt) {
+		return k.cert, nil
+	}
+
+	cert, err := sv2.NewCertificate(k.authority, k.static, now, k.validity)
+	if err != nil {
+		return k.cert, err
+	}
+
+	k.cert = cert
+	k.renewAt = now.Add(k.validity / 2)
+	return cert, nil
+}
+
+// handleV2 serves a Stratum V2 connection, executed in a goroutine.
+func (s *ProxyServer) handleV2(conn net.Conn, keys *v2Keys) error {
+	ip, err := s.admit(conn)
+	if err != nil {
+		return err
+	}
+	defer s.conns.release(ip.String())
+
+	cert, err := keys.certificate(time.Now())
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	sc, err := sv2.Accept(conn, keys.static, cert, time.Now().Add(InitTimeout))
+	if err != nil {
+		log.Printf("[server] noise handshake with %v failed: %v\n", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return err
+	}
+
+	sc.MaxPayloadSize = proxy.DefaultMaxMessageSize
+	if s.Config.Limits.MaxMessageSize > 0 {
+		sc.MaxPayloadSize = s.Config.Limits.MaxMessageSize
+	}
+
+	queueSize := s.Config.Limits.WriteQueue
+	if queueSize <= 0 {
+		queueSize = proxy.DefaultQueueSize
+	}
+
+	client := V2Client{
+		ID:   ClientID(atomic.AddUint64(&s.idCount, 1)),
+		ip:   ip,
+		ps:   s,
+		conn: sc,
+
+		out:  make(chan sv2.Message, queueSize),
+		done: make(chan struct{}),
+
+		channels: make(map[uint32]*v2Channel),
+		jobs:     make(map[uint32]v2Job),
+	}
+
+	if s.Config.Limits.MessageRate > 0 {
+		client.limiter = proxy.NewTokenBucket(s.Config.Limits.MessageRate, s.Config.Limits.MessageBurst)
+	}
+
+	go client.writeLoop()
+
+	return client.Serve()
+}
+
+func (s *ProxyServer) subscribeV2(c *V2Client) {
+	s.v2.Lock()
+	s.v2.m[c.ID] = c
+	s.v2.Unlock()
+}
+
+func (s *ProxyServer) unsubscribeV2(c *V2Client) {
+	s.v2.Lock()
+	delete(s.v2.m, c.ID)
+	s.v2.Unlock()
+}
+
+// Serve runs the client.
+func (c *V2Client) Serve() (err error) {
+	log.Printf("[client %v %v] -> serving stratum v2\n", c.ID, c.conn.RemoteAddr())
+	defer func() {
+		_ = c.Close()
+
+		if err != nil {
+			log.Printf("[client %v %v] <-!- disconnected with error: %v\n", c.ID, c.conn.RemoteAddr(), err)
+			return
+		}
+
+		log.Printf("[client %v %v] <- disconnected\n", c.ID, c.conn.RemoteAddr())
+	}()
+
+	if err := c.setup(); err != nil {
+		return err
+	}
+
+	c.ps.subscribeV2(c)
+	defer c.ps.unsubscribeV2(c)
+	defer c.closeChannels()
+
+	for {
+		msg, err := c.read(time.Now().Add(InactivityTimeout))
+		switch err {
+		case nil:
+		case sv2.ErrUnknownMessage:
+			continue
+		case sv2.ErrBadInput:
+			c.ps.Strike(c.ip, BanMalformed)
+			continue
+		case sv2.ErrFrameTooLarge:
+			c.ps.Strike(c.ip, BanMalformed)
+			return err
+		default:
+			return err
+		}
+
+		if c.limiter != nil && !c.limiter.Take() {
+			c.ps.Strike(c.ip, BanRateLimited)
+			return ErrRateLimited
+		}
+
+		if err := c.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *V2Client) read(deadline time.Time) (sv2.Message, error) {
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	frame, err := c.conn.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	return sv2.Parse(frame)
+}
+
+// setup answers the miner's SetupConnection.
+func (c *V2Client) setup() error {
+	msg, err := c.read(time.Now().Add(InitTimeout))
+	if err != nil {
+		return err
+	}
+
+	setup, ok := msg.(sv2.SetupConnection)
+	if !ok {
+		return ErrV2Setup
+	}
+
+	mask := c.ps.versionMask()
+
+	code := ""
+	switch {
+	case setup.Protocol != sv2.ProtocolMining:
+		code = v2ErrProtocol
+	case setup.MinVersion > sv2.ProtocolVersion || setup.MaxVersion < sv2.ProtocolVersion:
+		code = v2ErrVersion
+	case setup.Flags&sv2.FlagRequiresWorkSelection != 0:
+		// Jobs come from upstream.
+		code = v2ErrFlags
+	case setup.Flags&sv2.FlagRequiresVersionRolling != 0 && mask == 0:
+		code = v2ErrFlags
+	}
+
+	if code != "" {
+		_ = c.send(sv2.SetupConnectionError{Flags: setup.Flags, ErrorCode: code})
+		return errors.New(code)
+	}
+
+	log.Printf("[client %v %v] '%v' '%v' '%v'\n", c.ID, c.conn.RemoteAddr(), setup.Vendor, setup.HardwareVersion, setup.Firmware)
+
+	flags := uint32(0)
+	if mask == 0 {
+		flags |= sv2.FlagRequiresFixedVersion
+	} else {
+		c.rolling = true
+		atomic.StoreUint32(&c.versionMask, mask)
+	}
+
+	return c.send(sv2.SetupConnectionSuccess{
+		UsedVersion: sv2.ProtocolVersion,
+		Flags:       flags,
+	})
+}
+
+func (c *V2Client) handle(msg sv2.Message) error {
+	switch msg := msg.(type) {
+	case sv2.OpenStandardMiningChannel:
+		return c.openChannel(msg.RequestID, msg.UserIdentity, msg.MaxTarget, false, 0)
+
+	case sv2.OpenExtendedMiningChannel:
+		return c.openChannel(msg.RequestID, msg.UserIdentity, msg.MaxTarget, true, int(msg.MinExtranonceSize))
+
+	case sv2.UpdateChannel:
+		return c.updateChannel(msg)
+
+	case sv2.CloseChannel:
+		c.mu.Lock()
+		if ch, ok := c.channels[msg.ChannelID]; ok {
+			ch.nonceLease.release()
+			delete(c.channels, msg.ChannelID)
+		}
+		c.mu.Unlock()
+		return nil
+
+	case sv2.SubmitSharesStandard:
+		return c.submit(msg, nil)
+
+	case sv2.SubmitSharesExtended:
+		return c.submit(msg.SubmitSharesStandard, msg.Extranonce)
+
+	default:
+		return nil
+	}
+}
+
+func (c *V2Client) openChannel(requestID uint32, name string, maxTarget [32]byte, extended bool, minExtranonceSize int) error {
+	// V2 has no password, miners can't connect when one is required.
+	if !c.ps.checkAuth(name, "") {
+		log.Printf("[client %v %v] failed to authorize '%v'\n", c.ID, c.conn.RemoteAddr(), name)
+		c.ps.Strike(c.ip, BanFailedAuth)
+
+		return c.send(sv2.OpenMiningChannelError{RequestID: requestID, ErrorCode: v2ErrUnknownUser})
+	}
+
+	difficulty, target, ok := c.channelTarget(proxy.Difficulty(c.ps.Config.Difficulty), maxTarget)
+	if !ok {
+		return c.send(sv2.OpenMiningChannelError{RequestID: requestID, ErrorCode: v2ErrMaxTarget})
+	}
+
+	// Channels are opened one message at a time, so the count holds until
+	// this one is added.
+	c.mu.Lock()
+	open := len(c.channels)
+	c.mu.Unlock()
+	if open >= c.ps.Config.Limits.maxChannels() {
+		log.Printf("[client %v %v] channel for '%v' refused: %v open\n", c.ID, c.conn.RemoteAddr(), name, open)
+		return c.send(sv2.OpenMiningChannelError{RequestID: requestID, ErrorCode: v2ErrChannels})
+	}
+
+	noncePart1, noncePart2Size, lease, err := c.ps.clientNonce(stratum.Bitcoin, ClientID(atomic.AddUint64(&c.ps.idCount, 1)))
+	if err != nil {
+		log.Printf("[client %v %v] channel for '%v' refused: %v\n", c.ID, c.conn.RemoteAddr(), name, err)
+		return c.send(sv2.OpenMiningChannelError{RequestID: requestID, ErrorCode: v2ErrNonceSpace})
+	}
+
+	if extended && minExtranonceSize > noncePart2Size || !extended && len(noncePart1)+noncePart2Size > 32 {
+		lease.release()
+		return c.send(sv2.OpenMiningChannelError{RequestID: requestID, ErrorCode: v2ErrExtranonceSize})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextChannel++
+	ch := &v2Channel{
+		id:       c.nextChannel,
+		name:     name,
+		extended: extended,
+
+		noncePart1:     noncePart1,
+		noncePart2Size: noncePart2Size,
+		nonceLease:     lease,
+		difficulty:     difficulty,
+		target:         target,
+	}
+	c.channels[ch.id] = ch
+
+	log.Printf("[client %v %v] channel %v '%v'\n", c.ID, c.conn.RemoteAddr(), ch.id, name)
+
+	if extended {
+		err = c.send(sv2.OpenExtendedMiningChannelSuccess{
+			RequestID:        requestID,
+			ChannelID:        ch.id,
+			Target:           reverse(target),
+			ExtranonceSize:   uint16(noncePart2Size),
+			ExtranoncePrefix: noncePart1,
+		})
+	} else {
+		err = c.send(sv2.OpenStandardMiningChannelSuccess{
+			RequestID:        requestID,
+			ChannelID:        ch.id,
+			Target:           reverse(target),
+			ExtranoncePrefix: append(append([]byte{}, noncePart1...), make([]byte, noncePart2Size)...),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if !c.hasPrevHash {
+		return nil
+	}
+
+	// Start the channel on the current block.
+	if work := c.ps.CurrentWork(stratum.Bitcoin); work != nil && work.HashPrevBlock == c.prevHash {
+		return c.sendJob(ch, work, true)
+	}
+
+	return nil
+}
+
+// closeChannels drops the client's channels, giving back their nonces.
+func (c *V2Client) closeChannels() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, ch := range c.channels {
+		ch.nonceLease.release()
+		delete(c.channels, id)
+	}
+}
+
+// channelTarget lowers the share target to the miner's maximum.
+func (c *V2Client) channelTarget(difficulty proxy.Difficulty, maxTarget [32]byte) (proxy.Difficulty, stratum.Uint256, bool) {
+	target := difficulty.ToTarget(proxy.SHA256d)
+
+	max := stratum.Uint256(reverse(maxTarget))
+	if max == (stratum.Uint256{}) {
+		return 0, target, false
+	}
+
+	if proxy.TargetCompare(target, max) > 0 {
+		return proxy.FromTarget(max, proxy.SHA256d), max, true
+	}
+
+	return difficulty, target, true
+}
+
+func (c *V2Client) updateChannel(req sv2.UpdateChannel) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.channels[req.ChannelID]
+	if !ok {
+		return c.send(sv2.UpdateChannelError{ChannelID: req.ChannelID, ErrorCode: v2ErrChannelID})
+	}
+
+	difficulty, target, ok := c.channelTarget(ch.difficulty, req.MaxTarget)
+	if !ok {
+		return c.send(sv2.UpdateChannelError{ChannelID: req.ChannelID, ErrorCode: v2ErrMaxTarget})
+	}
+
+	if target == ch.target {
+		return nil
+	}
+
+	ch.difficulty, ch.target = difficulty, target
+	return c.send(sv2.SetTarget{ChannelID: ch.id, MaxTarget: reverse(target)})
+}
+
+// setWork sends a job built on w to every channel.
+func (c *V2Client) setWork(w *proxy.Work) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	newBlock := w.CleanJobs || !c.hasPrevHash || w.HashPrevBlock != c.prevHash
+	if newBlock {
+		c.jobs = make(map[uint32]v2Job)
+		c.firstJob = c.nextJob + 1
+		c.prevHash = w.HashPrevBlock
+		c.hasPrevHash = true
+	}
+
+	for _, ch := range c.channels {
+		if err := c.sendJob(ch, w, newBlock); err != nil {
+			log.Printf("[client %v %v] could not notify: %v\n", c.ID, c.conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// sendJob sends a job on w to the channel, c.mu held. A job for a new block
+// is sent as a future job and started with SetNewPrevHash.
+func (c *V2Client) sendJob(ch *v2Channel, w *proxy.Work, newBlock bool) error {
+	c.nextJob++
+	id := c.nextJob
+	c.jobs[id] = v2Job{work: w, channel: ch.id}
+
+	var minNTime *uint32
+	if !newBlock {
+		nTime := w.NTime
+		minNTime = &nTime
+	}
+
+	var err error
+	if ch.extended {
+		path := make([][32]byte, len(w.MerkleBranch))
+		for i, branch := range w.MerkleBranch {
+			path[i] = branch
+		}
+
+		err = c.send(sv2.NewExtendedMiningJob{
+			ChannelID:             ch.id,
+			JobID:                 id,
+			MinNTime:              minNTime,
+			Version:               w.Version,
+			VersionRollingAllowed: atomic.LoadUint32(&c.versionMask) != 0,
+			MerklePath:            path,
+			CoinbaseTxPrefix:      w.Coinbase1,
+			CoinbaseTxSuffix:      w.Coinbase2,
+		})
+	} else {
+		err = c.send(sv2.NewMiningJob{
+			ChannelID:  ch.id,
+			JobID:      id,
+			MinNTime:   minNTime,
+			Version:    w.Version,
+			MerkleRoot: w.MerkleRoot(ch.noncePart1, make([]byte, ch.noncePart2Size)),
+		})
+	}
+	if err != nil || !newBlock {
+		return err
+	}
+
+	return c.send(sv2.SetNewPrevHash{
+		ChannelID: ch.id,
+		JobID:     id,
+		PrevHash:  proxy.HeaderPrevHash(w.HashPrevBlock),
+		MinNTime:  w.NTime,
+		NBits:     w.NBits,
+	})
+}
+
+func (c *V2Client) submit(req sv2.SubmitSharesStandard, extranonce []byte) error {
+	c.mu.Lock()
+	ch, ok := c.channels[req.ChannelID]
+	var difficulty proxy.Difficulty
+	var noncePart1 []byte
+	var noncePart2Size int
+	var target stratum.Uint256
+	var name string
+	if ok {
+		difficulty, target, name = ch.difficulty, ch.target, ch.name
+		noncePart1, noncePart2Size = ch.noncePart1, ch.noncePart2Size
+	}
+	job, jobOK := c.jobs[req.JobID]
+	stale := !jobOK && req.JobID > 0 && req.JobID < c.firstJob
+	c.mu.Unlock()
+
+	reject := func(code string) error {
+		return c.send(sv2.SubmitSharesError{
+			ChannelID:      req.ChannelID,
+			SequenceNumber: req.SequenceNumber,
+			ErrorCode:      code,
+		})
+	}
+
+	switch {
+	case !ok:
+		return reject(v2ErrChannelID)
+	case stale:
+		c.ps.countRejection(RejectStale)
+		return reject(v2ErrStale)
+	case !jobOK || job.channel != req.ChannelID:
+		c.ps.countRejection(RejectJobNotFound)
+		return reject(v2ErrJobID)
+	}
+
+	noncePart2 := extranonce
+	if !ch.extended {
+		noncePart2 = make([]byte, noncePart2Size)
+	} else if len(extranonce) != noncePart2Size {
+		c.ps.Strike(c.ip, BanMalformed)
+		c.ps.countRejection(RejectMalformed)
+		return reject(v2ErrExtranonce)
+	}
+
+	share := proxy.Share{
+		Job:        job.work.Job,
+		NTime:      req.NTime,
+		NoncePart1: noncePart1,
+		NoncePart2: noncePart2,
+		Nonce:      req.Nonce,
+
+		Version:     req.Version,
+		VersionMask: atomic.LoadUint32(&c.versionMask),
+	}
+
+	_, status := c.ps.Job(stratum.Bitcoin, job.work.Job)
+	c.ps.countShare(name, status)
+	if status == proxy.JobPreviousBlock || status == proxy.JobUnknown {
+		c.ps.countRejection(RejectStale)
+		return reject(v2ErrStale)
+	}
+
+	if !c.ps.checkNTime(name, job.work, req.NTime) {
+		return reject(v2ErrNTime)
+	}
+
+	result := job.work.CheckShare(share, target)
+	switch result {
+	case proxy.ShareInvalid:
+		c.ps.Strike(c.ip, BanInvalidShare)
+		c.ps.countRejection(RejectLowDifficulty)
+		return reject(v2ErrDifficulty)
+
+	case proxy.ShareInvalidVersion:
+		c.ps.Strike(c.ip, BanInvalidShare)
+		c.ps.countRejection(RejectVersion)
+		return reject(v2ErrShareVersion)
+	}
+
+	if c.ps.seenShare(stratum.Bitcoin, share) {
+		c.ps.countDuplicate(name)
+		return reject(v2ErrDuplicate)
+	}
+
+	if result == proxy.ShareBlock {
+		c.ps.blockFound(stratum.Bitcoin, name, difficulty, job.work, share)
+	}
+
+	accept := func() error {
+		return c.send(sv2.SubmitSharesSuccess{
+			ChannelID:               req.ChannelID,
+			LastSequenceNumber:      req.SequenceNumber,
+			NewSubmitsAcceptedCount: 1,
+			NewSharesSum:            c.addShare(ch, difficulty),
+		})
+	}
+
+	upstream, ok := c.ps.upstream(stratum.Bitcoin)
+	if !ok {
+		// Solo, the share is as good as we know.
+		if result != proxy.ShareBlock {
+			c.ps.addRoundShare(stratum.Bitcoin, difficulty)
+		}
+
+		return accept()
+	}
+
+	// Relay the pool's verdict once it arrives, keep reading meanwhile.
+	err := upstream.Submit(share, func(e *stratum.Error) {
+		if e != nil {
+			c.ps.countRejection(RejectUpstream)
+			_ = reject(v2ShareError(e))
+			return
+		}
+
+		// A block share was counted when it ended the round.
+		if result != proxy.ShareBlock {
+			c.ps.addRoundShare(stratum.Bitcoin, difficulty)
+		}
+
+		_ = accept()
+	})
+	if err != nil {
+		return reject(v2ErrRejected)
+	}
+
+	return nil
+}
+
+// addShare adds an accepted share's difficulty to its channel's sum and
+// returns how far the sum's whole part moved, so shares below difficulty 1
+// add up instead of each counting as none.
+func (c *V2Client) addShare(ch *v2Channel, difficulty proxy.Difficulty) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := uint64(ch.sharesSum)
+	ch.sharesSum += float64(difficulty)
+	return uint64(ch.sharesSum) - before
+}
+
+// v2ShareError translates a pool's rejection.
+func v2ShareError(e *stratum.Error) string {
+	switch e.Code {
+	case stratum.ErrorJobNotFound.Code:
+		return v2ErrStale
+	case stratum.ErrorDuplicate.Code:
+		return v2ErrDuplicate
+	case stratum.ErrorLowDifficulty.Code:
+		return v2ErrDifficulty
+	default:
+		return v2ErrRejected
+	}
+}
+
+// send queues a message, closing the connection when the queue is full.
+func (c *V2Client) send(msg sv2.Message) error {
+	select {
+	case <-c.done:
+		return proxy.ErrWriterClosed
+	default:
+	}
+
+	select {
+	case c.out <- msg:
+		return nil
+	default:
+		c.ps.count(CounterQueueOverflows)
+		_ = c.Close()
+		return proxy.ErrQueueFull
+	}
+}
+
+func (c *V2Client) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+
+		case msg := <-c.out:
+			frame, err := sv2.Marshal(msg)
+			if err != nil {
+				log.Printf("[client %v %v] could not marshal %T: %v\n", c.ID, c.conn.RemoteAddr(), msg, err)
+				continue
+			}
+
+			if err := c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+				_ = c.Close()
+				return
+			}
+
+			if err := c.conn.WriteFrame(frame); err != nil {
+				_ = c.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close the client's connection.
+func (c *V2Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+	})
+
+	return err
+}
+
+// reverse converts between our big-endian targets and V2's little-endian U256.
+func reverse(x [32]byte) [32]byte {
+	for i, j := 0, len(x)-1; i < j; i, j = i+1, j-1 {
+		x[i], x[j] = x[j], x[i]
+	}
+
+	return x
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+	"github.com/BTCChina/mining-pool-proxy/stratum"
+	"github.com/BTCChina/mining-pool-proxy/sv2"
+)
+
+const testAuthorityKey = "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef"
+
+func TestV2Keys(t *testing.T) {
+	tests := []struct {
+		cfg      V2Config
+		validity time.Duration
+		err      bool
+	}{
+		{cfg: V2Config{AuthorityKey: testAuthorityKey}, validity: DefaultCertValidity},
+		{cfg: V2Config{AuthorityKey: testAuthorityKey, CertValidity: 3600}, validity: time.Hour},
+		{cfg: V2Config{}, err: true},
+		{cfg: V2Config{AuthorityKey: "0000000000000000000000000000000000000000000000000000000000000000"}, err: true},
+	}
+
+	for _, tt := range tests {
+		keys, err := tt.cfg.keys()
+		if tt.err {
+			if err == nil {
+				t.Errorf("%+v: want an error", tt.cfg)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%+v: %v", tt.cfg, err)
+			continue
+		}
+
+		if keys.validity != tt.validity {
+			t.Errorf("%+v: validity %v, want %v", tt.cfg, keys.validity, tt.validity)
+		}
+	}
+}
+
+// Handshakes share a certificate, signing one per connection would let
+// anyone make the proxy sign at will.
+func TestV2Certificate(t *testing.T) {
+	authority, err := sv2.ParsePrivateKey(testAuthorityKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	static, err := sv2.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := &v2Keys{authority: authority, static: static, validity: time.Hour}
+
+	start := time.Unix(1700000000, 0)
+	first, err := keys.certificate(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at    time.Duration
+		renew bool
+	}{
+		{at: time.Second},
+		{at: 29 * time.Minute},
+		{at: 30 * time.Minute, renew: true},
+		{at: 45 * time.Minute},
+	}
+
+	prev := first
+	for _, tt := range tests {
+		cert, err := keys.certificate(start.Add(tt.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if renewed := cert != prev; renewed != tt.renew {
+			t.Errorf("at %v: renewed %v, want %v", tt.at, renewed, tt.renew)
+		}
+
+		if now := uint32(start.Add(tt.at).Unix()); now < cert.ValidFrom || now >= cert.NotValidAfter {
+			t.Errorf("at %v: certificate valid from %d to %d", tt.at, cert.ValidFrom, cert.NotValidAfter)
+		}
+
+		prev = cert
+	}
+}
+
+// testV2Client is a V2 connection to ps whose messages queue on out.
+func testV2Client(t *testing.T, ps *ProxyServer) *V2Client {
+	t.Helper()
+
+	server, miner := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = miner.Close()
+	})
+
+	return &V2Client{
+		ps:       ps,
+		conn:     &sv2.Conn{Conn: server},
+		out:      make(chan sv2.Message, 16),
+		done:     make(chan struct{}),
+		channels: make(map[uint32]*v2Channel),
+		jobs:     make(map[uint32]v2Job),
+	}
+}
+
+// Each channel takes a nonce1, so one connection mustn't open them all.
+func TestV2ChannelLimit(t *testing.T) {
+	ps := &ProxyServer{Config: Config{Difficulty: 1, Limits: LimitsConfig{MaxChannels: 2}}}
+	u := &Upstream{Config: UpstreamConfig{Dialect: stratum.Bitcoin}, ps: ps, slots: newNonceSlots(nonceSlotCount)}
+	if err := u.setNoncePart1([]byte{0x01}, 6); err != nil {
+		t.Fatal(err)
+	}
+	ps.upstreams.active = map[stratum.Dialect]*Upstream{stratum.Bitcoin: u}
+
+	c := testV2Client(t, ps)
+	open := func(id uint32) sv2.Message {
+		var max [32]byte
+		for i := range max {
+			max[i] = 0xff
+		}
+
+		if err := c.handle(sv2.OpenStandardMiningChannel{RequestID: id, UserIdentity: "worker", MaxTarget: max}); err != nil {
+			t.Fatal(err)
+		}
+		return <-c.out
+	}
+
+	for id := uint32(1); id <= 2; id++ {
+		if msg, ok := open(id).(sv2.OpenStandardMiningChannelSuccess); !ok {
+			t.Fatalf("channel %d: %#v", id, msg)
+		}
+	}
+
+	if msg := open(3); msg != (sv2.OpenMiningChannelError{RequestID: 3, ErrorCode: v2ErrChannels}) {
+		t.Errorf("third channel: %#v", msg)
+	}
+	if n := u.slots.available(); n != nonceSlotCount-2 {
+		t.Errorf("%d slots available, want %d", n, nonceSlotCount-2)
+	}
+
+	// Closing one makes room.
+	if err := c.handle(sv2.CloseChannel{ChannelID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := open(4).(sv2.OpenStandardMiningChannelSuccess); !ok {
+		t.Errorf("channel after closing one: %#v", msg)
+	}
+}
+
+func TestV2SharesSum(t *testing.T) {
+	tests := []struct {
+		difficulty proxy.Difficulty
+		sums       []uint64
+	}{
+		{difficulty: 0.25, sums: []uint64{0, 0, 0, 1, 0, 0, 0, 1}},
+		{difficulty: 1.5, sums: []uint64{1, 2, 1, 2}},
+		{difficulty: 1024, sums: []uint64{1024, 1024}},
+	}
+
+	for _, tt := range tests {
+		c := &V2Client{}
+		ch := &v2Channel{}
+
+		for i, want := range tt.sums {
+			if got := c.addShare(ch, tt.difficulty); got != want {
+				t.Errorf("difficulty %v, share %d: sum %d, want %d", tt.difficulty, i, got, want)
+			}
+		}
+	}
+}
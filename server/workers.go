@@ -0,0 +1,168 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+)
+
+type (
+	// WorkerStats counts a worker's shares by the state of their job and how
+	// they were turned down.
+	WorkerStats struct {
+		Shares        int64 `json:"shares"`
+		Stale         int64 `json:"stale"`
+		PreviousBlock int64 `json:"previousBlock"`
+		Duplicates    int64 `json:"duplicates"`
+		// Rolled ntime out of range, usually a broken clock.
+		BadNTime int64 `json:"badNTime"`
+
+		// Share of submissions on superseded jobs, either kind.
+		StaleRate float64 `json:"staleRate"`
+
+		LastShare time.Time `json:"lastShare"`
+	}
+
+	workerStats struct {
+		mu sync.Mutex
+		m  map[string]*WorkerStats
+	}
+)
+
+// Counter names
+const (
+	CounterStaleShares         = "stale_shares"
+	CounterPreviousBlockShares = "previous_block_shares"
+)
+
+// Workers are forgotten a day after their last share, and the least recently
+// seen one makes room for a new worker once maxWorkerStats are tracked.
+const (
+	workerStatsExpiry = 24 * time.Hour
+	maxWorkerStats    = 10000
+)
+
+func newWorkerStats() *workerStats {
+	return &workerStats{
+		m: make(map[string]*WorkerStats),
+	}
+}
+
+// countShare records a share a worker submitted on a job in status.
+func (s *ProxyServer) countShare(worker string, status proxy.JobStatus) {
+	switch status {
+	case proxy.JobStale:
+		s.count(CounterStaleShares)
+	case proxy.JobPreviousBlock:
+		s.count(CounterPreviousBlockShares)
+	}
+
+	s.workers.update(worker, func(stats *WorkerStats) {
+		stats.Shares++
+		switch status {
+		case proxy.JobStale:
+			stats.Stale++
+		case proxy.JobPreviousBlock:
+			stats.PreviousBlock++
+		}
+	})
+}
+
+// checkNTime checks a share's ntime against the job and the clock, counting
+// a rejection when it is out of range.
+func (s *ProxyServer) checkNTime(worker string, work *proxy.Work, nTime uint32) bool {
+	switch work.CheckNTime(nTime, time.Now(), s.Config.NTime.limits()) {
+	case proxy.ShareNTimeTooOld:
+		s.countRejection(RejectNTimeTooOld)
+	case proxy.ShareNTimeTooNew:
+		s.countRejection(RejectNTimeTooNew)
+	default:
+		return true
+	}
+
+	s.workers.update(worker, func(stats *WorkerStats) {
+		stats.BadNTime++
+	})
+	return false
+}
+
+// countDuplicate records a share a worker had already submitted.
+func (s *ProxyServer) countDuplicate(worker string) {
+	s.countRejection(RejectDuplicate)
+	s.workers.update(worker, func(stats *WorkerStats) {
+		stats.Duplicates++
+	})
+}
+
+func (w *workerStats) update(worker string, f func(*WorkerStats)) {
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats, ok := w.m[worker]
+	if !ok {
+		if len(w.m) >= maxWorkerStats {
+			w.evict(now)
+		}
+
+		stats = new(WorkerStats)
+		w.m[worker] = stats
+	}
+
+	stats.LastShare = now
+	f(stats)
+}
+
+// evict drops the expired workers, or the least recently seen one when none
+// are.
+func (w *workerStats) evict(now time.Time) {
+	var oldest string
+	for name, stats := range w.m {
+		if now.Sub(stats.LastShare) > workerStatsExpiry {
+			delete(w.m, name)
+			continue
+		}
+
+		if oldest == "" || stats.LastShare.Before(w.m[oldest].LastShare) {
+			oldest = name
+		}
+	}
+
+	if len(w.m) >= maxWorkerStats {
+		delete(w.m, oldest)
+	}
+}
+
+// Workers returns the share counts of every worker that submitted one in
+// the last day.
+func (s *ProxyServer) Workers() map[string]WorkerStats {
+	now := time.Now()
+
+	s.workers.mu.Lock()
+	defer s.workers.mu.Unlock()
+
+	result := make(map[string]WorkerStats, len(s.workers.m))
+	for name, stats := range s.workers.m {
+		if now.Sub(stats.LastShare) > workerStatsExpiry {
+			delete(s.workers.m, name)
+			continue
+		}
+
+		w := *stats
+		if w.Shares > 0 {
+			w.StaleRate = float64(w.Stale+w.PreviousBlock) / float64(w.Shares)
+		}
+
+		result[name] = w
+	}
+
+	return result
+}
+
+// GET /workers
+func (s *ProxyServer) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Workers())
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BTCChina/mining-pool-proxy/proxy"
+)
+
+func TestCountShare(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	for _, status := range []proxy.JobStatus{proxy.JobCurrent, proxy.JobCurrent, proxy.JobStale, proxy.JobPreviousBlock} {
+		s.countShare("a.1", status)
+	}
+	s.countShare("b.1", proxy.JobUnknown)
+	s.countDuplicate("b.1")
+
+	workers := s.Workers()
+	a, b := workers["a.1"], workers["b.1"]
+
+	if a.Shares != 4 || a.Stale != 1 || a.PreviousBlock != 1 || a.StaleRate != 0.5 {
+		t.Errorf("a.1: %+v", a)
+	}
+	if b.Shares != 1 || b.Duplicates != 1 || b.StaleRate != 0 {
+		t.Errorf("b.1: %+v", b)
+	}
+	if a.LastShare.IsZero() || b.LastShare.IsZero() {
+		t.Errorf("last shares %v and %v", a.LastShare, b.LastShare)
+	}
+
+	for counter, want := range map[string]int64{
+		CounterStaleShares:            1,
+		CounterPreviousBlockShares:    1,
+		"rejected_" + RejectDuplicate: 1,
+	} {
+		if v := s.counters.Get(counter); v == nil || v.String() != fmt.Sprint(want) {
+			t.Errorf("counter %s = %v, want %d", counter, v, want)
+		}
+	}
+}
+
+func TestWorkerStatsExpiry(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	s.countShare("idle", proxy.JobCurrent)
+	s.countShare("active", proxy.JobCurrent)
+	s.workers.m["idle"].LastShare = time.Now().Add(-workerStatsExpiry - time.Minute)
+
+	workers := s.Workers()
+	if _, ok := workers["idle"]; ok {
+		t.Error("listed a worker idle for over a day")
+	}
+	if _, ok := workers["active"]; !ok {
+		t.Error("active worker not listed")
+	}
+	if _, ok := s.workers.m["idle"]; ok {
+		t.Error("kept an expired worker")
+	}
+}
+
+func TestWorkerStatsLimit(t *testing.T) {
+	w := newWorkerStats()
+	count := func(*WorkerStats) {}
+
+	for i := 0; i < maxWorkerStats; i++ {
+		w.update(fmt.Sprint("worker.", i), count)
+	}
+
+	// A full table makes room by dropping the least recently seen worker.
+	w.m["worker.7"].LastShare = time.Now().Add(-time.Hour)
+	w.update("new", count)
+
+	if len(w.m) != maxWorkerStats {
+		t.Errorf("tracking %d workers, want %d", len(w.m), maxWorkerStats)
+	}
+	if _, ok := w.m["worker.7"]; ok {
+		t.Error("kept the least recently seen worker")
+	}
+
+	// Or all the expired ones.
+	expired := time.Now().Add(-workerStatsExpiry - time.Minute)
+	for _, name := range []string{"worker.1", "worker.2", "worker.3"} {
+		w.m[name].LastShare = expired
+	}
+	w.update("newer", count)
+
+	if len(w.m) != maxWorkerStats-2 {
+		t.Errorf("tracking %d workers, want %d", len(w.m), maxWorkerStats-2)
+	}
+	if _, ok := w.m["newer"]; !ok {
+		t.Error("new worker not tracked")
+	}
+
+	// Known workers don't evict anyone.
+	w.update("worker.8", count)
+	if len(w.m) != maxWorkerStats-2 {
+		t.Errorf("tracking %d workers after a known one, want %d", len(w.m), maxWorkerStats-2)
+	}
+}
@@ -0,0 +1,308 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+)
+
+// ParseServerMessage decodes a line sent by a pool in the Equihash dialect.
+func ParseServerMessage(data []byte) (Response, error) {
+	return Equihash.ParseServerMessage(data)
+}
+
+// ParseServerMessage decodes a line sent by a pool: either a notification
+// or a ResponseGeneral replying to one of our requests. Replies carry the raw
+// *json.RawMessage result and an *Error, both left nil when null.
+func (d Dialect) ParseServerMessage(data []byte) (Response, error) {
+	var raw RawRPC
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if raw.Method == "" {
+		return parseReply(raw)
+	}
+
+	switch ResponseType(raw.Method) {
+	case Notify:
+		if d.IsBitcoin() {
+			return parseNotifyBitcoin(raw)
+		}
+
+		var params []json.RawMessage
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 8 {
+			return nil, ErrBadInput
+		}
+
+		var fields [7]string
+		for i := range fields {
+			if err := json.Unmarshal(params[i], &fields[i]); err != nil {
+				return nil, ErrBadInput
+			}
+		}
+
+		var clean bool
+		if err := json.Unmarshal(params[7], &clean); err != nil {
+			return nil, ErrBadInput
+		}
+
+		notify := ResponseNotify{
+			Job:       fields[0],
+			CleanJobs: clean,
+		}
+
+		var err error
+		if notify.Version, err = HexToUint32(fields[1]); err != nil {
+			return nil, ErrBadInput
+		}
+		if notify.HashPrevBlock, err = HexToUint256(fields[2]); err != nil {
+			return nil, ErrBadInput
+		}
+		if notify.HashMerkleRoot, err = HexToUint256(fields[3]); err != nil {
+			return nil, ErrBadInput
+		}
+		if notify.HashReserved, err = HexToUint256(fields[4]); err != nil {
+			return nil, ErrBadInput
+		}
+		if notify.NTime, err = HexToUint32(fields[5]); err != nil {
+			return nil, ErrBadInput
+		}
+		if notify.NBits, err = HexToUint32(fields[6]); err != nil {
+			return nil, ErrBadInput
+		}
+
+		return notify, nil
+
+	case SetDifficulty:
+		var params []json.Number
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 1 {
+			return nil, ErrBadInput
+		}
+
+		difficulty, err := params[0].Float64()
+		if err != nil {
+			return nil, ErrBadInput
+		}
+
+		return ResponseSetDifficulty{
+			Difficulty: difficulty,
+		}, nil
+
+	case SetTarget:
+		var params []string
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 1 {
+			return nil, ErrBadInput
+		}
+
+		target, err := HexToUint256(params[0])
+		if err != nil {
+			return nil, ErrBadInput
+		}
+
+		return ResponseSetTarget{
+			Target: target,
+		}, nil
+
+	case Extranonce:
+		var params []json.RawMessage
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 1 {
+			return nil, ErrBadInput
+		}
+
+		var nonce string
+		if err := json.Unmarshal(params[0], &nonce); err != nil {
+			return nil, ErrBadInput
+		}
+
+		resp := ResponseSetExtranonce{}
+		var err error
+		if resp.NoncePart1, err = hex.DecodeString(nonce); err != nil {
+			return nil, ErrBadInput
+		}
+
+		if len(params) > 1 {
+			if err := json.Unmarshal(params[1], &resp.NoncePart2Size); err != nil {
+				return nil, ErrBadInput
+			}
+		}
+
+		return resp, nil
+
+	case Reconnect:
+		var params []interface{}
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		resp := ResponseReconnect{}
+		if len(params) > 0 {
+			host, ok := params[0].(string)
+			if !ok {
+				return nil, ErrBadInput
+			}
+			resp.Host = host
+		}
+
+		if len(params) > 1 {
+			// Pools send the port as either a number or a string.
+			switch port := params[1].(type) {
+			case float64:
+				resp.Port = int(port)
+			case string:
+				p, err := strconv.Atoi(port)
+				if err != nil {
+					return nil, ErrBadInput
+				}
+				resp.Port = p
+			default:
+				return nil, ErrBadInput
+			}
+		}
+
+		if len(params) > 2 {
+			wait, ok := params[2].(float64)
+			if !ok {
+				return nil, ErrBadInput
+			}
+			resp.Wait = int(wait)
+		}
+
+		return resp, nil
+
+	case ShowMessage:
+		var params []string
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 1 {
+			return nil, ErrBadInput
+		}
+
+		return ResponseShowMessage{
+			Message: params[0],
+		}, nil
+
+	case SetVersionMask:
+		return parseSetVersionMask(raw)
+
+	case Version:
+		return ResponseGetVersion{
+			ID: raw.ID,
+		}, nil
+
+	default:
+		return nil, ErrUnknownType
+	}
+}
+
+func parseReply(raw RawRPC) (Response, error) {
+	if raw.ID == nil {
+		return nil, ErrUnknownType
+	}
+
+	resp := ResponseGeneral{
+		ID: raw.ID,
+	}
+
+	if raw.Result != nil {
+		resp.Result = raw.Result
+	}
+
+	if raw.Error != nil {
+		var e Error
+		if err := json.Unmarshal(*raw.Error, &e); err != nil {
+			return nil, err
+		}
+
+		resp.Error = &e
+	}
+
+	return resp, nil
+}
+
+// UnmarshalJSON accepts [code, message, traceback] as well as the
+// {"code": ..., "message": ...} form some pools use.
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var list []interface{}
+	if err := json.Unmarshal(data, &list); err == nil {
+		if len(list) < 2 {
+			return ErrBadInput
+		}
+
+		code, ok := list[0].(float64)
+		if !ok {
+			return ErrBadInput
+		}
+
+		message, ok := list[1].(string)
+		if !ok {
+			return ErrBadInput
+		}
+
+		e.Code, e.Message = int(code), message
+		return nil
+	}
+
+	var obj struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return ErrBadInput
+	}
+
+	e.Code, e.Message = obj.Code, obj.Message
+	return nil
+}
+
+// ParseSubscribeResult decodes a pool's reply to mining.subscribe:
+// [session or subscriptions, nonce1, nonce2 size]. The size is zero when
+// the pool doesn't send one.
+func ParseSubscribeResult(result *json.RawMessage) (session string, noncePart1 []byte, noncePart2Size int, err error) {
+	if result == nil {
+		return "", nil, 0, ErrBadInput
+	}
+
+	var params []json.RawMessage
+	if err := json.Unmarshal(*result, &params); err != nil || len(params) < 2 {
+		return "", nil, 0, ErrBadInput
+	}
+
+	// Bitcoin pools send a list of subscriptions instead of a session ID.
+	_ = json.Unmarshal(params[0], &session)
+
+	var nonce string
+	if err := json.Unmarshal(params[1], &nonce); err != nil {
+		return "", nil, 0, ErrBadInput
+	}
+
+	if noncePart1, err = hex.DecodeString(nonce); err != nil {
+		return "", nil, 0, ErrBadInput
+	}
+
+	if len(params) > 2 {
+		if err := json.Unmarshal(params[2], &noncePart2Size); err != nil {
+			return "", nil, 0, ErrBadInput
+		}
+	}
+
+	return session, noncePart1, noncePart2Size, nil
+}
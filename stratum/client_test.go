@@ -0,0 +1,286 @@
+package stratum
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func mustUint256(t *testing.T, s string) Uint256 {
+	t.Helper()
+
+	x, err := HexToUint256(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := BigEndian.Bytes(s, len(s)/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Lines captured from pools, as they send them.
+func TestParseServerMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		line    string
+		want    Response
+	}{
+		{
+			name:    "zcash notify",
+			dialect: Equihash,
+			line:    `{"id":null,"method":"mining.notify","params":["0","04000000","0000000000000000000000000000000000000000000000000000000000000000","f84c7a85b768123f1dff1d4c4cece70083b2d27e117b4ac2e31d087988a5eac4","0000000000000000000000000000000000000000000000000000000000000000","90041358","ffff071f",true]}`,
+			want: ResponseNotify{
+				Job:            "0",
+				Version:        0x04000000,
+				HashMerkleRoot: mustUint256(t, "f84c7a85b768123f1dff1d4c4cece70083b2d27e117b4ac2e31d087988a5eac4"),
+				NTime:          0x90041358,
+				NBits:          0xffff071f,
+				CleanJobs:      true,
+			},
+		},
+		{
+			name:    "bitcoin notify",
+			dialect: Bitcoin,
+			line:    `{"params": ["bf", "4d16b6f85af6e2198f44ae2a6de67f78487ae5611b77c6c0440b921e00000000", "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff20020862062f503253482f04b8864e5008", "072f736c7573682f000000000100f2052a010000001976a914d23fcdf86f7e756a64a7a9688ef9903327048ed988ac00000000", [], "00000002", "1c2ac4af", "504e86b9", false], "id": null, "method": "mining.notify"}`,
+			want: ResponseNotifyBitcoin{
+				Job:           "bf",
+				HashPrevBlock: mustUint256(t, "4d16b6f85af6e2198f44ae2a6de67f78487ae5611b77c6c0440b921e00000000"),
+				Coinbase1:     mustHex(t, "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff20020862062f503253482f04b8864e5008"),
+				Coinbase2:     mustHex(t, "072f736c7573682f000000000100f2052a010000001976a914d23fcdf86f7e756a64a7a9688ef9903327048ed988ac00000000"),
+				MerkleBranch:  []Uint256{},
+				Version:       2,
+				NBits:         0x1c2ac4af,
+				NTime:         0x504e86b9,
+			},
+		},
+		{
+			name:    "bitcoin notify with branches",
+			dialect: Bitcoin,
+			line:    `{"id":null,"method":"mining.notify","params":["58af8d8c","975b9717f7d18ec1f2ad55e2559b5997b8da0e3317c803780000000100000000","01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4803636004062f503253482f04428b055008","2f736c7573682f000000000100f2052a010000001976a914d23fcdf86f7e756a64a7a9688ef9903327048ed988ac00000000",["ea9da84d55ebf07f47def6b9b35ab30fc18b6e980fc618f262724388f2e9c591","f8578e6b5900de614aabe563c9622a8f514e11d368caa78890ac2ed615a2300c"],"00000002","1c2ac4af","5005b242",false]}`,
+			want: ResponseNotifyBitcoin{
+				Job:           "58af8d8c",
+				HashPrevBlock: mustUint256(t, "975b9717f7d18ec1f2ad55e2559b5997b8da0e3317c803780000000100000000"),
+				Coinbase1:     mustHex(t, "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4803636004062f503253482f04428b055008"),
+				Coinbase2:     mustHex(t, "2f736c7573682f000000000100f2052a010000001976a914d23fcdf86f7e756a64a7a9688ef9903327048ed988ac00000000"),
+				MerkleBranch: []Uint256{
+					mustUint256(t, "ea9da84d55ebf07f47def6b9b35ab30fc18b6e980fc618f262724388f2e9c591"),
+					mustUint256(t, "f8578e6b5900de614aabe563c9622a8f514e11d368caa78890ac2ed615a2300c"),
+				},
+				Version: 2,
+				NBits:   0x1c2ac4af,
+				NTime:   0x5005b242,
+			},
+		},
+		{
+			name:    "set difficulty",
+			dialect: Bitcoin,
+			line:    `{"id":null,"method":"mining.set_difficulty","params":[2048]}`,
+			want:    ResponseSetDifficulty{Difficulty: 2048},
+		},
+		{
+			name:    "set fractional difficulty",
+			dialect: Equihash,
+			line:    `{"id":null,"method":"mining.set_difficulty","params":[0.125]}`,
+			want:    ResponseSetDifficulty{Difficulty: 0.125},
+		},
+		{
+			name:    "set target",
+			dialect: Equihash,
+			line:    `{"id":null,"method":"mining.set_target","params":["0007ffff00000000000000000000000000000000000000000000000000000000"]}`,
+			want:    ResponseSetTarget{Target: Uint256{1: 0x07, 2: 0xff, 3: 0xff}},
+		},
+		{
+			name:    "set extranonce",
+			dialect: Bitcoin,
+			line:    `{"id":null,"method":"mining.set_extranonce","params":["08000002",4]}`,
+			want:    ResponseSetExtranonce{NoncePart1: []byte{0x08, 0x00, 0x00, 0x02}, NoncePart2Size: 4},
+		},
+		{
+			name:    "set extranonce without size",
+			dialect: Equihash,
+			line:    `{"id":null,"method":"mining.set_extranonce","params":["a0b1c2d3"]}`,
+			want:    ResponseSetExtranonce{NoncePart1: []byte{0xa0, 0xb1, 0xc2, 0xd3}},
+		},
+		{
+			name:    "reconnect",
+			dialect: Bitcoin,
+			line:    `{"id":null,"method":"client.reconnect","params":["stratum.example.com",3333,10]}`,
+			want:    ResponseReconnect{Host: "stratum.example.com", Port: 3333, Wait: 10},
+		},
+		{
+			name:    "reconnect with string port",
+			dialect: Equihash,
+			line:    `{"id":null,"method":"client.reconnect","params":["stratum.example.com","3357"]}`,
+			want:    ResponseReconnect{Host: "stratum.example.com", Port: 3357},
+		},
+		{
+			name:    "reconnect here",
+			dialect: Bitcoin,
+			line:    `{"id":null,"method":"client.reconnect","params":[]}`,
+			want:    ResponseReconnect{},
+		},
+		{
+			name:    "show message",
+			dialect: Bitcoin,
+			line:    `{"id":null,"method":"client.show_message","params":["Pool maintenance in 10 minutes"]}`,
+			want:    ResponseShowMessage{Message: "Pool maintenance in 10 minutes"},
+		},
+		{
+			name:    "get version",
+			dialect: Bitcoin,
+			line:    `{"id":"gv","method":"client.get_version","params":[]}`,
+			want:    ResponseGetVersion{ID: "gv"},
+		},
+		{
+			name:    "accepted share",
+			dialect: Bitcoin,
+			line:    `{"id":4,"result":true,"error":null}`,
+			want:    ResponseGeneral{ID: float64(4), Result: rawJSON(`true`)},
+		},
+		{
+			name:    "rejected share",
+			dialect: Bitcoin,
+			line:    `{"id":5,"result":null,"error":[21,"Job not found",null]}`,
+			want:    ResponseGeneral{ID: float64(5), Error: &Error{21, "Job not found"}},
+		},
+		{
+			name:    "rejected share with traceback",
+			dialect: Equihash,
+			line:    `{"id":6,"result":null,"error":[23,"Low difficulty share","Traceback (most recent call last)"]}`,
+			want:    ResponseGeneral{ID: float64(6), Error: &Error{23, "Low difficulty share"}},
+		},
+		{
+			name:    "rejected share as object",
+			dialect: Bitcoin,
+			line:    `{"id":7,"result":false,"error":{"code":22,"message":"Duplicate share"}}`,
+			want:    ResponseGeneral{ID: float64(7), Result: rawJSON(`false`), Error: &Error{22, "Duplicate share"}},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.dialect.ParseServerMessage([]byte(tt.line))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parsed %#v, want %#v", tt.name, got, tt.want)
+			continue
+		}
+
+		if _, ok := got.(ResponseGeneral); ok {
+			continue
+		}
+
+		// Notifications we relay must come out the way the pool sent them.
+		data, err := json.Marshal(got)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		again, err := tt.dialect.ParseServerMessage(data)
+		if err != nil {
+			t.Errorf("%s: parsing %s: %v", tt.name, data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(again, got) {
+			t.Errorf("%s: round trip through %s gave %#v", tt.name, data, again)
+		}
+	}
+}
+
+func TestParseServerMessageErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		line    string
+	}{
+		{"zcash notify too short", Equihash, `{"id":null,"method":"mining.notify","params":["0","04000000"]}`},
+		{"zcash notify bad clean", Equihash, `{"id":null,"method":"mining.notify","params":["0","04000000","00","00","00","90041358","ffff071f","yes"]}`},
+		{"zcash notify bad hex", Equihash, `{"id":null,"method":"mining.notify","params":["0","0400000g","00","00","00","90041358","ffff071f",true]}`},
+		{"bitcoin notify in zcash dialect", Equihash, `{"id":null,"method":"mining.notify","params":["bf","00","01","02",[],"00000002","1c2ac4af","504e86b9",false]}`},
+		{"bitcoin notify branches not a list", Bitcoin, `{"id":null,"method":"mining.notify","params":["bf","00","01","02","03","00000002","1c2ac4af","504e86b9",false]}`},
+		{"set difficulty no params", Bitcoin, `{"id":null,"method":"mining.set_difficulty","params":[]}`},
+		{"set target too long", Equihash, `{"id":null,"method":"mining.set_target","params":["000000000000000000000000000000000000000000000000000000000000000000"]}`},
+		{"set extranonce bad hex", Bitcoin, `{"id":null,"method":"mining.set_extranonce","params":["xyz",4]}`},
+		{"reconnect bad port", Bitcoin, `{"id":null,"method":"client.reconnect","params":["host","port"]}`},
+		{"unknown method", Bitcoin, `{"id":null,"method":"mining.ping","params":[]}`},
+		{"reply without id", Bitcoin, `{"id":null,"result":true,"error":null}`},
+		{"short error", Bitcoin, `{"id":1,"result":null,"error":[21]}`},
+		{"not json", Bitcoin, `{"id":1,`},
+	}
+
+	for _, tt := range tests {
+		if got, err := tt.dialect.ParseServerMessage([]byte(tt.line)); err == nil {
+			t.Errorf("%s: parsed %#v, want an error", tt.name, got)
+		}
+	}
+}
+
+func TestParseSubscribeResult(t *testing.T) {
+	tests := []struct {
+		name           string
+		result         string
+		session        string
+		noncePart1     []byte
+		noncePart2Size int
+		err            bool
+	}{
+		{
+			name:           "bitcoin",
+			result:         `[[["mining.set_difficulty","b4b6693b72a50c7116db18d6497cac52"],["mining.notify","ae6812eb4cd7735a302a8a9dd95cf71f"]],"08000002",4]`,
+			noncePart1:     []byte{0x08, 0x00, 0x00, 0x02},
+			noncePart2Size: 4,
+		},
+		{
+			name:       "zcash",
+			result:     `["3a3d2c8e","a0b1c2d3"]`,
+			session:    "3a3d2c8e",
+			noncePart1: []byte{0xa0, 0xb1, 0xc2, 0xd3},
+		},
+		{
+			name:       "zcash without session",
+			result:     `[null,"a0b1c2d3e4f5"]`,
+			noncePart1: []byte{0xa0, 0xb1, 0xc2, 0xd3, 0xe4, 0xf5},
+		},
+		{name: "no nonce", result: `["3a3d2c8e"]`, err: true},
+		{name: "bad nonce", result: `["3a3d2c8e","a0b1c2d"]`, err: true},
+		{name: "bad size", result: `["3a3d2c8e","a0b1c2d3","4"]`, err: true},
+		{name: "not a list", result: `true`, err: true},
+	}
+
+	for _, tt := range tests {
+		session, noncePart1, noncePart2Size, err := ParseSubscribeResult(rawJSON(tt.result))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: want an error", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if session != tt.session || !reflect.DeepEqual(noncePart1, tt.noncePart1) || noncePart2Size != tt.noncePart2Size {
+			t.Errorf("%s: got %q %x %d, want %q %x %d", tt.name, session, noncePart1, noncePart2Size, tt.session, tt.noncePart1, tt.noncePart2Size)
+		}
+	}
+
+	if _, _, _, err := ParseSubscribeResult(nil); err == nil {
+		t.Error("null result: want an error")
+	}
+}
@@ -0,0 +1,224 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Dialect of stratum v1 spoken on a connection.
+type Dialect string
+
+const (
+	// Equihash is the Zcash flavour: full header fields in mining.notify,
+	// 32 byte nonces and solutions in mining.submit. The zero Dialect.
+	Equihash Dialect = "equihash"
+
+	// Bitcoin is classic SHA256d stratum: coinbase parts and merkle branches
+	// in mining.notify, extranonce2 and a 4 byte nonce in mining.submit.
+	Bitcoin Dialect = "sha256d"
+)
+
+type (
+	// job, prevhash, coinbase1, coinbase2, merkle branches, version, nbits, ntime, clean
+	ResponseNotifyBitcoin struct {
+		Job           string
+		HashPrevBlock Uint256
+		Coinbase1     []byte
+		Coinbase2     []byte
+		MerkleBranch  []Uint256
+		Version       uint32
+		NBits         uint32
+		NTime         uint32
+		CleanJobs     bool
+	}
+
+	// worker, job, extranonce2, ntime, nonce, version bits
+	RequestSubmitBitcoin struct {
+		RequestBase
+
+		Worker     string
+		Job        string
+		NoncePart2 []byte
+		NTime      uint32
+		Nonce      uint32
+
+		// Set by miners rolling the version, see BIP 310.
+		VersionBits    uint32
+		HasVersionBits bool
+	}
+)
+
+// IsBitcoin reports whether the dialect is SHA256d stratum.
+func (d Dialect) IsBitcoin() bool {
+	return d == Bitcoin
+}
+
+// Valid reports whether d is a known dialect, the empty string included.
+func (d Dialect) Valid() bool {
+	return d == "" || d == Equihash || d == Bitcoin
+}
+
+func (d Dialect) String() string {
+	if d == "" {
+		return string(Equihash)
+	}
+
+	return string(d)
+}
+
+func parseSubmitBitcoin(raw RawRPC, base RequestBase) (Request, error) {
+	var params []string
+	if err := unmarshalParams(raw, &params); err != nil {
+		return nil, err
+	}
+
+	if len(params) < 5 {
+		return nil, ErrBadInput
+	}
+
+	nonce2, err := hex.DecodeString(params[2])
+	if err != nil {
+		return nil, ErrBadInput
+	}
+
+	ntime, err := HexToUint32(params[3])
+	if err != nil {
+		return nil, ErrBadInput
+	}
+
+	nonce, err := HexToUint32(params[4])
+	if err != nil {
+		return nil, ErrBadInput
+	}
+
+	req := RequestSubmitBitcoin{
+		RequestBase: base,
+		Worker:      params[0],
+		Job:         params[1],
+		NoncePart2:  nonce2,
+		NTime:       ntime,
+		Nonce:       nonce,
+	}
+
+	if len(params) > 5 {
+		if req.VersionBits, err = parseMask(params[5]); err != nil {
+			return nil, ErrBadInput
+		}
+		req.HasVersionBits = true
+	}
+
+	return req, nil
+}
+
+func parseNotifyBitcoin(raw RawRPC) (Response, error) {
+	var params []json.RawMessage
+	if err := unmarshalParams(raw, &params); err != nil {
+		return nil, err
+	}
+
+	if len(params) < 9 {
+		return nil, ErrBadInput
+	}
+
+	var fields [4]string
+	for i := range fields {
+		if err := json.Unmarshal(params[i], &fields[i]); err != nil {
+			return nil, ErrBadInput
+		}
+	}
+
+	var branches []string
+	if err := json.Unmarshal(params[4], &branches); err != nil {
+		return nil, ErrBadInput
+	}
+
+	var header [3]string
+	for i := range header {
+		if err := json.Unmarshal(params[5+i], &header[i]); err != nil {
+			return nil, ErrBadInput
+		}
+	}
+
+	notify := ResponseNotifyBitcoin{
+		Job: fields[0],
+	}
+
+	if err := json.Unmarshal(params[8], &notify.CleanJobs); err != nil {
+		return nil, ErrBadInput
+	}
+
+	var err error
+	if notify.HashPrevBlock, err = HexToUint256(fields[1]); err != nil {
+		return nil, ErrBadInput
+	}
+	if notify.Coinbase1, err = hex.DecodeString(fields[2]); err != nil {
+		return nil, ErrBadInput
+	}
+	if notify.Coinbase2, err = hex.DecodeString(fields[3]); err != nil {
+		return nil, ErrBadInput
+	}
+
+	notify.MerkleBranch = make([]Uint256, len(branches))
+	for i, branch := range branches {
+		if notify.MerkleBranch[i], err = HexToUint256(branch); err != nil {
+			return nil, ErrBadInput
+		}
+	}
+
+	if notify.Version, err = HexToUint32(header[0]); err != nil {
+		return nil, ErrBadInput
+	}
+	if notify.NBits, err = HexToUint32(header[1]); err != nil {
+		return nil, ErrBadInput
+	}
+	if notify.NTime, err = HexToUint32(header[2]); err != nil {
+		return nil, ErrBadInput
+	}
+
+	return notify, nil
+}
+
+func (r RequestSubmitBitcoin) MarshalJSON() ([]byte, error) {
+	params := []interface{}{
+		r.Worker,
+		r.Job,
+		hex.EncodeToString(r.NoncePart2),
+		ToHex(r.NTime),
+		ToHex(r.Nonce),
+	}
+
+	if r.HasVersionBits {
+		params = append(params, ToHex(r.VersionBits))
+	}
+
+	return marshalRequest(RawRPC{
+		ID:     r.ID,
+		Method: Submit,
+	}, params)
+}
+
+func (r ResponseNotifyBitcoin) MarshalJSON() ([]byte, error) {
+	branches := make([]string, len(r.MerkleBranch))
+	for i, branch := range r.MerkleBranch {
+		branches[i] = ToHex(branch)
+	}
+
+	return marshalRequest(RawRPC{
+		ID:     nil,
+		Method: RequestType(Notify),
+	}, []interface{}{
+		r.Job,
+		ToHex(r.HashPrevBlock),
+		hex.EncodeToString(r.Coinbase1),
+		hex.EncodeToString(r.Coinbase2),
+		branches,
+		ToHex(r.Version),
+		ToHex(r.NBits),
+		ToHex(r.NTime),
+		r.CleanJobs,
+	})
+}
+
+func (r ResponseNotifyBitcoin) Type() ResponseType {
+	return Notify
+}
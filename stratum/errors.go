@@ -0,0 +1,33 @@
+package stratum
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Error is sent to miners as [code, message, traceback].
+type Error struct {
+	Code    int
+	Message string
+}
+
+var (
+	ErrorOther         = &Error{20, "Other/Unknown"}
+	ErrorJobNotFound   = &Error{21, "Job not found"}
+	ErrorStale         = &Error{21, "Job not found (=stale)"}
+	ErrorDuplicate     = &Error{22, "Duplicate share"}
+	ErrorLowDifficulty = &Error{23, "Low difficulty share"}
+	ErrorUnauthorized  = &Error{24, "Unauthorized worker"}
+	ErrorNotSubscribed = &Error{25, "Not subscribed"}
+
+	ErrorNTime    = &Error{20, "ntime out of range"}
+	ErrorSolution = &Error{20, "Invalid solution"}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stratum error %d: %s", e.Code, e.Message)
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
@@ -8,37 +8,86 @@ import (
 	"log"
 )
 
-func readHex(s string, n int) ([]byte, error) {
+// HexMode says how a fixed size field is hex encoded. Stratum fields are
+// read big-endian, Equihash ones keeping the header's bytes in order so
+// their numbers come out byte swapped. Nodes display hashes byte reversed,
+// which LittleEndian reads.
+type HexMode struct {
+	// Bytes are sent least significant first.
+	LittleEndian bool
+	// Refuse values shorter than the field, otherwise they are zero padded
+	// as pools and miners don't always send leading zeros.
+	Exact bool
+}
+
+var (
+	BigEndian    = HexMode{}
+	LittleEndian = HexMode{LittleEndian: true}
+
+	ErrHexSize   = errors.New("hex value oversized")
+	ErrHexLength = errors.New("hex value has the wrong length")
+)
+
+// Strict returns the mode refusing values shorter than the field.
+func (m HexMode) Strict() HexMode {
+	m.Exact = true
+	return m
+}
+
+// Bytes decodes a field of n bytes, returned most significant first.
+func (m HexMode) Bytes(s string, n int) ([]byte, error) {
 	if len(s) > 2*n {
-		return nil, errors.New("value oversized")
+		return nil, ErrHexSize
+	}
+
+	if m.Exact && len(s) != 2*n {
+		return nil, ErrHexLength
+	}
+
+	// An odd digit count only makes sense as a number missing its leading
+	// zero, which a little-endian value can't be.
+	if len(s)%2 == 1 {
+		if m.LittleEndian {
+			return nil, ErrHexLength
+		}
+
+		s = "0" + s
 	}
 
-	bytes, err := hex.DecodeString(s)
+	data, err := hex.DecodeString(s)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(bytes) != n {
-		// pad with zeros
-		buf := make([]byte, n)
-		copy(buf[n-len(bytes):], bytes)
-		buf = bytes
+	if m.LittleEndian {
+		reverseBytes(data)
 	}
 
-	return bytes, nil
+	buf := make([]byte, n)
+	copy(buf[n-len(data):], data)
+
+	return buf, nil
 }
 
-func HexToInt32(s string) (int32, error) {
-	data, err := readHex(s, 4)
-	if err != nil {
-		return 0, err
+// Encode a field given most significant byte first.
+func (m HexMode) Encode(data []byte) string {
+	if !m.LittleEndian {
+		return hex.EncodeToString(data)
 	}
 
-	return int32(binary.BigEndian.Uint32(data)), nil
+	buf := append([]byte{}, data...)
+	reverseBytes(buf)
+
+	return hex.EncodeToString(buf)
 }
 
-func HexToUint32(s string) (uint32, error) {
-	data, err := readHex(s, 4)
+func (m HexMode) Int32(s string) (int32, error) {
+	x, err := m.Uint32(s)
+	return int32(x), err
+}
+
+func (m HexMode) Uint32(s string) (uint32, error) {
+	data, err := m.Bytes(s, 4)
 	if err != nil {
 		return 0, err
 	}
@@ -46,8 +95,8 @@ func HexToUint32(s string) (uint32, error) {
 	return binary.BigEndian.Uint32(data), nil
 }
 
-func HexToUint64(s string) (uint64, error) {
-	data, err := readHex(s, 8)
+func (m HexMode) Uint64(s string) (uint64, error) {
+	data, err := m.Bytes(s, 8)
 	if err != nil {
 		return 0, err
 	}
@@ -55,30 +104,56 @@ func HexToUint64(s string) (uint64, error) {
 	return binary.BigEndian.Uint64(data), nil
 }
 
-func HexToUint128(s string) (Uint128, error) {
-	data, err := readHex(s, 16)
+func (m HexMode) Uint128(s string) (Uint128, error) {
+	var res Uint128
+	data, err := m.Bytes(s, len(res))
 	if err != nil {
-		return Uint128{}, err
+		return res, err
 	}
 
-	var res Uint128
 	copy(res[:], data)
-
 	return res, nil
 }
 
-func HexToUint256(s string) (Uint256, error) {
-	data, err := readHex(s, 32)
+func (m HexMode) Uint256(s string) (Uint256, error) {
+	var res Uint256
+	data, err := m.Bytes(s, len(res))
 	if err != nil {
-		return Uint256{}, err
+		return res, err
 	}
 
-	var res Uint256
 	copy(res[:], data)
-
 	return res, nil
 }
 
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// The HexTo helpers read big-endian values, zero padding short ones.
+
+func HexToInt32(s string) (int32, error) {
+	return BigEndian.Int32(s)
+}
+
+func HexToUint32(s string) (uint32, error) {
+	return BigEndian.Uint32(s)
+}
+
+func HexToUint64(s string) (uint64, error) {
+	return BigEndian.Uint64(s)
+}
+
+func HexToUint128(s string) (Uint128, error) {
+	return BigEndian.Uint128(s)
+}
+
+func HexToUint256(s string) (Uint256, error) {
+	return BigEndian.Uint256(s)
+}
+
 func ToHex(x interface{}) string {
 	switch x.(type) {
 	case int32:
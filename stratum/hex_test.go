@@ -0,0 +1,99 @@
+package stratum
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHexModeBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		mode HexMode
+		s    string
+		n    int
+		want []byte
+		err  error
+	}{
+		{name: "big-endian", mode: BigEndian, s: "0102", n: 2, want: []byte{0x01, 0x02}},
+		{name: "little-endian", mode: LittleEndian, s: "0102", n: 2, want: []byte{0x02, 0x01}},
+		{name: "big-endian padded", mode: BigEndian, s: "0102", n: 4, want: []byte{0, 0, 0x01, 0x02}},
+		{name: "little-endian padded", mode: LittleEndian, s: "0102", n: 4, want: []byte{0, 0, 0x02, 0x01}},
+		{name: "missing leading zero", mode: BigEndian, s: "102", n: 2, want: []byte{0x01, 0x02}},
+		{name: "little-endian odd digits", mode: LittleEndian, s: "102", n: 2, err: ErrHexLength},
+		{name: "oversized", mode: BigEndian, s: "010203", n: 2, err: ErrHexSize},
+		{name: "strict short", mode: BigEndian.Strict(), s: "0102", n: 4, err: ErrHexLength},
+		{name: "strict", mode: LittleEndian.Strict(), s: "01020304", n: 4, want: []byte{0x04, 0x03, 0x02, 0x01}},
+		{name: "empty", mode: BigEndian, s: "", n: 2, want: []byte{0, 0}},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.mode.Bytes(tt.s, tt.n)
+		if tt.err != nil {
+			if err != tt.err {
+				t.Errorf("%s: got %x, %v, want %v", tt.name, got, err, tt.err)
+			}
+			continue
+		}
+
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, %v, want %x", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := BigEndian.Bytes("0g", 1); err == nil {
+		t.Error("decoded a non-hex digit")
+	}
+}
+
+func TestHexModeNumbers(t *testing.T) {
+	// Zcash genesis header fields as sent in mining.notify, and as nodes
+	// display the Bitcoin genesis hash.
+	if bits, err := HexToUint32("ffff071f"); err != nil || bits != 0xffff071f {
+		t.Errorf("zcash nbits read %08x, %v", bits, err)
+	}
+	if bits, err := LittleEndian.Uint32("ffff071f"); err != nil || bits != 0x1f07ffff {
+		t.Errorf("zcash nbits read little-endian %08x, %v", bits, err)
+	}
+	if ntime, err := LittleEndian.Int32("90041358"); err != nil || ntime != 1477641360 {
+		t.Errorf("zcash ntime read little-endian %d, %v", ntime, err)
+	}
+	if x, err := BigEndian.Uint64("1"); err != nil || x != 1 {
+		t.Errorf("read %d, %v", x, err)
+	}
+
+	hash := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	le, err := LittleEndian.Strict().Uint256(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le[0] != 0x6f || le[31] != 0x00 {
+		t.Errorf("hash read little-endian %x", le)
+	}
+	if s := LittleEndian.Encode(le[:]); s != hash {
+		t.Errorf("encoded back as %s", s)
+	}
+
+	be, err := HexToUint256(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := ToHex(be); s != hash {
+		t.Errorf("big-endian round trip %s", s)
+	}
+	if s := BigEndian.Encode(be[:]); s != hash {
+		t.Errorf("big-endian encode %s", s)
+	}
+
+	if x, err := HexToUint128("0102"); err != nil || x[15] != 0x02 || x[14] != 0x01 {
+		t.Errorf("uint128 %x, %v", x, err)
+	}
+	if s := ToHex(uint32(0x1d00ffff)); s != "1d00ffff" {
+		t.Errorf("ToHex(uint32) = %s", s)
+	}
+	if s := ToHex(int32(-1)); s != "ffffffff" {
+		t.Errorf("ToHex(int32) = %s", s)
+	}
+	if s := ToHex(uint64(1)); s != "0000000000000001" {
+		t.Errorf("ToHex(uint64) = %s", s)
+	}
+}
@@ -0,0 +1,317 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/hashicorp/errwrap"
+)
+
+type (
+	RequestExtranonceSubscribe struct {
+		RequestBase
+	}
+
+	RequestSuggestDifficulty struct {
+		RequestBase
+
+		Difficulty float64
+	}
+
+	RequestSuggestTarget struct {
+		RequestBase
+
+		Target Uint256
+	}
+
+	// extensions, extension params
+	RequestConfigure struct {
+		RequestBase
+
+		Extensions []string
+		Params     map[string]interface{}
+	}
+
+	RequestGetTransactions struct {
+		RequestBase
+
+		Job string
+	}
+
+	// RequestReply is a miner's answer to a request from the server,
+	// e.g. client.get_version.
+	RequestReply struct {
+		RequestBase
+
+		Result *json.RawMessage
+		Error  *json.RawMessage
+	}
+
+	// RequestUnknown is a well formed request for a method we don't implement.
+	RequestUnknown struct {
+		RequestBase
+	}
+
+	ResponseGetVersion struct {
+		ID interface{}
+	}
+
+	ResponseShowMessage struct {
+		Message string
+	}
+
+	// host, port, wait seconds
+	ResponseReconnect struct {
+		Host string
+		Port int
+		Wait int
+	}
+
+	// nonce1, nonce2 size
+	ResponseSetExtranonce struct {
+		NoncePart1 []byte
+
+		// Omitted when zero, the Equihash flavour only sends the nonce.
+		NoncePart2Size int
+	}
+
+	ResponseSetTarget struct {
+		Target Uint256
+	}
+)
+
+const (
+	ExtranonceSubscribe RequestType = "mining.extranonce.subscribe"
+	SuggestDifficulty   RequestType = "mining.suggest_difficulty"
+	SuggestTarget       RequestType = "mining.suggest_target"
+	Configure           RequestType = "mining.configure"
+	GetTransactions     RequestType = "mining.get_transactions"
+	Reply               RequestType = "reply"
+
+	ShowMessage ResponseType = "client.show_message"
+	SetTarget   ResponseType = "mining.set_target"
+)
+
+var ErrorMethodNotFound = &Error{-3, "Method not found"}
+
+// unmarshalParams decodes the params of raw into v.
+func unmarshalParams(raw RawRPC, v interface{}) error {
+	if raw.Params == nil {
+		return errwrap.Wrapf("error decoding "+string(raw.Method)+" params: {{err}}", ErrBadInput)
+	}
+
+	if err := json.Unmarshal(*raw.Params, v); err != nil {
+		return errwrap.Wrapf("error decoding "+string(raw.Method)+" params: {{err}}", ErrBadInput)
+	}
+
+	return nil
+}
+
+func parseExtended(raw RawRPC, base RequestBase) (Request, error) {
+	switch raw.Method {
+	case ExtranonceSubscribe:
+		return RequestExtranonceSubscribe{
+			RequestBase: base,
+		}, nil
+
+	case SuggestDifficulty:
+		var params []json.Number
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 1 {
+			return nil, ErrBadInput
+		}
+
+		difficulty, err := params[0].Float64()
+		if err != nil || difficulty < 0 {
+			return nil, ErrBadInput
+		}
+
+		return RequestSuggestDifficulty{
+			RequestBase: base,
+			Difficulty:  difficulty,
+		}, nil
+
+	case SuggestTarget:
+		var params []string
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 1 {
+			return nil, ErrBadInput
+		}
+
+		target, err := HexToUint256(params[0])
+		if err != nil {
+			return nil, ErrBadInput
+		}
+
+		return RequestSuggestTarget{
+			RequestBase: base,
+			Target:      target,
+		}, nil
+
+	case Configure:
+		var params []json.RawMessage
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params) < 1 {
+			return nil, ErrBadInput
+		}
+
+		req := RequestConfigure{
+			RequestBase: base,
+		}
+
+		if err := json.Unmarshal(params[0], &req.Extensions); err != nil {
+			return nil, ErrBadInput
+		}
+
+		if len(params) > 1 {
+			if err := json.Unmarshal(params[1], &req.Params); err != nil {
+				return nil, ErrBadInput
+			}
+		}
+
+		return req, nil
+
+	case GetTransactions:
+		var params []string
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+
+		req := RequestGetTransactions{
+			RequestBase: base,
+		}
+
+		if len(params) > 0 {
+			req.Job = params[0]
+		}
+
+		return req, nil
+
+	default:
+		return RequestUnknown{
+			RequestBase: base,
+		}, nil
+	}
+}
+
+func (r RequestExtranonceSubscribe) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     r.ID,
+		Method: ExtranonceSubscribe,
+	}, make([]int, 0))
+}
+
+func (r RequestSuggestDifficulty) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     r.ID,
+		Method: SuggestDifficulty,
+	}, []interface{}{r.Difficulty})
+}
+
+func (r RequestSuggestTarget) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     r.ID,
+		Method: SuggestTarget,
+	}, []interface{}{ToHex(r.Target)})
+}
+
+func (r RequestConfigure) MarshalJSON() ([]byte, error) {
+	params := r.Params
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+
+	extensions := r.Extensions
+	if extensions == nil {
+		extensions = make([]string, 0)
+	}
+
+	return marshalRequest(RawRPC{
+		ID:     r.ID,
+		Method: Configure,
+	}, []interface{}{extensions, params})
+}
+
+func (r RequestGetTransactions) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     r.ID,
+		Method: GetTransactions,
+	}, []string{r.Job})
+}
+
+func (r RequestReply) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":     r.ID,
+		"result": r.Result,
+		"error":  r.Error,
+	})
+}
+
+func (r ResponseGetVersion) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     r.ID,
+		Method: RequestType(Version),
+	}, make([]int, 0))
+}
+
+func (r ResponseGetVersion) Type() ResponseType {
+	return Version
+}
+
+func (r ResponseShowMessage) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     nil,
+		Method: RequestType(ShowMessage),
+	}, []string{r.Message})
+}
+
+func (r ResponseShowMessage) Type() ResponseType {
+	return ShowMessage
+}
+
+func (r ResponseReconnect) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     nil,
+		Method: RequestType(Reconnect),
+	}, []interface{}{r.Host, r.Port, r.Wait})
+}
+
+func (r ResponseReconnect) Type() ResponseType {
+	return Reconnect
+}
+
+func (r ResponseSetExtranonce) MarshalJSON() ([]byte, error) {
+	params := []interface{}{hex.EncodeToString(r.NoncePart1)}
+	if r.NoncePart2Size > 0 {
+		params = append(params, r.NoncePart2Size)
+	}
+
+	return marshalRequest(RawRPC{
+		ID:     nil,
+		Method: RequestType(Extranonce),
+	}, params)
+}
+
+func (r ResponseSetExtranonce) Type() ResponseType {
+	return Extranonce
+}
+
+func (r ResponseSetTarget) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     nil,
+		Method: RequestType(SetTarget),
+	}, []string{ToHex(r.Target)})
+}
+
+func (r ResponseSetTarget) Type() ResponseType {
+	return SetTarget
+}
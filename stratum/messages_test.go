@@ -0,0 +1,210 @@
+package stratum
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func rawJSON(s string) *json.RawMessage {
+	raw := json.RawMessage(s)
+	return &raw
+}
+
+func TestParseExtended(t *testing.T) {
+	target := Uint256{0x00, 0x00, 0x00, 0x00, 0xff, 0xff}
+
+	tests := []struct {
+		name string
+		line string
+		want Request
+		err  bool
+	}{
+		{
+			name: "extranonce subscribe",
+			line: `{"id":1,"method":"mining.extranonce.subscribe","params":[]}`,
+			want: RequestExtranonceSubscribe{RequestBase{float64(1), ExtranonceSubscribe}},
+		},
+		{
+			name: "suggest difficulty",
+			line: `{"id":2,"method":"mining.suggest_difficulty","params":[0.5]}`,
+			want: RequestSuggestDifficulty{RequestBase{float64(2), SuggestDifficulty}, 0.5},
+		},
+		{
+			name: "suggest difficulty integer",
+			line: `{"id":2,"method":"mining.suggest_difficulty","params":[1024]}`,
+			want: RequestSuggestDifficulty{RequestBase{float64(2), SuggestDifficulty}, 1024},
+		},
+		{name: "suggest negative difficulty", line: `{"id":2,"method":"mining.suggest_difficulty","params":[-1]}`, err: true},
+		{
+			name: "suggest quoted difficulty",
+			line: `{"id":2,"method":"mining.suggest_difficulty","params":["16"]}`,
+			want: RequestSuggestDifficulty{RequestBase{float64(2), SuggestDifficulty}, 16},
+		},
+		{name: "suggest difficulty not a number", line: `{"id":2,"method":"mining.suggest_difficulty","params":["high"]}`, err: true},
+		{name: "suggest difficulty no params", line: `{"id":2,"method":"mining.suggest_difficulty","params":[]}`, err: true},
+		{
+			name: "suggest short target",
+			line: `{"id":3,"method":"mining.suggest_target","params":["00000000ffff"]}`,
+			want: RequestSuggestTarget{RequestBase{float64(3), SuggestTarget}, Uint256{30: 0xff, 31: 0xff}},
+		},
+		{
+			name: "suggest full target",
+			line: `{"id":3,"method":"mining.suggest_target","params":["00000000ffff0000000000000000000000000000000000000000000000000000"]}`,
+			want: RequestSuggestTarget{RequestBase{float64(3), SuggestTarget}, target},
+		},
+		{name: "suggest oversized target", line: `{"id":3,"method":"mining.suggest_target","params":["` + ToHex(target) + `00"]}`, err: true},
+		{
+			name: "configure",
+			line: `{"id":4,"method":"mining.configure","params":[["version-rolling"],{"version-rolling.mask":"1fffe000","version-rolling.min-bit-count":2}]}`,
+			want: RequestConfigure{
+				RequestBase: RequestBase{float64(4), Configure},
+				Extensions:  []string{"version-rolling"},
+				Params: map[string]interface{}{
+					"version-rolling.mask":          "1fffe000",
+					"version-rolling.min-bit-count": float64(2),
+				},
+			},
+		},
+		{
+			name: "configure without params",
+			line: `{"id":4,"method":"mining.configure","params":[["minimum-difficulty"]]}`,
+			want: RequestConfigure{RequestBase: RequestBase{float64(4), Configure}, Extensions: []string{"minimum-difficulty"}},
+		},
+		{name: "configure extensions not a list", line: `{"id":4,"method":"mining.configure","params":["version-rolling"]}`, err: true},
+		{
+			name: "get transactions",
+			line: `{"id":5,"method":"mining.get_transactions","params":["1b2c"]}`,
+			want: RequestGetTransactions{RequestBase{float64(5), GetTransactions}, "1b2c"},
+		},
+		{
+			name: "get transactions without job",
+			line: `{"id":5,"method":"mining.get_transactions","params":[]}`,
+			want: RequestGetTransactions{RequestBase: RequestBase{float64(5), GetTransactions}},
+		},
+		{
+			name: "reply with result",
+			line: `{"id":"v","result":"cgminer/4.10.0","error":null}`,
+			want: RequestReply{RequestBase{"v", Reply}, rawJSON(`"cgminer/4.10.0"`), nil},
+		},
+		{
+			name: "reply with error",
+			line: `{"id":6,"result":null,"error":[20,"Other",null]}`,
+			want: RequestReply{RequestBase{float64(6), Reply}, nil, rawJSON(`[20,"Other",null]`)},
+		},
+		{name: "reply without id", line: `{"id":null,"result":true,"error":null}`, err: true},
+		{name: "reply without result", line: `{"id":7}`, err: true},
+		{
+			name: "unknown method",
+			line: `{"id":8,"method":"mining.capabilities","params":[{}]}`,
+			want: RequestUnknown{RequestBase{float64(8), "mining.capabilities"}},
+		},
+		{name: "not json", line: `mining.subscribe`, err: true},
+	}
+
+	for _, tt := range tests {
+		for _, d := range []Dialect{Equihash, Bitcoin} {
+			got, err := d.Parse([]byte(tt.line))
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s (%s): parsed %#v, want an error", tt.name, d, got)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s (%s): %v", tt.name, d, err)
+				continue
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s (%s): parsed %#v, want %#v", tt.name, d, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestExtendedRoundTrip(t *testing.T) {
+	requests := []Request{
+		RequestExtranonceSubscribe{RequestBase{float64(1), ExtranonceSubscribe}},
+		RequestSuggestDifficulty{RequestBase{float64(2), SuggestDifficulty}, 0.001},
+		RequestSuggestTarget{RequestBase{float64(3), SuggestTarget}, Uint256{4: 0xff, 5: 0xff}},
+		RequestConfigure{
+			RequestBase: RequestBase{float64(4), Configure},
+			Extensions:  []string{"version-rolling", "subscribe-extranonce"},
+			Params:      map[string]interface{}{"version-rolling.mask": "ffffffff"},
+		},
+		RequestGetTransactions{RequestBase{"tx", GetTransactions}, "job1"},
+		RequestReply{RequestBase{float64(6), Reply}, rawJSON(`"bfgminer/5.5.0"`), nil},
+	}
+
+	for _, req := range requests {
+		data, err := json.Marshal(req)
+		if err != nil {
+			t.Errorf("%s: %v", req.Type(), err)
+			continue
+		}
+
+		got, err := Parse(data)
+		if err != nil {
+			t.Errorf("%s: parsing %s: %v", req.Type(), data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, req) {
+			t.Errorf("%s: round trip through %s gave %#v", req.Type(), data, got)
+		}
+	}
+}
+
+func TestMarshalExtended(t *testing.T) {
+	tests := []struct {
+		msg  interface{}
+		want string
+	}{
+		{
+			RequestConfigure{RequestBase: RequestBase{ID: float64(1)}},
+			`{"id":1,"method":"mining.configure","params":[[],{}]}`,
+		},
+		{
+			RequestGetTransactions{RequestBase: RequestBase{ID: float64(2)}},
+			`{"id":2,"method":"mining.get_transactions","params":[""]}`,
+		},
+		{
+			ResponseGetVersion{ID: "version"},
+			`{"id":"version","method":"client.get_version","params":[]}`,
+		},
+		{
+			ResponseShowMessage{Message: "maintenance at 12:00 UTC"},
+			`{"id":null,"method":"client.show_message","params":["maintenance at 12:00 UTC"]}`,
+		},
+		{
+			ResponseReconnect{Host: "pool.example.com", Port: 3333, Wait: 5},
+			`{"id":null,"method":"client.reconnect","params":["pool.example.com",3333,5]}`,
+		},
+		{
+			ResponseSetExtranonce{NoncePart1: []byte{0x08, 0x00, 0x00, 0x2a}},
+			`{"id":null,"method":"mining.set_extranonce","params":["0800002a"]}`,
+		},
+		{
+			ResponseSetExtranonce{NoncePart1: []byte{0x08, 0x00}, NoncePart2Size: 6},
+			`{"id":null,"method":"mining.set_extranonce","params":["0800",6]}`,
+		},
+		{
+			ResponseSetTarget{Target: Uint256{3: 0x0f}},
+			`{"id":null,"method":"mining.set_target","params":["0000000f00000000000000000000000000000000000000000000000000000000"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.msg)
+		if err != nil {
+			t.Errorf("%T: %v", tt.msg, err)
+			continue
+		}
+
+		if string(data) != tt.want {
+			t.Errorf("%T: marshalled %s, want %s", tt.msg, data, tt.want)
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package stratum
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"log"
 	"math/big"
-
-	"github.com/hashicorp/errwrap"
+	"strconv"
 )
 
 type (
@@ -29,6 +29,10 @@ type (
 		ID     interface{}      `json:"id"`
 		Method RequestType      `json:"method"`
 		Params *json.RawMessage `json:"params"`
+
+		// Set on replies to requests from the server.
+		Result *json.RawMessage `json:"result,omitempty"`
+		Error  *json.RawMessage `json:"error,omitempty"`
 	}
 
 	RequestBase struct {
@@ -56,14 +60,18 @@ type (
 		Job    string
 		NTime  uint32
 
-		NoncePart2 Uint128
+		NoncePart2 []byte
 		Solution   []byte
 	}
 
 	ResponseSubscribeReply struct {
 		ID         interface{}
 		Session    string
-		NoncePart1 Uint128
+		NoncePart1 []byte
+
+		// The Bitcoin dialect also sends the subscriptions and nonce2 size.
+		Dialect        Dialect
+		NoncePart2Size int
 	}
 	// job, prevhash, coinbase1, coinbase2, merkle, blockversion, nbit, ntime, clean
 	ResponseNotify struct {
@@ -78,7 +86,7 @@ type (
 	}
 
 	ResponseSetDifficulty struct {
-		difficulty Uint256
+		Difficulty float64
 	}
 
 	ResponseGeneral struct {
@@ -93,6 +101,9 @@ var ErrUnknownType = errors.New("unknown type")
 
 var ErrBadInput = errors.New("bad input")
 
+// Compact size of a 1344 byte Equihash solution.
+var solutionPrefix = []byte{0xfd, 0x40, 0x05}
+
 const (
 	Subscribe      RequestType  = "mining.subscribe"
 	Authorize      RequestType  = "mining.authorize"
@@ -126,10 +137,57 @@ func (r RequestBase) Type() RequestType {
 }
 
 func (r RequestSubscribe) MarshalJSON() ([]byte, error) {
+	params := r.Params
+	if params == nil {
+		params = make([]string, 0)
+	}
+
 	return marshalRequest(RawRPC{
 		ID:     r.ID,
 		Method: Subscribe,
-	}, make([]int, 0))
+	}, params)
+}
+
+// SessionID is the session a miner asks to resume. SHA256d miners send it
+// after the user agent, Equihash ones after host, port and user agent
+// (ZIP 301).
+func (r RequestSubscribe) SessionID(d Dialect) string {
+	i := 1
+	if !d.IsBitcoin() {
+		i = 3
+	}
+
+	if len(r.Params) <= i {
+		return ""
+	}
+
+	return r.Params[i]
+}
+
+// parseSubscribeParams reads the user agent and session ID, preceded by
+// the host and port for Equihash. Miners send the port as a number and
+// null for a missing session.
+func parseSubscribeParams(raw RawRPC) ([]string, error) {
+	if raw.Params == nil {
+		return nil, nil
+	}
+
+	var params []interface{}
+	if err := unmarshalParams(raw, &params); err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, len(params))
+	for i, param := range params {
+		switch v := param.(type) {
+		case string:
+			strs[i] = v
+		case float64:
+			strs[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+
+	return strs, nil
 }
 
 func (r RequestAuthorize) MarshalJSON() ([]byte, error) {
@@ -143,10 +201,22 @@ func (r RequestSubmit) MarshalJSON() ([]byte, error) {
 	return marshalRequest(RawRPC{
 		ID:     r.ID,
 		Method: Submit,
-	}, []interface{}{r.Worker, r.Job, r.NTime, r.NoncePart2})
+	}, []interface{}{
+		r.Worker,
+		r.Job,
+		ToHex(r.NTime),
+		hex.EncodeToString(r.NoncePart2),
+		hex.EncodeToString(append(append([]byte{}, solutionPrefix...), r.Solution...)),
+	})
 }
 
+// Parse a request in the Equihash dialect.
 func Parse(data []byte) (Request, error) {
+	return Equihash.Parse(data)
+}
+
+// Parse a request sent by a miner.
+func (d Dialect) Parse(data []byte) (Request, error) {
 	var raw RawRPC
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return nil, err
@@ -159,16 +229,20 @@ func Parse(data []byte) (Request, error) {
 
 	switch RequestType(raw.Method) {
 	case Subscribe:
+		params, err := parseSubscribeParams(raw)
+		if err != nil {
+			return nil, err
+		}
+
 		return RequestSubscribe{
 			RequestBase: base,
-			Params:      nil,
+			Params:      params,
 		}, nil
 
 	case Authorize:
 		var params [2]string
-		if err := json.Unmarshal(*raw.Params, &params); err != nil {
-			log.Println(err)
-			return nil, errwrap.Wrapf("error decoding auth params: {{err}}", ErrBadInput)
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
 		}
 
 		return RequestAuthorize{
@@ -178,9 +252,13 @@ func Parse(data []byte) (Request, error) {
 		}, nil
 
 	case Submit:
+		if d.IsBitcoin() {
+			return parseSubmitBitcoin(raw, base)
+		}
+
 		var params [5]string
-		if err := json.Unmarshal(*raw.Params, &params); err != nil {
-			return nil, errwrap.Wrapf("error decoding submit params: {{err}}", ErrBadInput)
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
 		}
 
 		ntime, err := HexToUint32(params[2])
@@ -188,16 +266,16 @@ func Parse(data []byte) (Request, error) {
 			return nil, ErrBadInput
 		}
 
-		nonce, err := HexToUint128(params[3])
+		nonce, err := BigEndian.Strict().Bytes(params[3], 16)
 		if err != nil {
 			return nil, ErrBadInput
 		}
 
-		solution, err := readHex(params[4], 1347)
-		if err != nil {
+		solution, err := BigEndian.Strict().Bytes(params[4], 1347)
+		if err != nil || !bytes.HasPrefix(solution, solutionPrefix) {
 			return nil, ErrBadInput
 		}
-		solution = solution[3:]
+		solution = solution[len(solutionPrefix):]
 
 		return RequestSubmit{
 			RequestBase: base,
@@ -208,15 +286,42 @@ func Parse(data []byte) (Request, error) {
 			Solution:    solution,
 		}, nil
 
+	case "":
+		// Without a method it can only be a reply to us.
+		if raw.ID == nil || (raw.Result == nil && raw.Error == nil) {
+			return nil, ErrUnknownType
+		}
+
+		base.Method = Reply
+		return RequestReply{
+			RequestBase: base,
+			Result:      raw.Result,
+			Error:       raw.Error,
+		}, nil
+
 	default:
-		return nil, ErrUnknownType
+		return parseExtended(raw, base)
 	}
 }
 
-func (r ResponseSubscribeReply) MashalJSON() ([]byte, error) {
+func (r ResponseSubscribeReply) MarshalJSON() ([]byte, error) {
+	if r.Dialect.IsBitcoin() {
+		return json.Marshal(ResponseGeneral{
+			ID: r.ID,
+			Result: []interface{}{
+				[][]string{
+					{string(SetDifficulty), r.Session},
+					{string(Notify), r.Session},
+				},
+				hex.EncodeToString(r.NoncePart1),
+				r.NoncePart2Size,
+			},
+		})
+	}
+
 	return json.Marshal(ResponseGeneral{
 		ID:     r.ID,
-		Result: []interface{}{r.Session, ToHex(r.NoncePart1)},
+		Result: []interface{}{r.Session, hex.EncodeToString(r.NoncePart1)},
 	})
 }
 
@@ -249,7 +354,7 @@ func (r ResponseSetDifficulty) MarshalJSON() ([]byte, error) {
 		ID:     nil,
 		Method: RequestType(SetDifficulty),
 	}, []interface{}{
-		ToHex(r.difficulty),
+		r.Difficulty,
 	})
 }
 
@@ -0,0 +1,124 @@
+package stratum
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSubscribeSessionID(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		line    string
+		params  []string
+		session string
+	}{
+		{
+			name:    "zcash with session",
+			dialect: Equihash,
+			line:    `{"id":1,"method":"mining.subscribe","params":["pool.example.com",3357,"nheqminer/0.5c","b7a1c3d2"]}`,
+			params:  []string{"pool.example.com", "3357", "nheqminer/0.5c", "b7a1c3d2"},
+			session: "b7a1c3d2",
+		},
+		{
+			name:    "zcash without session",
+			dialect: Equihash,
+			line:    `{"id":1,"method":"mining.subscribe","params":["pool.example.com","3357","nheqminer/0.5c",null]}`,
+			params:  []string{"pool.example.com", "3357", "nheqminer/0.5c", ""},
+		},
+		{
+			name:    "zcash port is not a session",
+			dialect: Equihash,
+			line:    `{"id":1,"method":"mining.subscribe","params":["pool.example.com",3357]}`,
+			params:  []string{"pool.example.com", "3357"},
+		},
+		{
+			name:    "bitcoin with session",
+			dialect: Bitcoin,
+			line:    `{"id":1,"method":"mining.subscribe","params":["cgminer/4.10.0","b7a1c3d2"]}`,
+			params:  []string{"cgminer/4.10.0", "b7a1c3d2"},
+			session: "b7a1c3d2",
+		},
+		{
+			name:    "bitcoin user agent only",
+			dialect: Bitcoin,
+			line:    `{"id":1,"method":"mining.subscribe","params":["cgminer/4.10.0"]}`,
+			params:  []string{"cgminer/4.10.0"},
+		},
+		{
+			name:    "no params",
+			dialect: Bitcoin,
+			line:    `{"id":1,"method":"mining.subscribe","params":[]}`,
+			params:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := tt.dialect.Parse([]byte(tt.line))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		sub, ok := req.(RequestSubscribe)
+		if !ok {
+			t.Errorf("%s: parsed %#v", tt.name, req)
+			continue
+		}
+
+		if !reflect.DeepEqual(sub.Params, tt.params) {
+			t.Errorf("%s: params %q, want %q", tt.name, sub.Params, tt.params)
+		}
+
+		if session := sub.SessionID(tt.dialect); session != tt.session {
+			t.Errorf("%s: session %q, want %q", tt.name, session, tt.session)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		`{"id":1,"method":"mining.subscribe","params":["pool.example.com",3357,"nheqminer/0.5c",null]}`,
+		`{"id":1,"method":"mining.subscribe","params":["cgminer/4.10.0","b7a1c3d2"]}`,
+		`{"id":2,"method":"mining.authorize","params":["t1Zj3eDzA8yPa4fUZFNj3fc1Fo4QduhAHyA.rig1","x"]}`,
+		`{"id":4,"method":"mining.submit","params":["worker.1","1b2c","00000001","4966bc61","9962e301","00006000"]}`,
+		`{"id":4,"method":"mining.submit","params":["w","0","90041358","00000000000000000000000000000000","fd4005"]}`,
+		`{"id":5,"method":"mining.configure","params":[["version-rolling"],{"version-rolling.mask":"1fffe000","version-rolling.min-bit-count":2}]}`,
+		`{"id":6,"method":"mining.suggest_target","params":["00000000ffff"]}`,
+		`{"id":7,"method":"mining.suggest_difficulty","params":[0.5]}`,
+		`{"id":8,"method":"mining.extranonce.subscribe","params":[]}`,
+		`{"id":"v","result":"cgminer/4.10.0","error":null}`,
+		`{"id":null,"method":"mining.notify","params":[]}`,
+		`{"id":1,"params":null}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, d := range []Dialect{Equihash, Bitcoin} {
+			req, err := d.Parse(data)
+			if err != nil {
+				continue
+			}
+
+			// Whatever parses marshals and parses again as the same type.
+			out, err := json.Marshal(req)
+			if err != nil {
+				t.Fatalf("%s: marshalling %#v: %v", d, req, err)
+			}
+
+			again, err := d.Parse(out)
+			if err != nil {
+				t.Fatalf("%s: parsing %s back: %v", d, out, err)
+			}
+			if again.Type() != req.Type() {
+				t.Fatalf("%s: %s parsed back as %s", d, req.Type(), again.Type())
+			}
+		}
+
+		for _, d := range []Dialect{Equihash, Bitcoin} {
+			_, _ = d.ParseServerMessage(data)
+		}
+	})
+}
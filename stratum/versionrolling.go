@@ -0,0 +1,129 @@
+package stratum
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Version rolling, see BIP 310.
+
+const (
+	SetVersionMask ResponseType = "mining.set_version_mask"
+
+	// mining.configure extension and its parameters.
+	VersionRolling            = "version-rolling"
+	VersionRollingMask        = "version-rolling.mask"
+	VersionRollingMinBitCount = "version-rolling.min-bit-count"
+)
+
+// DefaultVersionMask covers the general purpose bits of BIP 320.
+const DefaultVersionMask uint32 = 0x1fffe000
+
+var ErrorVersionMask = &Error{20, "Version bits outside mask"}
+
+type ResponseSetVersionMask struct {
+	Mask uint32
+}
+
+// parseMask reads a hex mask, pools and miners don't always zero pad it.
+func parseMask(s string) (uint32, error) {
+	mask, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, ErrBadInput
+	}
+
+	return uint32(mask), nil
+}
+
+// VersionRolling returns the mask and the minimum number of bits a miner
+// asks for, ok is false when it didn't ask for version rolling.
+func (r RequestConfigure) VersionRolling() (mask uint32, minBitCount int, ok bool) {
+	for _, extension := range r.Extensions {
+		if extension == VersionRolling {
+			ok = true
+		}
+	}
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	mask = 0xffffffff
+	if s, isString := r.Params[VersionRollingMask].(string); isString {
+		if m, err := parseMask(s); err == nil {
+			mask = m
+		}
+	}
+
+	if n, isNumber := r.Params[VersionRollingMinBitCount].(float64); isNumber {
+		minBitCount = int(n)
+	}
+
+	return mask, minBitCount, true
+}
+
+// VersionRollingResult is the reply to a miner's version-rolling request, a
+// zero mask refuses it.
+func VersionRollingResult(mask uint32) map[string]interface{} {
+	if mask == 0 {
+		return map[string]interface{}{VersionRolling: false}
+	}
+
+	return map[string]interface{}{
+		VersionRolling:     true,
+		VersionRollingMask: ToHex(mask),
+	}
+}
+
+// ParseVersionRollingResult returns the mask a pool granted in its reply to
+// mining.configure, zero when it refused.
+func ParseVersionRollingResult(result *json.RawMessage) (uint32, error) {
+	if result == nil {
+		return 0, ErrBadInput
+	}
+
+	var params map[string]interface{}
+	if err := json.Unmarshal(*result, &params); err != nil {
+		return 0, ErrBadInput
+	}
+
+	if granted, _ := params[VersionRolling].(bool); !granted {
+		return 0, nil
+	}
+
+	s, _ := params[VersionRollingMask].(string)
+	return parseMask(s)
+}
+
+func parseSetVersionMask(raw RawRPC) (Response, error) {
+	var params []string
+	if err := unmarshalParams(raw, &params); err != nil {
+		return nil, err
+	}
+
+	if len(params) < 1 {
+		return nil, ErrBadInput
+	}
+
+	mask, err := parseMask(params[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return ResponseSetVersionMask{
+		Mask: mask,
+	}, nil
+}
+
+func (r ResponseSetVersionMask) MarshalJSON() ([]byte, error) {
+	return marshalRequest(RawRPC{
+		ID:     nil,
+		Method: RequestType(SetVersionMask),
+	}, []string{
+		ToHex(r.Mask),
+	})
+}
+
+func (r ResponseSetVersionMask) Type() ResponseType {
+	return SetVersionMask
+}
@@ -0,0 +1,214 @@
+package stratum
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConfigureVersionRolling(t *testing.T) {
+	tests := []struct {
+		name        string
+		extensions  []string
+		params      map[string]interface{}
+		mask        uint32
+		minBitCount int
+		ok          bool
+	}{
+		{name: "not asked", extensions: []string{"minimum-difficulty"}},
+		{name: "no extensions"},
+		{
+			name:       "mask and min bit count",
+			extensions: []string{"subscribe-extranonce", VersionRolling},
+			params: map[string]interface{}{
+				VersionRollingMask:        "1fffe000",
+				VersionRollingMinBitCount: float64(2),
+			},
+			mask:        0x1fffe000,
+			minBitCount: 2,
+			ok:          true,
+		},
+		{
+			name:       "mask not zero padded",
+			extensions: []string{VersionRolling},
+			params:     map[string]interface{}{VersionRollingMask: "e000"},
+			mask:       0xe000,
+			ok:         true,
+		},
+		{
+			name:       "without a mask",
+			extensions: []string{VersionRolling},
+			mask:       0xffffffff,
+			ok:         true,
+		},
+		{
+			name:       "invalid mask",
+			extensions: []string{VersionRolling},
+			params:     map[string]interface{}{VersionRollingMask: "1fffe000ff"},
+			mask:       0xffffffff,
+			ok:         true,
+		},
+		{
+			name:       "mask not a string",
+			extensions: []string{VersionRolling},
+			params:     map[string]interface{}{VersionRollingMask: float64(0x1fffe000)},
+			mask:       0xffffffff,
+			ok:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		r := RequestConfigure{Extensions: tt.extensions, Params: tt.params}
+		mask, minBitCount, ok := r.VersionRolling()
+		if mask != tt.mask || minBitCount != tt.minBitCount || ok != tt.ok {
+			t.Errorf("%s: got (%08x, %d, %v), want (%08x, %d, %v)", tt.name, mask, minBitCount, ok, tt.mask, tt.minBitCount, tt.ok)
+		}
+	}
+}
+
+func TestVersionRollingResult(t *testing.T) {
+	tests := []struct {
+		mask uint32
+		want string
+	}{
+		{0, `{"version-rolling":false}`},
+		{0x1fffe000, `{"version-rolling":true,"version-rolling.mask":"1fffe000"}`},
+		{0x00006000, `{"version-rolling":true,"version-rolling.mask":"00006000"}`},
+	}
+
+	for _, tt := range tests {
+		data, err := json.Marshal(VersionRollingResult(tt.mask))
+		if err != nil {
+			t.Errorf("%08x: %v", tt.mask, err)
+			continue
+		}
+
+		if string(data) != tt.want {
+			t.Errorf("%08x: marshalled %s, want %s", tt.mask, data, tt.want)
+		}
+
+		mask, err := ParseVersionRollingResult(rawJSON(string(data)))
+		if err != nil || mask != tt.mask {
+			t.Errorf("%08x: parsed back %08x, %v", tt.mask, mask, err)
+		}
+	}
+}
+
+func TestParseVersionRollingResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *json.RawMessage
+		mask   uint32
+		err    bool
+	}{
+		{name: "granted", result: rawJSON(`{"version-rolling":true,"version-rolling.mask":"1fffe000"}`), mask: 0x1fffe000},
+		{name: "refused", result: rawJSON(`{"version-rolling":false}`)},
+		{name: "other extensions only", result: rawJSON(`{"minimum-difficulty":true}`)},
+		{name: "granted without a mask", result: rawJSON(`{"version-rolling":true}`), err: true},
+		{name: "invalid mask", result: rawJSON(`{"version-rolling":true,"version-rolling.mask":"xyz"}`), err: true},
+		{name: "not an object", result: rawJSON(`true`), err: true},
+		{name: "no result", err: true},
+	}
+
+	for _, tt := range tests {
+		mask, err := ParseVersionRollingResult(tt.result)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: parsed %08x, want an error", tt.name, mask)
+			}
+			continue
+		}
+
+		if err != nil || mask != tt.mask {
+			t.Errorf("%s: got %08x, %v, want %08x", tt.name, mask, err, tt.mask)
+		}
+	}
+}
+
+func TestSetVersionMask(t *testing.T) {
+	msg, err := ParseServerMessage([]byte(`{"id":null,"method":"mining.set_version_mask","params":["1fffe000"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ResponseSetVersionMask{Mask: 0x1fffe000}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("parsed %#v, want %#v", msg, want)
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `{"id":null,"method":"mining.set_version_mask","params":["1fffe000"]}` {
+		t.Errorf("marshalled %s", data)
+	}
+
+	for _, line := range []string{
+		`{"id":null,"method":"mining.set_version_mask","params":[]}`,
+		`{"id":null,"method":"mining.set_version_mask","params":["1fffe000ff"]}`,
+		`{"id":null,"method":"mining.set_version_mask","params":[536862720]}`,
+	} {
+		if msg, err := ParseServerMessage([]byte(line)); err == nil {
+			t.Errorf("%s: parsed %#v, want an error", line, msg)
+		}
+	}
+}
+
+func TestSubmitVersionBits(t *testing.T) {
+	base := RequestBase{float64(4), Submit}
+
+	tests := []struct {
+		name string
+		line string
+		want RequestSubmitBitcoin
+		err  bool
+	}{
+		{
+			name: "without version bits",
+			line: `{"id":4,"method":"mining.submit","params":["worker.1","1b2c","00000001","4966bc61","9962e301"]}`,
+			want: RequestSubmitBitcoin{base, "worker.1", "1b2c", []byte{0, 0, 0, 1}, 0x4966bc61, 0x9962e301, 0, false},
+		},
+		{
+			name: "with version bits",
+			line: `{"id":4,"method":"mining.submit","params":["worker.1","1b2c","00000001","4966bc61","9962e301","00006000"]}`,
+			want: RequestSubmitBitcoin{base, "worker.1", "1b2c", []byte{0, 0, 0, 1}, 0x4966bc61, 0x9962e301, 0x6000, true},
+		},
+		{
+			name: "zero version bits",
+			line: `{"id":4,"method":"mining.submit","params":["worker.1","1b2c","00000001","4966bc61","9962e301","00000000"]}`,
+			want: RequestSubmitBitcoin{base, "worker.1", "1b2c", []byte{0, 0, 0, 1}, 0x4966bc61, 0x9962e301, 0, true},
+		},
+		{
+			name: "invalid version bits",
+			line: `{"id":4,"method":"mining.submit","params":["worker.1","1b2c","00000001","4966bc61","9962e301","g0006000"]}`,
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := Bitcoin.Parse([]byte(tt.line))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: parsed %#v, want an error", tt.name, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parsed %#v, want %#v", tt.name, got, tt.want)
+			continue
+		}
+
+		data, err := json.Marshal(got)
+		if err != nil || string(data) != tt.line {
+			t.Errorf("%s: marshalled %s, %v, want %s", tt.name, data, err, tt.line)
+		}
+	}
+}
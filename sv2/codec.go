@@ -0,0 +1,195 @@
+package sv2
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Binary encoding of the protocol's data types, all integers little-endian.
+
+var ErrBadInput = errors.New("bad input")
+
+type encoder struct {
+	buf []byte
+	err error
+}
+
+func (e *encoder) u8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.u8(1)
+		return
+	}
+
+	e.u8(0)
+}
+
+func (e *encoder) u16(v uint16) {
+	e.buf = append(e.buf, byte(v), byte(v>>8))
+}
+
+func (e *encoder) u32(v uint32) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) u64(v uint64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) f32(v float32) {
+	e.u32(math.Float32bits(v))
+}
+
+func (e *encoder) u256(v [32]byte) {
+	e.buf = append(e.buf, v[:]...)
+}
+
+// bytes with a length prefix of prefix bytes, at most max long.
+func (e *encoder) bytes(v []byte, prefix, max int) {
+	if len(v) > max {
+		e.err = ErrBadInput
+		return
+	}
+
+	if prefix == 1 {
+		e.u8(uint8(len(v)))
+	} else {
+		e.u16(uint16(len(v)))
+	}
+
+	e.buf = append(e.buf, v...)
+}
+
+// STR0_255
+func (e *encoder) str(v string) {
+	e.bytes([]byte(v), 1, 255)
+}
+
+// B0_32
+func (e *encoder) b032(v []byte) {
+	e.bytes(v, 1, 32)
+}
+
+// B0_64K
+func (e *encoder) b064k(v []byte) {
+	e.bytes(v, 2, 65535)
+}
+
+// SEQ0_255[U256]
+func (e *encoder) u256s(v [][32]byte) {
+	if len(v) > 255 {
+		e.err = ErrBadInput
+		return
+	}
+
+	e.u8(uint8(len(v)))
+	for _, x := range v {
+		e.u256(x)
+	}
+}
+
+// OPTION[u32]
+func (e *encoder) optionU32(v *uint32) {
+	if v == nil {
+		e.u8(0)
+		return
+	}
+
+	e.u8(1)
+	e.u32(*v)
+}
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = ErrBadInput
+		return make([]byte, n)
+	}
+
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) u8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *decoder) bool() bool {
+	return d.u8()&1 == 1
+}
+
+func (d *decoder) u16() uint16 {
+	return binary.LittleEndian.Uint16(d.next(2))
+}
+
+func (d *decoder) u32() uint32 {
+	return binary.LittleEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) u64() uint64 {
+	return binary.LittleEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) f32() float32 {
+	return math.Float32frombits(d.u32())
+}
+
+func (d *decoder) u256() (v [32]byte) {
+	copy(v[:], d.next(32))
+	return v
+}
+
+func (d *decoder) bytes(prefix, max int) []byte {
+	var length int
+	if prefix == 1 {
+		length = int(d.u8())
+	} else {
+		length = int(d.u16())
+	}
+
+	if length > max {
+		d.err = ErrBadInput
+		return nil
+	}
+
+	return append([]byte{}, d.next(length)...)
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes(1, 255))
+}
+
+func (d *decoder) b032() []byte {
+	return d.bytes(1, 32)
+}
+
+func (d *decoder) b064k() []byte {
+	return d.bytes(2, 65535)
+}
+
+func (d *decoder) u256s() [][32]byte {
+	v := make([][32]byte, d.u8())
+	for i := range v {
+		v[i] = d.u256()
+	}
+
+	return v
+}
+
+func (d *decoder) optionU32() *uint32 {
+	if d.u8() == 0 {
+		return nil
+	}
+
+	v := d.u32()
+	return &v
+}
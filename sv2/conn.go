@@ -0,0 +1,122 @@
+package sv2
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+const (
+	headerSize = 6
+
+	// Noise messages are at most 65535 bytes, MAC included.
+	maxChunkSize = 65535
+
+	// Highest length a frame header can carry.
+	MaxPayloadSize = 1<<24 - 1
+
+	channelMsgBit = 0x8000
+)
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// Frame of the Stratum V2 binary protocol.
+type Frame struct {
+	Extension  uint16
+	ChannelMsg bool
+	Type       uint8
+	Payload    []byte
+}
+
+// Conn is an encrypted connection after the handshake. Reads and writes
+// must each come from a single goroutine.
+type Conn struct {
+	net.Conn
+
+	recv, send *cipherState
+
+	// Frames with a larger payload are rejected before being read.
+	MaxPayloadSize int
+}
+
+// ReadFrame reads and decrypts the next frame.
+func (c *Conn) ReadFrame() (Frame, error) {
+	var frame Frame
+
+	buf := make([]byte, headerSize+macSize)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return frame, err
+	}
+
+	header, err := c.recv.decrypt(buf[:0], nil, buf)
+	if err != nil {
+		return frame, err
+	}
+
+	extension := uint16(header[0]) | uint16(header[1])<<8
+	frame.Extension = extension &^ channelMsgBit
+	frame.ChannelMsg = extension&channelMsgBit != 0
+	frame.Type = header[2]
+
+	length := int(header[3]) | int(header[4])<<8 | int(header[5])<<16
+	if c.MaxPayloadSize > 0 && length > c.MaxPayloadSize {
+		return frame, ErrFrameTooLarge
+	}
+
+	frame.Payload = make([]byte, 0, length)
+	for remaining := length; remaining > 0; {
+		size := remaining
+		if size > maxChunkSize-macSize {
+			size = maxChunkSize - macSize
+		}
+
+		chunk := make([]byte, size+macSize)
+		if _, err := io.ReadFull(c.Conn, chunk); err != nil {
+			return frame, err
+		}
+
+		if frame.Payload, err = c.recv.decrypt(frame.Payload, nil, chunk); err != nil {
+			return frame, err
+		}
+
+		remaining -= size
+	}
+
+	return frame, nil
+}
+
+// WriteFrame encrypts and writes a frame.
+func (c *Conn) WriteFrame(frame Frame) error {
+	length := len(frame.Payload)
+	if length > MaxPayloadSize {
+		return ErrFrameTooLarge
+	}
+
+	extension := frame.Extension
+	if frame.ChannelMsg {
+		extension |= channelMsgBit
+	}
+
+	header := []byte{
+		byte(extension), byte(extension >> 8),
+		frame.Type,
+		byte(length), byte(length >> 8), byte(length >> 16),
+	}
+
+	chunks := (length + maxChunkSize - macSize - 1) / (maxChunkSize - macSize)
+	buf := make([]byte, 0, headerSize+macSize+length+chunks*macSize)
+	buf = c.send.encrypt(buf, nil, header)
+
+	for payload := frame.Payload; len(payload) > 0; {
+		size := len(payload)
+		if size > maxChunkSize-macSize {
+			size = maxChunkSize - macSize
+		}
+
+		buf = c.send.encrypt(buf, nil, payload[:size])
+		payload = payload[size:]
+	}
+
+	_, err := c.Conn.Write(buf)
+	return err
+}
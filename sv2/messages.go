@@ -0,0 +1,449 @@
+package sv2
+
+import "errors"
+
+// Message types of the common and mining protocols.
+const (
+	TypeSetupConnection        uint8 = 0x00
+	TypeSetupConnectionSuccess uint8 = 0x01
+	TypeSetupConnectionError   uint8 = 0x02
+
+	TypeOpenStandardMiningChannel        uint8 = 0x10
+	TypeOpenStandardMiningChannelSuccess uint8 = 0x11
+	TypeOpenMiningChannelError           uint8 = 0x12
+	TypeOpenExtendedMiningChannel        uint8 = 0x13
+	TypeOpenExtendedMiningChannelSuccess uint8 = 0x14
+	TypeNewMiningJob                     uint8 = 0x15
+	TypeUpdateChannel                    uint8 = 0x16
+	TypeUpdateChannelError               uint8 = 0x17
+	TypeCloseChannel                     uint8 = 0x18
+	TypeSubmitSharesStandard             uint8 = 0x1a
+	TypeSubmitSharesExtended             uint8 = 0x1b
+	TypeSubmitSharesSuccess              uint8 = 0x1c
+	TypeSubmitSharesError                uint8 = 0x1d
+	TypeNewExtendedMiningJob             uint8 = 0x1f
+	TypeSetNewPrevHash                   uint8 = 0x20
+	TypeSetTarget                        uint8 = 0x21
+)
+
+// SetupConnection protocols
+const (
+	ProtocolMining uint8 = 0
+)
+
+// Version of the protocol we speak.
+const ProtocolVersion uint16 = 2
+
+// SetupConnection flags for the mining protocol, sent by the miner.
+const (
+	FlagRequiresStandardJobs   uint32 = 1 << 0
+	FlagRequiresWorkSelection  uint32 = 1 << 1
+	FlagRequiresVersionRolling uint32 = 1 << 2
+)
+
+// SetupConnection.Success flags for the mining protocol, sent by us.
+const (
+	FlagRequiresFixedVersion     uint32 = 1 << 0
+	FlagRequiresExtendedChannels uint32 = 1 << 1
+)
+
+var ErrUnknownMessage = errors.New("unknown message type")
+
+type (
+	// Message of the binary protocol.
+	Message interface {
+		MsgType() uint8
+	}
+
+	SetupConnection struct {
+		Protocol        uint8
+		MinVersion      uint16
+		MaxVersion      uint16
+		Flags           uint32
+		EndpointHost    string
+		EndpointPort    uint16
+		Vendor          string
+		HardwareVersion string
+		Firmware        string
+		DeviceID        string
+	}
+
+	SetupConnectionSuccess struct {
+		UsedVersion uint16
+		Flags       uint32
+	}
+
+	SetupConnectionError struct {
+		Flags     uint32
+		ErrorCode string
+	}
+
+	OpenStandardMiningChannel struct {
+		RequestID       uint32
+		UserIdentity    string
+		NominalHashRate float32
+		MaxTarget       [32]byte
+	}
+
+	OpenStandardMiningChannelSuccess struct {
+		RequestID        uint32
+		ChannelID        uint32
+		Target           [32]byte
+		ExtranoncePrefix []byte
+		GroupChannelID   uint32
+	}
+
+	OpenExtendedMiningChannel struct {
+		RequestID         uint32
+		UserIdentity      string
+		NominalHashRate   float32
+		MaxTarget         [32]byte
+		MinExtranonceSize uint16
+	}
+
+	OpenExtendedMiningChannelSuccess struct {
+		RequestID        uint32
+		ChannelID        uint32
+		Target           [32]byte
+		ExtranonceSize   uint16
+		ExtranoncePrefix []byte
+	}
+
+	OpenMiningChannelError struct {
+		RequestID uint32
+		ErrorCode string
+	}
+
+	UpdateChannel struct {
+		ChannelID       uint32
+		NominalHashRate float32
+		MaxTarget       [32]byte
+	}
+
+	UpdateChannelError struct {
+		ChannelID uint32
+		ErrorCode string
+	}
+
+	CloseChannel struct {
+		ChannelID  uint32
+		ReasonCode string
+	}
+
+	SubmitSharesStandard struct {
+		ChannelID      uint32
+		SequenceNumber uint32
+		JobID          uint32
+		Nonce          uint32
+		NTime          uint32
+		Version        uint32
+	}
+
+	SubmitSharesExtended struct {
+		SubmitSharesStandard
+		Extranonce []byte
+	}
+
+	SubmitSharesSuccess struct {
+		ChannelID               uint32
+		LastSequenceNumber      uint32
+		NewSubmitsAcceptedCount uint32
+		NewSharesSum            uint64
+	}
+
+	SubmitSharesError struct {
+		ChannelID      uint32
+		SequenceNumber uint32
+		ErrorCode      string
+	}
+
+	// NewMiningJob for a standard channel. Without MinNTime it is a future
+	// job, started by the next SetNewPrevHash.
+	NewMiningJob struct {
+		ChannelID  uint32
+		JobID      uint32
+		MinNTime   *uint32
+		Version    uint32
+		MerkleRoot [32]byte
+	}
+
+	NewExtendedMiningJob struct {
+		ChannelID             uint32
+		JobID                 uint32
+		MinNTime              *uint32
+		Version               uint32
+		VersionRollingAllowed bool
+		MerklePath            [][32]byte
+		CoinbaseTxPrefix      []byte
+		CoinbaseTxSuffix      []byte
+	}
+
+	SetNewPrevHash struct {
+		ChannelID uint32
+		JobID     uint32
+		PrevHash  [32]byte
+		MinNTime  uint32
+		NBits     uint32
+	}
+
+	SetTarget struct {
+		ChannelID uint32
+		MaxTarget [32]byte
+	}
+)
+
+func (SetupConnection) MsgType() uint8                  { return TypeSetupConnection }
+func (SetupConnectionSuccess) MsgType() uint8           { return TypeSetupConnectionSuccess }
+func (SetupConnectionError) MsgType() uint8             { return TypeSetupConnectionError }
+func (OpenStandardMiningChannel) MsgType() uint8        { return TypeOpenStandardMiningChannel }
+func (OpenStandardMiningChannelSuccess) MsgType() uint8 { return TypeOpenStandardMiningChannelSuccess }
+func (OpenExtendedMiningChannel) MsgType() uint8        { return TypeOpenExtendedMiningChannel }
+func (OpenExtendedMiningChannelSuccess) MsgType() uint8 { return TypeOpenExtendedMiningChannelSuccess }
+func (OpenMiningChannelError) MsgType() uint8           { return TypeOpenMiningChannelError }
+func (UpdateChannel) MsgType() uint8                    { return TypeUpdateChannel }
+func (UpdateChannelError) MsgType() uint8               { return TypeUpdateChannelError }
+func (CloseChannel) MsgType() uint8                     { return TypeCloseChannel }
+func (SubmitSharesStandard) MsgType() uint8             { return TypeSubmitSharesStandard }
+func (SubmitSharesExtended) MsgType() uint8             { return TypeSubmitSharesExtended }
+func (SubmitSharesSuccess) MsgType() uint8              { return TypeSubmitSharesSuccess }
+func (SubmitSharesError) MsgType() uint8                { return TypeSubmitSharesError }
+func (NewMiningJob) MsgType() uint8                     { return TypeNewMiningJob }
+func (NewExtendedMiningJob) MsgType() uint8             { return TypeNewExtendedMiningJob }
+func (SetNewPrevHash) MsgType() uint8                   { return TypeSetNewPrevHash }
+func (SetTarget) MsgType() uint8                        { return TypeSetTarget }
+
+// isChannelMsg reports whether messages of type t are addressed to a channel.
+func isChannelMsg(t uint8) bool {
+	switch t {
+	case TypeNewMiningJob, TypeUpdateChannel, TypeUpdateChannelError, TypeCloseChannel,
+		TypeSubmitSharesStandard, TypeSubmitSharesExtended, TypeSubmitSharesSuccess, TypeSubmitSharesError,
+		TypeNewExtendedMiningJob, TypeSetNewPrevHash, TypeSetTarget:
+		return true
+	}
+
+	return false
+}
+
+// Marshal a message into a frame.
+func Marshal(m Message) (Frame, error) {
+	var e encoder
+
+	switch m := m.(type) {
+	case SetupConnection:
+		e.u8(m.Protocol)
+		e.u16(m.MinVersion)
+		e.u16(m.MaxVersion)
+		e.u32(m.Flags)
+		e.str(m.EndpointHost)
+		e.u16(m.EndpointPort)
+		e.str(m.Vendor)
+		e.str(m.HardwareVersion)
+		e.str(m.Firmware)
+		e.str(m.DeviceID)
+
+	case SetupConnectionSuccess:
+		e.u16(m.UsedVersion)
+		e.u32(m.Flags)
+
+	case SetupConnectionError:
+		e.u32(m.Flags)
+		e.str(m.ErrorCode)
+
+	case OpenStandardMiningChannel:
+		e.u32(m.RequestID)
+		e.str(m.UserIdentity)
+		e.f32(m.NominalHashRate)
+		e.u256(m.MaxTarget)
+
+	case OpenStandardMiningChannelSuccess:
+		e.u32(m.RequestID)
+		e.u32(m.ChannelID)
+		e.u256(m.Target)
+		e.b032(m.ExtranoncePrefix)
+		e.u32(m.GroupChannelID)
+
+	case OpenExtendedMiningChannel:
+		e.u32(m.RequestID)
+		e.str(m.UserIdentity)
+		e.f32(m.NominalHashRate)
+		e.u256(m.MaxTarget)
+		e.u16(m.MinExtranonceSize)
+
+	case OpenExtendedMiningChannelSuccess:
+		e.u32(m.RequestID)
+		e.u32(m.ChannelID)
+		e.u256(m.Target)
+		e.u16(m.ExtranonceSize)
+		e.b032(m.ExtranoncePrefix)
+
+	case OpenMiningChannelError:
+		e.u32(m.RequestID)
+		e.str(m.ErrorCode)
+
+	case UpdateChannel:
+		e.u32(m.ChannelID)
+		e.f32(m.NominalHashRate)
+		e.u256(m.MaxTarget)
+
+	case UpdateChannelError:
+		e.u32(m.ChannelID)
+		e.str(m.ErrorCode)
+
+	case CloseChannel:
+		e.u32(m.ChannelID)
+		e.str(m.ReasonCode)
+
+	case SubmitSharesStandard:
+		marshalSubmit(&e, m)
+
+	case SubmitSharesExtended:
+		marshalSubmit(&e, m.SubmitSharesStandard)
+		e.b032(m.Extranonce)
+
+	case SubmitSharesSuccess:
+		e.u32(m.ChannelID)
+		e.u32(m.LastSequenceNumber)
+		e.u32(m.NewSubmitsAcceptedCount)
+		e.u64(m.NewSharesSum)
+
+	case SubmitSharesError:
+		e.u32(m.ChannelID)
+		e.u32(m.SequenceNumber)
+		e.str(m.ErrorCode)
+
+	case NewMiningJob:
+		e.u32(m.ChannelID)
+		e.u32(m.JobID)
+		e.optionU32(m.MinNTime)
+		e.u32(m.Version)
+		e.u256(m.MerkleRoot)
+
+	case NewExtendedMiningJob:
+		e.u32(m.ChannelID)
+		e.u32(m.JobID)
+		e.optionU32(m.MinNTime)
+		e.u32(m.Version)
+		e.bool(m.VersionRollingAllowed)
+		e.u256s(m.MerklePath)
+		e.b064k(m.CoinbaseTxPrefix)
+		e.b064k(m.CoinbaseTxSuffix)
+
+	case SetNewPrevHash:
+		e.u32(m.ChannelID)
+		e.u32(m.JobID)
+		e.u256(m.PrevHash)
+		e.u32(m.MinNTime)
+		e.u32(m.NBits)
+
+	case SetTarget:
+		e.u32(m.ChannelID)
+		e.u256(m.MaxTarget)
+
+	default:
+		return Frame{}, ErrUnknownMessage
+	}
+
+	if e.err != nil {
+		return Frame{}, e.err
+	}
+
+	return Frame{
+		ChannelMsg: isChannelMsg(m.MsgType()),
+		Type:       m.MsgType(),
+		Payload:    e.buf,
+	}, nil
+}
+
+func marshalSubmit(e *encoder, m SubmitSharesStandard) {
+	e.u32(m.ChannelID)
+	e.u32(m.SequenceNumber)
+	e.u32(m.JobID)
+	e.u32(m.Nonce)
+	e.u32(m.NTime)
+	e.u32(m.Version)
+}
+
+// Parse a frame sent by a miner.
+func Parse(frame Frame) (Message, error) {
+	if frame.Extension != 0 {
+		return nil, ErrUnknownMessage
+	}
+
+	d := decoder{buf: frame.Payload}
+
+	var m Message
+	switch frame.Type {
+	case TypeSetupConnection:
+		m = SetupConnection{
+			Protocol:        d.u8(),
+			MinVersion:      d.u16(),
+			MaxVersion:      d.u16(),
+			Flags:           d.u32(),
+			EndpointHost:    d.str(),
+			EndpointPort:    d.u16(),
+			Vendor:          d.str(),
+			HardwareVersion: d.str(),
+			Firmware:        d.str(),
+			DeviceID:        d.str(),
+		}
+
+	case TypeOpenStandardMiningChannel:
+		m = OpenStandardMiningChannel{
+			RequestID:       d.u32(),
+			UserIdentity:    d.str(),
+			NominalHashRate: d.f32(),
+			MaxTarget:       d.u256(),
+		}
+
+	case TypeOpenExtendedMiningChannel:
+		m = OpenExtendedMiningChannel{
+			RequestID:         d.u32(),
+			UserIdentity:      d.str(),
+			NominalHashRate:   d.f32(),
+			MaxTarget:         d.u256(),
+			MinExtranonceSize: d.u16(),
+		}
+
+	case TypeUpdateChannel:
+		m = UpdateChannel{
+			ChannelID:       d.u32(),
+			NominalHashRate: d.f32(),
+			MaxTarget:       d.u256(),
+		}
+
+	case TypeCloseChannel:
+		m = CloseChannel{
+			ChannelID:  d.u32(),
+			ReasonCode: d.str(),
+		}
+
+	case TypeSubmitSharesStandard:
+		m = parseSubmit(&d)
+
+	case TypeSubmitSharesExtended:
+		m = SubmitSharesExtended{
+			SubmitSharesStandard: parseSubmit(&d),
+			Extranonce:           d.b032(),
+		}
+
+	default:
+		return nil, ErrUnknownMessage
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return m, nil
+}
+
+func parseSubmit(d *decoder) SubmitSharesStandard {
+	return SubmitSharesStandard{
+		ChannelID:      d.u32(),
+		SequenceNumber: d.u32(),
+		JobID:          d.u32(),
+		Nonce:          d.u32(),
+		NTime:          d.u32(),
+		Version:        d.u32(),
+	}
+}
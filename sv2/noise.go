@@ -0,0 +1,247 @@
+package sv2
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Noise_NX handshake as in the Stratum V2 spec: the miner sends an
+// ephemeral key, we answer with ours, our static key and a certificate
+// signed by the authority key miners are configured with.
+
+const protocolName = "Noise_NX_Secp256k1+EllSwift_ChaChaPoly_SHA256"
+
+const (
+	ellSwiftSize    = 64
+	macSize         = 16
+	certificateSize = 2 + 4 + 4 + 64
+
+	// Size of our reply to the miner's ephemeral key.
+	handshakeReplySize = ellSwiftSize + ellSwiftSize + macSize + certificateSize + macSize
+)
+
+var ErrHandshake = errors.New("noise handshake failed")
+
+// Certificate binds the server's static key to the authority key.
+type Certificate struct {
+	Version       uint16
+	ValidFrom     uint32
+	NotValidAfter uint32
+	Signature     [64]byte
+}
+
+// NewCertificate signs static with authority, valid from now for validity.
+func NewCertificate(authority, static *PrivateKey, now time.Time, validity time.Duration) (Certificate, error) {
+	cert := Certificate{
+		ValidFrom:     uint32(now.Unix()),
+		NotValidAfter: uint32(now.Add(validity).Unix()),
+	}
+
+	sig, err := authority.Sign(cert.hash(static.PublicKey()))
+	if err != nil {
+		return cert, err
+	}
+
+	cert.Signature = sig
+	return cert, nil
+}
+
+// hash of the signed fields and the server's x-only static key.
+func (c Certificate) hash(static [32]byte) [32]byte {
+	buf := make([]byte, 10, 42)
+	binary.LittleEndian.PutUint16(buf, c.Version)
+	binary.LittleEndian.PutUint32(buf[2:], c.ValidFrom)
+	binary.LittleEndian.PutUint32(buf[6:], c.NotValidAfter)
+
+	return sha256.Sum256(append(buf, static[:]...))
+}
+
+func (c Certificate) bytes() []byte {
+	buf := make([]byte, certificateSize)
+	binary.LittleEndian.PutUint16(buf, c.Version)
+	binary.LittleEndian.PutUint32(buf[2:], c.ValidFrom)
+	binary.LittleEndian.PutUint32(buf[6:], c.NotValidAfter)
+	copy(buf[10:], c.Signature[:])
+	return buf
+}
+
+// cipherState is a key and its nonce counter.
+type cipherState struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newCipherState(key [32]byte) (*cipherState, error) {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &cipherState{aead: aead}, nil
+}
+
+func (c *cipherState) nextNonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], c.nonce)
+	c.nonce++
+	return nonce
+}
+
+func (c *cipherState) encrypt(dst, ad, plaintext []byte) []byte {
+	return c.aead.Seal(dst, c.nextNonce(), plaintext, ad)
+}
+
+func (c *cipherState) decrypt(dst, ad, ciphertext []byte) ([]byte, error) {
+	return c.aead.Open(dst, c.nextNonce(), ciphertext, ad)
+}
+
+// hkdf2 derives two keys from the chaining key, as Noise does.
+func hkdf2(ck [32]byte, ikm []byte) (out1, out2 [32]byte) {
+	mac := hmac.New(sha256.New, ck[:])
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{1})
+	copy(out1[:], mac.Sum(nil))
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{2})
+	copy(out2[:], mac.Sum(nil))
+	return out1, out2
+}
+
+// symmetricState of the handshake.
+type symmetricState struct {
+	ck, h [32]byte
+	cs    *cipherState
+}
+
+func newSymmetricState() *symmetricState {
+	s := symmetricState{ck: sha256.Sum256([]byte(protocolName))}
+	// Empty prologue
+	s.h = sha256.Sum256(s.ck[:])
+	return &s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	s.h = sha256.Sum256(append(s.h[:], data...))
+}
+
+func (s *symmetricState) mixKey(ikm [32]byte) error {
+	ck, key := hkdf2(s.ck, ikm[:])
+	s.ck = ck
+
+	cs, err := newCipherState(key)
+	if err != nil {
+		return err
+	}
+
+	s.cs = cs
+	return nil
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	if s.cs == nil {
+		s.mixHash(plaintext)
+		return plaintext
+	}
+
+	ciphertext := s.cs.encrypt(nil, s.h[:], plaintext)
+	s.mixHash(ciphertext)
+	return ciphertext
+}
+
+// split returns the initiator's and the responder's transport keys.
+func (s *symmetricState) split() (*cipherState, *cipherState, error) {
+	k1, k2 := hkdf2(s.ck, nil)
+
+	c1, err := newCipherState(k1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c2, err := newCipherState(k2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c1, c2, nil
+}
+
+// Accept runs the responder side of the handshake on conn, which must
+// complete before deadline.
+func Accept(conn net.Conn, static *PrivateKey, cert Certificate, deadline time.Time) (*Conn, error) {
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	s := newSymmetricState()
+
+	// -> e
+	var re [ellSwiftSize]byte
+	if _, err := io.ReadFull(conn, re[:]); err != nil {
+		return nil, err
+	}
+	s.mixHash(re[:])
+	s.encryptAndHash(nil)
+
+	// <- e, ee, s, es, certificate
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := ephemeral.ellSwiftEncode()
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 0, handshakeReplySize)
+	reply = append(reply, e[:]...)
+	s.mixHash(e[:])
+
+	ee, err := ephemeral.ellSwiftECDH(re, e)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	if err := s.mixKey(ee); err != nil {
+		return nil, err
+	}
+
+	encodedStatic, err := static.ellSwiftEncode()
+	if err != nil {
+		return nil, err
+	}
+	reply = append(reply, s.encryptAndHash(encodedStatic[:])...)
+
+	es, err := static.ellSwiftECDH(re, encodedStatic)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	if err := s.mixKey(es); err != nil {
+		return nil, err
+	}
+	reply = append(reply, s.encryptAndHash(cert.bytes())...)
+
+	if _, err := conn.Write(reply); err != nil {
+		return nil, err
+	}
+
+	recv, send, err := s.split()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Conn: conn, recv: recv, send: send}, nil
+}
@@ -0,0 +1,214 @@
+package sv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/ellswift"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// decryptAndHash is the initiator's side of encryptAndHash.
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.cs.decrypt(nil, s.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// initiate runs the miner's side of the handshake, checking the certificate
+// against the authority key.
+func initiate(t *testing.T, conn net.Conn, authority [32]byte) (*Conn, Certificate) {
+	t.Helper()
+
+	s := newSymmetricState()
+
+	ephemeral, e, err := ellswift.EllswiftCreate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Write(e[:]); err != nil {
+		t.Fatal(err)
+	}
+	s.mixHash(e[:])
+	s.encryptAndHash(nil)
+
+	reply := make([]byte, handshakeReplySize)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	var re [ellSwiftSize]byte
+	copy(re[:], reply)
+	s.mixHash(re[:])
+
+	ee, err := ellswift.V2Ecdh(ephemeral, re, e, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.mixKey(*ee); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := s.decryptAndHash(reply[ellSwiftSize : 2*ellSwiftSize+macSize])
+	if err != nil {
+		t.Fatalf("decrypting the static key: %v", err)
+	}
+
+	var rs [ellSwiftSize]byte
+	copy(rs[:], plaintext)
+
+	es, err := ellswift.V2Ecdh(ephemeral, rs, e, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.mixKey(*es); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err = s.decryptAndHash(reply[2*ellSwiftSize+macSize:])
+	if err != nil {
+		t.Fatalf("decrypting the certificate: %v", err)
+	}
+
+	cert := Certificate{
+		Version:       binary.LittleEndian.Uint16(plaintext),
+		ValidFrom:     binary.LittleEndian.Uint32(plaintext[2:]),
+		NotValidAfter: binary.LittleEndian.Uint32(plaintext[6:]),
+	}
+	copy(cert.Signature[:], plaintext[10:])
+
+	pk, err := schnorr.ParsePubKey(authority[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := schnorr.ParseSignature(cert.Signature[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := cert.hash(decodeEllSwift(t, rs))
+	if !sig.Verify(hash[:], pk) {
+		t.Fatal("certificate isn't signed by the authority")
+	}
+
+	send, recv, err := s.split()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Conn{Conn: conn, recv: recv, send: send}, cert
+}
+
+func TestHandshake(t *testing.T) {
+	authority, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	static, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	cert, err := NewCertificate(authority, static, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.ValidFrom != 1700000000 || cert.NotValidAfter != 1700003600 {
+		t.Errorf("certificate valid from %d to %d", cert.ValidFrom, cert.NotValidAfter)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := Accept(server, static, cert, time.Now().Add(5*time.Second))
+		accepted <- result{conn, err}
+	}()
+
+	miner, got := initiate(t, client, authority.PublicKey())
+	if got != cert {
+		t.Errorf("received certificate %+v, want %+v", got, cert)
+	}
+
+	res := <-accepted
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	pool := res.conn
+
+	frames := []Frame{
+		{Type: TypeSetupConnection, Payload: []byte{0, 2, 0}},
+		{Extension: 1, ChannelMsg: true, Type: TypeSubmitSharesStandard, Payload: []byte{}},
+		// Split into two noise messages.
+		{Type: TypeNewMiningJob, ChannelMsg: true, Payload: bytes.Repeat([]byte{0xab}, maxChunkSize+100)},
+	}
+
+	for _, frame := range frames {
+		errs := make(chan error, 1)
+		go func() { errs <- miner.WriteFrame(frame) }()
+
+		got, err := pool.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Extension != frame.Extension || got.ChannelMsg != frame.ChannelMsg || got.Type != frame.Type || !bytes.Equal(got.Payload, frame.Payload) {
+			t.Errorf("read frame %d/%v/%d of %d bytes, want %d/%v/%d of %d bytes", got.Extension, got.ChannelMsg, got.Type, len(got.Payload), frame.Extension, frame.ChannelMsg, frame.Type, len(frame.Payload))
+		}
+
+		// And back the other way.
+		go func() { errs <- pool.WriteFrame(frame) }()
+		if got, err = miner.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Payload, frame.Payload) {
+			t.Errorf("miner read %d bytes, want %d", len(got.Payload), len(frame.Payload))
+		}
+	}
+
+	pool.MaxPayloadSize = 10
+	go func() { _ = miner.WriteFrame(Frame{Type: TypeSetupConnection, Payload: make([]byte, 11)}) }()
+	if _, err := pool.ReadFrame(); err != ErrFrameTooLarge {
+		t.Errorf("oversized frame: %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	static, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// The miner never sends its key.
+	if _, err := Accept(server, static, Certificate{}, time.Now().Add(50*time.Millisecond)); err == nil {
+		t.Error("handshake without a key succeeded")
+	}
+}
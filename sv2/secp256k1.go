@@ -0,0 +1,103 @@
+package sv2
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ellswift"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// secp256k1 for the handshake, from btcec: ECDH over ElligatorSwift encoded
+// keys (BIP 324) and BIP 340 signatures for the certificate.
+
+var ErrInvalidKey = errors.New("invalid secp256k1 key")
+
+// PrivateKey on secp256k1.
+type PrivateKey struct {
+	key *btcec.PrivateKey
+}
+
+// GenerateKey returns a random private key.
+func GenerateKey() (*PrivateKey, error) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrivateKey{key: key}, nil
+}
+
+// NewPrivateKey from a 32 byte big-endian secret.
+func NewPrivateKey(secret []byte) (*PrivateKey, error) {
+	if len(secret) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	var d btcec.ModNScalar
+	if overflow := d.SetByteSlice(secret); overflow || d.IsZero() {
+		return nil, ErrInvalidKey
+	}
+
+	return &PrivateKey{key: btcec.PrivKeyFromScalar(&d)}, nil
+}
+
+// ParsePrivateKey from a hex string.
+func ParsePrivateKey(s string) (*PrivateKey, error) {
+	secret, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	return NewPrivateKey(secret)
+}
+
+// PublicKey returns the x-only public key, as in BIP 340.
+func (k *PrivateKey) PublicKey() [32]byte {
+	var out [32]byte
+	copy(out[:], schnorr.SerializePubKey(k.key.PubKey()))
+	return out
+}
+
+// Sign a 32 byte message with a BIP 340 Schnorr signature.
+func (k *PrivateKey) Sign(msg [32]byte) ([64]byte, error) {
+	var out [64]byte
+
+	sig, err := schnorr.Sign(k.key, msg[:])
+	if err != nil {
+		return out, err
+	}
+
+	copy(out[:], sig.Serialize())
+	return out, nil
+}
+
+// ellSwiftEncode returns a random 64 byte encoding of the public key.
+func (k *PrivateKey) ellSwiftEncode() ([64]byte, error) {
+	var out [64]byte
+
+	pub := k.PublicKey()
+	var x btcec.FieldVal
+	x.SetBytes(&pub)
+
+	u, t, err := ellswift.XElligatorSwift(&x)
+	if err != nil {
+		return out, err
+	}
+
+	copy(out[:32], u.Bytes()[:])
+	copy(out[32:], t.Bytes()[:])
+	return out, nil
+}
+
+// ellSwiftECDH returns the BIP 324 x-only shared secret between k, encoded
+// as ours, and the remote key of the initiator.
+func (k *PrivateKey) ellSwiftECDH(remote, ours [64]byte) ([32]byte, error) {
+	secret, err := ellswift.V2Ecdh(k.key, remote, ours, false)
+	if err != nil {
+		return [32]byte{}, ErrInvalidKey
+	}
+
+	return *secret, nil
+}
@@ -0,0 +1,163 @@
+package sv2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ellswift"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	tests := []struct {
+		secret string
+		public string
+		err    bool
+	}{
+		// BIP 340 test vectors.
+		{
+			secret: "0000000000000000000000000000000000000000000000000000000000000003",
+			public: "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+		},
+		{
+			secret: "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef",
+			public: "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+		},
+		{secret: "", err: true},
+		{secret: "0000000000000000000000000000000000000000000000000000000000000000", err: true},
+		// The group order and above.
+		{secret: "fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", err: true},
+		{secret: "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", err: true},
+		{secret: "03", err: true},
+		{secret: strings.Repeat("zz", 32), err: true},
+	}
+
+	for _, tt := range tests {
+		key, err := ParsePrivateKey(tt.secret)
+		if tt.err {
+			if err != ErrInvalidKey {
+				t.Errorf("%q: err %v, want ErrInvalidKey", tt.secret, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", tt.secret, err)
+			continue
+		}
+
+		if pub := key.PublicKey(); hex.EncodeToString(pub[:]) != tt.public {
+			t.Errorf("%q: public key %x, want %v", tt.secret, pub, tt.public)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := key.PublicKey()
+	pk, err := schnorr.ParsePubKey(pub[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range [][32]byte{{}, {1, 2, 3}, {31: 0xff}} {
+		sig, err := key.Sign(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := schnorr.ParseSignature(sig[:])
+		if err != nil {
+			t.Fatalf("%x: %v", msg, err)
+		}
+
+		if !parsed.Verify(msg[:], pk) {
+			t.Errorf("%x: signature %x doesn't verify", msg, sig)
+		}
+
+		other := msg
+		other[0] ^= 1
+		if parsed.Verify(other[:], pk) {
+			t.Errorf("%x: signature verifies another message", msg)
+		}
+	}
+}
+
+// decodeEllSwift returns the x-only key of an encoding.
+func decodeEllSwift(t *testing.T, encoded [64]byte) [32]byte {
+	t.Helper()
+
+	var u, tt btcec.FieldVal
+	u.SetByteSlice(encoded[:32])
+	tt.SetByteSlice(encoded[32:])
+
+	x, err := ellswift.XSwiftEC(&u, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *x.Bytes()
+}
+
+func TestEllSwift(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := key.ellSwiftEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := key.ellSwiftEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b {
+		t.Error("encodings aren't random")
+	}
+
+	for _, encoded := range [][64]byte{a, b} {
+		if x := decodeEllSwift(t, encoded); x != key.PublicKey() {
+			t.Errorf("%x decodes to %x, want %x", encoded, x, key.PublicKey())
+		}
+	}
+
+	// Both sides of the handshake must agree on the secret.
+	initiator, encodedInitiator, err := ellswift.EllswiftCreate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ours, err := key.ellSwiftECDH(encodedInitiator, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	theirs, err := ellswift.V2Ecdh(initiator, a, encodedInitiator, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ours[:], theirs[:]) {
+		t.Errorf("secret %x, initiator has %x", ours, theirs)
+	}
+
+	// Another encoding of our key is another secret.
+	other, err := key.ellSwiftECDH(encodedInitiator, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == ours {
+		t.Error("secret doesn't commit to our encoding")
+	}
+}